@@ -0,0 +1,24 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package handler
+
+import (
+  "github.com/CTFxd/ctfxd-server/internal/apitoken"
+  "github.com/CTFxd/ctfxd-server/internal/auth"
+  "github.com/gin-gonic/gin"
+)
+
+func SetupTokenRoutes(apiGrp *gin.RouterGroup, tokenHandler *apitoken.Handler) {
+  // tokens can only be managed from a login session, never with a token
+  protected := apiGrp.Group("/me/tokens")
+  protected.Use(auth.AuthMiddleware(), auth.SessionOnly())
+  {
+    protected.GET("", tokenHandler.ListTokens)
+    protected.POST("", tokenHandler.CreateToken)
+    protected.DELETE("/:id", tokenHandler.RevokeToken)
+  }
+}
@@ -0,0 +1,23 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package handler
+
+import (
+  "github.com/CTFxd/ctfxd-server/internal/audit"
+  "github.com/CTFxd/ctfxd-server/internal/auth"
+  "github.com/gin-gonic/gin"
+)
+
+func SetupAuditRoutes(apiGrp *gin.RouterGroup, auditHandler *audit.Handler) {
+
+  admin := apiGrp.Group("/admin/audit")
+  admin.Use(auth.AuthMiddleware(), auth.RequirePermission(auth.PermViewAudit), auth.SessionOnly())
+  {
+    admin.GET("", auditHandler.ListEntries)
+    admin.GET("/export", auditHandler.ExportEntries)
+  }
+}
@@ -0,0 +1,21 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package handler
+
+import (
+  "github.com/CTFxd/ctfxd-server/internal/auth"
+  "github.com/gin-gonic/gin"
+)
+
+// SetupWellKnownRoutes registers the routes served outside of the versioned
+// API, at the root of the server.
+func SetupWellKnownRoutes(rootGrp *gin.RouterGroup, authHandler *auth.Handler) {
+  public := rootGrp.Group("/.well-known")
+  {
+    public.GET("/jwks.json", authHandler.JWKS)
+  }
+}
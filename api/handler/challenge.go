@@ -17,27 +17,65 @@ func SetupChallengeRoutes(apiGrp *gin.RouterGroup, challengeHandler *challenge.H
   public := apiGrp.Group("/challenge")
   {
     public.GET("", challengeHandler.GetChallenges)
-    public.GET("/:id", challengeHandler.GetChallenge)
-    public.GET("/:id/file/:uuid", challengeHandler.DownloadChallengeFile)
+    // the logged in users get the files of the challenges they see, the
+    // others need a signed URL
+    public.GET("/:id", auth.OptionalAuthMiddleware(), challengeHandler.GetChallenge)
+    public.GET("/:id/file/:uuid", auth.OptionalAuthMiddleware(), challengeHandler.DownloadChallengeFile)
+    public.GET("/:id/files.zip", auth.OptionalAuthMiddleware(), challengeHandler.DownloadChallengeZip)
+    public.GET("/:id/files.tar.gz", auth.OptionalAuthMiddleware(), challengeHandler.DownloadChallengeTarGz)
   }
 
   // protected routes (requires login)
   protected := apiGrp.Group("/challenge")
   protected.Use(auth.AuthMiddleware())
   {
-    protected.GET("/:id/solves", challengeHandler.GetSolves)
+    protected.GET("/:id/solves", auth.RequireScope(auth.ScopeChallengesRead), challengeHandler.GetSolves)
 
-    admin := protected.Group("")
-    admin.Use(auth.AdminMiddleware())
+    // authors manage their own challenges, admins manage every challenge
+    manage := protected.Group("")
+    manage.Use(auth.RequirePermission(auth.PermManageChallenges, auth.PermManageOwnChallenges))
     {
-      admin.POST("", challengeHandler.CreateChallenge)
-      admin.PATCH("/:id", challengeHandler.UpdateChallenge)
-      admin.DELETE("/:id", challengeHandler.DeleteChallenge)
-      admin.GET("/:id/flag", challengeHandler.GetFlag)
-
-      admin.POST("/:id/file", challengeHandler.AddChallengeFile)
-      admin.PUT("/:id/file/:uuid", challengeHandler.UpdateChallengeFile)
-      admin.DELETE("/:id/file/:uuid", challengeHandler.DeleteChallengeFile)
+      manage.POST("", auth.SessionOnly(), challengeHandler.CreateChallenge)
     }
+
+    owned := manage.Group("/:id")
+    owned.Use(challengeHandler.OwnershipMiddleware())
+    {
+      owned.GET("/flag", auth.RequireScope(auth.ScopeAdminRead), challengeHandler.GetFlag)
+    }
+
+    ownedWrite := owned.Group("")
+    ownedWrite.Use(auth.SessionOnly())
+    {
+      ownedWrite.PATCH("", challengeHandler.UpdateChallenge)
+      ownedWrite.DELETE("", challengeHandler.DeleteChallenge)
+
+      ownedWrite.POST("/file", challengeHandler.AddChallengeFile)
+      ownedWrite.PUT("/file/:uuid", challengeHandler.UpdateChallengeFile)
+      ownedWrite.DELETE("/file/:uuid", challengeHandler.DeleteChallengeFile)
+
+      // resumable uploads of large files
+      ownedWrite.POST("/uploads", challengeHandler.InitUpload)
+      ownedWrite.GET("/uploads/:upload", challengeHandler.GetUpload)
+      ownedWrite.PUT("/uploads/:upload/parts/:part", challengeHandler.UploadPart)
+      ownedWrite.POST("/uploads/:upload/complete", challengeHandler.CompleteUpload)
+      ownedWrite.DELETE("/uploads/:upload", challengeHandler.AbortUpload)
+    }
+  }
+
+  admin := apiGrp.Group("/admin/files")
+  admin.Use(auth.AuthMiddleware(), auth.RequirePermission(auth.PermManageChallenges), auth.SessionOnly())
+  {
+    admin.GET("/orphans", challengeHandler.ListOrphanFiles)
+    admin.GET("/cleaner", challengeHandler.GetCleanerStats)
+    admin.GET("/downloads", challengeHandler.GetDownloadStats)
+    admin.GET("/downloads/:id", challengeHandler.GetFileDownloadStats)
+  }
+
+  bundles := apiGrp.Group("/admin/challenges")
+  bundles.Use(auth.AuthMiddleware(), auth.RequirePermission(auth.PermManageChallenges), auth.SessionOnly())
+  {
+    bundles.POST("/import", challengeHandler.ImportBundle)
+    bundles.GET("/:id/export", challengeHandler.ExportBundle)
   }
 }
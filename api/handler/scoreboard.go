@@ -15,5 +15,6 @@ func SetupScoreboardRoutes(apiGrp *gin.RouterGroup, scoreboardHandler *scoreboar
   public := apiGrp.Group("")
   {
     public.GET("/scoreboard", scoreboardHandler.Get)
+    public.GET("/users/:id", scoreboardHandler.GetUserProfile)
   }
 }
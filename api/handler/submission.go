@@ -15,8 +15,14 @@ import (
 func SetupSubmissionRoutes(apiGrp *gin.RouterGroup, submissionHandler *submission.Handler) {
   // protected group (all routes require auth)
   protected := apiGrp.Group("")
-  protected.Use(auth.AuthMiddleware())
+  protected.Use(auth.AuthMiddleware(), auth.RequireScope(auth.ScopeSubmit))
   {
     protected.POST("/submit", submissionHandler.Submit)
   }
+
+  admin := apiGrp.Group("/admin/cheating")
+  admin.Use(auth.AuthMiddleware(), auth.RequirePermission(auth.PermManageUsers), auth.SessionOnly())
+  {
+    admin.GET("/report", submissionHandler.CheatingReport)
+  }
 }
@@ -19,6 +19,15 @@ func SetupUserRoutes(apiGrp *gin.RouterGroup, userHandler *user.Handler) {
   {
     public.POST("/register", userHandler.RegisterUser)
     public.POST("/login", userHandler.Login)
+    public.POST("/login/2fa", userHandler.LoginMFA)
+    public.POST("/token/refresh", userHandler.RefreshToken)
+    public.POST("/verify-email", userHandler.VerifyEmail)
+    public.POST("/verify-email/resend", userHandler.ResendVerification)
+    public.POST("/password/forgot", userHandler.ForgotPassword)
+    public.POST("/password/reset", userHandler.ResetPassword)
+    public.GET("/auth/providers", userHandler.ListSSOProviders)
+    public.GET("/auth/:provider/login", userHandler.StartSSO)
+    public.POST("/auth/:provider/callback", userHandler.FinishSSO)
   }
 
   // protected group (all routes require auth)
@@ -26,11 +35,48 @@ func SetupUserRoutes(apiGrp *gin.RouterGroup, userHandler *user.Handler) {
   protected.Use(auth.AuthMiddleware())
   {
     protected.GET("/me", userHandler.GetMe)
+    protected.PATCH("/me", auth.SessionOnly(), userHandler.UpdateProfile)
+    protected.POST("/logout", auth.SessionOnly(), userHandler.Logout)
+    protected.POST("/me/password", auth.SessionOnly(), userHandler.ChangePassword)
+  }
+
+  twoFactor := protected.Group("/me/2fa")
+  twoFactor.Use(auth.SessionOnly())
+  {
+    twoFactor.POST("/enroll", userHandler.EnrollTOTP)
+    twoFactor.POST("/confirm", userHandler.ConfirmTOTP)
+    twoFactor.POST("/disable", userHandler.DisableTOTP)
+    twoFactor.POST("/recovery-codes", userHandler.RegenerateRecoveryCodes)
   }
 
   admin := protected.Group("")
-  admin.Use(auth.AdminMiddleware())
+  admin.Use(auth.RequirePermission(auth.PermManageRoles), auth.SessionOnly())
   {
     admin.POST("/admin/register", userHandler.RegisterAdmin)
   }
+
+  settings := protected.Group("/admin")
+  settings.Use(auth.RequirePermission(auth.PermManageSettings), auth.SessionOnly())
+  {
+    settings.GET("/registration", userHandler.GetRegistrationSettings)
+    settings.PUT("/registration", userHandler.UpdateRegistrationSettings)
+    settings.GET("/invites", userHandler.ListInvites)
+    settings.POST("/invites", userHandler.CreateInvite)
+    settings.GET("/invites/:id", userHandler.GetInvite)
+    settings.DELETE("/invites/:id", userHandler.RevokeInvite)
+  }
+
+  moderation := protected.Group("/admin/users")
+  moderation.Use(auth.RequirePermission(auth.PermManageUsers), auth.SessionOnly())
+  {
+    moderation.GET("", userHandler.ListUsers)
+    moderation.GET("/:id", userHandler.GetUser)
+    moderation.PUT("/:id/role", auth.RequirePermission(auth.PermManageRoles), userHandler.SetUserRole)
+    moderation.POST("/:id/ban", userHandler.BanUser)
+    moderation.DELETE("/:id/ban", userHandler.UnbanUser)
+    moderation.PUT("/:id/hidden", userHandler.SetUserHidden)
+    moderation.DELETE("/:id", userHandler.DeleteUser)
+    moderation.POST("/:id/verify", userHandler.ForceVerifyUser)
+    moderation.POST("/:id/unlock", userHandler.UnlockUser)
+  }
 }
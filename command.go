@@ -0,0 +1,152 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package main
+
+import (
+  "archive/zip"
+  "context"
+  "errors"
+  "flag"
+  "fmt"
+  "os"
+  "strings"
+
+  "github.com/CTFxd/ctfxd-server/internal/challenge"
+  "github.com/CTFxd/ctfxd-server/pkg/db"
+)
+
+const commandUsage = `usage:
+  ctfxd-server                                start the server
+  ctfxd-server import [-dry-run] <bundle>...  import challenge bundles (directories or zips)
+  ctfxd-server export <id|slug> <file.zip>    export a challenge bundle
+`
+
+// runCommand runs a subcommand against the database and the storage of the
+// server, and returns the exit code.
+func runCommand(config *ServerConfig, args []string) int {
+  var run func(context.Context, *challenge.Service, []string) error
+  switch args[0] {
+  case "import":
+    run = importBundles
+  case "export":
+    run = exportBundle
+  default:
+    fmt.Fprint(os.Stderr, commandUsage)
+    return 2
+  }
+
+  mongoClient := db.NewMongodbInit(config.mongodbUri, config.dbName)
+  defer mongoClient.Close()
+
+  service, err := newChallengeService(config, mongoClient.Database)
+  if err != nil {
+    fmt.Fprintln(os.Stderr, err)
+    return 1
+  }
+
+  if err := run(context.Background(), service, args[1:]); err != nil {
+    fmt.Fprintln(os.Stderr, err)
+    return 1
+  }
+
+  return 0
+}
+
+func importBundles(ctx context.Context, service *challenge.Service, args []string) error {
+  flags := flag.NewFlagSet("import", flag.ContinueOnError)
+  dryRun := flags.Bool("dry-run", false, "only validate the bundles")
+  if err := flags.Parse(args); err != nil {
+    return err
+  }
+  if flags.NArg() == 0 {
+    return errors.New(commandUsage)
+  }
+
+  failed := 0
+  for _, path := range flags.Args() {
+    result, err := importBundle(ctx, service, path, *dryRun)
+    if err != nil {
+      failed++
+      fmt.Fprintf(os.Stderr, "%s: import failed\n", path)
+
+      var bundleErr *challenge.BundleError
+      if errors.As(err, &bundleErr) {
+        for _, fe := range bundleErr.Errors {
+          fmt.Fprintf(os.Stderr, "  %s: %s\n", fe.Field, fe.Message)
+        }
+      } else {
+        fmt.Fprintf(os.Stderr, "  %v\n", err)
+      }
+      continue
+    }
+
+    action := "updated"
+    if result.Created {
+      action = "created"
+    }
+    if result.DryRun {
+      action = "valid, would be " + action
+    }
+    fmt.Printf("%s: %s %s (added %d, kept %d, removed %d files)\n", path, result.Slug, action,
+      len(result.AddedFiles), len(result.KeptFiles), len(result.RemovedFiles))
+  }
+
+  if failed > 0 {
+    return fmt.Errorf("%d of %d bundles failed", failed, flags.NArg())
+  }
+
+  return nil
+}
+
+func importBundle(ctx context.Context, service *challenge.Service, path string, dryRun bool) (*challenge.ImportResult, error) {
+  opts := challenge.ImportOptions{DryRun: dryRun}
+
+  info, err := os.Stat(path)
+  if err != nil {
+    return nil, err
+  }
+
+  if info.IsDir() {
+    return service.ImportBundle(ctx, os.DirFS(path), opts)
+  }
+
+  if !strings.HasSuffix(path, ".zip") {
+    return nil, fmt.Errorf("%w: expected a directory or a zip", challenge.ErrInvalidBundle)
+  }
+
+  zr, err := zip.OpenReader(path)
+  if err != nil {
+    return nil, fmt.Errorf("%w: %v", challenge.ErrInvalidBundle, err)
+  }
+  defer zr.Close()
+
+  return service.ImportBundle(ctx, zr, opts)
+}
+
+func exportBundle(ctx context.Context, service *challenge.Service, args []string) error {
+  if len(args) != 2 {
+    return errors.New(commandUsage)
+  }
+
+  c, err := service.FindChallenge(ctx, args[0])
+  if err != nil {
+    return fmt.Errorf("challenge %s: %w", args[0], err)
+  }
+
+  f, err := os.Create(args[1])
+  if err != nil {
+    return err
+  }
+
+  if err := service.ExportBundle(ctx, c, f); err != nil {
+    f.Close()
+    os.Remove(args[1])
+    return err
+  }
+
+  return f.Close()
+}
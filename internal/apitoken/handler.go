@@ -0,0 +1,167 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package apitoken
+
+import (
+  "errors"
+  "log"
+  "net/http"
+
+  "github.com/CTFxd/ctfxd-server/internal/auth"
+  "github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+  service *Service
+}
+
+// swagger:model CreateTokenRequest
+type CreateTokenRequest struct {
+  // Name telling the tokens apart, up to 64 characters
+  // required: true
+  // example: ci
+  Name string `json:"name" binding:"required,max=64"`
+
+  // Scopes granted to the token, admin:read requires a privileged role
+  // required: true
+  // example: ["challenges:read", "submit"]
+  Scopes []string `json:"scopes" binding:"required,min=1"`
+
+  // Lifetime of the token, 1 to 365 days
+  // required: true
+  // example: 30
+  ExpiresInDays int `json:"expires_in_days" binding:"required"`
+}
+
+func NewHandler(service *Service) *Handler {
+  handler := new(Handler)
+  handler.service = service
+  return handler
+}
+
+// swagger:operation POST /me/tokens tokens createToken
+// ---
+// tags: [tokens]
+// description: Create a personal access token, the token is only shown once (login session only)
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: body
+//     in: body
+//     required: true
+//     schema: {$ref: "#/definitions/CreateTokenRequest"}
+//
+// responses:
+//
+//  201:
+//    description: Token created
+//    schema:
+//      type: object
+//      properties:
+//        token:
+//          type: string
+//          example: ctfxd_pat_...
+//        details:
+//          type: object
+//  400:
+//    description: Invalid request format, scope or expiry
+//  403:
+//    description: Scope not allowed for this user
+//  500:
+//    description: Internal server error
+func (h *Handler) CreateToken(c *gin.Context) {
+  var req CreateTokenRequest
+
+  if err := c.ShouldBindJSON(&req); err != nil {
+    log.Printf("apitoken: error(%v)\n", err)
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    return
+  }
+
+  mfa := false
+  if claims := auth.GetClaims(c); claims != nil {
+    mfa = claims.MFA
+  }
+
+  token, plain, err := h.service.CreateToken(c.Request.Context(), auth.GetUserID(c), req.Name, req.Scopes, req.ExpiresInDays, mfa)
+  if err != nil {
+    log.Printf("apitoken: error(%v)\n", err)
+    switch {
+    case errors.Is(err, ErrInvalidScope), errors.Is(err, ErrInvalidExpiry):
+      c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    case errors.Is(err, ErrScopeNotAllowed):
+      c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+    default:
+      c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create token"})
+    }
+    return
+  }
+
+  c.JSON(http.StatusCreated, gin.H{
+    "token":   plain,
+    "details": token,
+  })
+}
+
+// swagger:operation GET /me/tokens tokens listTokens
+// ---
+// tags: [tokens]
+// description: List the personal access tokens of the user (login session only)
+// security:
+// - bearerAuth: []
+//
+// responses:
+//
+//  200:
+//    description: The tokens, without their secret part
+//  500:
+//    description: Internal server error
+func (h *Handler) ListTokens(c *gin.Context) {
+  tokens, err := h.service.ListTokens(c.Request.Context(), auth.GetUserID(c))
+  if err != nil {
+    log.Printf("apitoken: error(%v)\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch tokens"})
+    return
+  }
+
+  c.JSON(http.StatusOK, tokens)
+}
+
+// swagger:operation DELETE /me/tokens/{id} tokens revokeToken
+// ---
+// tags: [tokens]
+// description: Revoke a personal access token (login session only)
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: id
+//     in: path
+//     required: true
+//     type: string
+//
+// responses:
+//
+//  204:
+//    description: Token revoked
+//  404:
+//    description: Token not found
+//  500:
+//    description: Internal server error
+func (h *Handler) RevokeToken(c *gin.Context) {
+  err := h.service.RevokeToken(c.Request.Context(), auth.GetUserID(c), c.Param("id"))
+  if err != nil {
+    log.Printf("apitoken: error(%v)\n", err)
+    if errors.Is(err, ErrTokenNotFound) {
+      c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+    } else {
+      c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke token"})
+    }
+    return
+  }
+
+  c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,29 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package apitoken
+
+import (
+  "time"
+
+  "go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// PersonalToken is a user-managed API token. Only the SHA-256 hash of the
+// token is stored, the prefix is kept to tell the tokens apart.
+type PersonalToken struct {
+  ID         bson.ObjectID `bson:"_id,omitempty" json:"id"`
+  UserID     bson.ObjectID `bson:"user_id" json:"-"`
+  Name       string        `bson:"name" json:"name"`
+  Prefix     string        `bson:"prefix" json:"prefix"`
+  Hash       string        `bson:"hash" json:"-"`
+  Scopes     []string      `bson:"scopes" json:"scopes"`
+  MFA        bool          `bson:"mfa,omitempty" json:"-"`
+  CreatedAt  time.Time     `bson:"created_at" json:"created_at"`
+  ExpiresAt  time.Time     `bson:"expires_at" json:"expires_at"`
+  LastUsedAt *time.Time    `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+  LastUsedIP string        `bson:"last_used_ip,omitempty" json:"last_used_ip,omitempty"`
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package apitoken
+
+import (
+  "context"
+  "log"
+  "time"
+
+  "go.mongodb.org/mongo-driver/v2/bson"
+  "go.mongodb.org/mongo-driver/v2/mongo"
+  "go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type Repository struct {
+  collection *mongo.Collection
+}
+
+func NewRepository(db *mongo.Database) *Repository {
+  repo := new(Repository)
+  repo.collection = db.Collection("personal_tokens")
+
+  ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+  defer cancel()
+
+  _, err := repo.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+    Keys:    bson.D{{Key: "hash", Value: 1}},
+    Options: options.Index().SetUnique(true),
+  })
+  if err != nil {
+    log.Printf("apitoken: error: indexes(%v)\n", err)
+  }
+
+  return repo
+}
+
+func (r *Repository) Create(ctx context.Context, token *PersonalToken) error {
+  result, err := r.collection.InsertOne(ctx, token)
+  if err != nil {
+    return err
+  }
+
+  token.ID = result.InsertedID.(bson.ObjectID)
+  return nil
+}
+
+func (r *Repository) GetByHash(ctx context.Context, hash string) (*PersonalToken, error) {
+  token := new(PersonalToken)
+
+  err := r.collection.FindOne(ctx, bson.M{"hash": hash}).Decode(token)
+  if err != nil {
+    return nil, err
+  }
+
+  return token, nil
+}
+
+func (r *Repository) ListByUser(ctx context.Context, userID bson.ObjectID) ([]PersonalToken, error) {
+  opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+  cursor, err := r.collection.Find(ctx, bson.M{"user_id": userID}, opts)
+  if err != nil {
+    return nil, err
+  }
+  defer cursor.Close(ctx)
+
+  tokens := []PersonalToken{}
+  if err := cursor.All(ctx, &tokens); err != nil {
+    return nil, err
+  }
+
+  return tokens, nil
+}
+
+func (r *Repository) Delete(ctx context.Context, userID, id bson.ObjectID) (bool, error) {
+  result, err := r.collection.DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+  if err != nil {
+    return false, err
+  }
+
+  return result.DeletedCount > 0, nil
+}
+
+func (r *Repository) DeleteByUser(ctx context.Context, userID bson.ObjectID) error {
+  _, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+
+  return err
+}
+
+func (r *Repository) TouchLastUsed(ctx context.Context, id bson.ObjectID, usedAt time.Time, ip string) error {
+  update := bson.M{"$set": bson.M{"last_used_at": usedAt, "last_used_ip": ip}}
+  _, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+
+  return err
+}
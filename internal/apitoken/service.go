@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package apitoken
+
+import (
+  "context"
+  "crypto/rand"
+  "encoding/base64"
+  "errors"
+  "log"
+  "time"
+
+  "go.mongodb.org/mongo-driver/v2/bson"
+  "go.mongodb.org/mongo-driver/v2/mongo"
+
+  "github.com/CTFxd/ctfxd-server/internal/auth"
+  "github.com/CTFxd/ctfxd-server/internal/user"
+)
+
+const (
+  maxTokenLifetimeDays = 365
+  lastUsedResolution   = time.Minute
+)
+
+var (
+  ErrInvalidScope    = errors.New("invalid token scope")
+  ErrScopeNotAllowed = errors.New("token scope not allowed for this user")
+  ErrInvalidExpiry   = errors.New("token expiry must be between 1 and 365 days")
+  ErrTokenNotFound   = errors.New("token not found")
+  ErrInvalidToken    = errors.New("invalid or expired token")
+)
+
+var validScopes = map[string]bool{
+  auth.ScopeChallengesRead: true,
+  auth.ScopeSubmit:         true,
+  auth.ScopeAdminRead:      true,
+}
+
+type Service struct {
+  repo        *Repository
+  userService *user.Service
+}
+
+func NewService(repo *Repository, userService *user.Service) *Service {
+  serv := new(Service)
+  serv.repo = repo
+  serv.userService = userService
+
+  return serv
+}
+
+// CreateToken creates a token for the user and returns it along with the
+// plaintext token, which is never shown again. A token created from a session
+// opened with two-factor authentication inherits it.
+func (s *Service) CreateToken(ctx context.Context, userID, name string, scopes []string, expiresInDays int, mfa bool) (*PersonalToken, string, error) {
+  if expiresInDays < 1 || expiresInDays > maxTokenLifetimeDays {
+    return nil, "", ErrInvalidExpiry
+  }
+
+  owner, err := s.userService.GetUser(ctx, userID)
+  if err != nil {
+    return nil, "", err
+  }
+
+  seen := make(map[string]bool)
+  var grantedScopes []string
+  for _, scope := range scopes {
+    if !validScopes[scope] {
+      return nil, "", ErrInvalidScope
+    }
+    if scope == auth.ScopeAdminRead && !auth.IsPrivilegedRole(owner.Role) {
+      return nil, "", ErrScopeNotAllowed
+    }
+    if !seen[scope] {
+      seen[scope] = true
+      grantedScopes = append(grantedScopes, scope)
+    }
+  }
+
+  plain, err := generateToken()
+  if err != nil {
+    return nil, "", err
+  }
+
+  now := time.Now().UTC()
+  token := &PersonalToken{
+    UserID:    owner.ID,
+    Name:      name,
+    Prefix:    plain[:len(auth.PersonalTokenPrefix)+6],
+    Hash:      auth.HashToken(plain),
+    Scopes:    grantedScopes,
+    CreatedAt: now,
+    ExpiresAt: now.AddDate(0, 0, expiresInDays),
+    MFA:       mfa,
+  }
+
+  if err := s.repo.Create(ctx, token); err != nil {
+    return nil, "", err
+  }
+
+  return token, plain, nil
+}
+
+func (s *Service) ListTokens(ctx context.Context, userID string) ([]PersonalToken, error) {
+  objId, err := bson.ObjectIDFromHex(userID)
+  if err != nil {
+    return nil, err
+  }
+
+  return s.repo.ListByUser(ctx, objId)
+}
+
+func (s *Service) RevokeToken(ctx context.Context, userID, tokenID string) error {
+  userObjId, err := bson.ObjectIDFromHex(userID)
+  if err != nil {
+    return err
+  }
+
+  tokenObjId, err := bson.ObjectIDFromHex(tokenID)
+  if err != nil {
+    return ErrTokenNotFound
+  }
+
+  deleted, err := s.repo.Delete(ctx, userObjId, tokenObjId)
+  if err != nil {
+    return err
+  }
+  if !deleted {
+    return ErrTokenNotFound
+  }
+
+  return nil
+}
+
+// DeleteUserData implements user.AccountDataCleaner, the tokens of a deleted
+// account are dropped.
+func (s *Service) DeleteUserData(ctx context.Context, userID string) error {
+  objId, err := bson.ObjectIDFromHex(userID)
+  if err != nil {
+    return err
+  }
+
+  return s.repo.DeleteByUser(ctx, objId)
+}
+
+// RevokeUserCredentials implements user.CredentialRevoker, the tokens of a
+// claimed account are dropped.
+func (s *Service) RevokeUserCredentials(ctx context.Context, userID string) error {
+  return s.DeleteUserData(ctx, userID)
+}
+
+// AuthenticateToken implements auth.PersonalTokenAuthenticator. The owner is
+// looked up on every use so role changes apply to existing tokens.
+func (s *Service) AuthenticateToken(ctx context.Context, token, clientIP string) (*auth.Claims, []string, error) {
+  record, err := s.repo.GetByHash(ctx, auth.HashToken(token))
+  if err != nil {
+    if errors.Is(err, mongo.ErrNoDocuments) {
+      return nil, nil, ErrInvalidToken
+    }
+
+    return nil, nil, err
+  }
+
+  now := time.Now().UTC()
+  if now.After(record.ExpiresAt) {
+    return nil, nil, ErrInvalidToken
+  }
+
+  owner, err := s.userService.GetUser(ctx, record.UserID.Hex())
+  if err != nil {
+    if errors.Is(err, mongo.ErrNoDocuments) {
+      return nil, nil, ErrInvalidToken
+    }
+
+    return nil, nil, err
+  }
+
+  if record.LastUsedAt == nil || now.Sub(*record.LastUsedAt) > lastUsedResolution || record.LastUsedIP != clientIP {
+    if err := s.repo.TouchLastUsed(ctx, record.ID, now, clientIP); err != nil {
+      log.Printf("apitoken: error: last used update(%v)\n", err)
+    }
+  }
+
+  claims := &auth.Claims{
+    UserID: owner.ID.Hex(),
+    Email:  owner.Email,
+    Role:   owner.Role,
+    MFA:    record.MFA,
+  }
+
+  return claims, record.Scopes, nil
+}
+
+func generateToken() (string, error) {
+  buf := make([]byte, 32)
+  if _, err := rand.Read(buf); err != nil {
+    return "", err
+  }
+
+  return auth.PersonalTokenPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package audit
+
+import (
+  "fmt"
+  "log"
+  "net/http"
+  "strconv"
+  "time"
+
+  "github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+  service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+  handler := new(Handler)
+  handler.service = service
+  return handler
+}
+
+// swagger:operation GET /admin/audit admin listAuditLog
+// ---
+// tags: [admin]
+// description: Search the audit log of the privileged actions, newest first (requires audit privileges)
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: actor_id
+//     in: query
+//     type: string
+//   - name: action
+//     in: query
+//     type: string
+//     description: Exact action, or a prefix ending with a dot (e.g. "challenge.")
+//   - name: resource_type
+//     in: query
+//     type: string
+//   - name: resource_id
+//     in: query
+//     type: string
+//   - name: from
+//     in: query
+//     type: string
+//     format: date-time
+//   - name: to
+//     in: query
+//     type: string
+//     format: date-time
+//   - name: page
+//     in: query
+//     type: integer
+//     default: 1
+//   - name: per_page
+//     in: query
+//     type: integer
+//     default: 100
+//
+// responses:
+//
+//  200:
+//    description: A page of audit entries
+//  400:
+//    description: Invalid query parameters
+//  500:
+//    description: Internal server error
+func (h *Handler) ListEntries(c *gin.Context) {
+  filter, err := parseFilter(c)
+  if err != nil {
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    return
+  }
+
+  page, err := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 64)
+  if err != nil {
+    c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page parameter"})
+    return
+  }
+
+  perPage, err := strconv.ParseInt(c.DefaultQuery("per_page", strconv.Itoa(maxPageSize)), 10, 64)
+  if err != nil {
+    c.JSON(http.StatusBadRequest, gin.H{"error": "invalid per_page parameter"})
+    return
+  }
+
+  entries, err := h.service.List(c.Request.Context(), filter, page, perPage)
+  if err != nil {
+    log.Printf("audit: error(internal): %v\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list audit entries"})
+    return
+  }
+
+  c.JSON(http.StatusOK, entries)
+}
+
+// swagger:operation GET /admin/audit/export admin exportAuditLog
+// ---
+// tags: [admin]
+// description: Export the audit log as JSON lines, oldest first (requires audit privileges).
+//   Takes the same filters as GET /admin/audit.
+// security:
+// - bearerAuth: []
+// produces:
+//   - application/x-ndjson
+//
+// responses:
+//
+//  200:
+//    description: One audit entry per line
+//  400:
+//    description: Invalid query parameters
+//  500:
+//    description: Internal server error
+func (h *Handler) ExportEntries(c *gin.Context) {
+  filter, err := parseFilter(c)
+  if err != nil {
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    return
+  }
+
+  h.service.Record(c, Entry{
+    Action:       ActionAuditExport,
+    ResourceType: ResourceAudit,
+    Metadata:     map[string]any{"query": c.Request.URL.RawQuery},
+  })
+
+  filename := fmt.Sprintf("audit-%s.jsonl", time.Now().UTC().Format("20060102-150405"))
+  c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+  c.Header("Content-Type", "application/x-ndjson")
+  c.Status(http.StatusOK)
+
+  // the status is sent, an error can only end the stream early
+  if err := h.service.Export(c.Request.Context(), filter, c.Writer); err != nil {
+    log.Printf("audit: error: export(%v)\n", err)
+  }
+}
+
+func parseFilter(c *gin.Context) (*Filter, error) {
+  filter := &Filter{
+    ActorID:      c.Query("actor_id"),
+    Action:       c.Query("action"),
+    ResourceType: c.Query("resource_type"),
+    ResourceID:   c.Query("resource_id"),
+  }
+
+  for _, param := range []struct {
+    name string
+    dst  **time.Time
+  }{{"from", &filter.From}, {"to", &filter.To}} {
+    value := c.Query(param.name)
+    if value == "" {
+      continue
+    }
+
+    t, err := time.Parse(time.RFC3339, value)
+    if err != nil {
+      return nil, fmt.Errorf("invalid %s parameter, expected RFC 3339", param.name)
+    }
+    *param.dst = &t
+  }
+
+  return filter, nil
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package audit
+
+import (
+  "time"
+
+  "go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Redacted replaces the secret values (flags) in the recorded changes.
+const Redacted = "[REDACTED]"
+
+const (
+  ResourceChallenge = "challenge"
+  ResourceFile      = "file"
+  ResourceUser      = "user"
+  ResourceInvite    = "invite"
+  ResourceSettings  = "settings"
+  ResourceAudit     = "audit"
+)
+
+const (
+  ActionChallengeCreate = "challenge.create"
+  ActionChallengeUpdate = "challenge.update"
+  ActionChallengeDelete = "challenge.delete"
+  ActionFlagRead        = "challenge.flag_read"
+  ActionFileAdd         = "challenge.file_add"
+  ActionFileReplace     = "challenge.file_replace"
+  ActionFileDelete      = "challenge.file_delete"
+  ActionChallengeImport = "challenge.import"
+  ActionChallengeExport = "challenge.export"
+
+  ActionUserRegisterPrivileged = "user.register_privileged"
+  ActionUserRoleChange         = "user.role_change"
+  ActionUserBan                = "user.ban"
+  ActionUserUnban              = "user.unban"
+  ActionUserHide               = "user.hide"
+  ActionUserDelete             = "user.delete"
+  ActionUserVerify             = "user.verify"
+  ActionUserUnlock             = "user.unlock"
+  ActionUserSSOClaim           = "user.sso_claim"
+
+  ActionRegistrationUpdate = "settings.registration_update"
+  ActionInviteCreate       = "invite.create"
+  ActionInviteRevoke       = "invite.revoke"
+
+  ActionAuditExport = "audit.export"
+)
+
+// Entry is one recorded privileged action: who did what, on which resource
+// and when.
+type Entry struct {
+  ID   bson.ObjectID `bson:"_id,omitempty" json:"id"`
+  Time time.Time     `bson:"time" json:"time"`
+
+  ActorID    string `bson:"actor_id" json:"actor_id"`
+  ActorEmail string `bson:"actor_email" json:"actor_email"`
+  ActorRole  string `bson:"actor_role" json:"actor_role"`
+  // "session" or "token" (personal access token)
+  Via      string `bson:"via" json:"via"`
+  ClientIP string `bson:"client_ip" json:"client_ip"`
+
+  Action       string `bson:"action" json:"action"`
+  ResourceType string `bson:"resource_type" json:"resource_type"`
+  ResourceID   string `bson:"resource_id,omitempty" json:"resource_id,omitempty"`
+
+  Changes  []Change       `bson:"changes,omitempty" json:"changes,omitempty"`
+  Metadata map[string]any `bson:"metadata,omitempty" json:"metadata,omitempty"`
+}
+
+// Change is the before and after value of a field of the resource.
+type Change struct {
+  Field  string `bson:"field" json:"field"`
+  Before any    `bson:"before" json:"before"`
+  After  any    `bson:"after" json:"after"`
+}
+
+type Filter struct {
+  ActorID      string
+  // exact action, or a prefix ending with "." (e.g. "challenge.")
+  Action       string
+  ResourceType string
+  ResourceID   string
+  From         *time.Time
+  To           *time.Time
+}
+
+type Page struct {
+  Entries []Entry `json:"entries"`
+  Total   int64   `json:"total"`
+  Page    int64   `json:"page"`
+  PerPage int64   `json:"per_page"`
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package audit
+
+import (
+  "context"
+  "log"
+  "time"
+
+  "go.mongodb.org/mongo-driver/v2/bson"
+  "go.mongodb.org/mongo-driver/v2/mongo"
+  "go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type Repository struct {
+  collection *mongo.Collection
+}
+
+func NewRepository(db *mongo.Database) *Repository {
+  repo := new(Repository)
+  repo.collection = db.Collection("audit_log")
+
+  ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+  defer cancel()
+
+  _, err := repo.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+    {Keys: bson.D{{Key: "time", Value: -1}}},
+    {Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "time", Value: -1}}},
+    {Keys: bson.D{{Key: "resource_type", Value: 1}, {Key: "resource_id", Value: 1}, {Key: "time", Value: -1}}},
+    {Keys: bson.D{{Key: "action", Value: 1}, {Key: "time", Value: -1}}},
+  })
+  if err != nil {
+    log.Printf("audit: error: indexes(%v)\n", err)
+  }
+
+  return repo
+}
+
+func (r *Repository) Create(ctx context.Context, entry *Entry) error {
+  result, err := r.collection.InsertOne(ctx, entry)
+  if err != nil {
+    return err
+  }
+
+  entry.ID = result.InsertedID.(bson.ObjectID)
+  return nil
+}
+
+// List returns a page of the entries, newest first, and the total count.
+func (r *Repository) List(ctx context.Context, filter bson.M, skip, limit int64) ([]Entry, int64, error) {
+  total, err := r.collection.CountDocuments(ctx, filter)
+  if err != nil {
+    return nil, 0, err
+  }
+
+  opts := options.Find().
+    SetSort(bson.D{{Key: "time", Value: -1}, {Key: "_id", Value: -1}}).
+    SetSkip(skip).
+    SetLimit(limit)
+
+  cursor, err := r.collection.Find(ctx, filter, opts)
+  if err != nil {
+    return nil, 0, err
+  }
+
+  entries := []Entry{}
+  if err := cursor.All(ctx, &entries); err != nil {
+    return nil, 0, err
+  }
+
+  return entries, total, nil
+}
+
+// Cursor iterates over the entries oldest first, for the exports.
+func (r *Repository) Cursor(ctx context.Context, filter bson.M) (*mongo.Cursor, error) {
+  opts := options.Find().SetSort(bson.D{{Key: "time", Value: 1}, {Key: "_id", Value: 1}})
+
+  return r.collection.Find(ctx, filter, opts)
+}
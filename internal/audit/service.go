@@ -0,0 +1,142 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package audit
+
+import (
+  "context"
+  "encoding/json"
+  "io"
+  "log"
+  "regexp"
+  "strings"
+  "time"
+
+  "github.com/gin-gonic/gin"
+  "go.mongodb.org/mongo-driver/v2/bson"
+
+  "github.com/CTFxd/ctfxd-server/internal/auth"
+)
+
+const (
+  maxPageSize  = 100
+  writeTimeout = 5 * time.Second
+)
+
+type Service struct {
+  repo *Repository
+}
+
+func NewService(repo *Repository) *Service {
+  serv := new(Service)
+  serv.repo = repo
+  return serv
+}
+
+// Record saves the entry for the authenticated user of the request. A failure
+// is logged but does not fail the action, which has already happened. Record
+// is a no-op on a nil service.
+func (s *Service) Record(c *gin.Context, entry Entry) {
+  if s == nil {
+    return
+  }
+
+  entry.Time = time.Now().UTC()
+  entry.ActorID = auth.GetUserID(c)
+  entry.ActorEmail = auth.GetUserEmail(c)
+  entry.ActorRole = auth.GetUserRole(c)
+  entry.ClientIP = c.ClientIP()
+
+  entry.Via = "session"
+  if _, exists := c.Get(auth.ContextScopes); exists {
+    entry.Via = "token"
+  }
+
+  // the entry is written even if the client went away
+  ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), writeTimeout)
+  defer cancel()
+
+  if err := s.repo.Create(ctx, &entry); err != nil {
+    log.Printf("audit: error: %s on %s/%s by %s: %v\n",
+      entry.Action, entry.ResourceType, entry.ResourceID, entry.ActorID, err)
+  }
+}
+
+func (s *Service) List(ctx context.Context, filter *Filter, page, perPage int64) (*Page, error) {
+  if page < 1 {
+    page = 1
+  }
+  if perPage < 1 || perPage > maxPageSize {
+    perPage = maxPageSize
+  }
+
+  entries, total, err := s.repo.List(ctx, filter.query(), (page-1)*perPage, perPage)
+  if err != nil {
+    return nil, err
+  }
+
+  return &Page{Entries: entries, Total: total, Page: page, PerPage: perPage}, nil
+}
+
+// Export writes the matching entries to w as JSON lines, oldest first.
+func (s *Service) Export(ctx context.Context, filter *Filter, w io.Writer) error {
+  cursor, err := s.repo.Cursor(ctx, filter.query())
+  if err != nil {
+    return err
+  }
+  defer cursor.Close(ctx)
+
+  encoder := json.NewEncoder(w)
+  for cursor.Next(ctx) {
+    var entry Entry
+    if err := cursor.Decode(&entry); err != nil {
+      return err
+    }
+
+    if err := encoder.Encode(&entry); err != nil {
+      return err
+    }
+  }
+
+  return cursor.Err()
+}
+
+func (f *Filter) query() bson.M {
+  query := bson.M{}
+  if f == nil {
+    return query
+  }
+
+  if f.ActorID != "" {
+    query["actor_id"] = f.ActorID
+  }
+  if f.Action != "" {
+    if strings.HasSuffix(f.Action, ".") {
+      query["action"] = bson.M{"$regex": "^" + regexp.QuoteMeta(f.Action)}
+    } else {
+      query["action"] = f.Action
+    }
+  }
+  if f.ResourceType != "" {
+    query["resource_type"] = f.ResourceType
+  }
+  if f.ResourceID != "" {
+    query["resource_id"] = f.ResourceID
+  }
+
+  if f.From != nil || f.To != nil {
+    period := bson.M{}
+    if f.From != nil {
+      period["$gte"] = f.From.UTC()
+    }
+    if f.To != nil {
+      period["$lt"] = f.To.UTC()
+    }
+    query["time"] = period
+  }
+
+  return query
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package auth
+
+import (
+  "crypto/rand"
+  "crypto/subtle"
+  "encoding/base64"
+  "errors"
+  "net/http"
+  "time"
+
+  "github.com/gin-gonic/gin"
+)
+
+// Cookie sessions: browser clients ask for them at login with the
+// SessionModeHeader, the tokens are then kept in HttpOnly cookies instead of
+// the response body. Requests authenticated by the cookie must prove they come
+// from the frontend (double-submit): the value of the readable CSRF cookie is
+// sent back in the CSRFHeader on every state-changing request.
+const (
+  AccessCookieName  = "ctfxd_session"
+  RefreshCookieName = "ctfxd_refresh"
+  CSRFCookieName    = "ctfxd_csrf"
+
+  CSRFHeader        = "X-CSRF-Token"
+  SessionModeHeader = "X-Session-Mode"
+  SessionModeCookie = "cookie"
+)
+
+var ErrInvalidCSRFToken = errors.New("missing or invalid CSRF token")
+
+type CookieConfig struct {
+  // cookie sessions are refused (bearer tokens only) when disabled
+  Enabled  bool
+  Domain   string
+  Path     string
+  Secure   bool
+  SameSite http.SameSite
+}
+
+// Cookies configures the cookie sessions, set at startup.
+var Cookies = CookieConfig{Path: "/", Secure: true, SameSite: http.SameSiteLaxMode}
+
+// WantsCookieSession reports whether the client asked for a cookie session
+// and the server allows them.
+func WantsCookieSession(c *gin.Context) bool {
+  return Cookies.Enabled && c.GetHeader(SessionModeHeader) == SessionModeCookie
+}
+
+// SetSessionCookies stores the token pair in HttpOnly cookies and returns a
+// new CSRF token, also set in the readable CSRF cookie.
+func SetSessionCookies(c *gin.Context, tokens *TokenPair) (string, error) {
+  buf := make([]byte, 32)
+  if _, err := rand.Read(buf); err != nil {
+    return "", err
+  }
+  csrfToken := base64.RawURLEncoding.EncodeToString(buf)
+
+  refreshAge := int(RefreshTokenTTL / time.Second)
+
+  setCookie(c, AccessCookieName, tokens.AccessToken, int(tokens.ExpiresIn), true)
+  setCookie(c, RefreshCookieName, tokens.RefreshToken, refreshAge, true)
+  // the frontend reads it to fill the CSRF header
+  setCookie(c, CSRFCookieName, csrfToken, refreshAge, false)
+
+  return csrfToken, nil
+}
+
+func ClearSessionCookies(c *gin.Context) {
+  for _, name := range []string{AccessCookieName, RefreshCookieName, CSRFCookieName} {
+    setCookie(c, name, "", -1, name != CSRFCookieName)
+  }
+}
+
+// RefreshTokenFromCookie returns the refresh token of the cookie session, if
+// any.
+func RefreshTokenFromCookie(c *gin.Context) string {
+  if !Cookies.Enabled {
+    return ""
+  }
+
+  value, err := c.Cookie(RefreshCookieName)
+  if err != nil {
+    return ""
+  }
+
+  return value
+}
+
+// VerifyCSRF checks the double-submitted CSRF token of a request relying on
+// the session cookies.
+func VerifyCSRF(c *gin.Context) error {
+  cookie, err := c.Cookie(CSRFCookieName)
+  if err != nil || cookie == "" {
+    return ErrInvalidCSRFToken
+  }
+
+  header := c.GetHeader(CSRFHeader)
+  if subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) != 1 {
+    return ErrInvalidCSRFToken
+  }
+
+  return nil
+}
+
+// isSafeMethod tells whether the method does not change any state, these
+// requests need no CSRF token.
+func isSafeMethod(method string) bool {
+  switch method {
+  case http.MethodGet, http.MethodHead, http.MethodOptions:
+    return true
+  }
+
+  return false
+}
+
+func setCookie(c *gin.Context, name, value string, maxAge int, httpOnly bool) {
+  http.SetCookie(c.Writer, &http.Cookie{
+    Name:     name,
+    Value:    value,
+    Path:     Cookies.Path,
+    Domain:   Cookies.Domain,
+    MaxAge:   maxAge,
+    Secure:   Cookies.Secure,
+    HttpOnly: httpOnly,
+    SameSite: Cookies.SameSite,
+  })
+}
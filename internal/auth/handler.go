@@ -0,0 +1,35 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package auth
+
+import (
+  "net/http"
+
+  "github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+  service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+  handler := new(Handler)
+  handler.service = service
+  return handler
+}
+
+// JWKS publishes the public signing keys so other services can verify the
+// access tokens without the shared secret.
+func (h *Handler) JWKS(c *gin.Context) {
+  if Keys == nil {
+    c.JSON(http.StatusOK, JWKSet{Keys: []JWK{}})
+    return
+  }
+
+  c.Header("Cache-Control", "public, max-age=300")
+  c.JSON(http.StatusOK, Keys.JWKS())
+}
@@ -12,43 +12,64 @@ import (
   "time"
 
   "github.com/golang-jwt/jwt/v5"
+  "github.com/google/uuid"
 )
 
 var JwtKey []byte
 
+// AccessTokenTTL is the lifetime of the access tokens issued by GenerateJWT.
+// Access tokens are short-lived, clients renew them with a refresh token.
+var AccessTokenTTL = 15 * time.Minute
+
 type Claims struct {
-  UserID string `json:"user_id"`
-  Email  string `json:"email"`
-  Role   string `json:"role"`
+  UserID  string `json:"user_id"`
+  Email   string `json:"email"`
+  Role    string `json:"role"`
+  Version int    `json:"ver"`
+  MFA     bool   `json:"mfa,omitempty"`
   jwt.RegisteredClaims
 }
 
-func GenerateJWT(userID, email, role string) (string, error) {
+func GenerateJWT(userID, email, role string, version int, mfa bool) (string, *Claims, error) {
+  now := time.Now()
   claims := &Claims{
-    UserID: userID,
-    Email:  email,
-    Role:   role,
+    UserID:  userID,
+    Email:   email,
+    Role:    role,
+    Version: version,
+    MFA:     mfa,
     RegisteredClaims: jwt.RegisteredClaims{
-      ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-      IssuedAt:  jwt.NewNumericDate(time.Now()),
+      ID:        uuid.NewString(),
+      ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+      IssuedAt:  jwt.NewNumericDate(now),
     },
   }
 
-  token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-  return token.SignedString(JwtKey)
+  var signed string
+  var err error
+
+  if Keys != nil {
+    key := Keys.Active()
+    token := jwt.NewWithClaims(key.Method, claims)
+    token.Header["kid"] = key.ID
+    signed, err = token.SignedString(key.Private)
+  } else {
+    token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+    signed, err = token.SignedString(JwtKey)
+  }
+
+  if err != nil {
+    return "", nil, err
+  }
+
+  return signed, claims, nil
 }
 
 func ParseJWT(tokenStr string) (*Claims, error) {
   claims := new(Claims)
 
   parser := jwt.NewParser(jwt.WithLeeway(10 * time.Second))
-  token, err := parser.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (any, error) {
-    if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-      return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-    }
-    return JwtKey, nil
-  })
-
+  token, err := parser.ParseWithClaims(tokenStr, claims, verificationKey)
   if err != nil || !token.Valid {
     return nil, errors.New("invalid JWT")
   }
@@ -59,3 +80,34 @@ func ParseJWT(tokenStr string) (*Claims, error) {
 
   return claims, nil
 }
+
+// verificationKey picks the key for the token: asymmetric keys are looked up
+// by the "kid" header, HS256 tokens fall back to the shared secret.
+func verificationKey(token *jwt.Token) (any, error) {
+  if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+    if token.Method != jwt.SigningMethodHS256 || len(JwtKey) == 0 {
+      return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+    }
+    return JwtKey, nil
+  }
+
+  if Keys == nil {
+    return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+  }
+
+  kid, ok := token.Header["kid"].(string)
+  if !ok || kid == "" {
+    return nil, errors.New("missing key id")
+  }
+
+  key, err := Keys.Lookup(kid)
+  if err != nil {
+    return nil, err
+  }
+
+  if token.Method.Alg() != key.Method.Alg() {
+    return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+  }
+
+  return key.Public, nil
+}
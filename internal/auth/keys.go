@@ -0,0 +1,287 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package auth
+
+import (
+  "crypto"
+  "crypto/ed25519"
+  "crypto/rsa"
+  "crypto/x509"
+  "encoding/base64"
+  "encoding/pem"
+  "errors"
+  "fmt"
+  "log"
+  "math/big"
+  "os"
+  "path/filepath"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/golang-jwt/jwt/v5"
+)
+
+var (
+  ErrNoSigningKeys   = errors.New("no signing keys found")
+  ErrUnknownKeyID    = errors.New("unknown signing key id")
+  ErrUnsupportedKey  = errors.New("unsupported key type")
+  ErrActiveKeyNoPriv = errors.New("active signing key has no private key")
+)
+
+// Keys holds the asymmetric signing keys. When nil, tokens are signed with
+// the HS256 secret in JwtKey.
+var Keys *KeySet
+
+// SigningKey is a key loaded from the keys directory. The key id (kid) is the
+// file name without the ".pem" extension. Public-only keys can verify tokens
+// but never sign them.
+type SigningKey struct {
+  ID        string
+  Method    jwt.SigningMethod
+  Private   crypto.Signer
+  Public    crypto.PublicKey
+  RetiredAt time.Time
+  ExpiresAt time.Time
+}
+
+// KeySet is the set of signing keys loaded from a directory of PEM files. One
+// key is active and signs the new tokens, the others are only used to verify
+// tokens issued before a rotation. A key that stops being active and is
+// removed from the directory is still accepted until every token it signed
+// has expired. The retirement times are only kept in memory: after a
+// restart a removed key is no longer accepted, so keep the file of a retired
+// key until the tokens it signed have expired.
+type KeySet struct {
+  mtx      sync.RWMutex
+  dir      string
+  activeID string
+  keys     map[string]*SigningKey
+}
+
+// JWK is the public part of a signing key as served by the JWKS endpoint.
+type JWK struct {
+  Kty string `json:"kty"`
+  Use string `json:"use"`
+  Alg string `json:"alg"`
+  Kid string `json:"kid"`
+  N   string `json:"n,omitempty"`
+  E   string `json:"e,omitempty"`
+  Crv string `json:"crv,omitempty"`
+  X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+  Keys []JWK `json:"keys"`
+}
+
+// LoadKeySet loads the keys from dir. activeKID selects the signing key, if
+// empty the most recently modified private key is used.
+func LoadKeySet(dir, activeKID string) (*KeySet, error) {
+  ks := new(KeySet)
+  ks.dir = dir
+  ks.keys = make(map[string]*SigningKey)
+
+  if err := ks.Reload(activeKID); err != nil {
+    return nil, err
+  }
+
+  return ks, nil
+}
+
+// Reload re-reads the keys directory and selects activeKID as the signing
+// key, or the most recently modified private key if empty. This is how keys
+// are rotated: add the new key file (and point activeKID at it) then reload.
+func (ks *KeySet) Reload(activeKID string) error {
+  entries, err := os.ReadDir(ks.dir)
+  if err != nil {
+    return err
+  }
+
+  loaded := make(map[string]*SigningKey)
+  var newestID string
+  var newestMod time.Time
+
+  for _, entry := range entries {
+    if entry.IsDir() || filepath.Ext(entry.Name()) != ".pem" {
+      continue
+    }
+
+    kid := strings.TrimSuffix(entry.Name(), ".pem")
+    key, err := loadSigningKey(filepath.Join(ks.dir, entry.Name()), kid)
+    if err != nil {
+      return fmt.Errorf("key %s: %w", entry.Name(), err)
+    }
+    loaded[kid] = key
+
+    info, err := entry.Info()
+    if err != nil {
+      return err
+    }
+
+    if key.Private != nil && info.ModTime().After(newestMod) {
+      newestID = kid
+      newestMod = info.ModTime()
+    }
+  }
+
+  activeID := activeKID
+  if activeID == "" {
+    activeID = newestID
+  }
+
+  if activeID == "" {
+    return ErrNoSigningKeys
+  }
+
+  active, ok := loaded[activeID]
+  if !ok {
+    return fmt.Errorf("%w: %s", ErrUnknownKeyID, activeID)
+  }
+  if active.Private == nil {
+    return ErrActiveKeyNoPriv
+  }
+
+  ks.mtx.Lock()
+  defer ks.mtx.Unlock()
+
+  now := time.Now()
+  for kid, old := range ks.keys {
+    if kid == ks.activeID && kid != activeID {
+      old.RetiredAt = now
+    }
+
+    if _, ok := loaded[kid]; ok {
+      loaded[kid].RetiredAt = old.RetiredAt
+      continue
+    }
+
+    // removed from disk, keep it while the tokens it signed are still valid
+    if !old.RetiredAt.IsZero() && now.Before(old.RetiredAt.Add(AccessTokenTTL)) {
+      old.ExpiresAt = old.RetiredAt.Add(AccessTokenTTL)
+      loaded[kid] = old
+    }
+  }
+
+  if ks.activeID != "" && ks.activeID != activeID {
+    log.Printf("auth: signing key rotated(%s -> %s)\n", ks.activeID, activeID)
+  }
+
+  ks.keys = loaded
+  ks.activeID = activeID
+
+  return nil
+}
+
+func (ks *KeySet) Active() *SigningKey {
+  ks.mtx.RLock()
+  defer ks.mtx.RUnlock()
+
+  return ks.keys[ks.activeID]
+}
+
+func (ks *KeySet) Lookup(kid string) (*SigningKey, error) {
+  ks.mtx.RLock()
+  defer ks.mtx.RUnlock()
+
+  key, ok := ks.keys[kid]
+  if !ok || key.expired() {
+    return nil, ErrUnknownKeyID
+  }
+
+  return key, nil
+}
+
+func (ks *KeySet) JWKS() JWKSet {
+  ks.mtx.RLock()
+  defer ks.mtx.RUnlock()
+
+  set := JWKSet{Keys: []JWK{}}
+  for _, key := range ks.keys {
+    if !key.expired() {
+      set.Keys = append(set.Keys, key.JWK())
+    }
+  }
+
+  return set
+}
+
+func (k *SigningKey) JWK() JWK {
+  jwk := JWK{
+    Use: "sig",
+    Alg: k.Method.Alg(),
+    Kid: k.ID,
+  }
+
+  switch pub := k.Public.(type) {
+  case *rsa.PublicKey:
+    jwk.Kty = "RSA"
+    jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+    jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+  case ed25519.PublicKey:
+    jwk.Kty = "OKP"
+    jwk.Crv = "Ed25519"
+    jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+  }
+
+  return jwk
+}
+
+func (k *SigningKey) expired() bool {
+  return !k.ExpiresAt.IsZero() && time.Now().After(k.ExpiresAt)
+}
+
+func loadSigningKey(path, kid string) (*SigningKey, error) {
+  data, err := os.ReadFile(path)
+  if err != nil {
+    return nil, err
+  }
+
+  block, _ := pem.Decode(data)
+  if block == nil {
+    return nil, errors.New("invalid PEM data")
+  }
+
+  var parsed any
+  switch block.Type {
+  case "PRIVATE KEY":
+    parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+  case "RSA PRIVATE KEY":
+    parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+  case "PUBLIC KEY":
+    parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+  default:
+    return nil, fmt.Errorf("%w: %s", ErrUnsupportedKey, block.Type)
+  }
+
+  if err != nil {
+    return nil, err
+  }
+
+  key := &SigningKey{ID: kid}
+
+  switch k := parsed.(type) {
+  case *rsa.PrivateKey:
+    key.Method = jwt.SigningMethodRS256
+    key.Private = k
+    key.Public = &k.PublicKey
+  case *rsa.PublicKey:
+    key.Method = jwt.SigningMethodRS256
+    key.Public = k
+  case ed25519.PrivateKey:
+    key.Method = jwt.SigningMethodEdDSA
+    key.Private = k
+    key.Public = k.Public()
+  case ed25519.PublicKey:
+    key.Method = jwt.SigningMethodEdDSA
+    key.Public = k
+  default:
+    return nil, ErrUnsupportedKey
+  }
+
+  return key, nil
+}
@@ -7,6 +7,7 @@
 package auth
 
 import (
+  "context"
   "errors"
   "log"
   "net/http"
@@ -19,34 +20,179 @@ const (
   ContextUserID = "user_id"
   ContextEmail  = "email"
   ContextRole   = "role"
+  ContextClaims = "claims"
+  ContextScopes = "scopes"
+  ContextCookie = "cookie_session"
 )
 
+// Scopes of the personal access tokens. Sessions (JWT) are not restricted.
+const (
+  ScopeChallengesRead = "challenges:read"
+  ScopeSubmit         = "submit"
+  ScopeAdminRead      = "admin:read"
+)
+
+// PersonalTokenPrefix marks a bearer token as a personal access token.
+const PersonalTokenPrefix = "ctfxd_pat_"
+
+// PersonalTokenAuthenticator resolves a personal access token to the claims
+// of its owner and the scopes granted to the token.
+type PersonalTokenAuthenticator interface {
+  AuthenticateToken(ctx context.Context, token, clientIP string) (*Claims, []string, error)
+}
+
+// PersonalTokens is used by AuthMiddleware for personal access tokens, if set.
+var PersonalTokens PersonalTokenAuthenticator
+
+// RevocationChecker reports whether an otherwise valid access token has been
+// revoked (logout, password change, ban...).
+type RevocationChecker interface {
+  IsRevoked(ctx context.Context, claims *Claims) (bool, error)
+}
+
+// Revocations is consulted by AuthMiddleware for every request, if set.
+var Revocations RevocationChecker
+
+var ErrAccountDisabled = errors.New("account disabled")
+
+// AccountChecker returns an error wrapping ErrAccountDisabled when the
+// account may not use the API anymore (banned, deleted...).
+type AccountChecker interface {
+  CheckAccount(ctx context.Context, userID string) error
+}
+
+// Accounts is consulted by AuthMiddleware for every request, if set.
+var Accounts AccountChecker
+
 func AuthMiddleware() gin.HandlerFunc {
   return func(c *gin.Context) {
-    token, err := extractJWT(c)
-    if err != nil {
-      log.Printf("JWT validation failed: %v\n", err)
-      c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+    session, authErr := authenticate(c)
+    if authErr != nil {
+      c.AbortWithStatusJSON(authErr.status, gin.H{"error": authErr.message})
       return
     }
 
-    claims, err := validateJWT(token)
-    if err != nil {
-      log.Printf("JWT validation failed: %v\n", err)
-      c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+    session.set(c)
+    c.Next()
+  }
+}
+
+// OptionalAuthMiddleware authenticates the requests carrying a token like
+// AuthMiddleware, the others go through anonymously. So do the requests with
+// a stale token (expired, revoked...): the routes are public, a signed URL
+// grants access by itself. The session cookies of such a token are cleared.
+func OptionalAuthMiddleware() gin.HandlerFunc {
+  return func(c *gin.Context) {
+    if _, _, err := extractJWT(c); err != nil && c.GetHeader("Authorization") == "" {
+      c.Next()
+      return
+    }
+
+    session, authErr := authenticate(c)
+    if authErr == nil {
+      session.set(c)
+      c.Next()
+      return
+    }
+
+    if authErr.status == http.StatusInternalServerError {
+      c.AbortWithStatusJSON(authErr.status, gin.H{"error": authErr.message})
       return
     }
 
-    setUserContext(c, claims)
+    if session.fromCookie {
+      // the refresh cookie may still renew a merely invalid access token
+      if authErr.revoked {
+        ClearSessionCookies(c)
+      } else if authErr.status == http.StatusUnauthorized {
+        setCookie(c, AccessCookieName, "", -1, true)
+      }
+    }
 
     c.Next()
   }
 }
 
+// session is the result of an authentication.
+type session struct {
+  claims     *Claims
+  fromCookie bool
+  // set for a personal access token
+  scopes   []string
+  personal bool
+}
+
+func (s *session) set(c *gin.Context) {
+  setUserContext(c, s.claims)
+  if s.personal {
+    c.Set(ContextScopes, s.scopes)
+  }
+  if s.fromCookie {
+    c.Set(ContextCookie, true)
+  }
+}
+
+// authError is a failed authentication and the status answering it.
+type authError struct {
+  status  int
+  message string
+  // the whole session is dead (revoked token, disabled account), not only
+  // the access token
+  revoked bool
+}
+
+// authenticate checks the token of the request, the returned session tells
+// where the token came from even on failure.
+func authenticate(c *gin.Context) (*session, *authError) {
+  token, fromCookie, err := extractJWT(c)
+  session := &session{fromCookie: fromCookie}
+  if err != nil {
+    log.Printf("JWT validation failed: %v\n", err)
+    return session, &authError{status: http.StatusUnauthorized, message: err.Error()}
+  }
+
+  // the browser sends the cookie along with any request, the CSRF token
+  // proves the request comes from the frontend
+  if fromCookie && !isSafeMethod(c.Request.Method) {
+    if err := VerifyCSRF(c); err != nil {
+      return session, &authError{status: http.StatusForbidden, message: err.Error()}
+    }
+  }
+
+  if !fromCookie && strings.HasPrefix(token, PersonalTokenPrefix) {
+    return authenticatePersonalToken(c, token)
+  }
+
+  claims, err := validateJWT(token)
+  if err != nil {
+    log.Printf("JWT validation failed: %v\n", err)
+    return session, &authError{status: http.StatusUnauthorized, message: err.Error()}
+  }
+
+  if Revocations != nil {
+    revoked, err := Revocations.IsRevoked(c.Request.Context(), claims)
+    if err != nil {
+      log.Printf("JWT revocation check failed: %v\n", err)
+      return session, &authError{status: http.StatusInternalServerError, message: "failed to validate token"}
+    }
+
+    if revoked {
+      return session, &authError{status: http.StatusUnauthorized, message: "Token has been revoked", revoked: true}
+    }
+  }
+
+  if authErr := checkAccount(c, claims); authErr != nil {
+    return session, authErr
+  }
+
+  session.claims = claims
+  return session, nil
+}
+
 func AdminMiddleware() gin.HandlerFunc {
   return func(c *gin.Context) {
     role := GetUserRole(c)
-    if role == "" || role != "admin" {
+    if role == "" || role != RoleAdmin {
       c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin privilege not found"})
       return
     }
@@ -55,6 +201,39 @@ func AdminMiddleware() gin.HandlerFunc {
   }
 }
 
+// RequireScope rejects personal access tokens without the scope. Requests
+// authenticated with a session token are not restricted.
+func RequireScope(scope string) gin.HandlerFunc {
+  return func(c *gin.Context) {
+    val, exists := c.Get(ContextScopes)
+    if !exists {
+      c.Next()
+      return
+    }
+
+    for _, s := range val.([]string) {
+      if s == scope {
+        c.Next()
+        return
+      }
+    }
+
+    c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "token scope \"" + scope + "\" required"})
+  }
+}
+
+// SessionOnly rejects requests authenticated with a personal access token.
+func SessionOnly() gin.HandlerFunc {
+  return func(c *gin.Context) {
+    if _, exists := c.Get(ContextScopes); exists {
+      c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "not allowed with a personal access token"})
+      return
+    }
+
+    c.Next()
+  }
+}
+
 func GetUserID(c *gin.Context) string {
   val, exists := c.Get(ContextUserID)
   if !exists {
@@ -82,18 +261,79 @@ func GetUserRole(c *gin.Context) string {
   return val.(string)
 }
 
-func extractJWT(c *gin.Context) (string, error) {
+// IsCookieSession reports whether the request was authenticated by the
+// session cookie.
+func IsCookieSession(c *gin.Context) bool {
+  return c.GetBool(ContextCookie)
+}
+
+func GetClaims(c *gin.Context) *Claims {
+  val, exists := c.Get(ContextClaims)
+  if !exists {
+    return nil
+  }
+
+  return val.(*Claims)
+}
+
+func authenticatePersonalToken(c *gin.Context, token string) (*session, *authError) {
+  session := &session{personal: true}
+  if PersonalTokens == nil {
+    return session, &authError{status: http.StatusUnauthorized, message: "Invalid or expired token"}
+  }
+
+  claims, scopes, err := PersonalTokens.AuthenticateToken(c.Request.Context(), token, c.ClientIP())
+  if err != nil {
+    log.Printf("personal token validation failed: %v\n", err)
+    return session, &authError{status: http.StatusUnauthorized, message: "Invalid or expired token"}
+  }
+
+  if authErr := checkAccount(c, claims); authErr != nil {
+    return session, authErr
+  }
+
+  session.claims, session.scopes = claims, scopes
+  return session, nil
+}
+
+func checkAccount(c *gin.Context, claims *Claims) *authError {
+  if Accounts == nil {
+    return nil
+  }
+
+  err := Accounts.CheckAccount(c.Request.Context(), claims.UserID)
+  if err == nil {
+    return nil
+  }
+
+  if errors.Is(err, ErrAccountDisabled) {
+    return &authError{status: http.StatusForbidden, message: err.Error(), revoked: true}
+  }
+
+  log.Printf("account check failed: %v\n", err)
+  return &authError{status: http.StatusInternalServerError, message: "failed to validate account"}
+}
+
+// extractJWT returns the bearer token, or else the token of the session
+// cookie (fromCookie).
+func extractJWT(c *gin.Context) (token string, fromCookie bool, err error) {
   authHeader := c.GetHeader("Authorization")
   if authHeader == "" {
-    return "", errors.New("Missing auth token")
+    if Cookies.Enabled {
+      if cookie, err := c.Cookie(AccessCookieName); err == nil && cookie != "" {
+        return cookie, true, nil
+      }
+    }
+
+    return "", false, errors.New("Missing auth token")
   }
 
   headerParts := strings.Split(authHeader, " ")
   if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-    return "", errors.New("Invalid auth header")
+    return "", false, errors.New("Invalid auth header")
   }
 
-  return headerParts[1], nil
+  return headerParts[1], false, nil
 }
 
 func validateJWT(token string) (*Claims, error) {
@@ -109,4 +349,5 @@ func setUserContext(c *gin.Context, claims *Claims) {
   c.Set(ContextUserID, claims.UserID)
   c.Set(ContextEmail, claims.Email)
   c.Set(ContextRole, claims.Role)
+  c.Set(ContextClaims, claims)
 }
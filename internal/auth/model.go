@@ -0,0 +1,48 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package auth
+
+import (
+  "time"
+
+  "go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// RefreshToken is the server-side record of an issued refresh token. Only
+// the SHA-256 hash of the token is stored. Every rotation creates a new token
+// in the same family, so reusing an already rotated token revokes the family.
+type RefreshToken struct {
+  ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+  Hash      string        `bson:"hash" json:"-"`
+  UserID    string        `bson:"user_id" json:"user_id"`
+  FamilyID  string        `bson:"family_id" json:"family_id"`
+  CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+  ExpiresAt time.Time     `bson:"expires_at" json:"expires_at"`
+  UsedAt    *time.Time    `bson:"used_at,omitempty" json:"used_at,omitempty"`
+  Revoked   bool          `bson:"revoked" json:"revoked"`
+  MFA       bool          `bson:"mfa,omitempty" json:"mfa,omitempty"`
+}
+
+// RevokedToken is an access token (by its jti) that must not be accepted
+// anymore, kept until the token would have expired anyway.
+type RevokedToken struct {
+  JTI       string    `bson:"_id" json:"jti"`
+  ExpiresAt time.Time `bson:"expires_at" json:"expires_at"`
+}
+
+// TokenVersion is the per-user token version. Bumping it invalidates every
+// access token issued to the user before.
+type TokenVersion struct {
+  UserID  string `bson:"_id" json:"user_id"`
+  Version int    `bson:"version" json:"version"`
+}
+
+type TokenPair struct {
+  AccessToken  string `json:"token"`
+  RefreshToken string `json:"refresh_token"`
+  ExpiresIn    int64  `json:"expires_in"`
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package auth
+
+import (
+  "net/http"
+
+  "github.com/gin-gonic/gin"
+)
+
+const (
+  RoleUser      = "user"
+  RoleAuthor    = "author"
+  RoleModerator = "moderator"
+  RoleAdmin     = "admin"
+)
+
+type Permission string
+
+const (
+  // manage (create, edit, delete, read flags of) the challenges owned by the user
+  PermManageOwnChallenges Permission = "challenges:manage_own"
+  // manage every challenge, regardless of the owner
+  PermManageChallenges Permission = "challenges:manage"
+  // manage the regular user accounts
+  PermManageUsers Permission = "users:manage"
+  // create privileged accounts and change roles
+  PermManageRoles Permission = "users:manage_roles"
+  // change the platform settings (registration...)
+  PermManageSettings Permission = "settings:manage"
+  // read and export the audit log
+  PermViewAudit Permission = "audit:read"
+)
+
+var rolePermissions = map[string][]Permission{
+  RoleUser:      {},
+  RoleAuthor:    {PermManageOwnChallenges},
+  RoleModerator: {PermManageUsers},
+  RoleAdmin: {
+    PermManageOwnChallenges,
+    PermManageChallenges,
+    PermManageUsers,
+    PermManageRoles,
+    PermManageSettings,
+    PermViewAudit,
+  },
+}
+
+func IsValidRole(role string) bool {
+  _, ok := rolePermissions[role]
+  return ok
+}
+
+// IsPrivilegedRole reports whether the role has any permission at all.
+func IsPrivilegedRole(role string) bool {
+  return len(rolePermissions[role]) > 0
+}
+
+func HasPermission(role string, perm Permission) bool {
+  for _, p := range rolePermissions[role] {
+    if p == perm {
+      return true
+    }
+  }
+
+  return false
+}
+
+// RequireAdminMFA makes two-factor authentication mandatory for the admin
+// role: admin permissions are only granted to sessions opened with 2FA.
+var RequireAdminMFA bool
+
+// RequirePermission lets the request through if the role of the user grants
+// at least one of the permissions.
+func RequirePermission(perms ...Permission) gin.HandlerFunc {
+  return func(c *gin.Context) {
+    role := GetUserRole(c)
+    if RequireAdminMFA && role == RoleAdmin {
+      claims := GetClaims(c)
+      if claims == nil || !claims.MFA {
+        c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "two-factor authentication required"})
+        return
+      }
+    }
+
+    for _, perm := range perms {
+      if HasPermission(role, perm) {
+        c.Next()
+        return
+      }
+    }
+
+    c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "permission denied"})
+  }
+}
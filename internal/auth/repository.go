@@ -0,0 +1,139 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package auth
+
+import (
+  "context"
+  "errors"
+  "log"
+  "time"
+
+  "go.mongodb.org/mongo-driver/v2/bson"
+  "go.mongodb.org/mongo-driver/v2/mongo"
+  "go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type Repository struct {
+  refreshTokens *mongo.Collection
+  revokedTokens *mongo.Collection
+  tokenVersions *mongo.Collection
+}
+
+func NewRepository(db *mongo.Database) *Repository {
+  repo := new(Repository)
+  repo.refreshTokens = db.Collection("refresh_tokens")
+  repo.revokedTokens = db.Collection("revoked_tokens")
+  repo.tokenVersions = db.Collection("token_versions")
+
+  repo.ensureIndexes()
+
+  return repo
+}
+
+// ensureIndexes lets mongodb drop the expired tokens by itself
+func (r *Repository) ensureIndexes() {
+  ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+  defer cancel()
+
+  ttl := options.Index().SetExpireAfterSeconds(0)
+
+  _, err := r.refreshTokens.Indexes().CreateMany(ctx, []mongo.IndexModel{
+    {Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+    {Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: ttl},
+  })
+  if err != nil {
+    log.Printf("auth: error: refresh token indexes(%v)\n", err)
+  }
+
+  _, err = r.revokedTokens.Indexes().CreateOne(ctx, mongo.IndexModel{
+    Keys:    bson.D{{Key: "expires_at", Value: 1}},
+    Options: ttl,
+  })
+  if err != nil {
+    log.Printf("auth: error: revoked token indexes(%v)\n", err)
+  }
+}
+
+func (r *Repository) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
+  _, err := r.refreshTokens.InsertOne(ctx, token)
+
+  return err
+}
+
+func (r *Repository) GetRefreshTokenByHash(ctx context.Context, hash string) (*RefreshToken, error) {
+  token := new(RefreshToken)
+
+  err := r.refreshTokens.FindOne(ctx, bson.M{"hash": hash}).Decode(token)
+  if err != nil {
+    return nil, err
+  }
+
+  return token, nil
+}
+
+// MarkRefreshTokenUsed atomically marks the token as rotated. It reports
+// false when the token was already used (or revoked) by someone else.
+func (r *Repository) MarkRefreshTokenUsed(ctx context.Context, id bson.ObjectID, usedAt time.Time) (bool, error) {
+  filter := bson.M{"_id": id, "used_at": bson.M{"$exists": false}, "revoked": false}
+
+  result, err := r.refreshTokens.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"used_at": usedAt}})
+  if err != nil {
+    return false, err
+  }
+
+  return result.ModifiedCount == 1, nil
+}
+
+func (r *Repository) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+  _, err := r.refreshTokens.UpdateMany(ctx, bson.M{"family_id": familyID}, bson.M{"$set": bson.M{"revoked": true}})
+
+  return err
+}
+
+func (r *Repository) RevokeUserRefreshTokens(ctx context.Context, userID string) error {
+  _, err := r.refreshTokens.UpdateMany(ctx, bson.M{"user_id": userID}, bson.M{"$set": bson.M{"revoked": true}})
+
+  return err
+}
+
+func (r *Repository) RevokeAccessToken(ctx context.Context, token *RevokedToken) error {
+  opts := options.UpdateOne().SetUpsert(true)
+  _, err := r.revokedTokens.UpdateOne(ctx, bson.M{"_id": token.JTI}, bson.M{"$set": bson.M{"expires_at": token.ExpiresAt}}, opts)
+
+  return err
+}
+
+func (r *Repository) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+  count, err := r.revokedTokens.CountDocuments(ctx, bson.M{"_id": jti})
+  if err != nil {
+    return false, err
+  }
+
+  return count > 0, nil
+}
+
+func (r *Repository) GetTokenVersion(ctx context.Context, userID string) (int, error) {
+  version := new(TokenVersion)
+
+  err := r.tokenVersions.FindOne(ctx, bson.M{"_id": userID}).Decode(version)
+  if err != nil {
+    if errors.Is(err, mongo.ErrNoDocuments) {
+      return 0, nil
+    }
+
+    return 0, err
+  }
+
+  return version.Version, nil
+}
+
+func (r *Repository) IncrementTokenVersion(ctx context.Context, userID string) error {
+  opts := options.UpdateOne().SetUpsert(true)
+  _, err := r.tokenVersions.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$inc": bson.M{"version": 1}}, opts)
+
+  return err
+}
@@ -0,0 +1,196 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package auth
+
+import (
+  "context"
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/hex"
+  "errors"
+  "time"
+
+  "github.com/google/uuid"
+  "go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// RefreshTokenTTL is the lifetime of a refresh token, every rotation issues
+// a new token with a fresh lifetime.
+var RefreshTokenTTL = 7 * 24 * time.Hour
+
+var (
+  ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+  ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+type Service struct {
+  repo *Repository
+}
+
+func NewService(repo *Repository) *Service {
+  serv := new(Service)
+  serv.repo = repo
+
+  return serv
+}
+
+// IssueTokens creates a new access token and refresh token for the user. An
+// empty familyID starts a new refresh token family (a new login). mfa tells
+// whether the session was opened with a second factor.
+func (s *Service) IssueTokens(ctx context.Context, userID, email, role, familyID string, mfa bool) (*TokenPair, error) {
+  version, err := s.repo.GetTokenVersion(ctx, userID)
+  if err != nil {
+    return nil, err
+  }
+
+  accessToken, _, err := GenerateJWT(userID, email, role, version, mfa)
+  if err != nil {
+    return nil, err
+  }
+
+  refreshToken, err := generateOpaqueToken()
+  if err != nil {
+    return nil, err
+  }
+
+  if familyID == "" {
+    familyID = uuid.NewString()
+  }
+
+  now := time.Now().UTC()
+  err = s.repo.CreateRefreshToken(ctx, &RefreshToken{
+    Hash:      HashToken(refreshToken),
+    UserID:    userID,
+    FamilyID:  familyID,
+    CreatedAt: now,
+    ExpiresAt: now.Add(RefreshTokenTTL),
+    MFA:       mfa,
+  })
+  if err != nil {
+    return nil, err
+  }
+
+  return &TokenPair{
+    AccessToken:  accessToken,
+    RefreshToken: refreshToken,
+    ExpiresIn:    int64(AccessTokenTTL.Seconds()),
+  }, nil
+}
+
+// ConsumeRefreshToken validates the refresh token and marks it as used, the
+// caller is expected to issue a new pair in the returned token's family. A
+// token presented a second time revokes the whole family, since either the
+// legitimate client or an attacker holds a stolen copy.
+func (s *Service) ConsumeRefreshToken(ctx context.Context, token string) (*RefreshToken, error) {
+  record, err := s.repo.GetRefreshTokenByHash(ctx, HashToken(token))
+  if err != nil {
+    if errors.Is(err, mongo.ErrNoDocuments) {
+      return nil, ErrInvalidRefreshToken
+    }
+
+    return nil, err
+  }
+
+  if record.Revoked || time.Now().After(record.ExpiresAt) {
+    return nil, ErrInvalidRefreshToken
+  }
+
+  if record.UsedAt != nil {
+    if err := s.repo.RevokeRefreshTokenFamily(ctx, record.FamilyID); err != nil {
+      return nil, err
+    }
+
+    return nil, ErrRefreshTokenReused
+  }
+
+  ok, err := s.repo.MarkRefreshTokenUsed(ctx, record.ID, time.Now().UTC())
+  if err != nil {
+    return nil, err
+  }
+
+  if !ok {
+    // lost the race against another rotation with the same token
+    if err := s.repo.RevokeRefreshTokenFamily(ctx, record.FamilyID); err != nil {
+      return nil, err
+    }
+
+    return nil, ErrRefreshTokenReused
+  }
+
+  return record, nil
+}
+
+// Logout revokes the access token described by claims and, when given, the
+// refresh token family of the session.
+func (s *Service) Logout(ctx context.Context, claims *Claims, refreshToken string) error {
+  if claims != nil && claims.ID != "" {
+    err := s.repo.RevokeAccessToken(ctx, &RevokedToken{
+      JTI:       claims.ID,
+      ExpiresAt: claims.ExpiresAt.Time,
+    })
+    if err != nil {
+      return err
+    }
+  }
+
+  if refreshToken == "" {
+    return nil
+  }
+
+  record, err := s.repo.GetRefreshTokenByHash(ctx, HashToken(refreshToken))
+  if err != nil {
+    if errors.Is(err, mongo.ErrNoDocuments) {
+      return nil
+    }
+
+    return err
+  }
+
+  if claims != nil && record.UserID != claims.UserID {
+    return ErrInvalidRefreshToken
+  }
+
+  return s.repo.RevokeRefreshTokenFamily(ctx, record.FamilyID)
+}
+
+// RevokeUser invalidates every access and refresh token issued to the user.
+func (s *Service) RevokeUser(ctx context.Context, userID string) error {
+  if err := s.repo.IncrementTokenVersion(ctx, userID); err != nil {
+    return err
+  }
+
+  return s.repo.RevokeUserRefreshTokens(ctx, userID)
+}
+
+func (s *Service) IsRevoked(ctx context.Context, claims *Claims) (bool, error) {
+  revoked, err := s.repo.IsAccessTokenRevoked(ctx, claims.ID)
+  if err != nil || revoked {
+    return revoked, err
+  }
+
+  version, err := s.repo.GetTokenVersion(ctx, claims.UserID)
+  if err != nil {
+    return false, err
+  }
+
+  return claims.Version != version, nil
+}
+
+func HashToken(token string) string {
+  sum := sha256.Sum256([]byte(token))
+  return hex.EncodeToString(sum[:])
+}
+
+func generateOpaqueToken() (string, error) {
+  buf := make([]byte, 32)
+  if _, err := rand.Read(buf); err != nil {
+    return "", err
+  }
+
+  return base64.RawURLEncoding.EncodeToString(buf), nil
+}
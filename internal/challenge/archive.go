@@ -0,0 +1,199 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package challenge
+
+import (
+  "archive/tar"
+  "archive/zip"
+  "compress/gzip"
+  "context"
+  "errors"
+  "io"
+  "path"
+  "strconv"
+  "strings"
+
+  "github.com/CTFxd/ctfxd-server/pkg/storage"
+)
+
+// Formats of the archives of the challenge files.
+const (
+  ArchiveZip   = "zip"
+  ArchiveTarGz = "tar.gz"
+)
+
+var (
+  ErrNoFiles            = errors.New("challenge has no files")
+  ErrUnsupportedArchive = errors.New("unsupported archive format")
+)
+
+// Archive streams the files of a challenge in a single archive, their
+// content is only read while the archive is written.
+type Archive struct {
+  format string
+  files  []archiveFile
+}
+
+type archiveFile struct {
+  name string
+  meta *FileMeta
+  r    *storage.ReadSeeker
+}
+
+// OpenArchive opens every file of the challenge, so a missing one fails
+// before anything is written. The caller closes the archive.
+func (s *Service) OpenArchive(ctx context.Context, challenge *Challenge, format string) (*Archive, error) {
+  if format != ArchiveZip && format != ArchiveTarGz {
+    return nil, ErrUnsupportedArchive
+  }
+  if len(challenge.Files) == 0 {
+    return nil, ErrNoFiles
+  }
+
+  archive := &Archive{format: format}
+  names := archiveNames(challenge.Files)
+  for i := range challenge.Files {
+    r, err := s.fileService.OpenFile(ctx, &challenge.Files[i])
+    if err != nil {
+      archive.Close()
+      return nil, err
+    }
+    archive.files = append(archive.files, archiveFile{name: names[i], meta: &challenge.Files[i], r: r})
+  }
+
+  return archive, nil
+}
+
+func (a *Archive) WriteTo(w io.Writer) (int64, error) {
+  cw := &countingWriter{w: w}
+
+  var err error
+  if a.format == ArchiveZip {
+    err = a.writeZip(cw)
+  } else {
+    err = a.writeTarGz(cw)
+  }
+
+  return cw.n, err
+}
+
+func (a *Archive) writeZip(w io.Writer) error {
+  zw := zip.NewWriter(w)
+  for _, file := range a.files {
+    header := &zip.FileHeader{
+      Name:               file.name,
+      Method:             zip.Deflate,
+      Modified:           file.meta.UploadedAt,
+      UncompressedSize64: uint64(file.r.Size()),
+    }
+    if compressed(file.meta.MimeType) {
+      header.Method = zip.Store
+    }
+    header.SetMode(0644)
+
+    fw, err := zw.CreateHeader(header)
+    if err != nil {
+      return err
+    }
+    if _, err := io.Copy(fw, file.r); err != nil {
+      return err
+    }
+  }
+
+  return zw.Close()
+}
+
+func (a *Archive) writeTarGz(w io.Writer) error {
+  gw := gzip.NewWriter(w)
+  tw := tar.NewWriter(gw)
+  for _, file := range a.files {
+    err := tw.WriteHeader(&tar.Header{
+      Typeflag: tar.TypeReg,
+      Name:     file.name,
+      Size:     file.r.Size(),
+      Mode:     0644,
+      ModTime:  file.meta.UploadedAt,
+    })
+    if err != nil {
+      return err
+    }
+    if _, err := io.Copy(tw, file.r); err != nil {
+      return err
+    }
+  }
+
+  if err := tw.Close(); err != nil {
+    return err
+  }
+
+  return gw.Close()
+}
+
+func (a *Archive) Close() error {
+  var errs []error
+  for _, file := range a.files {
+    errs = append(errs, file.r.Close())
+  }
+
+  return errors.Join(errs...)
+}
+
+// archiveNames names the files in the archive after their original names,
+// numbering the duplicates ("name (1).ext"). The names are compared ignoring
+// the case, as the filesystems they are extracted to may do.
+func archiveNames(files []FileMeta) []string {
+  taken := make(map[string]bool, len(files))
+  names := make([]string, len(files))
+
+  for i, f := range files {
+    name := path.Base(strings.ReplaceAll(f.Name, "\\", "/"))
+    if name == "." || name == ".." || name == "/" {
+      name = "file"
+    }
+
+    ext := path.Ext(name)
+    // keep the double extensions together, e.g. "dist (1).tar.gz"
+    if stem := strings.TrimSuffix(name, ext); path.Ext(stem) == ".tar" {
+      ext = ".tar" + ext
+    }
+    stem := strings.TrimSuffix(name, ext)
+
+    for n := 1; taken[strings.ToLower(name)]; n++ {
+      name = stem + " (" + strconv.Itoa(n) + ")" + ext
+    }
+    taken[strings.ToLower(name)] = true
+    names[i] = name
+  }
+
+  return names
+}
+
+// compressed tells whether deflating a content of the MIME type is a waste
+// of time.
+func compressed(mimeType string) bool {
+  mimeType, _, _ = strings.Cut(mimeType, ";")
+  switch mimeType {
+  case "application/zip", "application/gzip", "application/x-gzip", "application/x-bzip2",
+    "application/x-xz", "application/x-7z-compressed", "application/vnd.rar",
+    "application/x-rar-compressed", "application/zstd", "application/pdf":
+    return true
+  }
+
+  return strings.HasPrefix(mimeType, "image/") || strings.HasPrefix(mimeType, "audio/") ||
+    strings.HasPrefix(mimeType, "video/")
+}
+
+type countingWriter struct {
+  w io.Writer
+  n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+  n, err := cw.w.Write(p)
+  cw.n += int64(n)
+  return n, err
+}
@@ -0,0 +1,734 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package challenge
+
+import (
+  "archive/zip"
+  "bytes"
+  "context"
+  "crypto/sha256"
+  "encoding/hex"
+  "errors"
+  "fmt"
+  "io"
+  "io/fs"
+  "os"
+  "path"
+  "regexp"
+  "slices"
+  "strconv"
+  "strings"
+
+  "go.mongodb.org/mongo-driver/v2/bson"
+  "go.mongodb.org/mongo-driver/v2/mongo"
+  "gopkg.in/yaml.v3"
+)
+
+// A bundle is a directory or a zip holding a challenge.yml spec (the format of
+// ctfcli) and the files it lists, by path relative to the spec. The spec may
+// also be in the single top directory of the bundle.
+const (
+  BundleSpecName = "challenge.yml"
+  maxSpecSize    = 1 << 20
+  maxSlugLength  = 64
+)
+
+var ErrInvalidBundle = errors.New("invalid bundle")
+
+var slugRe = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// bundle states of the challenges, the visible ones have no state
+var bundleStates = []string{"visible", StateHidden, StateDraft}
+
+type BundleSpec struct {
+  // key of the import, derived from the name if missing
+  Slug        string       `yaml:"slug,omitempty"`
+  Name        string       `yaml:"name"`
+  Author      string       `yaml:"author,omitempty"`
+  Category    string       `yaml:"category,omitempty"`
+  Description string       `yaml:"description,omitempty"`
+  Value       int          `yaml:"value"`
+  Type        string       `yaml:"type,omitempty"`
+  State       string       `yaml:"state,omitempty"`
+  Flags       []BundleFlag `yaml:"flags"`
+  DecoyFlags  []string     `yaml:"decoy_flags,omitempty"`
+  Tags        []string     `yaml:"tags,omitempty"`
+  Hints       []BundleHint `yaml:"hints,omitempty"`
+  Files       []string     `yaml:"files,omitempty"`
+}
+
+// BundleFlag is a flag string, or a mapping with its type and content.
+type BundleFlag struct {
+  Type    string `yaml:"type,omitempty"`
+  Content string `yaml:"content"`
+}
+
+// BundleHint is a free hint string, or a mapping with its content and cost.
+type BundleHint struct {
+  Content string `yaml:"content"`
+  Cost    int    `yaml:"cost,omitempty"`
+}
+
+func (f *BundleFlag) UnmarshalYAML(node *yaml.Node) error {
+  if node.Kind == yaml.ScalarNode {
+    return node.Decode(&f.Content)
+  }
+
+  type plain BundleFlag
+  return node.Decode((*plain)(f))
+}
+
+func (f BundleFlag) MarshalYAML() (any, error) {
+  if f.Type == "" || f.Type == "static" {
+    return f.Content, nil
+  }
+
+  type plain BundleFlag
+  return plain(f), nil
+}
+
+func (h *BundleHint) UnmarshalYAML(node *yaml.Node) error {
+  if node.Kind == yaml.ScalarNode {
+    return node.Decode(&h.Content)
+  }
+
+  type plain BundleHint
+  return node.Decode((*plain)(h))
+}
+
+func (h BundleHint) MarshalYAML() (any, error) {
+  if h.Cost == 0 {
+    return h.Content, nil
+  }
+
+  type plain BundleHint
+  return plain(h), nil
+}
+
+type FieldError struct {
+  Field   string `json:"field"`
+  Message string `json:"message"`
+}
+
+// BundleError lists the invalid fields of a bundle spec.
+type BundleError struct {
+  Errors []FieldError
+}
+
+func (e *BundleError) Error() string {
+  msgs := make([]string, len(e.Errors))
+  for i, fe := range e.Errors {
+    msgs[i] = fe.Field + ": " + fe.Message
+  }
+
+  return "invalid bundle: " + strings.Join(msgs, "; ")
+}
+
+func (e *BundleError) Unwrap() error {
+  return ErrInvalidBundle
+}
+
+func (e *BundleError) add(field, format string, args ...any) {
+  e.Errors = append(e.Errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+type ImportOptions struct {
+  // set on the created challenges, the spec may name another author
+  AuthorID bson.ObjectID
+  Author   string
+  // only validates the bundle and tells what would change
+  DryRun bool
+}
+
+// ImportResult tells what the import did, by file name.
+type ImportResult struct {
+  ChallengeID  string   `json:"challenge_id,omitempty"`
+  Slug         string   `json:"slug"`
+  Created      bool     `json:"created"`
+  DryRun       bool     `json:"dry_run"`
+  AddedFiles   []string `json:"added_files"`
+  KeptFiles    []string `json:"kept_files"`
+  RemovedFiles []string `json:"removed_files"`
+}
+
+// ReadBundleSpec reads the spec of the bundle, it returns the directory of
+// the spec, the root of its file paths.
+func ReadBundleSpec(bundle fs.FS) (*BundleSpec, fs.FS, error) {
+  dir, err := bundleDir(bundle)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  f, err := dir.Open(BundleSpecName)
+  if err != nil {
+    return nil, nil, err
+  }
+  defer f.Close()
+
+  data, err := io.ReadAll(io.LimitReader(f, maxSpecSize+1))
+  if err != nil {
+    return nil, nil, err
+  }
+  if len(data) > maxSpecSize {
+    return nil, nil, &BundleError{Errors: []FieldError{{Field: BundleSpecName, Message: "spec too large"}}}
+  }
+
+  spec := new(BundleSpec)
+  if err := yaml.Unmarshal(data, spec); err != nil {
+    return nil, nil, &BundleError{Errors: []FieldError{{Field: BundleSpecName, Message: err.Error()}}}
+  }
+
+  return spec, dir, nil
+}
+
+// bundleDir finds the directory of the spec: the root of the bundle, or its
+// single top directory (an archived directory).
+func bundleDir(bundle fs.FS) (fs.FS, error) {
+  if _, err := fs.Stat(bundle, BundleSpecName); err == nil {
+    return bundle, nil
+  }
+
+  entries, err := fs.ReadDir(bundle, ".")
+  if err != nil {
+    return nil, err
+  }
+
+  var dirs []string
+  for _, entry := range entries {
+    // e.g. __MACOSX or .git
+    if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") && !strings.HasPrefix(entry.Name(), "__") {
+      dirs = append(dirs, entry.Name())
+    }
+  }
+
+  if len(dirs) == 1 {
+    if _, err := fs.Stat(bundle, dirs[0]+"/"+BundleSpecName); err == nil {
+      return fs.Sub(bundle, dirs[0])
+    }
+  }
+
+  return nil, &BundleError{Errors: []FieldError{{Field: BundleSpecName, Message: "spec not found in the bundle"}}}
+}
+
+// validate checks the spec and its files against dir, deriving the missing
+// slug.
+func (s *Service) validate(spec *BundleSpec, dir fs.FS) error {
+  errs := new(BundleError)
+
+  if spec.Slug == "" {
+    spec.Slug = slugify(spec.Name)
+  }
+  if len(spec.Slug) > maxSlugLength || !slugRe.MatchString(spec.Slug) {
+    errs.add("slug", "must be lowercase letters and digits separated by dashes, up to %d characters", maxSlugLength)
+  }
+
+  if strings.TrimSpace(spec.Name) == "" {
+    errs.add("name", "is required")
+  }
+  if spec.Value < 0 {
+    errs.add("value", "must not be negative")
+  }
+  if spec.State != "" && !slices.Contains(bundleStates, spec.State) {
+    errs.add("state", "must be one of %s", strings.Join(bundleStates, ", "))
+  }
+
+  switch len(spec.Flags) {
+  case 0:
+    errs.add("flags", "one flag is required")
+  case 1:
+  default:
+    errs.add("flags", "only one flag is supported")
+  }
+  for i, flag := range spec.Flags {
+    if flag.Type != "" && flag.Type != "static" {
+      errs.add("flags["+strconv.Itoa(i)+"].type", "only static flags are supported")
+    }
+    if flag.Content == "" {
+      errs.add("flags["+strconv.Itoa(i)+"]", "must not be empty")
+    }
+  }
+
+  for i, decoy := range spec.DecoyFlags {
+    if decoy == "" || (len(spec.Flags) > 0 && decoy == spec.Flags[0].Content) {
+      errs.add("decoy_flags["+strconv.Itoa(i)+"]", "must not be empty or the flag")
+    }
+  }
+
+  for i, tag := range spec.Tags {
+    if strings.TrimSpace(tag) == "" {
+      errs.add("tags["+strconv.Itoa(i)+"]", "must not be empty")
+    }
+  }
+
+  for i, hint := range spec.Hints {
+    field := "hints[" + strconv.Itoa(i) + "]"
+    if strings.TrimSpace(hint.Content) == "" {
+      errs.add(field+".content", "must not be empty")
+    }
+    if hint.Cost < 0 {
+      errs.add(field+".cost", "must not be negative")
+    }
+  }
+
+  var total int64
+  for i, name := range spec.Files {
+    field := "files[" + strconv.Itoa(i) + "]"
+    name = path.Clean(name)
+    if !fs.ValidPath(name) || name == "." {
+      errs.add(field, "must be a path inside the bundle")
+      continue
+    }
+
+    info, err := fs.Stat(dir, name)
+    if err != nil || !info.Mode().IsRegular() {
+      errs.add(field, "file not found in the bundle")
+      continue
+    }
+
+    if limit := s.fileService.fileLimit(); limit >= 0 && info.Size() > limit {
+      errs.add(field, "%v", ErrFileExcedLimit)
+    }
+    total += info.Size()
+  }
+  if budget := s.uploadBudget(nil, ""); budget >= 0 && total > budget {
+    errs.add("files", "%v", ErrChallengeSizeExceeded)
+  }
+
+  if len(errs.Errors) > 0 {
+    return errs
+  }
+
+  return nil
+}
+
+// ImportBundle creates the challenge of the bundle, or updates the challenge
+// with its slug. The files already in the challenge, same name and content,
+// are kept as they are, so importing the same bundle again changes nothing.
+func (s *Service) ImportBundle(ctx context.Context, bundle fs.FS, opts ImportOptions) (*ImportResult, error) {
+  spec, dir, err := ReadBundleSpec(bundle)
+  if err != nil {
+    return nil, err
+  }
+
+  if err := s.validate(spec, dir); err != nil {
+    return nil, err
+  }
+
+  existing, err := s.repo.GetBySlug(ctx, spec.Slug)
+  if errors.Is(err, mongo.ErrNoDocuments) {
+    existing = nil
+  } else if err != nil {
+    return nil, err
+  }
+
+  result := &ImportResult{
+    Slug:         spec.Slug,
+    Created:      existing == nil,
+    DryRun:       opts.DryRun,
+    AddedFiles:   []string{},
+    KeptFiles:    []string{},
+    RemovedFiles: []string{},
+  }
+
+  var current []FileMeta
+  if existing != nil {
+    result.ChallengeID = existing.ID.Hex()
+    current = existing.Files
+  }
+
+  files, added, err := s.importFiles(ctx, spec, dir, current, opts.DryRun, result)
+  if err != nil {
+    return nil, err
+  }
+
+  kept := make(map[string]bool, len(files))
+  for _, f := range files {
+    kept[f.UUID] = true
+  }
+  var removed []FileMeta
+  for _, f := range current {
+    if !kept[f.UUID] {
+      removed = append(removed, f)
+      result.RemovedFiles = append(result.RemovedFiles, f.Name)
+    }
+  }
+
+  if opts.DryRun {
+    return result, nil
+  }
+
+  challenge := spec.challenge()
+  challenge.Files = files
+  if challenge.Author == "" {
+    challenge.Author = opts.Author
+  }
+
+  if existing == nil {
+    challenge.AuthorID = opts.AuthorID
+    if err := s.CreateChallengeWithFiles(ctx, challenge, files); err != nil {
+      return nil, err
+    }
+    result.ChallengeID = challenge.ID.Hex()
+    return result, nil
+  }
+
+  update := bson.M{
+    "title":       challenge.Title,
+    "category":    challenge.Category,
+    "description": challenge.Description,
+    "points":      challenge.Points,
+    "state":       challenge.State,
+    "type":        challenge.Type,
+    "flag":        challenge.Flag,
+    "decoy_flags": challenge.DecoyFlags,
+    "tags":        challenge.Tags,
+    "hints":       challenge.Hints,
+    "files":       files,
+  }
+  if challenge.Author != "" {
+    update["author"] = challenge.Author
+  }
+
+  if err := s.repo.Update(ctx, existing.ID.Hex(), bson.M{"$set": update}); err != nil {
+    s.fileService.releaseFiles(added)
+    return nil, err
+  }
+  s.fileService.releaseFiles(removed)
+
+  return result, nil
+}
+
+// importFiles returns the files of the spec: the current file with the same
+// name and content, or else a new file (added). On a dry run, the new files
+// are not stored.
+func (s *Service) importFiles(ctx context.Context, spec *BundleSpec, dir fs.FS, current []FileMeta, dryRun bool, result *ImportResult) ([]FileMeta, []FileMeta, error) {
+  var files, added []FileMeta
+  taken := make(map[string]bool)
+
+  for _, name := range spec.Files {
+    name = path.Clean(name)
+    base := path.Base(name)
+
+    if i, err := s.findImportedFile(ctx, dir, name, base, current, taken); err != nil {
+      s.fileService.releaseFiles(added)
+      return nil, nil, err
+    } else if i >= 0 {
+      taken[current[i].UUID] = true
+      files = append(files, current[i])
+      result.KeptFiles = append(result.KeptFiles, base)
+      continue
+    }
+
+    result.AddedFiles = append(result.AddedFiles, base)
+    if dryRun {
+      continue
+    }
+
+    f, err := dir.Open(name)
+    if err != nil {
+      s.fileService.releaseFiles(added)
+      return nil, nil, err
+    }
+
+    file, err := s.fileService.storeStream(ctx, base, f, s.fileService.fileLimit(), ErrFileExcedLimit)
+    f.Close()
+    if err != nil {
+      s.fileService.releaseFiles(added)
+      return nil, nil, fmt.Errorf("%s: %w", name, err)
+    }
+
+    files = append(files, *file)
+    added = append(added, *file)
+  }
+
+  return files, added, nil
+}
+
+// findImportedFile returns the index of a current file not taken yet with
+// the name and the content of the bundle file, -1 if none.
+func (s *Service) findImportedFile(ctx context.Context, dir fs.FS, name, base string, current []FileMeta, taken map[string]bool) (int, error) {
+  candidate := func(f FileMeta) bool {
+    return f.Name == base && !taken[f.UUID]
+  }
+  if !slices.ContainsFunc(current, candidate) {
+    return -1, nil
+  }
+
+  f, err := dir.Open(name)
+  if err != nil {
+    return -1, err
+  }
+  defer f.Close()
+
+  sum, err := hashContent(f)
+  if err != nil {
+    return -1, err
+  }
+
+  for i, file := range current {
+    if !candidate(file) {
+      continue
+    }
+
+    // the files stored before the blobs have no SHA-256, their content is
+    // hashed
+    fileSum := file.SHA256
+    if fileSum == "" {
+      fileSum, err = s.hashStoredFile(ctx, &current[i])
+      if errors.Is(err, ErrFileNotOnStorage) {
+        continue
+      } else if err != nil {
+        return -1, err
+      }
+    }
+
+    if fileSum == sum {
+      return i, nil
+    }
+  }
+
+  return -1, nil
+}
+
+// hashStoredFile returns the hex SHA-256 of the stored content of the file.
+func (s *Service) hashStoredFile(ctx context.Context, file *FileMeta) (string, error) {
+  r, err := s.fileService.OpenFile(ctx, file)
+  if err != nil {
+    return "", err
+  }
+  defer r.Close()
+
+  return hashContent(r)
+}
+
+// hashContent returns the hex SHA-256 of the content of r.
+func hashContent(r io.Reader) (string, error) {
+  hash := sha256.New()
+  if _, err := io.Copy(hash, r); err != nil {
+    return "", err
+  }
+
+  return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (spec *BundleSpec) challenge() *Challenge {
+  c := &Challenge{
+    Slug:        spec.Slug,
+    Title:       spec.Name,
+    Category:    spec.Category,
+    Description: spec.Description,
+    Points:      spec.Value,
+    Type:        spec.Type,
+    Flag:        spec.Flags[0].Content,
+    DecoyFlags:  spec.DecoyFlags,
+    Author:      spec.Author,
+    Tags:        spec.Tags,
+  }
+
+  if spec.State != "visible" {
+    c.State = spec.State
+  }
+
+  for _, hint := range spec.Hints {
+    c.Hints = append(c.Hints, Hint{Content: hint.Content, Cost: hint.Cost})
+  }
+
+  return c
+}
+
+// ImportBundleZip imports a zipped bundle, spooled to a temporary file: a zip
+// is read from its end.
+func (s *Service) ImportBundleZip(ctx context.Context, r io.Reader, opts ImportOptions) (*ImportResult, error) {
+  tmp, err := os.CreateTemp("", "ctfxd-bundle-*.zip")
+  if err != nil {
+    return nil, err
+  }
+  defer os.Remove(tmp.Name())
+  defer tmp.Close()
+
+  // the files and the spec, with some room for the zip structure
+  limit := int64(-1)
+  if budget := s.uploadBudget(nil, ""); budget >= 0 {
+    limit = budget + 2*maxSpecSize
+  }
+
+  var src io.Reader = r
+  if limit >= 0 {
+    src = io.LimitReader(r, limit+1)
+  }
+  size, err := io.Copy(tmp, src)
+  if err != nil {
+    return nil, err
+  }
+  if limit >= 0 && size > limit {
+    return nil, ErrChallengeSizeExceeded
+  }
+
+  zr, err := zip.NewReader(tmp, size)
+  if err != nil {
+    return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+  }
+
+  return s.ImportBundle(ctx, zr, opts)
+}
+
+// ExportBundle writes the challenge as a zipped bundle, its files under
+// files/. A challenge without slug gets one derived from its title, saved so
+// that importing the bundle back updates the challenge.
+func (s *Service) ExportBundle(ctx context.Context, challenge *Challenge, w io.Writer) error {
+  if err := s.ensureSlug(ctx, challenge); err != nil {
+    return err
+  }
+
+  spec := &BundleSpec{
+    Slug:        challenge.Slug,
+    Name:        challenge.Title,
+    Author:      challenge.Author,
+    Category:    challenge.Category,
+    Description: challenge.Description,
+    Value:       challenge.Points,
+    Type:        challenge.Type,
+    State:       challenge.State,
+    Flags:       []BundleFlag{{Content: challenge.Flag}},
+    DecoyFlags:  challenge.DecoyFlags,
+    Tags:        challenge.Tags,
+  }
+  if challenge.Released() {
+    spec.State = "visible"
+  }
+  for _, hint := range challenge.Hints {
+    spec.Hints = append(spec.Hints, BundleHint{Content: hint.Content, Cost: hint.Cost})
+  }
+
+  names := archiveNames(challenge.Files)
+  for _, name := range names {
+    spec.Files = append(spec.Files, "files/"+name)
+  }
+
+  var data bytes.Buffer
+  encoder := yaml.NewEncoder(&data)
+  encoder.SetIndent(2)
+  if err := encoder.Encode(spec); err != nil {
+    return err
+  }
+
+  // every file is opened first, a missing one fails before anything is
+  // written
+  var readers []io.ReadCloser
+  defer func() {
+    for _, r := range readers {
+      r.Close()
+    }
+  }()
+  for i := range challenge.Files {
+    r, err := s.fileService.OpenFile(ctx, &challenge.Files[i])
+    if err != nil {
+      return err
+    }
+    readers = append(readers, r)
+  }
+
+  zw := zip.NewWriter(w)
+  fw, err := zw.Create(BundleSpecName)
+  if err != nil {
+    return err
+  }
+  if _, err := fw.Write(data.Bytes()); err != nil {
+    return err
+  }
+
+  for i, file := range challenge.Files {
+    header := &zip.FileHeader{Name: spec.Files[i], Method: zip.Deflate, Modified: file.UploadedAt}
+    if compressed(file.MimeType) {
+      header.Method = zip.Store
+    }
+
+    fw, err := zw.CreateHeader(header)
+    if err != nil {
+      return err
+    }
+    if _, err := io.Copy(fw, readers[i]); err != nil {
+      return err
+    }
+  }
+
+  return zw.Close()
+}
+
+// ensureSlug gives the challenge without slug the one derived from its title,
+// or with its ID appended if another challenge has it.
+func (s *Service) ensureSlug(ctx context.Context, challenge *Challenge) error {
+  if challenge.Slug != "" {
+    return nil
+  }
+
+  base := slugify(challenge.Title)
+  suffix := challenge.ID.Hex()
+  if base == "" {
+    base = "challenge"
+  }
+  candidates := []string{base, strings.TrimRight(base[:min(len(base), maxSlugLength-len(suffix)-1)], "-") + "-" + suffix}
+
+  var err error
+  for _, slug := range candidates {
+    var set bool
+    set, err = s.repo.SetSlug(ctx, challenge.ID, slug)
+    if mongo.IsDuplicateKeyError(err) {
+      continue
+    } else if err != nil {
+      return err
+    }
+
+    // a concurrent export set it first
+    if !set {
+      current, err := s.repo.GetByID(ctx, challenge.ID.Hex())
+      if err != nil {
+        return err
+      }
+      slug = current.Slug
+    }
+
+    challenge.Slug = slug
+    return nil
+  }
+
+  return err
+}
+
+// FindChallenge finds a challenge by ID or slug.
+func (s *Service) FindChallenge(ctx context.Context, ref string) (*Challenge, error) {
+  if _, err := bson.ObjectIDFromHex(ref); err == nil {
+    return s.repo.GetByID(ctx, ref)
+  }
+
+  return s.repo.GetBySlug(ctx, ref)
+}
+
+// slugify derives a slug from a name, e.g. "Baby's First Pwn!" gives
+// "baby-s-first-pwn".
+func slugify(name string) string {
+  var b strings.Builder
+  dash := false
+  for _, r := range strings.ToLower(name) {
+    if 'a' <= r && r <= 'z' || '0' <= r && r <= '9' {
+      if dash && b.Len() > 0 {
+        b.WriteByte('-')
+      }
+      b.WriteRune(r)
+      dash = false
+    } else {
+      dash = true
+    }
+  }
+
+  slug := b.String()
+  if len(slug) > maxSlugLength {
+    slug = strings.TrimRight(slug[:maxSlugLength], "-")
+  }
+
+  return slug
+}
@@ -0,0 +1,292 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package challenge
+
+import (
+  "context"
+  "errors"
+  "log"
+  "strconv"
+  "strings"
+  "time"
+
+  "go.mongodb.org/mongo-driver/v2/bson"
+
+  "github.com/CTFxd/ctfxd-server/pkg/storage"
+)
+
+// The orphan cleaner reclaims the stored objects nothing refers to anymore.
+// An object is stored before the document referring to it is written, so an
+// object younger than the grace period is kept. The reclaimed
+// objects are moved to quarantinePrefix/<unix time>/<key> and purged once
+// the quarantine period is over.
+const quarantinePrefix = "quarantine/"
+
+// Kinds of orphans.
+const (
+  // a file uploaded before the blobs, not used by any challenge
+  OrphanFile = "file"
+  // a blob without references
+  OrphanBlob = "blob"
+  // a blob content without blob document
+  OrphanStray = "stray"
+  // a part of a closed upload session
+  OrphanUploadPart = "upload_part"
+  // a quarantined object due to be purged
+  OrphanQuarantined = "quarantined"
+)
+
+type Orphan struct {
+  Key     string    `json:"key"`
+  Kind    string    `json:"kind"`
+  Size    int64     `json:"size"`
+  ModTime time.Time `json:"mod_time"`
+  // the hash of a blob
+  SHA256 string `json:"sha256,omitempty"`
+}
+
+// CleanupReport describes a run of the cleaner. A dry run only lists the
+// orphans.
+type CleanupReport struct {
+  DryRun     bool      `json:"dry_run"`
+  StartedAt  time.Time `json:"started_at"`
+  FinishedAt time.Time `json:"finished_at"`
+  Orphans    []Orphan  `json:"orphans"`
+  // unreferenced objects still in their grace period
+  Kept int `json:"kept"`
+
+  QuarantinedFiles int   `json:"quarantined_files"`
+  QuarantinedBytes int64 `json:"quarantined_bytes"`
+  // deleted from the storage, from the quarantine or right away
+  ReclaimedFiles int   `json:"reclaimed_files"`
+  ReclaimedBytes int64 `json:"reclaimed_bytes"`
+}
+
+// CleanerStats sums up the runs of the cleaner since the server started.
+type CleanerStats struct {
+  Runs           int            `json:"runs"`
+  Failures       int            `json:"failures"`
+  ReclaimedFiles int            `json:"reclaimed_files"`
+  ReclaimedBytes int64          `json:"reclaimed_bytes"`
+  LastReport     *CleanupReport `json:"last_report,omitempty"`
+  LastError      string         `json:"last_error,omitempty"`
+}
+
+// CleanOrphanFileUploads quarantines the orphans older than the grace
+// period and purges the quarantine, or only lists them on a dry run.
+func (s *Service) CleanOrphanFileUploads(ctx context.Context, dryRun bool) (*CleanupReport, error) {
+  report := &CleanupReport{DryRun: dryRun, StartedAt: time.Now().UTC(), Orphans: []Orphan{}}
+
+  err := s.findOrphans(ctx, report)
+  if err == nil && !dryRun {
+    err = s.disposeOrphans(ctx, report)
+  }
+  report.FinishedAt = time.Now().UTC()
+
+  if !dryRun {
+    s.cleanerMu.Lock()
+    s.cleanerStats.Runs++
+    s.cleanerStats.ReclaimedFiles += report.ReclaimedFiles
+    s.cleanerStats.ReclaimedBytes += report.ReclaimedBytes
+    s.cleanerStats.LastReport = report
+    s.cleanerStats.LastError = ""
+    if err != nil {
+      s.cleanerStats.Failures++
+      s.cleanerStats.LastError = err.Error()
+    }
+    s.cleanerMu.Unlock()
+  }
+
+  return report, err
+}
+
+func (s *Service) CleanerStats() CleanerStats {
+  s.cleanerMu.Lock()
+  defer s.cleanerMu.Unlock()
+
+  return s.cleanerStats
+}
+
+// findOrphans lists the orphans in the report. The objects are listed before
+// the documents are read, so the objects stored for the documents written
+// meanwhile are not listed.
+func (s *Service) findOrphans(ctx context.Context, report *CleanupReport) error {
+  store := s.fileService.store
+
+  var files, contents, parts, quarantined []storage.ObjectInfo
+  lists := []struct {
+    prefix  string
+    objects *[]storage.ObjectInfo
+  }{
+    {filePrefix, &files},
+    {blobPrefix, &contents},
+    {uploadPrefix, &parts},
+    {quarantinePrefix, &quarantined},
+  }
+  for _, list := range lists {
+    err := store.List(ctx, list.prefix, func(object storage.ObjectInfo) error {
+      *list.objects = append(*list.objects, object)
+      return nil
+    })
+    if err != nil {
+      return err
+    }
+  }
+
+  challenges, err := s.ListChallenges(ctx)
+  if err != nil {
+    return err
+  }
+
+  usedFiles := make(map[string]bool)
+  usedBlobs := make(map[string]bool)
+  for _, challenge := range challenges {
+    for _, f := range challenge.Files {
+      usedFiles[f.UUID] = true
+      if f.SHA256 != "" {
+        usedBlobs[f.SHA256] = true
+      }
+    }
+  }
+
+  blobs, err := s.repo.ListBlobs(ctx, bson.M{})
+  if err != nil {
+    return err
+  }
+
+  sessions, err := s.repo.ListUploadIDs(ctx)
+  if err != nil {
+    return err
+  }
+
+  cutoff := time.Now().Add(-s.config.OrphanGracePeriod)
+  add := func(object storage.ObjectInfo, kind, hash string, modTime time.Time) {
+    if modTime.After(cutoff) {
+      report.Kept++
+      return
+    }
+    report.Orphans = append(report.Orphans, Orphan{
+      Key:     object.Key,
+      Kind:    kind,
+      Size:    object.Size,
+      ModTime: modTime,
+      SHA256:  hash,
+    })
+  }
+
+  for _, object := range files {
+    fileUUID := strings.TrimPrefix(object.Key, filePrefix)
+    if !strings.Contains(fileUUID, "/") && !usedFiles[fileUUID] {
+      add(object, OrphanFile, "", object.ModTime)
+    }
+  }
+
+  byKey := make(map[string]storage.ObjectInfo, len(contents))
+  for _, object := range contents {
+    byKey[object.Key] = object
+  }
+
+  knownKeys := make(map[string]bool, len(blobs))
+  for _, blob := range blobs {
+    knownKeys[blob.Key] = true
+    if blob.Refs > 0 {
+      continue
+    }
+
+    if usedBlobs[blob.SHA256] {
+      log.Printf("challenge: warning: blob %s is used but has no references\n", blob.SHA256)
+      continue
+    }
+
+    // the content may be missing, the document is reclaimed all the same
+    object, ok := byKey[blob.Key]
+    if !ok {
+      object = storage.ObjectInfo{Key: blob.Key}
+    }
+    // a blob is released before it is taken again by a replaced file
+    add(object, OrphanBlob, blob.SHA256, blob.UpdatedAt)
+  }
+
+  // a content without document is either dropped or still waiting for its
+  // document
+  for _, object := range contents {
+    if !knownKeys[object.Key] {
+      add(object, OrphanStray, "", object.ModTime)
+    }
+  }
+
+  for _, object := range parts {
+    uploadID, _, _ := strings.Cut(strings.TrimPrefix(object.Key, uploadPrefix), "/")
+    if !sessions[uploadID] {
+      add(object, OrphanUploadPart, "", object.ModTime)
+    }
+  }
+
+  purgeCutoff := time.Now().Add(-s.config.QuarantinePeriod)
+  for _, object := range quarantined {
+    if at, ok := quarantinedAt(object.Key); !ok || !at.After(purgeCutoff) {
+      report.Orphans = append(report.Orphans, Orphan{
+        Key:     object.Key,
+        Kind:    OrphanQuarantined,
+        Size:    object.Size,
+        ModTime: object.ModTime,
+      })
+    }
+  }
+
+  return nil
+}
+
+// disposeOrphans quarantines or deletes the orphans of the report. A blob is
+// claimed first, it may have been taken again since it was listed.
+func (s *Service) disposeOrphans(ctx context.Context, report *CleanupReport) error {
+  store := s.fileService.store
+  quarantineDir := quarantinePrefix + strconv.FormatInt(report.StartedAt.Unix(), 10) + "/"
+
+  for _, orphan := range report.Orphans {
+    if orphan.Kind == OrphanBlob {
+      claimed, err := s.repo.DeleteUnreferencedBlob(ctx, orphan.SHA256)
+      if err != nil {
+        return err
+      }
+      if !claimed {
+        continue
+      }
+    }
+
+    if orphan.Kind == OrphanQuarantined || s.config.QuarantinePeriod <= 0 {
+      if err := store.Delete(ctx, orphan.Key); err != nil {
+        return err
+      }
+      report.ReclaimedFiles++
+      report.ReclaimedBytes += orphan.Size
+      continue
+    }
+
+    err := storage.Move(ctx, store, orphan.Key, quarantineDir+orphan.Key)
+    if errors.Is(err, storage.ErrNotFound) {
+      continue
+    } else if err != nil {
+      return err
+    }
+    report.QuarantinedFiles++
+    report.QuarantinedBytes += orphan.Size
+  }
+
+  return nil
+}
+
+// quarantinedAt parses the time an object was quarantined from its key.
+func quarantinedAt(key string) (time.Time, bool) {
+  dir, _, _ := strings.Cut(strings.TrimPrefix(key, quarantinePrefix), "/")
+  sec, err := strconv.ParseInt(dir, 10, 64)
+  if err != nil {
+    return time.Time{}, false
+  }
+
+  return time.Unix(sec, 0), true
+}
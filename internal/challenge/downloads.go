@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package challenge
+
+import (
+  "cmp"
+  "context"
+  "log"
+  "slices"
+  "time"
+
+  "go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// The download events are queued by the requests and written in batches by
+// RunDownloadRecorder, a download never waits for the database. The events
+// are dropped if the queue is full.
+const (
+  downloadQueueSize   = 1024
+  downloadBatchSize   = 100
+  downloadFlushPeriod = time.Second
+)
+
+func (s *Service) RecordDownload(event *DownloadEvent) {
+  select {
+  case s.downloads <- *event:
+  default:
+    log.Printf("challenge: warning: download queue full, event dropped(%s/%s)\n",
+      event.ChallengeID.Hex(), event.FileUUID)
+  }
+}
+
+// RunDownloadRecorder writes the queued download events until ctx is done,
+// then writes the remaining ones.
+func (s *Service) RunDownloadRecorder(ctx context.Context) {
+  ticker := time.NewTicker(downloadFlushPeriod)
+  defer ticker.Stop()
+
+  batch := make([]DownloadEvent, 0, downloadBatchSize)
+  flush := func() {
+    if len(batch) == 0 {
+      return
+    }
+
+    writeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    if err := s.repo.InsertDownloads(writeCtx, batch); err != nil {
+      log.Printf("challenge: error: download events(%d: %v)\n", len(batch), err)
+    }
+    batch = batch[:0]
+  }
+
+  for {
+    select {
+    case event := <-s.downloads:
+      batch = append(batch, event)
+      if len(batch) == downloadBatchSize {
+        flush()
+      }
+    case <-ticker.C:
+      flush()
+    case <-ctx.Done():
+      for {
+        select {
+        case event := <-s.downloads:
+          batch = append(batch, event)
+          if len(batch) == downloadBatchSize {
+            flush()
+          }
+        default:
+          flush()
+          return
+        }
+      }
+    }
+  }
+}
+
+// DownloadStatsByChallenge sums up the downloads of every challenge with
+// files since the time (if not zero), the most downloaded first.
+func (s *Service) DownloadStatsByChallenge(ctx context.Context, since time.Time) ([]ChallengeDownloadStats, error) {
+  var counted []ChallengeDownloadStats
+  if err := s.repo.DownloadStats(ctx, bson.M{}, since, "$challenge_id", &counted); err != nil {
+    return nil, err
+  }
+
+  byID := make(map[bson.ObjectID]*ChallengeDownloadStats, len(counted))
+  for i := range counted {
+    byID[counted[i].ChallengeID] = &counted[i]
+  }
+
+  challenges, err := s.ListChallenges(ctx)
+  if err != nil {
+    return nil, err
+  }
+
+  stats := []ChallengeDownloadStats{}
+  for _, challenge := range challenges {
+    if len(challenge.Files) == 0 {
+      continue
+    }
+
+    entry := ChallengeDownloadStats{ChallengeID: challenge.ID}
+    if counts, ok := byID[challenge.ID]; ok {
+      entry = *counts
+    }
+    entry.Title = challenge.Title
+    entry.Solves = challenge.Solves
+    entry.Files = len(challenge.Files)
+    entry.Aborted = max(entry.Downloads-entry.Completed, 0)
+    stats = append(stats, entry)
+  }
+
+  slices.SortStableFunc(stats, func(a, b ChallengeDownloadStats) int {
+    return cmp.Compare(b.Downloads, a.Downloads)
+  })
+
+  return stats, nil
+}
+
+// FileDownloadStats sums up the downloads of every file of the challenge
+// since the time (if not zero), in the order of the files.
+func (s *Service) FileDownloadStats(ctx context.Context, challengeID string, since time.Time) ([]FileDownloadStats, error) {
+  challenge, err := s.repo.GetByID(ctx, challengeID)
+  if err != nil {
+    return nil, err
+  }
+
+  var counted []FileDownloadStats
+  if err := s.repo.DownloadStats(ctx, bson.M{"challenge_id": challenge.ID}, since, "$file_uuid", &counted); err != nil {
+    return nil, err
+  }
+
+  byUUID := make(map[string]*FileDownloadStats, len(counted))
+  for i := range counted {
+    byUUID[counted[i].FileUUID] = &counted[i]
+  }
+
+  // the downloads of the removed files are left out
+  stats := make([]FileDownloadStats, 0, len(challenge.Files))
+  for _, file := range challenge.Files {
+    entry := FileDownloadStats{FileUUID: file.UUID}
+    if counts, ok := byUUID[file.UUID]; ok {
+      entry = *counts
+    }
+    entry.Name = file.Name
+    entry.Size = file.Size
+    entry.Aborted = max(entry.Downloads-entry.Completed, 0)
+    stats = append(stats, entry)
+  }
+
+  return stats, nil
+}
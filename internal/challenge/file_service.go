@@ -7,103 +7,375 @@
 package challenge
 
 import (
+  "context"
+  "crypto/sha256"
+  "encoding/hex"
   "errors"
+  "fmt"
+  "hash"
+  "io"
   "log"
+  "mime"
   "mime/multipart"
-  "os"
+  "net/http"
   "path/filepath"
+  "strings"
   "time"
 
-  "github.com/gin-gonic/gin"
   "github.com/google/uuid"
+  "go.mongodb.org/mongo-driver/v2/mongo"
+
+  "github.com/CTFxd/ctfxd-server/pkg/scanner"
+  "github.com/CTFxd/ctfxd-server/pkg/storage"
 )
 
-const uploadDir = "uploads/challenge"
+const (
+  // the files uploaded before the blobs are stored under this prefix, keyed
+  // by their UUID
+  filePrefix = "challenge/"
+  // the blob contents are stored under this prefix, keyed by a UUID
+  blobPrefix = "blobs/"
+
+  // a field of the upload forms, e.g. the challenge data
+  maxFormFieldSize = 1 << 20
+  maxFormFields    = 16
+)
 
 var (
-  ErrNoFile           = errors.New("uploaded files not found")
-  ErrFileExcedLimit   = errors.New("file size exceeds limit")
-  ErrFileNotOnStorage = errors.New("file not found on storage")
+  ErrNoFile                = errors.New("uploaded files not found")
+  ErrFileExcedLimit        = errors.New("file size exceeds limit")
+  ErrChallengeSizeExceeded = errors.New("challenge files exceed the size limit")
+  ErrInvalidForm           = errors.New("invalid multipart-form")
+  ErrFileNotOnStorage      = errors.New("file not found on storage")
+  ErrFileInfected          = errors.New("file is infected")
+  ErrScanFailed            = errors.New("file could not be scanned")
+)
+
+// Policies for the files the scanner detects as infected, fails to scan or
+// skips for their size.
+const (
+  // rejects the upload
+  ScanPolicyBlock = "block"
+  // keeps the file, with its verdict
+  ScanPolicyFlag = "flag"
 )
 
 type FileService struct {
-  uploadDir string
+  store storage.Storage
+  repo  *Repository
+  // 0 for no limit
+  maxFileSize int64
+  // nil for no scan
+  scanner        scanner.Scanner
+  scanPolicy     string
+  oversizePolicy string
 }
 
-func NewFileService() *FileService {
+func NewFileService(store storage.Storage, repo *Repository, config Config) *FileService {
   fileService := new(FileService)
-
-  fileService.uploadDir = uploadDir
-  os.MkdirAll(uploadDir, 0755)
+  fileService.store = store
+  fileService.repo = repo
+  fileService.maxFileSize = config.MaxFileSize
+  fileService.scanner = config.Scanner
+  fileService.scanPolicy = config.ScanPolicy
+  fileService.oversizePolicy = config.OversizePolicy
 
   return fileService
 }
 
-func (fs *FileService) processUploads(files []*multipart.FileHeader, c *gin.Context) ([]FileMeta, error) {
-
-  if len(files) == 0 {
-    return nil, ErrNoFile
+// fileLimit is the size limit of a file, -1 for no limit.
+func (fs *FileService) fileLimit() int64 {
+  if fs.maxFileSize <= 0 {
+    return -1
   }
 
-  maxSize := int64(10 << 20)
-  for _, file := range files {
-    if file.Size > maxSize {
-      return nil, ErrFileExcedLimit
-    }
-  }
+  return fs.maxFileSize
+}
 
+func fileKey(fileUUID string) string {
+  return filePrefix + fileUUID
+}
+
+// processUploads streams the files of the multipart form to the storage as
+// they arrive, and returns them with the other fields of the form. budget is
+// the total size the files may take, -1 for no limit.
+func (fs *FileService) processUploads(ctx context.Context, mr *multipart.Reader, budget int64) ([]FileMeta, map[string]string, error) {
   var uploadedFiles []FileMeta
-  var cleanups []string
+  fields := make(map[string]string)
 
   var err error
   defer func() {
     if err != nil {
-      for _, path := range cleanups {
-        os.Remove(path)
-      }
-      log.Printf("cleaning files: %d(%v)\n", len(cleanups), err)
+      fs.releaseFiles(uploadedFiles)
+      log.Printf("cleaning files: %d(%v)\n", len(uploadedFiles), err)
     }
   }()
 
-  for _, fileHeader := range files {
-    uuid := uuid.NewString()
-    filePath := filepath.Join(uploadDir, uuid)
+  for {
+    var part *multipart.Part
+    part, err = mr.NextPart()
+    if err == io.EOF {
+      err = nil
+      break
+    } else if err != nil {
+      err = ErrInvalidForm
+      return nil, nil, err
+    }
 
-    if err = c.SaveUploadedFile(fileHeader, filePath); err != nil {
-      return nil, err
+    if part.FileName() == "" {
+      err = readFormField(part, fields)
+      part.Close()
+      if err != nil {
+        return nil, nil, err
+      }
+      continue
     }
 
-    cleanups = append(cleanups, filePath)
+    if part.FormName() != "files" {
+      part.Close()
+      continue
+    }
+
+    limit, limitErr := fs.fileLimit(), ErrFileExcedLimit
+    if budget >= 0 && (limit < 0 || budget < limit) {
+      limit, limitErr = budget, ErrChallengeSizeExceeded
+    }
+
+    var file *FileMeta
+    file, err = fs.storeStream(ctx, part.FileName(), part, limit, limitErr)
+    part.Close()
+    if err != nil {
+      return nil, nil, err
+    }
 
-    file, errFh := fileHeader.Open()
-    if errFh != nil {
-      err = errFh
+    uploadedFiles = append(uploadedFiles, *file)
+    if budget >= 0 {
+      budget -= file.Size
+    }
+  }
+
+  return uploadedFiles, fields, nil
+}
+
+func readFormField(part *multipart.Part, fields map[string]string) error {
+  if len(fields) >= maxFormFields {
+    return ErrInvalidForm
+  }
+
+  value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize+1))
+  if err != nil || len(value) > maxFormFieldSize {
+    return ErrInvalidForm
+  }
+  fields[part.FormName()] = string(value)
+
+  return nil
+}
+
+// storeStream writes the content of r to a new object while hashing it, then
+// takes a reference on the blob of the hash. The new object becomes the
+// content of the blob if the blob is new, else it is dropped. A content
+// longer than limit (unless negative) fails with limitErr.
+func (fs *FileService) storeStream(ctx context.Context, name string, r io.Reader, limit int64, limitErr error) (*FileMeta, error) {
+  key := blobPrefix + uuid.NewString()
+  hr := &hashingReader{r: r, hash: sha256.New(), limit: limit, limitErr: limitErr}
+
+  if err := fs.store.Put(ctx, key, hr, -1); err != nil {
+    if errors.Is(err, limitErr) {
+      return nil, limitErr
+    }
+    return nil, err
+  }
+
+  hash := hex.EncodeToString(hr.hash.Sum(nil))
+  blob, err := fs.repo.AcquireBlob(ctx, &Blob{SHA256: hash, Key: key, Size: hr.n, MimeType: detectMimeType(hr.head, name)})
+  if err != nil {
+    fs.deleteObject(key)
+    return nil, err
+  }
+
+  if blob.Key != key {
+    // known content: keep the stored one, or take its place if it is missing
+    _, err := fs.store.Stat(ctx, blob.Key)
+    if errors.Is(err, storage.ErrNotFound) {
+      var swapped bool
+      if swapped, err = fs.repo.SwapBlobKey(ctx, hash, blob.Key, key); swapped {
+        blob.Key = key
+      }
+    }
+    if err != nil {
+      fs.releaseBlob(hash)
+      fs.deleteObject(key)
       return nil, err
     }
-    defer file.Close()
+    if blob.Key != key {
+      fs.deleteObject(key)
+    }
+  }
+
+  if err := fs.scanBlob(ctx, blob, name); err != nil {
+    fs.releaseBlob(hash)
+    return nil, err
+  }
+
+  return &FileMeta{
+    UUID:       uuid.NewString(),
+    Name:       name,
+    Size:       blob.Size,
+    SHA256:     blob.SHA256,
+    MimeType:   blob.MimeType,
+    UploadedAt: time.Now().UTC(),
+    ScanStatus: blob.ScanStatus,
+    ScanThreat: blob.ScanThreat,
+  }, nil
+}
+
+// scanBlob scans the content of a new blob, or of a blob whose scan failed,
+// and applies the scan policy to its verdict.
+func (fs *FileService) scanBlob(ctx context.Context, blob *Blob, name string) error {
+  if fs.scanner == nil {
+    return nil
+  }
+
+  // a skipped content is tried again, the limit of the scanner may be raised
+  if blob.ScanStatus != ScanClean && blob.ScanStatus != ScanInfected {
+    blob.ScanStatus, blob.ScanThreat = ScanError, ""
+
+    result, err := fs.scanObject(ctx, blob.Key)
+    if errors.Is(err, scanner.ErrTooLarge) {
+      blob.ScanStatus = ScanSkipped
+    } else if err != nil {
+      log.Printf("challenge: error: scan(%s: %v)\n", blob.SHA256, err)
+    } else if result.Infected {
+      blob.ScanStatus, blob.ScanThreat = ScanInfected, result.Threat
+    } else {
+      blob.ScanStatus = ScanClean
+    }
 
-    uploadedFiles = append(uploadedFiles, FileMeta{
-      UUID:       uuid,
-      Name:       fileHeader.Filename,
-      Size:       fileHeader.Size,
-      UploadedAt: time.Now().UTC(),
-    })
+    if err := fs.repo.SetBlobScan(ctx, blob.SHA256, blob.ScanStatus, blob.ScanThreat); err != nil {
+      log.Printf("challenge: error: scan(%s: %v)\n", blob.SHA256, err)
+    }
   }
 
-  return uploadedFiles, nil
+  block := fs.scanPolicy != ScanPolicyFlag
+  switch blob.ScanStatus {
+  case ScanInfected:
+    log.Printf("challenge: warning: %s is infected(%s)\n", name, blob.ScanThreat)
+    if block {
+      return fmt.Errorf("%w: %s", ErrFileInfected, blob.ScanThreat)
+    }
+  case ScanError:
+    if block {
+      return ErrScanFailed
+    }
+  case ScanSkipped:
+    log.Printf("challenge: warning: %s is too large to be scanned\n", name)
+    if fs.oversizePolicy == ScanPolicyBlock {
+      return fmt.Errorf("%w: too large to be scanned", ErrScanFailed)
+    }
+  }
+
+  return nil
 }
 
-func (fs *FileService) ValidateFile(file *FileMeta) (string, error) {
-  filePath := filepath.Join(fs.uploadDir, file.UUID)
-  if _, err := os.Stat(filePath); os.IsNotExist(err) {
-    return "", ErrFileNotOnStorage
+func (fs *FileService) scanObject(ctx context.Context, key string) (*scanner.Result, error) {
+  r, _, err := fs.store.Get(ctx, key)
+  if err != nil {
+    return nil, err
+  }
+  defer r.Close()
+
+  return fs.scanner.Scan(ctx, r)
+}
+
+// OpenFile opens the stored content of the file, seeking reads a range of it.
+func (fs *FileService) OpenFile(ctx context.Context, file *FileMeta) (*storage.ReadSeeker, error) {
+  key := fileKey(file.UUID)
+  if file.SHA256 != "" {
+    blob, err := fs.repo.GetBlob(ctx, file.SHA256)
+    if errors.Is(err, mongo.ErrNoDocuments) {
+      return nil, ErrFileNotOnStorage
+    } else if err != nil {
+      return nil, err
+    }
+    key = blob.Key
+  }
+
+  info, err := fs.store.Stat(ctx, key)
+  if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+    return nil, ErrFileNotOnStorage
+  } else if err != nil {
+    return nil, err
   }
 
-  return filePath, nil
+  return storage.NewReadSeeker(ctx, fs.store, key, info.Size), nil
 }
 
-func (fs *FileService) cleanupFiles(files []FileMeta) {
+// releaseFiles drops the references of the files on their blobs. The files
+// without a blob are left to the orphan cleaner.
+func (fs *FileService) releaseFiles(files []FileMeta) {
   for _, file := range files {
-    os.Remove(filepath.Join(uploadDir, file.UUID))
+    if file.SHA256 != "" {
+      fs.releaseBlob(file.SHA256)
+    }
+  }
+}
+
+// releaseBlob drops a reference even if the request was cancelled, a leaked
+// reference keeps the blob forever.
+func (fs *FileService) releaseBlob(hash string) {
+  ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+  defer cancel()
+
+  if err := fs.repo.ReleaseBlob(ctx, hash); err != nil {
+    log.Printf("challenge: error: release blob(%s: %v)\n", hash, err)
+  }
+}
+
+// deleteObject drops an object, even if the request was cancelled.
+func (fs *FileService) deleteObject(key string) {
+  ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+  defer cancel()
+
+  if err := fs.store.Delete(ctx, key); err != nil {
+    log.Printf("challenge: error: delete(%s: %v)\n", key, err)
+  }
+}
+
+// hashingReader hashes and counts the content read, keeping its head to
+// detect the MIME type.
+type hashingReader struct {
+  r        io.Reader
+  hash     hash.Hash
+  head     []byte
+  n        int64
+  limit    int64
+  limitErr error
+}
+
+func (hr *hashingReader) Read(p []byte) (int, error) {
+  n, err := hr.r.Read(p)
+  hr.hash.Write(p[:n])
+  if missing := 512 - len(hr.head); missing > 0 {
+    hr.head = append(hr.head, p[:min(n, missing)]...)
   }
+
+  hr.n += int64(n)
+  if hr.limit >= 0 && hr.n > hr.limit {
+    return n, hr.limitErr
+  }
+
+  return n, err
+}
+
+// detectMimeType detects the MIME type from the content, or else the
+// extension of the name.
+func detectMimeType(head []byte, name string) string {
+  mimeType := http.DetectContentType(head)
+  if strings.HasPrefix(mimeType, "application/octet-stream") || strings.HasPrefix(mimeType, "text/plain") {
+    if byName := mime.TypeByExtension(filepath.Ext(name)); byName != "" {
+      mimeType = byName
+    }
+  }
+
+  return mimeType
 }
@@ -7,38 +7,83 @@
 package challenge
 
 import (
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/hex"
   "encoding/json"
   "errors"
   "fmt"
+  "io"
   "log"
   "net/http"
+  "strconv"
+  "strings"
+  "time"
 
+  "github.com/CTFxd/ctfxd-server/internal/audit"
   "github.com/CTFxd/ctfxd-server/internal/auth"
+  "github.com/CTFxd/ctfxd-server/internal/user"
+  "github.com/CTFxd/ctfxd-server/pkg/urlsign"
   "github.com/gin-gonic/gin"
+  "go.mongodb.org/mongo-driver/v2/bson"
+  "go.mongodb.org/mongo-driver/v2/mongo"
 )
 
 type Handler struct {
   service *Service
+  users   *user.Service
+  audit   *audit.Service
+  urls    *urlsign.Signer
 }
 
 type UpdateChallengeRequest struct {
-  Title       *string `bson:"title" json:"title"`
-  Category    *string `bson:"category" json:"category"`
-  Description *string `bson:"description" json:"description"`
-  Points      *int    `bson:"points" json:"points"`
-  State       *string `bson:"state" json:"state"`
-  Type        *string `bson:"type" json:"type"`
-  Solves      *int    `bson:"solves" json:"solves"`
-  Flag        *string `bson:"flag" json:"flag"`
-  Author      *string `bson:"author" json:"author"`
-}
-
-func NewHandler(service *Service) *Handler {
+  Title       *string   `bson:"title" json:"title"`
+  Category    *string   `bson:"category" json:"category"`
+  Description *string   `bson:"description" json:"description"`
+  Points      *int      `bson:"points" json:"points"`
+  State       *string   `bson:"state" json:"state"`
+  Type        *string   `bson:"type" json:"type"`
+  Solves      *int      `bson:"solves" json:"solves"`
+  Flag        *string   `bson:"flag" json:"flag"`
+  DecoyFlags  *[]string `bson:"decoy_flags" json:"decoy_flags"`
+  Author      *string   `bson:"author" json:"author"`
+  AuthorID    *string   `bson:"author_id" json:"author_id"`
+}
+
+func NewHandler(service *Service, userService *user.Service, auditService *audit.Service, urls *urlsign.Signer) *Handler {
   handler := new(Handler)
   handler.service = service
+  handler.users = userService
+  handler.audit = auditService
+  handler.urls = urls
   return handler
 }
 
+// OwnershipMiddleware restricts the challenge routes to the owner of the
+// challenge, unless the user may manage every challenge.
+func (h *Handler) OwnershipMiddleware() gin.HandlerFunc {
+  return func(c *gin.Context) {
+    if auth.HasPermission(auth.GetUserRole(c), auth.PermManageChallenges) {
+      c.Next()
+      return
+    }
+
+    challenge, err := h.service.GetChallenge(c.Request.Context(), c.Param("id"))
+    if err != nil {
+      log.Printf("challenge: error(%v)\n", err)
+      c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "challenge not found"})
+      return
+    }
+
+    if challenge.AuthorID.IsZero() || challenge.AuthorID.Hex() != auth.GetUserID(c) {
+      c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "not the owner of the challenge"})
+      return
+    }
+
+    c.Next()
+  }
+}
+
 func (h *Handler) GetChallenges(c *gin.Context) {
   ctx := c.Request.Context()
   challenges, err := h.service.ListChallenges(ctx)
@@ -49,7 +94,7 @@ func (h *Handler) GetChallenges(c *gin.Context) {
   }
 
   for i := range challenges {
-    challenges[i].Flag = ""
+    challenges[i].redact()
   }
 
   c.JSON(http.StatusOK, challenges)
@@ -65,7 +110,21 @@ func (h *Handler) GetChallenge(c *gin.Context) {
     return
   }
 
-  challenge.Flag = ""
+  challenge.redact()
+
+  // the files can be downloaded with these links without logging in, the
+  // downloads are recorded for the user they are issued to
+  if canView(c, challenge) {
+    base := strings.TrimSuffix(c.Request.URL.Path, "/")
+    userID := auth.GetUserID(c)
+    for i := range challenge.Files {
+      challenge.Files[i].URL = h.urls.Sign(base+"/file/"+challenge.Files[i].UUID, userID)
+    }
+    if len(challenge.Files) > 0 {
+      challenge.ArchiveURL = h.urls.Sign(base+"/files.zip", userID)
+    }
+  }
+
   c.JSON(http.StatusOK, challenge)
 }
 
@@ -94,41 +153,71 @@ func (h *Handler) GetFlag(c *gin.Context) {
     return
   }
 
+  h.audit.Record(c, audit.Entry{
+    Action:       audit.ActionFlagRead,
+    ResourceType: audit.ResourceChallenge,
+    ResourceID:   challenge.ID.Hex(),
+  })
+
   c.JSON(http.StatusOK, challenge.Flag)
 }
 
 func (h *Handler) CreateChallenge(c *gin.Context) {
-  if err := c.Request.ParseMultipartForm(10 << 20); err != nil {
+  mr, err := c.Request.MultipartReader()
+  if err != nil {
     log.Printf("challenge: error(%v)\n", err)
     c.JSON(http.StatusBadRequest, gin.H{"error": "invalid multipart-form"})
     return
   }
 
+  // the files are streamed to the storage while the form is read
+  uploadedFiles, fields, err := h.service.UploadFiles(c.Request.Context(), mr)
+  if err != nil {
+    log.Printf("challenge: error(%v)\n", err)
+    uploadError(c, err, "failed to upload challenge files")
+    return
+  }
+
   var req Challenge
-  if err := json.Unmarshal([]byte(c.PostForm("data")), &req); err != nil {
+  if err := json.Unmarshal([]byte(fields["data"]), &req); err != nil {
     log.Printf("challenge: error(%v)\n", err)
+    h.service.ReleaseFiles(uploadedFiles)
     c.JSON(http.StatusBadRequest, gin.H{"error": "invalid challenge data"})
     return
   }
 
-  // set author from JWT context
-  if req.Author == "" {
-    req.Author = auth.GetUserEmail(c)
+  // set author from JWT context, only full managers may create for others
+  if req.AuthorID.IsZero() || !auth.HasPermission(auth.GetUserRole(c), auth.PermManageChallenges) {
+    authorID, err := bson.ObjectIDFromHex(auth.GetUserID(c))
+    if err != nil {
+      log.Printf("challenge: error(%v)\n", err)
+      h.service.ReleaseFiles(uploadedFiles)
+      c.JSON(http.StatusBadRequest, gin.H{"error": "invalid author"})
+      return
+    }
+    req.AuthorID = authorID
+  } else if !h.checkAuthor(c, req.AuthorID.Hex()) {
+    h.service.ReleaseFiles(uploadedFiles)
+    return
   }
 
-  form, err := c.MultipartForm()
-  if err != nil {
-    log.Printf("challenge: error(%v)\n", err)
-    c.JSON(http.StatusBadRequest, gin.H{"error": "invalid multipart-form"})
-    return
+  if req.Author == "" {
+    req.Author = auth.GetUserEmail(c)
   }
 
-  if err := h.service.CreateChallengeWithFiles(c.Request.Context(), &req, form, c); err != nil {
+  if err := h.service.CreateChallengeWithFiles(c.Request.Context(), &req, uploadedFiles); err != nil {
     log.Printf("challenge: error(%v)\n", err)
     c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create challenge"})
     return
   }
 
+  h.audit.Record(c, audit.Entry{
+    Action:       audit.ActionChallengeCreate,
+    ResourceType: audit.ResourceChallenge,
+    ResourceID:   req.ID.Hex(),
+    Metadata:     map[string]any{"title": req.Title, "author_id": req.AuthorID.Hex(), "files": fileNames(req.Files)},
+  })
+
   c.Status(http.StatusCreated)
 }
 
@@ -142,15 +231,62 @@ func (h *Handler) UpdateChallenge(c *gin.Context) {
     return
   }
 
-  if err := h.service.UpdateChallenge(c.Request.Context(), id, &update); err != nil {
+  if update.AuthorID != nil {
+    if !auth.HasPermission(auth.GetUserRole(c), auth.PermManageChallenges) {
+      c.JSON(http.StatusForbidden, gin.H{"error": "only admins can transfer challenges"})
+      return
+    }
+    if !h.checkAuthor(c, *update.AuthorID) {
+      return
+    }
+  }
+
+  changes, err := h.service.UpdateChallenge(c.Request.Context(), id, &update)
+  if err != nil {
     log.Printf("challenge: error(%v)\n", err)
-    c.JSON(http.StatusInternalServerError, gin.H{"error": "update failed"})
+    if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, bson.ErrInvalidHex) {
+      c.JSON(http.StatusNotFound, gin.H{"error": "challenge not found"})
+    } else {
+      c.JSON(http.StatusInternalServerError, gin.H{"error": "update failed"})
+    }
     return
   }
 
+  if len(changes) > 0 {
+    h.audit.Record(c, audit.Entry{
+      Action:       audit.ActionChallengeUpdate,
+      ResourceType: audit.ResourceChallenge,
+      ResourceID:   id,
+      Changes:      changes,
+    })
+  }
+
   c.Status(http.StatusOK)
 }
 
+// checkAuthor makes sure a challenge can be given to the user, an existing
+// account allowed to author challenges. Otherwise it answers 400.
+func (h *Handler) checkAuthor(c *gin.Context, authorID string) bool {
+  author, err := h.users.GetUser(c.Request.Context(), authorID)
+  if err != nil {
+    if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, bson.ErrInvalidHex) {
+      c.JSON(http.StatusBadRequest, gin.H{"error": "unknown author"})
+    } else {
+      log.Printf("challenge: error(%v)\n", err)
+      c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check the author"})
+    }
+    return false
+  }
+
+  if !auth.HasPermission(author.Role, auth.PermManageOwnChallenges) &&
+    !auth.HasPermission(author.Role, auth.PermManageChallenges) {
+    c.JSON(http.StatusBadRequest, gin.H{"error": "user may not author challenges"})
+    return false
+  }
+
+  return true
+}
+
 func (h *Handler) DeleteChallenge(c *gin.Context) {
   id := c.Param("id")
 
@@ -160,31 +296,41 @@ func (h *Handler) DeleteChallenge(c *gin.Context) {
     return
   }
 
+  h.audit.Record(c, audit.Entry{
+    Action:       audit.ActionChallengeDelete,
+    ResourceType: audit.ResourceChallenge,
+    ResourceID:   id,
+  })
+
   c.Status(http.StatusNoContent)
 }
 
 func (h *Handler) AddChallengeFile(c *gin.Context) {
   challengeID := c.Param("id")
 
-  form, err := c.MultipartForm()
+  mr, err := c.Request.MultipartReader()
   if err != nil {
     log.Printf("challenge: error(%v)\n", err)
     c.JSON(http.StatusBadRequest, gin.H{"error": "invalid multipart-form"})
     return
   }
 
-  uploadedFiles, err := h.service.AddChallengeFile(c.Request.Context(), challengeID, form, c)
+  uploadedFiles, err := h.service.AddChallengeFile(c.Request.Context(), challengeID, mr)
   if err != nil && len(uploadedFiles) == 0 {
     log.Printf("challenge: error(%v)\n", err)
-    if errors.Is(err, ErrNoFile) || errors.Is(err, ErrFileExcedLimit) {
-      c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-    } else {
-      c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upload challenge file"})
-    }
-
+    uploadError(c, err, "failed to upload challenge file")
     return
   }
 
+  if len(uploadedFiles) > 0 {
+    h.audit.Record(c, audit.Entry{
+      Action:       audit.ActionFileAdd,
+      ResourceType: audit.ResourceChallenge,
+      ResourceID:   challengeID,
+      Metadata:     map[string]any{"files": fileNames(uploadedFiles)},
+    })
+  }
+
   c.JSON(http.StatusCreated, uploadedFiles)
 }
 
@@ -192,23 +338,27 @@ func (h *Handler) UpdateChallengeFile(c *gin.Context) {
   challengeID := c.Param("id")
   fileUUID := c.Param("uuid")
 
-  form, err := c.MultipartForm()
+  mr, err := c.Request.MultipartReader()
   if err != nil {
     log.Printf("challenge: error(%v)\n", err)
     c.JSON(http.StatusBadRequest, gin.H{"error": "invalid multipart-form"})
     return
   }
 
-  if err := h.service.UpdateChallengeFile(c.Request.Context(), challengeID, fileUUID, form, c); err != nil {
+  replaced, replacement, err := h.service.UpdateChallengeFile(c.Request.Context(), challengeID, fileUUID, mr)
+  if err != nil {
     log.Printf("challenge: error(%v)\n", err)
-    if errors.Is(err, ErrFileNotFound) || errors.Is(err, ErrNoFile) || errors.Is(err, ErrFileExcedLimit) {
-      c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-    } else {
-      c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update challenge file"})
-    }
+    uploadError(c, err, "failed to update challenge file")
     return
   }
 
+  h.audit.Record(c, audit.Entry{
+    Action:       audit.ActionFileReplace,
+    ResourceType: audit.ResourceChallenge,
+    ResourceID:   challengeID,
+    Changes:      []audit.Change{{Field: "file", Before: fileName(replaced), After: fileName(replacement)}},
+  })
+
   c.Status(http.StatusOK)
 }
 
@@ -216,7 +366,7 @@ func (h *Handler) DeleteChallengeFile(c *gin.Context) {
   challengeID := c.Param("id")
   fileUUID := c.Param("uuid")
 
-  err := h.service.DeleteChallengeFile(c.Request.Context(), challengeID, fileUUID, c)
+  err := h.service.DeleteChallengeFile(c.Request.Context(), challengeID, fileUUID)
   if err != nil {
     log.Printf("challenge: error(%v)\n", err)
     if errors.Is(err, ErrFileNotFound) || errors.Is(err, ErrNoFile) || errors.Is(err, ErrFileExcedLimit) {
@@ -227,14 +377,25 @@ func (h *Handler) DeleteChallengeFile(c *gin.Context) {
     return
   }
 
+  h.audit.Record(c, audit.Entry{
+    Action:       audit.ActionFileDelete,
+    ResourceType: audit.ResourceChallenge,
+    ResourceID:   challengeID,
+    Metadata:     map[string]any{"file_uuid": fileUUID},
+  })
+
   c.Status(http.StatusNoContent)
 }
 
 func (h *Handler) DownloadChallengeFile(c *gin.Context) {
-  challengeID := c.Param("id")
   fileUUID := c.Param("uuid")
 
-  filePath, fileName, err := h.service.GetChallengeFile(c.Request.Context(), challengeID, fileUUID)
+  challenge, userID, ok := h.authorizeDownload(c)
+  if !ok {
+    return
+  }
+
+  r, file, err := h.service.GetChallengeFile(c.Request.Context(), challenge, fileUUID)
   if err != nil {
     log.Printf("challenge: error(%v)\n", err)
     if errors.Is(err, ErrFileNotFound) || errors.Is(err, ErrFileNotOnStorage) {
@@ -244,8 +405,441 @@ func (h *Handler) DownloadChallengeFile(c *gin.Context) {
     }
     return
   }
+  defer r.Close()
+
+  header := c.Writer.Header()
+  header.Set("Content-Disposition", contentDisposition(file.Name))
+  header.Set("X-Content-Type-Options", "nosniff")
+  // else guessed from the name
+  if file.MimeType != "" {
+    header.Set("Content-Type", file.MimeType)
+  }
+  // players can check the download against the SHA-256, it also validates
+  // the conditional and range requests
+  if sum, err := hex.DecodeString(file.SHA256); err == nil && len(sum) == sha256.Size {
+    header.Set("ETag", `"`+file.SHA256+`"`)
+    header.Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(sum))
+  }
+
+  // answers the Range, If-Range and conditional requests
+  http.ServeContent(c.Writer, c.Request, file.Name, file.UploadedAt, r)
+
+  // the conditional requests and the errors send no content
+  status := c.Writer.Status()
+  if status != http.StatusOK && status != http.StatusPartialContent {
+    return
+  }
+
+  length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+  if err != nil {
+    length = file.Size
+  }
+  sent := int64(max(c.Writer.Size(), 0))
+
+  // a download is split in ranges by the download managers and the resumed
+  // downloads: it starts with the range at 0, the one reaching the end of the
+  // file completes it
+  event := &DownloadEvent{
+    ChallengeID: challenge.ID,
+    FileUUID:    file.UUID,
+    UserID:      userID,
+    Time:        time.Now().UTC(),
+    Bytes:       sent,
+    Length:      length,
+    Completed:   sent == length,
+  }
+  if status == http.StatusPartialContent {
+    event.Partial = true
+    event.Offset = rangeStart(header.Get("Content-Range"))
+    event.Resumed = event.Offset != 0
+    event.Completed = event.Offset >= 0 && event.Offset+sent == file.Size
+  }
+  h.service.RecordDownload(event)
+}
+
+// rangeStart returns the first byte of the single range of a partial
+// response, -1 for a multipart one.
+func rangeStart(contentRange string) int64 {
+  spec, ok := strings.CutPrefix(contentRange, "bytes ")
+  if !ok {
+    return -1
+  }
+
+  first, _, _ := strings.Cut(spec, "-")
+  start, err := strconv.ParseInt(first, 10, 64)
+  if err != nil {
+    return -1
+  }
+
+  return start
+}
+
+func (h *Handler) DownloadChallengeZip(c *gin.Context) {
+  h.downloadArchive(c, ArchiveZip)
+}
+
+func (h *Handler) DownloadChallengeTarGz(c *gin.Context) {
+  h.downloadArchive(c, ArchiveTarGz)
+}
+
+// downloadArchive streams all the files of the challenge in an archive.
+func (h *Handler) downloadArchive(c *gin.Context, format string) {
+  challenge, _, ok := h.authorizeDownload(c)
+  if !ok {
+    return
+  }
+
+  archive, err := h.service.OpenArchive(c.Request.Context(), challenge, format)
+  if err != nil {
+    log.Printf("challenge: error(%v)\n", err)
+    if errors.Is(err, ErrNoFiles) || errors.Is(err, ErrFileNotOnStorage) {
+      c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+    } else {
+      c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to download files"})
+    }
+    return
+  }
+  defer archive.Close()
+
+  contentType := "application/zip"
+  if format == ArchiveTarGz {
+    contentType = "application/gzip"
+  }
+
+  header := c.Writer.Header()
+  header.Set("Content-Type", contentType)
+  header.Set("Content-Disposition", contentDisposition(archiveBaseName(challenge.Title)+"."+format))
+  header.Set("X-Content-Type-Options", "nosniff")
+  c.Status(http.StatusOK)
+
+  // the status is sent, a failure leaves a truncated archive
+  if _, err := archive.WriteTo(c.Writer); err != nil {
+    log.Printf("challenge: error: archive(%s: %v)\n", challenge.ID.Hex(), err)
+  }
+}
+
+// authorizeDownload returns the challenge whose files are downloaded and the
+// downloading user (the one a signed URL was issued to), or answers the
+// request if they may not be.
+func (h *Handler) authorizeDownload(c *gin.Context) (*Challenge, string, bool) {
+  // a signed URL grants access by itself, else the user must see the
+  // challenge
+  query := c.Request.URL.Query()
+  userID := auth.GetUserID(c)
+  signed := urlsign.Signed(query)
+  if signed {
+    if err := h.urls.Verify(c.Request.URL.Path, query); err != nil {
+      c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+      return nil, "", false
+    }
+    userID = urlsign.User(query)
+  } else if userID == "" {
+    c.JSON(http.StatusUnauthorized, gin.H{"error": "login or signed URL required"})
+    return nil, "", false
+  }
+
+  challenge, err := h.service.GetChallenge(c.Request.Context(), c.Param("id"))
+  if err != nil {
+    log.Printf("challenge: error(%v)\n", err)
+    c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+    return nil, "", false
+  }
+
+  // unreleased files do not exist for the players
+  if !signed && !canView(c, challenge) {
+    c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+    return nil, "", false
+  }
+
+  return challenge, userID, true
+}
+
+// archiveBaseName names the archive of the files after the challenge.
+func archiveBaseName(title string) string {
+  name := strings.Map(func(r rune) rune {
+    if r == '/' || r == '\\' {
+      return '_'
+    }
+    return r
+  }, strings.TrimSpace(title))
+
+  if name == "" || name == "." || name == ".." {
+    return "files"
+  }
+
+  return name
+}
+
+// canView tells whether the user sees the challenge and its files: anyone
+// sees the released challenges, their managers see the others.
+func canView(c *gin.Context, challenge *Challenge) bool {
+  if challenge.Released() {
+    return true
+  }
+
+  role := auth.GetUserRole(c)
+  if auth.HasPermission(role, auth.PermManageChallenges) {
+    return true
+  }
+
+  return auth.HasPermission(role, auth.PermManageOwnChallenges) &&
+    !challenge.AuthorID.IsZero() && challenge.AuthorID.Hex() == auth.GetUserID(c)
+}
+
+// contentDisposition formats an RFC 6266 attachment header: the name in
+// UTF-8 (RFC 5987) and an ASCII fallback for the older clients.
+func contentDisposition(name string) string {
+  name = strings.ToValidUTF8(name, "_")
+
+  var fallback, encoded strings.Builder
+  for _, r := range name {
+    if r < 0x20 || r > 0x7e || r == '"' || r == '\\' || r == '%' {
+      fallback.WriteByte('_')
+    } else {
+      fallback.WriteRune(r)
+    }
+  }
+
+  for _, b := range []byte(name) {
+    if 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' || strings.IndexByte("!#$&+-.^_`|~", b) >= 0 {
+      encoded.WriteByte(b)
+    } else {
+      fmt.Fprintf(&encoded, "%%%02X", b)
+    }
+  }
+
+  return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, fallback.String(), encoded.String())
+}
+
+// uploadError answers the errors of an upload, the unexpected ones with msg.
+func uploadError(c *gin.Context, err error, msg string) {
+  switch {
+  case errors.Is(err, ErrFileExcedLimit) || errors.Is(err, ErrChallengeSizeExceeded):
+    c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+  case errors.Is(err, ErrFileNotFound) || errors.Is(err, ErrNoFile) || errors.Is(err, ErrInvalidForm) ||
+    errors.Is(err, ErrInvalidUpload) || errors.Is(err, ErrInvalidPart) || errors.Is(err, ErrUploadIncomplete) ||
+    errors.Is(err, ErrChecksumMismatch):
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+  case errors.Is(err, ErrFileInfected):
+    c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+  case errors.Is(err, ErrScanFailed):
+    c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+  case errors.Is(err, ErrPartCorrupted):
+    c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+  case errors.Is(err, ErrUploadNotFound):
+    c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+  case errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, bson.ErrInvalidHex):
+    c.JSON(http.StatusNotFound, gin.H{"error": "challenge not found"})
+  default:
+    c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+  }
+}
+
+// fileName identifies a file in the audit log.
+func fileName(f *FileMeta) string {
+  return fmt.Sprintf("%s (%s, %d bytes)", f.Name, f.UUID, f.Size)
+}
+
+func fileNames(files []FileMeta) []string {
+  names := make([]string, 0, len(files))
+  for i := range files {
+    names = append(names, fileName(&files[i]))
+  }
+
+  return names
+}
+
+// ListOrphanFiles lists the files the orphan cleaner would reclaim, without
+// touching them.
+func (h *Handler) ListOrphanFiles(c *gin.Context) {
+  report, err := h.service.CleanOrphanFileUploads(c.Request.Context(), true)
+  if err != nil {
+    log.Printf("challenge: error(%v)\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list orphan files"})
+    return
+  }
+
+  c.JSON(http.StatusOK, report)
+}
+
+func (h *Handler) GetCleanerStats(c *gin.Context) {
+  c.JSON(http.StatusOK, h.service.CleanerStats())
+}
+
+// GetDownloadStats sums up the downloads of the files of every challenge,
+// since the optional RFC 3339 time.
+func (h *Handler) GetDownloadStats(c *gin.Context) {
+  since, ok := parseSince(c)
+  if !ok {
+    return
+  }
+
+  stats, err := h.service.DownloadStatsByChallenge(c.Request.Context(), since)
+  if err != nil {
+    log.Printf("challenge: error(%v)\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get download stats"})
+    return
+  }
+
+  c.JSON(http.StatusOK, stats)
+}
+
+// GetFileDownloadStats sums up the downloads of every file of the challenge.
+func (h *Handler) GetFileDownloadStats(c *gin.Context) {
+  since, ok := parseSince(c)
+  if !ok {
+    return
+  }
+
+  stats, err := h.service.FileDownloadStats(c.Request.Context(), c.Param("id"), since)
+  if err != nil {
+    log.Printf("challenge: error(%v)\n", err)
+    if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, bson.ErrInvalidHex) {
+      c.JSON(http.StatusNotFound, gin.H{"error": "challenge not found"})
+    } else {
+      c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get download stats"})
+    }
+    return
+  }
+
+  c.JSON(http.StatusOK, stats)
+}
+
+// ImportBundle creates or updates the challenge of a zipped bundle, sent in
+// the "bundle" field of the form. With ?dry_run=true, the bundle is only
+// validated.
+func (h *Handler) ImportBundle(c *gin.Context) {
+  mr, err := c.Request.MultipartReader()
+  if err != nil {
+    c.JSON(http.StatusBadRequest, gin.H{"error": "invalid multipart-form"})
+    return
+  }
+
+  authorID, _ := bson.ObjectIDFromHex(auth.GetUserID(c))
+  opts := ImportOptions{AuthorID: authorID, Author: auth.GetUserEmail(c), DryRun: c.Query("dry_run") == "true"}
+
+  var result *ImportResult
+  for {
+    part, err := mr.NextPart()
+    if err == io.EOF {
+      c.JSON(http.StatusBadRequest, gin.H{"error": "bundle not found in the form"})
+      return
+    } else if err != nil {
+      c.JSON(http.StatusBadRequest, gin.H{"error": "invalid multipart-form"})
+      return
+    }
+
+    if part.FormName() != "bundle" {
+      part.Close()
+      continue
+    }
+
+    result, err = h.service.ImportBundleZip(c.Request.Context(), part, opts)
+    part.Close()
+    if err != nil {
+      log.Printf("challenge: error(%v)\n", err)
+      var bundleErr *BundleError
+      switch {
+      case errors.As(err, &bundleErr):
+        c.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidBundle.Error(), "fields": bundleErr.Errors})
+      case errors.Is(err, ErrInvalidBundle):
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+      case mongo.IsDuplicateKeyError(err):
+        c.JSON(http.StatusConflict, gin.H{"error": "challenge imported concurrently"})
+      default:
+        uploadError(c, err, "failed to import bundle")
+      }
+      return
+    }
+    break
+  }
+
+  if !result.DryRun {
+    action := audit.ActionChallengeUpdate
+    if result.Created {
+      action = audit.ActionChallengeCreate
+    }
+    h.audit.Record(c, audit.Entry{
+      Action:       action,
+      ResourceType: audit.ResourceChallenge,
+      ResourceID:   result.ChallengeID,
+      Metadata: map[string]any{
+        "slug": result.Slug, "source": "bundle",
+        "added_files": result.AddedFiles, "removed_files": result.RemovedFiles,
+      },
+    })
+  }
+
+  status := http.StatusOK
+  if result.Created && !result.DryRun {
+    status = http.StatusCreated
+  }
+  c.JSON(status, result)
+}
+
+// ExportBundle streams the challenge, with its flags and files, as a zipped
+// bundle.
+func (h *Handler) ExportBundle(c *gin.Context) {
+  challenge, err := h.service.GetChallenge(c.Request.Context(), c.Param("id"))
+  if err != nil {
+    log.Printf("challenge: error(%v)\n", err)
+    c.JSON(http.StatusNotFound, gin.H{"error": "challenge not found"})
+    return
+  }
+
+  h.audit.Record(c, audit.Entry{
+    Action:       audit.ActionChallengeExport,
+    ResourceType: audit.ResourceChallenge,
+    ResourceID:   challenge.ID.Hex(),
+  })
+
+  header := c.Writer.Header()
+  header.Set("Content-Type", "application/zip")
+  header.Set("Content-Disposition", contentDisposition(archiveBaseName(challenge.Title)+".zip"))
+
+  // the files are opened before the first write, a missing one can still be
+  // answered
+  w := &lazyStatusWriter{c: c}
+  if err := h.service.ExportBundle(c.Request.Context(), challenge, w); err != nil {
+    log.Printf("challenge: error: export(%s: %v)\n", challenge.ID.Hex(), err)
+    if !w.started {
+      header.Del("Content-Type")
+      header.Del("Content-Disposition")
+      if errors.Is(err, ErrFileNotOnStorage) {
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+      } else {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export challenge"})
+      }
+    }
+  }
+}
+
+// lazyStatusWriter sends the OK status with the first write.
+type lazyStatusWriter struct {
+  c       *gin.Context
+  started bool
+}
+
+func (w *lazyStatusWriter) Write(p []byte) (int, error) {
+  if !w.started {
+    w.started = true
+    w.c.Status(http.StatusOK)
+  }
+
+  return w.c.Writer.Write(p)
+}
+
+func parseSince(c *gin.Context) (time.Time, bool) {
+  since := c.Query("since")
+  if since == "" {
+    return time.Time{}, true
+  }
+
+  t, err := time.Parse(time.RFC3339, since)
+  if err != nil {
+    c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since parameter, expected RFC 3339"})
+    return time.Time{}, false
+  }
 
-  c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileName))
-  c.Header("content-Type", "application/octet-stream")
-  c.File(filePath)
+  return t.UTC(), true
 }
@@ -12,8 +12,18 @@ import (
   "go.mongodb.org/mongo-driver/v2/bson"
 )
 
+// the challenges in these states are only seen by their managers, with their
+// files
+const (
+  StateHidden = "hidden"
+  StateDraft  = "draft"
+)
+
 type Challenge struct {
   ID          bson.ObjectID `bson:"_id,omitempty" json:"id"`
+  // stable name of the challenges imported from a bundle, the key of the
+  // import
+  Slug        string        `bson:"slug,omitempty" json:"slug,omitempty"`
   Title       string        `bson:"title" json:"title"`
   Category    string        `bson:"category" json:"category"`
   Description string        `bson:"description" json:"description"`
@@ -22,13 +32,155 @@ type Challenge struct {
   Type        string        `bson:"type" json:"type"`
   Solves      int           `bson:"solves" json:"solves"`
   Flag        string        `bson:"flag" json:"flag"`
+  // known-wrong flags (e.g. planted in a leaked writeup), submitting one is
+  // reported as cheating
+  DecoyFlags  []string      `bson:"decoy_flags,omitempty" json:"decoy_flags,omitempty"`
   Author      string        `bson:"author,omitempty" json:"author,omitempty"`
+  AuthorID    bson.ObjectID `bson:"author_id,omitempty" json:"author_id,omitempty"`
+  Tags        []string      `bson:"tags,omitempty" json:"tags,omitempty"`
+  Hints       []Hint        `bson:"hints,omitempty" json:"hints,omitempty"`
   Files       []FileMeta    `bson:"files,omitempty" json:"files,omitempty"`
+  // signed link to the zip archive of the files, set for the responses
+  ArchiveURL  string        `bson:"-" json:"archive_url,omitempty"`
+}
+
+type Hint struct {
+  Content string `bson:"content" json:"content"`
+  // points the hint costs, 0 if free
+  Cost    int    `bson:"cost,omitempty" json:"cost,omitempty"`
 }
 
 type FileMeta struct {
   UUID       string    `bson:"uuid" json:"uuid"`
   Name       string    `bson:"name" json:"name"`
   Size       int64     `bson:"size" json:"size"`
+  // hex SHA-256 of the content, the key of its blob (empty for the files
+  // uploaded before the blobs, stored under their UUID)
+  SHA256     string    `bson:"sha256,omitempty" json:"sha256,omitempty"`
+  MimeType   string    `bson:"mime_type,omitempty" json:"mime_type,omitempty"`
   UploadedAt time.Time `bson:"uploadedat" json:"uploadedat"`
+  // verdict of the malware scan of the content, empty if not scanned
+  ScanStatus string    `bson:"scan_status,omitempty" json:"scan_status,omitempty"`
+  ScanThreat string    `bson:"scan_threat,omitempty" json:"scan_threat,omitempty"`
+  // signed download URL, set in the responses
+  URL        string    `bson:"-" json:"url,omitempty"`
+}
+
+// verdicts of the malware scan
+const (
+  ScanClean    = "clean"
+  ScanInfected = "infected"
+  // the scanner failed, the content is scanned again by the next upload
+  ScanError = "error"
+  // the content is over the size limit of the scanner, see OversizePolicy
+  ScanSkipped = "skipped"
+)
+
+// redact drops what the public responses must not show: the flags and the
+// content of the paid hints.
+func (c *Challenge) redact() {
+  c.Flag = ""
+  c.DecoyFlags = nil
+  for i := range c.Hints {
+    if c.Hints[i].Cost > 0 {
+      c.Hints[i].Content = ""
+    }
+  }
+}
+
+// Released tells whether the challenge is seen by the players.
+func (c *Challenge) Released() bool {
+  return c.State != StateHidden && c.State != StateDraft
+}
+
+// Blob is the stored content of the challenge files, shared by every file with
+// the same SHA-256. Refs counts these files, an unreferenced blob is reclaimed
+// by the orphan cleaner.
+type Blob struct {
+  SHA256     string    `bson:"_id" json:"sha256"`
+  // storage key, unique to this blob document: a blob reclaimed and uploaded
+  // again gets a new key, so the cleaner never deletes the new content
+  Key        string    `bson:"key" json:"key"`
+  Size       int64     `bson:"size" json:"size"`
+  MimeType   string    `bson:"mime_type" json:"mime_type"`
+  Refs       int       `bson:"refs" json:"refs"`
+  CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+  UpdatedAt  time.Time `bson:"updated_at" json:"updated_at"`
+  // the content is scanned once, the files taking the blob again get its
+  // verdict
+  ScanStatus string    `bson:"scan_status,omitempty" json:"scan_status,omitempty"`
+  ScanThreat string    `bson:"scan_threat,omitempty" json:"scan_threat,omitempty"`
+  ScannedAt  time.Time `bson:"scanned_at,omitempty" json:"scanned_at,omitempty"`
+}
+
+// UploadSession is a resumable upload of a challenge file: the client sends
+// the parts in any order, retrying the failed ones, then completes it.
+type UploadSession struct {
+  ID          bson.ObjectID         `bson:"_id,omitempty" json:"id"`
+  ChallengeID bson.ObjectID         `bson:"challenge_id" json:"challenge_id"`
+  UserID      string                `bson:"user_id" json:"user_id"`
+  Name        string                `bson:"name" json:"name"`
+  Size        int64                 `bson:"size" json:"size"`
+  // hex SHA-256 of the file given by the client, checked at the completion
+  SHA256      string                `bson:"sha256,omitempty" json:"sha256,omitempty"`
+  // every part has this size, but the last one
+  PartSize    int64                 `bson:"part_size" json:"part_size"`
+  PartCount   int                   `bson:"part_count" json:"part_count"`
+  // received parts by number
+  Parts       map[string]UploadPart `bson:"parts" json:"-"`
+  Received    []UploadPart          `bson:"-" json:"received"`
+  CreatedAt   time.Time             `bson:"created_at" json:"created_at"`
+  ExpiresAt   time.Time             `bson:"expires_at" json:"expires_at"`
+}
+
+type UploadPart struct {
+  Number     int       `bson:"number" json:"number"`
+  Size       int64     `bson:"size" json:"size"`
+  SHA256     string    `bson:"sha256" json:"sha256"`
+  UploadedAt time.Time `bson:"uploaded_at" json:"uploaded_at"`
+}
+
+// DownloadEvent is a request for a challenge file, recorded for the download
+// statistics.
+type DownloadEvent struct {
+  ID          bson.ObjectID `bson:"_id,omitempty" json:"id"`
+  ChallengeID bson.ObjectID `bson:"challenge_id" json:"challenge_id"`
+  FileUUID    string        `bson:"file_uuid" json:"file_uuid"`
+  // user the signed URL was issued to, empty for an anonymous one
+  UserID      string        `bson:"user_id,omitempty" json:"user_id,omitempty"`
+  Time        time.Time     `bson:"time" json:"time"`
+  // bytes sent of the length requested, the file or its ranges
+  Bytes       int64         `bson:"bytes" json:"bytes"`
+  Length      int64         `bson:"length" json:"length"`
+  Partial     bool          `bson:"partial" json:"partial"`
+  // first byte of the range, -1 for multiple ranges
+  Offset      int64         `bson:"offset,omitempty" json:"offset,omitempty"`
+  // the range continues a download, it is not counted as a new one
+  Resumed     bool          `bson:"resumed,omitempty" json:"resumed,omitempty"`
+  // the end of the file was sent, false if the client went away before
+  Completed   bool          `bson:"completed" json:"completed"`
+}
+
+type DownloadStats struct {
+  Downloads      int64     `bson:"downloads" json:"downloads"`
+  Completed      int64     `bson:"completed" json:"completed"`
+  Aborted        int64     `bson:"-" json:"aborted"`
+  Bytes          int64     `bson:"bytes" json:"bytes"`
+  UniqueUsers    int64     `bson:"unique_users" json:"unique_users"`
+  LastDownloadAt time.Time `bson:"last_download_at" json:"last_download_at"`
+}
+
+type ChallengeDownloadStats struct {
+  ChallengeID   bson.ObjectID `bson:"_id" json:"challenge_id"`
+  Title         string        `bson:"-" json:"title"`
+  Solves        int           `bson:"-" json:"solves"`
+  Files         int           `bson:"-" json:"files"`
+  DownloadStats `bson:",inline"`
+}
+
+type FileDownloadStats struct {
+  FileUUID      string `bson:"_id" json:"uuid"`
+  Name          string `bson:"-" json:"name"`
+  Size          int64  `bson:"-" json:"size"`
+  DownloadStats `bson:",inline"`
 }
@@ -9,18 +9,64 @@ package challenge
 import (
   "context"
   "errors"
+  "fmt"
+  "log"
+  "time"
 
   "go.mongodb.org/mongo-driver/v2/bson"
   "go.mongodb.org/mongo-driver/v2/mongo"
+  "go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 type Repository struct {
   collection *mongo.Collection
+  blobs      *mongo.Collection
+  uploads    *mongo.Collection
+  downloads  *mongo.Collection
 }
 
 func NewRepository(db *mongo.Database) *Repository {
   repo := new(Repository)
   repo.collection = db.Collection("challenges")
+  repo.blobs = db.Collection("file_blobs")
+  repo.uploads = db.Collection("upload_sessions")
+  repo.downloads = db.Collection("file_downloads")
+
+  ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+  defer cancel()
+
+  // the challenges created by hand have no slug
+  _, err := repo.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+    Keys: bson.D{{Key: "slug", Value: 1}},
+    Options: options.Index().SetUnique(true).
+      SetPartialFilterExpression(bson.M{"slug": bson.M{"$type": "string"}}),
+  })
+  if err != nil {
+    log.Printf("challenge: error: indexes(%v)\n", err)
+  }
+
+  _, err = repo.blobs.Indexes().CreateOne(ctx, mongo.IndexModel{
+    Keys: bson.D{{Key: "refs", Value: 1}},
+  })
+  if err != nil {
+    log.Printf("challenge: error: indexes(%v)\n", err)
+  }
+
+  // mongodb drops the expired upload sessions by itself
+  _, err = repo.uploads.Indexes().CreateOne(ctx, mongo.IndexModel{
+    Keys:    bson.D{{Key: "expires_at", Value: 1}},
+    Options: options.Index().SetExpireAfterSeconds(0),
+  })
+  if err != nil {
+    log.Printf("challenge: error: indexes(%v)\n", err)
+  }
+
+  _, err = repo.downloads.Indexes().CreateOne(ctx, mongo.IndexModel{
+    Keys: bson.D{{Key: "challenge_id", Value: 1}, {Key: "time", Value: 1}},
+  })
+  if err != nil {
+    log.Printf("challenge: error: indexes(%v)\n", err)
+  }
 
   return repo
 }
@@ -56,10 +102,37 @@ func (r *Repository) GetByID(ctx context.Context, id string) (*Challenge, error)
   return challenge, nil
 }
 
+func (r *Repository) GetBySlug(ctx context.Context, slug string) (*Challenge, error) {
+  challenge := new(Challenge)
+  err := r.collection.FindOne(ctx, bson.M{"slug": slug}).Decode(challenge)
+  if err != nil {
+    return nil, err
+  }
+
+  return challenge, nil
+}
+
+// SetSlug sets the slug of a challenge without one, it reports whether the
+// challenge had none.
+func (r *Repository) SetSlug(ctx context.Context, id bson.ObjectID, slug string) (bool, error) {
+  result, err := r.collection.UpdateOne(ctx,
+    bson.M{"_id": id, "slug": bson.M{"$exists": false}},
+    bson.M{"$set": bson.M{"slug": slug}})
+  if err != nil {
+    return false, err
+  }
+
+  return result.ModifiedCount > 0, nil
+}
+
 func (r *Repository) Create(ctx context.Context, c *Challenge) error {
-  _, err := r.collection.InsertOne(ctx, c)
+  result, err := r.collection.InsertOne(ctx, c)
+  if err != nil {
+    return err
+  }
 
-  return err
+  c.ID = result.InsertedID.(bson.ObjectID)
+  return nil
 }
 
 func (r *Repository) Update(ctx context.Context, id string, update any) error {
@@ -87,3 +160,240 @@ func (r *Repository) Delete(ctx context.Context, id string) error {
 
   return nil
 }
+
+// AcquireBlob takes a reference on the blob with the hash, creating it from
+// blob if missing. It returns the stored blob, whose Key is blob.Key only if
+// it was just created.
+func (r *Repository) AcquireBlob(ctx context.Context, blob *Blob) (*Blob, error) {
+  now := time.Now().UTC()
+  update := bson.M{
+    "$inc": bson.M{"refs": 1},
+    "$set": bson.M{"updated_at": now},
+    "$setOnInsert": bson.M{
+      "key":        blob.Key,
+      "size":       blob.Size,
+      "mime_type":  blob.MimeType,
+      "created_at": now,
+    },
+  }
+  opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+  stored := new(Blob)
+  err := r.blobs.FindOneAndUpdate(ctx, bson.M{"_id": blob.SHA256}, update, opts).Decode(stored)
+  if err != nil {
+    return nil, err
+  }
+
+  return stored, nil
+}
+
+// ReleaseBlob drops a reference on the blob, the count never goes below zero.
+func (r *Repository) ReleaseBlob(ctx context.Context, hash string) error {
+  _, err := r.blobs.UpdateOne(ctx,
+    bson.M{"_id": hash, "refs": bson.M{"$gt": 0}},
+    bson.M{"$inc": bson.M{"refs": -1}, "$set": bson.M{"updated_at": time.Now().UTC()}},
+  )
+
+  return err
+}
+
+func (r *Repository) GetBlob(ctx context.Context, hash string) (*Blob, error) {
+  blob := new(Blob)
+  if err := r.blobs.FindOne(ctx, bson.M{"_id": hash}).Decode(blob); err != nil {
+    return nil, err
+  }
+
+  return blob, nil
+}
+
+func (r *Repository) ListBlobs(ctx context.Context, filter bson.M) ([]Blob, error) {
+  cursor, err := r.blobs.Find(ctx, filter)
+  if err != nil {
+    return nil, err
+  }
+
+  blobs := []Blob{}
+  if err := cursor.All(ctx, &blobs); err != nil {
+    return nil, err
+  }
+
+  return blobs, nil
+}
+
+// SetBlobScan records the verdict of the scan of the blob content.
+func (r *Repository) SetBlobScan(ctx context.Context, hash, status, threat string) error {
+  _, err := r.blobs.UpdateOne(ctx,
+    bson.M{"_id": hash},
+    bson.M{"$set": bson.M{"scan_status": status, "scan_threat": threat, "scanned_at": time.Now().UTC()}},
+  )
+
+  return err
+}
+
+// SwapBlobKey points the blob to a new content, if its key is still oldKey.
+func (r *Repository) SwapBlobKey(ctx context.Context, hash, oldKey, newKey string) (bool, error) {
+  result, err := r.blobs.UpdateOne(ctx,
+    bson.M{"_id": hash, "key": oldKey},
+    bson.M{"$set": bson.M{"key": newKey, "updated_at": time.Now().UTC()}},
+  )
+  if err != nil {
+    return false, err
+  }
+
+  return result.ModifiedCount == 1, nil
+}
+
+// DeleteUnreferencedBlob deletes the blob document if nothing took a
+// reference on it meanwhile, its content can then be deleted safely.
+func (r *Repository) DeleteUnreferencedBlob(ctx context.Context, hash string) (bool, error) {
+  result, err := r.blobs.DeleteOne(ctx, bson.M{"_id": hash, "refs": bson.M{"$lte": 0}})
+  if err != nil {
+    return false, err
+  }
+
+  return result.DeletedCount == 1, nil
+}
+
+func (r *Repository) CreateUpload(ctx context.Context, upload *UploadSession) error {
+  result, err := r.uploads.InsertOne(ctx, upload)
+  if err != nil {
+    return err
+  }
+
+  upload.ID = result.InsertedID.(bson.ObjectID)
+  return nil
+}
+
+// GetUpload returns the upload session if it has not expired yet.
+func (r *Repository) GetUpload(ctx context.Context, id string) (*UploadSession, error) {
+  objId, err := bson.ObjectIDFromHex(id)
+  if err != nil {
+    return nil, err
+  }
+
+  upload := new(UploadSession)
+  filter := bson.M{"_id": objId, "expires_at": bson.M{"$gt": time.Now().UTC()}}
+  if err := r.uploads.FindOne(ctx, filter).Decode(upload); err != nil {
+    return nil, err
+  }
+
+  return upload, nil
+}
+
+// SetUploadPart records a received part and extends the session.
+func (r *Repository) SetUploadPart(ctx context.Context, id bson.ObjectID, part *UploadPart, expiresAt time.Time) error {
+  _, err := r.uploads.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+    fmt.Sprintf("parts.%d", part.Number): part,
+    "expires_at":                         expiresAt,
+  }})
+
+  return err
+}
+
+// UnsetUploadPart forgets a received part.
+func (r *Repository) UnsetUploadPart(ctx context.Context, id bson.ObjectID, number int) error {
+  _, err := r.uploads.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$unset": bson.M{
+    fmt.Sprintf("parts.%d", number): "",
+  }})
+
+  return err
+}
+
+// PendingUploadSize sums the size of the open upload sessions of the
+// challenge.
+func (r *Repository) PendingUploadSize(ctx context.Context, challengeID bson.ObjectID) (int64, error) {
+  pipeline := mongo.Pipeline{
+    {{Key: "$match", Value: bson.M{"challenge_id": challengeID, "expires_at": bson.M{"$gt": time.Now().UTC()}}}},
+    {{Key: "$group", Value: bson.M{"_id": nil, "size": bson.M{"$sum": "$size"}}}},
+  }
+
+  cursor, err := r.uploads.Aggregate(ctx, pipeline)
+  if err != nil {
+    return 0, err
+  }
+
+  var results []struct {
+    Size int64 `bson:"size"`
+  }
+  if err := cursor.All(ctx, &results); err != nil {
+    return 0, err
+  }
+  if len(results) == 0 {
+    return 0, nil
+  }
+
+  return results[0].Size, nil
+}
+
+func (r *Repository) DeleteUpload(ctx context.Context, id bson.ObjectID) (bool, error) {
+  result, err := r.uploads.DeleteOne(ctx, bson.M{"_id": id})
+  if err != nil {
+    return false, err
+  }
+
+  return result.DeletedCount == 1, nil
+}
+
+// ListUploadIDs returns the IDs of the upload sessions, expired or not.
+func (r *Repository) ListUploadIDs(ctx context.Context) (map[string]bool, error) {
+  opts := options.Find().SetProjection(bson.M{"_id": 1})
+  cursor, err := r.uploads.Find(ctx, bson.M{}, opts)
+  if err != nil {
+    return nil, err
+  }
+  defer cursor.Close(ctx)
+
+  ids := make(map[string]bool)
+  for cursor.Next(ctx) {
+    var upload struct {
+      ID bson.ObjectID `bson:"_id"`
+    }
+    if err := cursor.Decode(&upload); err != nil {
+      return nil, err
+    }
+    ids[upload.ID.Hex()] = true
+  }
+
+  return ids, cursor.Err()
+}
+
+func (r *Repository) InsertDownloads(ctx context.Context, events []DownloadEvent) error {
+  docs := make([]any, len(events))
+  for i := range events {
+    docs[i] = events[i]
+  }
+
+  _, err := r.downloads.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+  return err
+}
+
+// DownloadStats sums up the downloads since the time (if not zero), grouped
+// by groupBy, e.g. "$file_uuid". filter narrows the downloads.
+func (r *Repository) DownloadStats(ctx context.Context, filter bson.M, since time.Time, groupBy string, results any) error {
+  if !since.IsZero() {
+    filter["time"] = bson.M{"$gte": since}
+  }
+
+  pipeline := mongo.Pipeline{
+    {{Key: "$match", Value: filter}},
+    {{Key: "$group", Value: bson.M{
+      "_id":              groupBy,
+      "downloads":        bson.M{"$sum": bson.M{"$cond": bson.A{"$resumed", 0, 1}}},
+      "completed":        bson.M{"$sum": bson.M{"$cond": bson.A{"$completed", 1, 0}}},
+      "bytes":            bson.M{"$sum": "$bytes"},
+      "users":            bson.M{"$addToSet": "$user_id"},
+      "last_download_at": bson.M{"$max": "$time"},
+    }}},
+    // the signed URL downloads have no user
+    {{Key: "$addFields", Value: bson.M{
+      "unique_users": bson.M{"$size": bson.M{"$setDifference": bson.A{"$users", bson.A{nil, ""}}}},
+    }}},
+  }
+
+  cursor, err := r.downloads.Aggregate(ctx, pipeline)
+  if err != nil {
+    return err
+  }
+
+  return cursor.All(ctx, results)
+}
@@ -10,28 +10,58 @@ import (
   "context"
   "errors"
   "mime/multipart"
-  "os"
-  "path/filepath"
+  "slices"
+  "sync"
+  "time"
 
-  "github.com/gin-gonic/gin"
   "go.mongodb.org/mongo-driver/v2/bson"
+
+  "github.com/CTFxd/ctfxd-server/internal/audit"
+  "github.com/CTFxd/ctfxd-server/pkg/scanner"
+  "github.com/CTFxd/ctfxd-server/pkg/storage"
 )
 
 var (
   ErrFileNotFound = errors.New("file not found")
 )
 
+// Config sets the upload limits (0 for no limit) and the orphan cleaner.
+type Config struct {
+  // size of a file
+  MaxFileSize int64
+  // total size of the files of a challenge
+  MaxChallengeSize int64
+  // age an unreferenced file must reach to be reclaimed
+  OrphanGracePeriod time.Duration
+  // time a reclaimed file is kept in the quarantine, 0 to delete it right away
+  QuarantinePeriod time.Duration
+  // scans the uploaded files if set, ScanPolicy tells what becomes of the
+  // infected ones and OversizePolicy of the ones too large to be scanned
+  Scanner        scanner.Scanner
+  ScanPolicy     string
+  OversizePolicy string
+}
+
 type Service struct {
   repo        *Repository
   fileService *FileService
+  config      Config
+
+  cleanerMu    sync.Mutex
+  cleanerStats CleanerStats
+
+  // queued events of RunDownloadRecorder
+  downloads chan DownloadEvent
 }
 
-func NewService(repo *Repository) *Service {
+func NewService(repo *Repository, store storage.Storage, config Config) *Service {
   serv := new(Service)
-  fileserv := NewFileService()
+  fileserv := NewFileService(store, repo, config)
 
   serv.repo = repo
   serv.fileService = fileserv
+  serv.config = config
+  serv.downloads = make(chan DownloadEvent, downloadQueueSize)
 
   return serv
 }
@@ -48,64 +78,120 @@ func (s *Service) CreateChallenge(ctx context.Context, c *Challenge) error {
   return s.repo.Create(ctx, c)
 }
 
-func (s *Service) UpdateChallenge(ctx context.Context, id string, update *UpdateChallengeRequest) error {
+// UpdateChallenge applies the set fields of update and returns the changes
+// for the audit log, with the flag values redacted.
+func (s *Service) UpdateChallenge(ctx context.Context, id string, update *UpdateChallengeRequest) ([]audit.Change, error) {
+  current, err := s.repo.GetByID(ctx, id)
+  if err != nil {
+    return nil, err
+  }
+
   updateDoc := bson.M{}
+  var changes []audit.Change
+
+  set := func(field string, before, after any) {
+    updateDoc[field] = after
+    if before != after {
+      changes = append(changes, audit.Change{Field: field, Before: before, After: after})
+    }
+  }
 
   if update.Title != nil {
-    updateDoc["title"] = *update.Title
+    set("title", current.Title, *update.Title)
   }
   if update.Category != nil {
-    updateDoc["category"] = *update.Category
+    set("category", current.Category, *update.Category)
   }
   if update.Description != nil {
-    updateDoc["description"] = *update.Description
+    set("description", current.Description, *update.Description)
   }
   if update.Points != nil {
-    updateDoc["points"] = *update.Points
+    set("points", current.Points, *update.Points)
   }
   if update.State != nil {
-    updateDoc["state"] = *update.State
+    set("state", current.State, *update.State)
   }
   if update.Type != nil {
-    updateDoc["type"] = *update.Type
+    set("type", current.Type, *update.Type)
   }
   if update.Solves != nil {
-    updateDoc["solves"] = *update.Solves
+    set("solves", current.Solves, *update.Solves)
   }
   if update.Flag != nil {
     updateDoc["flag"] = *update.Flag
+    if current.Flag != *update.Flag {
+      changes = append(changes, audit.Change{Field: "flag", Before: audit.Redacted, After: audit.Redacted})
+    }
+  }
+  if update.DecoyFlags != nil {
+    updateDoc["decoy_flags"] = *update.DecoyFlags
+    if !slices.Equal(current.DecoyFlags, *update.DecoyFlags) {
+      changes = append(changes, audit.Change{Field: "decoy_flags", Before: audit.Redacted, After: audit.Redacted})
+    }
   }
   if update.Author != nil {
-    updateDoc["author"] = *update.Author
+    set("author", current.Author, *update.Author)
+  }
+  if update.AuthorID != nil {
+    authorID, err := bson.ObjectIDFromHex(*update.AuthorID)
+    if err != nil {
+      return nil, err
+    }
+    set("author_id", current.AuthorID, authorID)
   }
 
-  return s.repo.Update(ctx, id, bson.M{"$set": updateDoc})
-}
+  if len(updateDoc) == 0 {
+    return nil, nil
+  }
 
-func (s *Service) DeleteChallenge(ctx context.Context, id string) error {
-  return s.repo.Delete(ctx, id)
+  return changes, s.repo.Update(ctx, id, bson.M{"$set": updateDoc})
 }
 
-func (s *Service) CreateChallengeWithFiles(ctx context.Context, c *Challenge, form *multipart.Form, gc *gin.Context) error {
-  uploadedFiles, err := s.fileService.processUploads(form.File["files"], gc)
+func (s *Service) DeleteChallenge(ctx context.Context, id string) error {
+  challenge, err := s.repo.GetByID(ctx, id)
   if err != nil {
     return err
   }
 
+  if err := s.repo.Delete(ctx, id); err != nil {
+    return err
+  }
+
+  s.fileService.releaseFiles(challenge.Files)
+
+  return nil
+}
+
+// UploadFiles stores the files of the multipart form of a new challenge, and
+// returns them with the other fields of the form.
+func (s *Service) UploadFiles(ctx context.Context, mr *multipart.Reader) ([]FileMeta, map[string]string, error) {
+  return s.fileService.processUploads(ctx, mr, s.uploadBudget(nil, ""))
+}
+
+// ReleaseFiles drops the uploaded files not attached to a challenge.
+func (s *Service) ReleaseFiles(files []FileMeta) {
+  s.fileService.releaseFiles(files)
+}
+
+// CreateChallengeWithFiles creates the challenge with the uploaded files, the
+// files are released if it fails.
+func (s *Service) CreateChallengeWithFiles(ctx context.Context, c *Challenge, uploadedFiles []FileMeta) error {
   c.Files = uploadedFiles
 
   if err := s.repo.Create(ctx, c); err != nil {
-    // s.fileService.cleanupFiles(uploadedFiles)
+    s.fileService.releaseFiles(uploadedFiles)
     return err
   }
 
   return nil
 }
 
-func (s *Service) UpdateChallengeFile(ctx context.Context, id string, fileUUID string, form *multipart.Form, gc *gin.Context) error {
+// UpdateChallengeFile replaces the file, it returns the replaced file and its
+// replacement.
+func (s *Service) UpdateChallengeFile(ctx context.Context, id string, fileUUID string, mr *multipart.Reader) (*FileMeta, *FileMeta, error) {
   challenge, err := s.repo.GetByID(ctx, id)
   if err != nil {
-    return err
+    return nil, nil, err
   }
 
   fileIndx := -1
@@ -118,37 +204,52 @@ func (s *Service) UpdateChallengeFile(ctx context.Context, id string, fileUUID s
     break
   }
 
-  newFiles, err := s.fileService.processUploads(form.File["files"], gc)
-  if err != nil || len(newFiles) != 1 {
-    return ErrFileNotFound
+  if fileIndx < 0 {
+    return nil, nil, ErrFileNotFound
   }
 
-  // oldFile := challenge.Files[fileIndx]
+  newFiles, _, err := s.fileService.processUploads(ctx, mr, s.uploadBudget(challenge, fileUUID))
+  if err != nil {
+    return nil, nil, err
+  }
+  if len(newFiles) != 1 {
+    s.fileService.releaseFiles(newFiles)
+    return nil, nil, ErrNoFile
+  }
+
+  oldFile := challenge.Files[fileIndx]
   challenge.Files[fileIndx] = newFiles[0]
 
   update := bson.M{"$set": bson.M{"files": challenge.Files}}
   if err := s.repo.Update(ctx, id, update); err != nil {
-    // s.fileService.cleanupFiles(newFiles)
-    return err
+    s.fileService.releaseFiles(newFiles)
+    return nil, nil, err
   }
 
-  // Not need as the cleanup handler with take care of it
-  // s.fileService.cleanupFiles([]FileMeta{oldFile})
+  s.fileService.releaseFiles([]FileMeta{oldFile})
 
-  return nil
+  return &oldFile, &newFiles[0], nil
 }
 
-func (s *Service) AddChallengeFile(ctx context.Context, id string, form *multipart.Form, gc *gin.Context) ([]FileMeta, error) {
-  newFiles, err := s.fileService.processUploads(form.File["files"], gc)
+func (s *Service) AddChallengeFile(ctx context.Context, id string, mr *multipart.Reader) ([]FileMeta, error) {
+  challenge, err := s.repo.GetByID(ctx, id)
+  if err != nil {
+    return nil, err
+  }
+
+  newFiles, _, err := s.fileService.processUploads(ctx, mr, s.uploadBudget(challenge, ""))
   if err != nil {
     return nil, err
   }
+  if len(newFiles) == 0 {
+    return nil, ErrNoFile
+  }
 
   var uploadedFiles []FileMeta
-  for _, newFile := range newFiles {
+  for i, newFile := range newFiles {
     update := bson.M{"$push": bson.M{"files": newFile}}
     if err := s.repo.Update(ctx, id, update); err != nil {
-      // s.fileService.cleanupFiles([]FileMeta{newFile})
+      s.fileService.releaseFiles(newFiles[i:])
       return uploadedFiles, err
     }
 
@@ -158,7 +259,7 @@ func (s *Service) AddChallengeFile(ctx context.Context, id string, form *multipa
   return uploadedFiles, nil
 }
 
-func (s *Service) DeleteChallengeFile(ctx context.Context, id string, fileUUID string, gc *gin.Context) error {
+func (s *Service) DeleteChallengeFile(ctx context.Context, id string, fileUUID string) error {
   challenge, err := s.repo.GetByID(ctx, id)
   if err != nil {
     return err
@@ -190,77 +291,43 @@ func (s *Service) DeleteChallengeFile(ctx context.Context, id string, fileUUID s
     return err
   }
 
-  // Not need as the cleanup handler with take care of it
-  // s.fileService.cleanupFiles([]FileMeta{deletedFile})
+  s.fileService.releaseFiles([]FileMeta{deletedFile})
 
   return nil
 }
 
-func (s *Service) GetChallengeFile(ctx context.Context, id, fileUUID string) (string, string, error) {
-  fileMeta, err := s.FileExistsInChallenge(ctx, id, fileUUID)
-  if err != nil {
-    return "", "", err
+// GetChallengeFile opens the file of the challenge, the caller closes the
+// reader.
+func (s *Service) GetChallengeFile(ctx context.Context, challenge *Challenge, fileUUID string) (*storage.ReadSeeker, *FileMeta, error) {
+  i := slices.IndexFunc(challenge.Files, func(f FileMeta) bool { return f.UUID == fileUUID })
+  if i < 0 {
+    return nil, nil, ErrFileNotFound
   }
+  fileMeta := &challenge.Files[i]
 
-  filePath, err := s.fileService.ValidateFile(fileMeta)
+  r, err := s.fileService.OpenFile(ctx, fileMeta)
   if err != nil {
-    return "", "", err
+    return nil, nil, err
   }
 
-  return filePath, fileMeta.Name, nil
+  return r, fileMeta, nil
 }
 
-func (s *Service) CleanOrphanFileUploads(ctx context.Context) ([]string, error) {
-  challenges, err := s.ListChallenges(ctx)
-  if err != nil {
-    return nil, err
+// uploadBudget is the size the new files of the challenge (nil for a new one)
+// may take, -1 for no limit. The replaced file does not count.
+func (s *Service) uploadBudget(challenge *Challenge, replaced string) int64 {
+  if s.config.MaxChallengeSize <= 0 {
+    return -1
   }
 
-  hasParentFiles := make(map[string]int)
-  for _, challenge := range challenges {
+  budget := s.config.MaxChallengeSize
+  if challenge != nil {
     for _, f := range challenge.Files {
-      hasParentFiles[f.UUID] = 1
+      if f.UUID != replaced {
+        budget -= f.Size
+      }
     }
   }
 
-  files, err := os.ReadDir(s.fileService.uploadDir)
-  if err != nil {
-    return nil, err
-  }
-
-  var removedFiles []string
-
-  for _, file := range files {
-    if file.IsDir() {
-      continue
-    }
-    if _, ok := hasParentFiles[file.Name()]; !ok {
-      os.Remove(filepath.Join(s.fileService.uploadDir, file.Name()))
-      removedFiles = append(removedFiles, file.Name())
-    }
-  }
-
-  return removedFiles, nil
-}
-
-func (s *Service) FileExistsInChallenge(ctx context.Context, id, fileUUID string) (*FileMeta, error) {
-  challenge, err := s.repo.GetByID(ctx, id)
-  if err != nil {
-    return nil, err
-  }
-
-  var downloadFile *FileMeta
-
-  for _, f := range challenge.Files {
-    if f.UUID == fileUUID {
-      downloadFile = &f
-      break
-    }
-  }
-
-  if downloadFile == nil {
-    return nil, ErrFileNotFound
-  }
-
-  return downloadFile, nil
+  return max(budget, 0)
 }
@@ -0,0 +1,326 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package challenge
+
+import (
+  "cmp"
+  "context"
+  "crypto/sha256"
+  "encoding/hex"
+  "errors"
+  "fmt"
+  "hash"
+  "io"
+  "maps"
+  "path"
+  "slices"
+  "strconv"
+  "strings"
+  "time"
+
+  "go.mongodb.org/mongo-driver/v2/bson"
+  "go.mongodb.org/mongo-driver/v2/mongo"
+
+  "github.com/CTFxd/ctfxd-server/pkg/storage"
+)
+
+// Resumable uploads: the client opens an upload session with the name and
+// size of the file, sends its parts (retrying the failed ones, the received
+// parts are listed by the session) and completes it. The parts are then
+// streamed in order to the blob of the file, each checked against the hash
+// taken when it was received.
+const (
+  DefaultPartSize = 16 << 20
+  maxUploadParts  = 10000
+  // extended by every received part
+  uploadTTL = 24 * time.Hour
+
+  // the parts are stored under uploadPrefix/<session>/<number>
+  uploadPrefix = "uploads/"
+)
+
+var (
+  ErrUploadNotFound   = errors.New("upload not found")
+  ErrInvalidUpload    = errors.New("invalid upload")
+  ErrInvalidPart      = errors.New("invalid upload part")
+  ErrUploadIncomplete = errors.New("upload is missing parts")
+  ErrChecksumMismatch = errors.New("uploaded file does not match its checksum")
+  ErrPartCorrupted    = errors.New("stored upload part is corrupted, upload it again")
+)
+
+func uploadPartKey(uploadID string, number int) string {
+  return uploadPrefix + uploadID + "/" + strconv.Itoa(number)
+}
+
+// partLength is the expected size of the part.
+func (u *UploadSession) partLength(number int) int64 {
+  if number == u.PartCount {
+    return u.Size - int64(u.PartCount-1)*u.PartSize
+  }
+
+  return u.PartSize
+}
+
+// InitUpload opens an upload session for a file of the challenge, checked
+// against the size limits. The open sessions of the challenge reserve their
+// size in its budget, concurrent inits may still overshoot it: the budget is
+// checked again at the completion. checksum is the optional hex SHA-256 of
+// the file.
+func (s *Service) InitUpload(ctx context.Context, challengeID, userID, name string, size int64, checksum string) (*UploadSession, error) {
+  challenge, err := s.repo.GetByID(ctx, challengeID)
+  if err != nil {
+    return nil, err
+  }
+
+  name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+  checksum = strings.ToLower(checksum)
+  if name == "." || name == ".." || name == "/" || size <= 0 || (checksum != "" && !validSHA256(checksum)) {
+    return nil, ErrInvalidUpload
+  }
+
+  if limit := s.fileService.fileLimit(); limit >= 0 && size > limit {
+    return nil, ErrFileExcedLimit
+  }
+  if budget := s.uploadBudget(challenge, ""); budget >= 0 {
+    pending, err := s.repo.PendingUploadSize(ctx, challenge.ID)
+    if err != nil {
+      return nil, err
+    }
+    if size > budget-pending {
+      return nil, ErrChallengeSizeExceeded
+    }
+  }
+
+  partSize := max(DefaultPartSize, (size+maxUploadParts-1)/maxUploadParts)
+  now := time.Now().UTC()
+
+  upload := &UploadSession{
+    ChallengeID: challenge.ID,
+    UserID:      userID,
+    Name:        name,
+    Size:        size,
+    SHA256:      checksum,
+    PartSize:    partSize,
+    PartCount:   int((size + partSize - 1) / partSize),
+    Parts:       map[string]UploadPart{},
+    CreatedAt:   now,
+    ExpiresAt:   now.Add(uploadTTL),
+  }
+  if err := s.repo.CreateUpload(ctx, upload); err != nil {
+    return nil, err
+  }
+
+  upload.Received = []UploadPart{}
+  return upload, nil
+}
+
+// GetUpload returns the upload session of the challenge, with its received
+// parts.
+func (s *Service) GetUpload(ctx context.Context, challengeID, uploadID string) (*UploadSession, error) {
+  upload, err := s.repo.GetUpload(ctx, uploadID)
+  if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, bson.ErrInvalidHex) {
+    return nil, ErrUploadNotFound
+  } else if err != nil {
+    return nil, err
+  }
+
+  if upload.ChallengeID.Hex() != challengeID {
+    return nil, ErrUploadNotFound
+  }
+
+  upload.Received = slices.SortedFunc(maps.Values(upload.Parts), func(a, b UploadPart) int {
+    return cmp.Compare(a.Number, b.Number)
+  })
+
+  return upload, nil
+}
+
+// UploadPart stores a part of the upload, replacing any previous attempt. The
+// part must have its exact expected size.
+func (s *Service) UploadPart(ctx context.Context, challengeID, uploadID string, number int, r io.Reader) (*UploadPart, error) {
+  upload, err := s.GetUpload(ctx, challengeID, uploadID)
+  if err != nil {
+    return nil, err
+  }
+
+  if number < 1 || number > upload.PartCount {
+    return nil, ErrInvalidPart
+  }
+
+  length := upload.partLength(number)
+  hr := &hashingReader{r: r, hash: sha256.New(), limit: length, limitErr: ErrInvalidPart}
+
+  key := uploadPartKey(uploadID, number)
+  if err := s.fileService.store.Put(ctx, key, hr, -1); err != nil {
+    if errors.Is(err, ErrInvalidPart) {
+      return nil, ErrInvalidPart
+    }
+    return nil, err
+  }
+
+  if hr.n != length {
+    s.fileService.deleteObject(key)
+    return nil, ErrInvalidPart
+  }
+
+  now := time.Now().UTC()
+  part := &UploadPart{
+    Number:     number,
+    Size:       hr.n,
+    SHA256:     hex.EncodeToString(hr.hash.Sum(nil)),
+    UploadedAt: now,
+  }
+  if err := s.repo.SetUploadPart(ctx, upload.ID, part, now.Add(uploadTTL)); err != nil {
+    return nil, err
+  }
+
+  return part, nil
+}
+
+// CompleteUpload assembles the parts into a file of the challenge and closes
+// the session.
+func (s *Service) CompleteUpload(ctx context.Context, challengeID, uploadID string) (*FileMeta, error) {
+  upload, err := s.GetUpload(ctx, challengeID, uploadID)
+  if err != nil {
+    return nil, err
+  }
+
+  if len(upload.Received) != upload.PartCount {
+    return nil, ErrUploadIncomplete
+  }
+
+  challenge, err := s.repo.GetByID(ctx, challengeID)
+  if err != nil {
+    return nil, err
+  }
+  if budget := s.uploadBudget(challenge, ""); budget >= 0 && upload.Size > budget {
+    return nil, ErrChallengeSizeExceeded
+  }
+
+  r := &partsReader{ctx: ctx, store: s.fileService.store, uploadID: uploadID, parts: upload.Received}
+  defer r.Close()
+
+  file, err := s.fileService.storeStream(ctx, upload.Name, r, upload.Size, ErrInvalidPart)
+  if r.corrupted != 0 {
+    // the client sends the part again
+    if err := s.repo.UnsetUploadPart(ctx, upload.ID, r.corrupted); err != nil {
+      return nil, err
+    }
+    return nil, fmt.Errorf("%w: part %d", ErrPartCorrupted, r.corrupted)
+  }
+  if errors.Is(err, storage.ErrNotFound) {
+    return nil, ErrUploadIncomplete
+  } else if err != nil {
+    return nil, err
+  }
+
+  if file.Size != upload.Size || (upload.SHA256 != "" && file.SHA256 != upload.SHA256) {
+    s.fileService.releaseFiles([]FileMeta{*file})
+    return nil, ErrChecksumMismatch
+  }
+
+  // a concurrent completion or abort closed the session first
+  closed, err := s.repo.DeleteUpload(ctx, upload.ID)
+  if err != nil || !closed {
+    s.fileService.releaseFiles([]FileMeta{*file})
+    return nil, cmp.Or(err, ErrUploadNotFound)
+  }
+  s.deleteUploadParts(upload)
+
+  if err := s.repo.Update(ctx, challengeID, bson.M{"$push": bson.M{"files": file}}); err != nil {
+    s.fileService.releaseFiles([]FileMeta{*file})
+    return nil, err
+  }
+
+  return file, nil
+}
+
+// AbortUpload closes the session and drops its parts.
+func (s *Service) AbortUpload(ctx context.Context, challengeID, uploadID string) error {
+  upload, err := s.GetUpload(ctx, challengeID, uploadID)
+  if err != nil {
+    return err
+  }
+
+  if _, err := s.repo.DeleteUpload(ctx, upload.ID); err != nil {
+    return err
+  }
+  s.deleteUploadParts(upload)
+
+  return nil
+}
+
+func (s *Service) deleteUploadParts(upload *UploadSession) {
+  for number := 1; number <= upload.PartCount; number++ {
+    s.fileService.deleteObject(uploadPartKey(upload.ID.Hex(), number))
+  }
+}
+
+func validSHA256(checksum string) bool {
+  sum, err := hex.DecodeString(checksum)
+  return err == nil && len(sum) == sha256.Size
+}
+
+// partsReader reads the parts of an upload one after the other, failing on
+// a part that differs from the one received.
+type partsReader struct {
+  ctx      context.Context
+  store    storage.Storage
+  uploadID string
+  parts    []UploadPart
+  cur      io.ReadCloser
+  hash     hash.Hash
+  n        int64
+  // number of the part that failed its check
+  corrupted int
+}
+
+func (pr *partsReader) Read(p []byte) (int, error) {
+  for {
+    if pr.cur == nil {
+      if len(pr.parts) == 0 {
+        return 0, io.EOF
+      }
+
+      r, _, err := pr.store.Get(pr.ctx, uploadPartKey(pr.uploadID, pr.parts[0].Number))
+      if err != nil {
+        return 0, err
+      }
+      pr.cur, pr.hash, pr.n = r, sha256.New(), 0
+    }
+
+    n, err := pr.cur.Read(p)
+    pr.hash.Write(p[:n])
+    pr.n += int64(n)
+    if err == io.EOF {
+      pr.cur.Close()
+      pr.cur = nil
+
+      part := pr.parts[0]
+      pr.parts = pr.parts[1:]
+      if pr.n != part.Size || hex.EncodeToString(pr.hash.Sum(nil)) != part.SHA256 {
+        pr.corrupted = part.Number
+        return n, ErrPartCorrupted
+      }
+
+      if n == 0 {
+        continue
+      }
+      err = nil
+    }
+
+    return n, err
+  }
+}
+
+func (pr *partsReader) Close() error {
+  if pr.cur == nil {
+    return nil
+  }
+
+  return pr.cur.Close()
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package challenge
+
+import (
+  "log"
+  "net/http"
+  "strconv"
+
+  "github.com/CTFxd/ctfxd-server/internal/audit"
+  "github.com/CTFxd/ctfxd-server/internal/auth"
+  "github.com/gin-gonic/gin"
+)
+
+type InitUploadRequest struct {
+  Name   string `json:"name" binding:"required"`
+  Size   int64  `json:"size" binding:"required"`
+  // optional hex SHA-256 of the file, checked at the completion
+  SHA256 string `json:"sha256"`
+}
+
+func (h *Handler) InitUpload(c *gin.Context) {
+  var req InitUploadRequest
+  if err := c.ShouldBindJSON(&req); err != nil {
+    log.Printf("challenge: error(%v)\n", err)
+    c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+    return
+  }
+
+  upload, err := h.service.InitUpload(c.Request.Context(), c.Param("id"), auth.GetUserID(c), req.Name, req.Size, req.SHA256)
+  if err != nil {
+    log.Printf("challenge: error(%v)\n", err)
+    uploadError(c, err, "failed to start upload")
+    return
+  }
+
+  c.JSON(http.StatusCreated, upload)
+}
+
+func (h *Handler) GetUpload(c *gin.Context) {
+  upload, err := h.service.GetUpload(c.Request.Context(), c.Param("id"), c.Param("upload"))
+  if err != nil {
+    log.Printf("challenge: error(%v)\n", err)
+    uploadError(c, err, "failed to get upload")
+    return
+  }
+
+  c.JSON(http.StatusOK, upload)
+}
+
+// UploadPart receives a part of the upload as the raw request body.
+func (h *Handler) UploadPart(c *gin.Context) {
+  number, err := strconv.Atoi(c.Param("part"))
+  if err != nil {
+    c.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidPart.Error()})
+    return
+  }
+
+  part, err := h.service.UploadPart(c.Request.Context(), c.Param("id"), c.Param("upload"), number, c.Request.Body)
+  if err != nil {
+    log.Printf("challenge: error(%v)\n", err)
+    uploadError(c, err, "failed to upload part")
+    return
+  }
+
+  c.JSON(http.StatusOK, part)
+}
+
+func (h *Handler) CompleteUpload(c *gin.Context) {
+  challengeID := c.Param("id")
+  uploadID := c.Param("upload")
+
+  file, err := h.service.CompleteUpload(c.Request.Context(), challengeID, uploadID)
+  if err != nil {
+    log.Printf("challenge: error(%v)\n", err)
+    uploadError(c, err, "failed to complete upload")
+    return
+  }
+
+  h.audit.Record(c, audit.Entry{
+    Action:       audit.ActionFileAdd,
+    ResourceType: audit.ResourceChallenge,
+    ResourceID:   challengeID,
+    Metadata:     map[string]any{"files": []string{fileName(file)}, "upload_id": uploadID},
+  })
+
+  c.JSON(http.StatusCreated, file)
+}
+
+func (h *Handler) AbortUpload(c *gin.Context) {
+  if err := h.service.AbortUpload(c.Request.Context(), c.Param("id"), c.Param("upload")); err != nil {
+    log.Printf("challenge: error(%v)\n", err)
+    uploadError(c, err, "failed to abort upload")
+    return
+  }
+
+  c.Status(http.StatusNoContent)
+}
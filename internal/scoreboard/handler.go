@@ -7,10 +7,13 @@
 package scoreboard
 
 import (
+  "errors"
   "log"
   "net/http"
 
   "github.com/gin-gonic/gin"
+  "go.mongodb.org/mongo-driver/v2/bson"
+  "go.mongodb.org/mongo-driver/v2/mongo"
 )
 
 type Handler struct {
@@ -33,3 +36,37 @@ func (h *Handler) Get(c *gin.Context) {
 
   c.JSON(http.StatusOK, scores)
 }
+
+// swagger:operation GET /users/{id} users getUserProfile
+// ---
+// tags: [users]
+// description: Get the public profile of a user with its solves per category
+// parameters:
+//   - name: id
+//     in: path
+//     required: true
+//     type: string
+//
+// responses:
+//
+//  200:
+//    description: The profile of the user
+//  404:
+//    description: User not found
+//  500:
+//    description: Internal server error
+func (h *Handler) GetUserProfile(c *gin.Context) {
+  profile, err := h.service.GetUserProfile(c.Request.Context(), c.Param("id"))
+  if err != nil {
+    if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, bson.ErrInvalidHex) {
+      c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+      return
+    }
+
+    log.Printf("profile: error(%v)\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch profile"})
+    return
+  }
+
+  c.JSON(http.StatusOK, profile)
+}
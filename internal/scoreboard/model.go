@@ -10,11 +10,27 @@ import (
   "time"
 
   "go.mongodb.org/mongo-driver/v2/bson"
+
+  "github.com/CTFxd/ctfxd-server/internal/user"
 )
 
 type Score struct {
-  UserID    bson.ObjectID `bson:"user_id" json:"user_id"`
-  Email     string        `bson:"email" json:"email"`
-  Score     int           `bson:"score" json:"score"`
-  LastSolve time.Time     `bson:"last_solve" json:"last_solve"`
+  UserID      bson.ObjectID `bson:"user_id" json:"user_id"`
+  DisplayName string        `bson:"display_name" json:"display_name"`
+  Country     string        `bson:"country,omitempty" json:"country,omitempty"`
+  Score       int           `bson:"score" json:"score"`
+  LastSolve   time.Time     `bson:"last_solve" json:"last_solve"`
+}
+
+type CategorySolves struct {
+  Category string `bson:"_id" json:"category"`
+  Solves   int    `bson:"solves" json:"solves"`
+  Points   int    `bson:"points" json:"points"`
+}
+
+// UserProfile is the public profile of a user with its solves.
+type UserProfile struct {
+  user.Profile
+  Score  int              `json:"score"`
+  Solves []CategorySolves `json:"solves"`
 }
@@ -10,6 +10,7 @@ import (
   "context"
 
   "github.com/CTFxd/ctfxd-server/internal/submission"
+  "github.com/CTFxd/ctfxd-server/internal/user"
   "go.mongodb.org/mongo-driver/v2/bson"
   "go.mongodb.org/mongo-driver/v2/mongo"
 )
@@ -42,7 +43,7 @@ func (r *Repository) GetScoreboard(ctx context.Context) ([]Score, error) {
       "last_solve": bson.M{"$max": "$timestamp"},
     }}},
 
-    // join users to get the email
+    // join users to get the display name
     bson.D{{Key: "$lookup", Value: bson.M{
       "from":         "users",
       "localField":   "_id", // _id -> user_id from group (Should Work ?)
@@ -51,13 +52,21 @@ func (r *Repository) GetScoreboard(ctx context.Context) ([]Score, error) {
     }}},
     bson.D{{Key: "$unwind", Value: "$user"}},
 
+    // hidden users are left out of the scoreboard
+    bson.D{{Key: "$match", Value: bson.M{"user.hidden": bson.M{"$ne": true}}}},
+
     // projection
     bson.D{{Key: "$project", Value: bson.M{
       "_id":        0,
       "user_id":    "$_id",
       "score":      1,
       "last_solve": 1,
-      "email":      "$user.email",
+      "country":    "$user.country",
+      // same placeholder as user.User.Name for users without a display name
+      "display_name": bson.M{"$ifNull": bson.A{
+        "$user.display_name",
+        bson.M{"$concat": bson.A{user.DefaultNamePrefix, bson.M{"$substrCP": bson.A{bson.M{"$toString": "$_id"}, 16, 8}}}},
+      }},
     }}},
 
     // sort the final result
@@ -84,3 +93,40 @@ func (r *Repository) GetScoreboard(ctx context.Context) ([]Score, error) {
 
   return scores, nil
 }
+
+// GetUserSolves sums up the solves of the user per challenge category.
+func (r *Repository) GetUserSolves(ctx context.Context, userID bson.ObjectID) ([]CategorySolves, error) {
+  pipeline := mongo.Pipeline{
+    bson.D{{Key: "$match", Value: bson.M{"user_id": userID}}},
+    bson.D{{Key: "$lookup", Value: bson.M{
+      "from":         "challenges",
+      "localField":   "challenge_id",
+      "foreignField": "_id",
+      "as":           "challenge",
+    }}},
+    bson.D{{Key: "$unwind", Value: "$challenge"}},
+
+    bson.D{{Key: "$group", Value: bson.M{
+      "_id":    "$challenge.category",
+      "solves": bson.M{"$sum": 1},
+      "points": bson.M{"$sum": "$challenge.points"},
+    }}},
+    bson.D{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+  }
+
+  raw, err := r.submisRepo.AggregateSubmission(ctx, pipeline)
+  if err != nil {
+    return nil, err
+  }
+
+  solves := make([]CategorySolves, 0, len(raw))
+  for _, doc := range raw {
+    var cs CategorySolves
+    bsonBytes, _ := bson.Marshal(doc)
+    if err := bson.Unmarshal(bsonBytes, &cs); err == nil {
+      solves = append(solves, cs)
+    }
+  }
+
+  return solves, nil
+}
@@ -12,6 +12,7 @@ import (
   "time"
 
   "github.com/CTFxd/ctfxd-server/internal/submission"
+  "github.com/CTFxd/ctfxd-server/internal/user"
 )
 
 var scoreBoardCache = struct {
@@ -24,13 +25,15 @@ type scoreBoardWMeta struct {
 }
 
 type Service struct {
-  repo *Repository
+  repo        *Repository
+  userService *user.Service
 }
 
-func NewService(repo *Repository) *Service {
+func NewService(repo *Repository, userService *user.Service) *Service {
   serv := new(Service)
 
   serv.repo = repo
+  serv.userService = userService
   return serv
 }
 
@@ -58,3 +61,25 @@ func (s *Service) GetScoreboard(ctx context.Context) ([]Score, error) {
 
   return scores, nil
 }
+
+func (s *Service) GetUserProfile(ctx context.Context, userID string) (*UserProfile, error) {
+  u, err := s.userService.GetProfile(ctx, userID)
+  if err != nil {
+    return nil, err
+  }
+
+  solves, err := s.repo.GetUserSolves(ctx, u.ID)
+  if err != nil {
+    return nil, err
+  }
+
+  profile := &UserProfile{
+    Profile: u.Profile(),
+    Solves:  solves,
+  }
+  for _, cs := range solves {
+    profile.Score += cs.Points
+  }
+
+  return profile, nil
+}
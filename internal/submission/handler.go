@@ -9,6 +9,8 @@ package submission
 import (
   "log"
   "net/http"
+  "strconv"
+  "time"
 
   "github.com/CTFxd/ctfxd-server/internal/auth"
   "github.com/gin-gonic/gin"
@@ -39,7 +41,7 @@ func (h *Handler) Submit(c *gin.Context) {
 
   userID := auth.GetUserID(c)
   userEmail := auth.GetUserEmail(c)
-  err := h.service.Submit(c.Request.Context(), userID, userEmail, req.ChallengeID, req.Flag)
+  err := h.service.Submit(c.Request.Context(), userID, userEmail, req.ChallengeID, req.Flag, c.ClientIP(), c.Request.UserAgent())
   if err != nil {
     log.Printf("challenge: error(%v)\n", err)
   }
@@ -55,3 +57,73 @@ func (h *Handler) Submit(c *gin.Context) {
     c.JSON(http.StatusInternalServerError, gin.H{"error": "submission failed"})
   }
 }
+
+// swagger:operation GET /admin/cheating/report admin cheatingReport
+// ---
+// tags: [admin]
+// description: Rank the pairs of users whose submissions suggest a shared account or shared flags
+//   (shared IPs, close solves, identical wrong flags, decoy flags), with the evidence. Requires
+//   user management privileges.
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: since
+//     in: query
+//     type: string
+//     format: date-time
+//     description: Only analyse the submissions after this time
+//   - name: window
+//     in: query
+//     type: string
+//     default: 1m0s
+//     description: Solves of the same challenge closer than this are suspicious
+//   - name: limit
+//     in: query
+//     type: integer
+//     default: 50
+//
+// responses:
+//
+//  200:
+//    description: The report, "truncated" when only the latest submissions were analysed
+//  400:
+//    description: Invalid query parameters
+//  500:
+//    description: Internal server error
+func (h *Handler) CheatingReport(c *gin.Context) {
+  var opts ReportOptions
+
+  if since := c.Query("since"); since != "" {
+    t, err := time.Parse(time.RFC3339, since)
+    if err != nil {
+      c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since parameter, expected RFC 3339"})
+      return
+    }
+    opts.Since = t.UTC()
+  }
+
+  if window := c.Query("window"); window != "" {
+    d, err := time.ParseDuration(window)
+    if err != nil || d <= 0 {
+      c.JSON(http.StatusBadRequest, gin.H{"error": "invalid window parameter"})
+      return
+    }
+    opts.Window = d
+  }
+
+  limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(DefaultReportLimit)))
+  if err != nil {
+    c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit parameter"})
+    return
+  }
+  opts.Limit = limit
+
+  report, err := h.service.CheatingReport(c.Request.Context(), opts)
+  if err != nil {
+    log.Printf("cheating report: error(internal): %v\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build report"})
+    return
+  }
+
+  c.JSON(http.StatusOK, report)
+}
@@ -18,4 +18,77 @@ type Submission struct {
   Email       string        `bson:"email" json:"email"`
   ChallengeID bson.ObjectID `bson:"challenge_id" json:"challenge_id"`
   Timestamp   time.Time     `bson:"timestamp" json:"timestamp"`
+  ClientIP    string        `bson:"client_ip,omitempty" json:"client_ip,omitempty"`
+  UserAgent   string        `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+}
+
+// Attempt is a submission that did not solve the challenge: a wrong flag, or
+// the right one for an already solved challenge. Only the solves are kept in
+// Submission, the scoreboard is computed from them.
+type Attempt struct {
+  ID          bson.ObjectID `bson:"_id,omitempty" json:"id"`
+  UserID      bson.ObjectID `bson:"user_id" json:"user_id"`
+  Email       string        `bson:"email" json:"email"`
+  ChallengeID bson.ObjectID `bson:"challenge_id" json:"challenge_id"`
+  Flag        string        `bson:"flag" json:"flag"`
+  Correct     bool          `bson:"correct" json:"correct"`
+  // the flag is one of the decoy flags of the challenge
+  Decoy     bool      `bson:"decoy,omitempty" json:"decoy,omitempty"`
+  Timestamp time.Time `bson:"timestamp" json:"timestamp"`
+  ClientIP  string    `bson:"client_ip" json:"client_ip"`
+  UserAgent string    `bson:"user_agent" json:"user_agent"`
+}
+
+// Signal kinds of the cheating report
+const (
+  SignalSharedIP        = "shared_ip"
+  SignalCloseSolves     = "close_solves"
+  SignalSharedWrongFlag = "shared_wrong_flag"
+  SignalDecoyFlag       = "decoy_flag"
+)
+
+type UserRef struct {
+  ID    string `json:"id"`
+  Email string `json:"email"`
+}
+
+type Evidence struct {
+  Kind        string     `json:"kind"`
+  ChallengeID string     `json:"challenge_id,omitempty"`
+  Detail      string     `json:"detail"`
+  Time        *time.Time `json:"time,omitempty"`
+}
+
+// SuspiciousPair is two users whose activity suggests a shared account or
+// shared flags, Score ranks the pairs.
+type SuspiciousPair struct {
+  Users    [2]UserRef `json:"users"`
+  Score    int        `json:"score"`
+  Evidence []Evidence `json:"evidence"`
+}
+
+// SuspiciousUser is a user who submitted a decoy flag.
+type SuspiciousUser struct {
+  User     UserRef    `json:"user"`
+  Score    int        `json:"score"`
+  Evidence []Evidence `json:"evidence"`
+}
+
+type ReportOptions struct {
+  // only the activity after Since is analysed (zero: everything)
+  Since time.Time
+  // solves of the same challenge closer than Window are suspicious
+  Window time.Duration
+  // number of pairs returned
+  Limit int
+}
+
+type Report struct {
+  GeneratedAt time.Time        `json:"generated_at"`
+  Since       *time.Time       `json:"since,omitempty"`
+  Window      string           `json:"window"`
+  Pairs       []SuspiciousPair `json:"pairs"`
+  Users       []SuspiciousUser `json:"users"`
+  // older submissions were left out of the analysis
+  Truncated bool `json:"truncated,omitempty"`
 }
@@ -0,0 +1,317 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package submission
+
+import (
+  "cmp"
+  "context"
+  "fmt"
+  "maps"
+  "slices"
+  "time"
+)
+
+const (
+  DefaultReportWindow = time.Minute
+  DefaultReportLimit  = 50
+  maxReportLimit      = 500
+
+  // an IP shared by more users is a NAT (venue, campus), not evidence
+  maxUsersPerIP = 8
+  // a wrong flag submitted by more users is a common guess
+  maxUsersPerWrongFlag = 5
+  // only the first users submitting a decoy are paired, the pairs grow with
+  // the square of the users
+  maxUsersPerDecoyPairs = 20
+  // the latest solves and attempts analysed, each
+  maxReportSubmissions = 50000
+  maxEvidence          = 20
+  maxFlagExcerpt       = 64
+)
+
+// weights of the signals in the score of a pair
+const (
+  weightSharedIP        = 3
+  weightSharedIPAndUA   = 5
+  weightCloseSolves     = 2
+  weightSharedWrongFlag = 4
+  weightSharedDecoy     = 8
+  weightDecoy           = 10
+)
+
+type pairKey [2]string
+
+type reportBuilder struct {
+  users   map[string]UserRef
+  pairs   map[pairKey]*SuspiciousPair
+  flagged map[string]*SuspiciousUser
+}
+
+// CheatingReport correlates the submissions of the users: shared IPs, solves
+// of the same challenge close in time, identical wrong flags and decoy flags.
+// The pairs are ranked by score. Only the latest maxReportSubmissions solves
+// and attempts are analysed, the report tells when older ones were left out.
+//
+// Submitting the flag of another user is not a signal: the flags are the
+// same for every user, there are no per-user flags to tell them apart.
+func (s *Service) CheatingReport(ctx context.Context, opts ReportOptions) (*Report, error) {
+  if opts.Window <= 0 {
+    opts.Window = DefaultReportWindow
+  }
+  if opts.Limit < 1 || opts.Limit > maxReportLimit {
+    opts.Limit = DefaultReportLimit
+  }
+
+  solves, err := s.repo.ListSolves(ctx, opts.Since, maxReportSubmissions)
+  if err != nil {
+    return nil, err
+  }
+
+  attempts, err := s.repo.ListAttempts(ctx, opts.Since, maxReportSubmissions)
+  if err != nil {
+    return nil, err
+  }
+
+  b := &reportBuilder{
+    users:   make(map[string]UserRef),
+    pairs:   make(map[pairKey]*SuspiciousPair),
+    flagged: make(map[string]*SuspiciousUser),
+  }
+
+  b.sharedIPs(solves, attempts)
+  b.closeSolves(solves, opts.Window)
+  b.sharedWrongFlags(attempts)
+
+  report := &Report{
+    GeneratedAt: time.Now().UTC(),
+    Window:      opts.Window.String(),
+    Pairs:       make([]SuspiciousPair, 0, len(b.pairs)),
+    Users:       make([]SuspiciousUser, 0, len(b.flagged)),
+    Truncated:   len(solves) == maxReportSubmissions || len(attempts) == maxReportSubmissions,
+  }
+  if !opts.Since.IsZero() {
+    report.Since = &opts.Since
+  }
+
+  for _, pair := range b.pairs {
+    report.Pairs = append(report.Pairs, *pair)
+  }
+  slices.SortFunc(report.Pairs, func(a, b SuspiciousPair) int {
+    if a.Score != b.Score {
+      return b.Score - a.Score
+    }
+    return cmp.Or(cmp.Compare(a.Users[0].ID, b.Users[0].ID), cmp.Compare(a.Users[1].ID, b.Users[1].ID))
+  })
+  if len(report.Pairs) > opts.Limit {
+    report.Pairs = report.Pairs[:opts.Limit]
+  }
+
+  for _, user := range b.flagged {
+    report.Users = append(report.Users, *user)
+  }
+  slices.SortFunc(report.Users, func(a, b SuspiciousUser) int {
+    if a.Score != b.Score {
+      return b.Score - a.Score
+    }
+    return cmp.Compare(a.User.ID, b.User.ID)
+  })
+
+  return report, nil
+}
+
+// sharedIPs pairs the users submitting from the same IP, more so with the
+// same user agent.
+func (b *reportBuilder) sharedIPs(solves []Submission, attempts []Attempt) {
+  // ip -> user -> user agents
+  ips := make(map[string]map[string]map[string]bool)
+  seen := func(ip, userID, email, userAgent string) {
+    if ip == "" {
+      return
+    }
+
+    b.users[userID] = UserRef{ID: userID, Email: email}
+    if ips[ip] == nil {
+      ips[ip] = make(map[string]map[string]bool)
+    }
+    if ips[ip][userID] == nil {
+      ips[ip][userID] = make(map[string]bool)
+    }
+    ips[ip][userID][userAgent] = true
+  }
+
+  for _, sub := range solves {
+    seen(sub.ClientIP, sub.UserID.Hex(), sub.Email, sub.UserAgent)
+  }
+  for _, attempt := range attempts {
+    seen(attempt.ClientIP, attempt.UserID.Hex(), attempt.Email, attempt.UserAgent)
+  }
+
+  for _, ip := range slices.Sorted(maps.Keys(ips)) {
+    users := ips[ip]
+    if len(users) < 2 || len(users) > maxUsersPerIP {
+      continue
+    }
+
+    ids := slices.Sorted(maps.Keys(users))
+    for i := range ids {
+      for j := i + 1; j < len(ids); j++ {
+        weight, detail := weightSharedIP, "submitted from "+ip
+        for userAgent := range users[ids[i]] {
+          if users[ids[j]][userAgent] {
+            weight, detail = weightSharedIPAndUA, "submitted from "+ip+" with the same user agent"
+            break
+          }
+        }
+
+        b.addPair(ids[i], ids[j], weight, Evidence{Kind: SignalSharedIP, Detail: detail})
+      }
+    }
+  }
+}
+
+// closeSolves pairs the users solving the same challenge within the window.
+func (b *reportBuilder) closeSolves(solves []Submission, window time.Duration) {
+  byChallenge := make(map[string][]Submission)
+  for _, sub := range solves {
+    b.users[sub.UserID.Hex()] = UserRef{ID: sub.UserID.Hex(), Email: sub.Email}
+    byChallenge[sub.ChallengeID.Hex()] = append(byChallenge[sub.ChallengeID.Hex()], sub)
+  }
+
+  for challengeID, subs := range byChallenge {
+    // subs are sorted by time
+    for i := range subs {
+      for j := i + 1; j < len(subs) && subs[j].Timestamp.Sub(subs[i].Timestamp) <= window; j++ {
+        if subs[i].UserID == subs[j].UserID {
+          continue
+        }
+
+        solvedAt := subs[j].Timestamp
+        b.addPair(subs[i].UserID.Hex(), subs[j].UserID.Hex(), weightCloseSolves, Evidence{
+          Kind:        SignalCloseSolves,
+          ChallengeID: challengeID,
+          Detail:      fmt.Sprintf("solved %s apart", subs[j].Timestamp.Sub(subs[i].Timestamp).Round(time.Second)),
+          Time:        &solvedAt,
+        })
+      }
+    }
+  }
+}
+
+// sharedWrongFlags pairs the users submitting the same wrong flag, and
+// reports the users submitting a decoy flag. A leaked decoy is only paired
+// between its first maxUsersPerDecoyPairs submitters, the others are still
+// reported on their own.
+func (b *reportBuilder) sharedWrongFlags(attempts []Attempt) {
+  type wrongFlag struct {
+    challengeID string
+    flag        string
+  }
+
+  // wrong flag -> user -> first attempt
+  wrong := make(map[wrongFlag]map[string]Attempt)
+  decoys := make(map[wrongFlag]bool)
+  for _, attempt := range attempts {
+    if attempt.Correct || attempt.Flag == "" {
+      continue
+    }
+
+    userID := attempt.UserID.Hex()
+    b.users[userID] = UserRef{ID: userID, Email: attempt.Email}
+
+    if attempt.Decoy {
+      submittedAt := attempt.Timestamp
+      b.flagUser(userID, Evidence{
+        Kind:        SignalDecoyFlag,
+        ChallengeID: attempt.ChallengeID.Hex(),
+        Detail:      "submitted the decoy flag " + excerpt(attempt.Flag),
+        Time:        &submittedAt,
+      })
+    }
+
+    key := wrongFlag{challengeID: attempt.ChallengeID.Hex(), flag: attempt.Flag}
+    if attempt.Decoy {
+      decoys[key] = true
+    }
+    if wrong[key] == nil {
+      wrong[key] = make(map[string]Attempt)
+    }
+    if _, ok := wrong[key][userID]; !ok {
+      wrong[key][userID] = attempt
+    }
+  }
+
+  for key, users := range wrong {
+    // a decoy is never a common guess
+    if len(users) < 2 || (!decoys[key] && len(users) > maxUsersPerWrongFlag) {
+      continue
+    }
+
+    kind, weight, detail := SignalSharedWrongFlag, weightSharedWrongFlag, "both submitted the wrong flag "
+    if decoys[key] {
+      kind, weight, detail = SignalDecoyFlag, weightSharedDecoy, "both submitted the decoy flag "
+    }
+
+    ids := slices.Sorted(maps.Keys(users))
+    if len(ids) > maxUsersPerDecoyPairs {
+      slices.SortStableFunc(ids, func(a, b string) int {
+        return users[a].Timestamp.Compare(users[b].Timestamp)
+      })
+      ids = slices.Sorted(slices.Values(ids[:maxUsersPerDecoyPairs]))
+    }
+
+    for i := range ids {
+      for j := i + 1; j < len(ids); j++ {
+        submittedAt := users[ids[j]].Timestamp
+        b.addPair(ids[i], ids[j], weight, Evidence{
+          Kind:        kind,
+          ChallengeID: key.challengeID,
+          Detail:      detail + excerpt(key.flag),
+          Time:        &submittedAt,
+        })
+      }
+    }
+  }
+}
+
+func (b *reportBuilder) addPair(userA, userB string, weight int, evidence Evidence) {
+  if userA > userB {
+    userA, userB = userB, userA
+  }
+
+  key := pairKey{userA, userB}
+  pair, ok := b.pairs[key]
+  if !ok {
+    pair = &SuspiciousPair{Users: [2]UserRef{b.users[userA], b.users[userB]}}
+    b.pairs[key] = pair
+  }
+
+  pair.Score += weight
+  if len(pair.Evidence) < maxEvidence {
+    pair.Evidence = append(pair.Evidence, evidence)
+  }
+}
+
+func (b *reportBuilder) flagUser(userID string, evidence Evidence) {
+  user, ok := b.flagged[userID]
+  if !ok {
+    user = &SuspiciousUser{User: b.users[userID]}
+    b.flagged[userID] = user
+  }
+
+  user.Score += weightDecoy
+  if len(user.Evidence) < maxEvidence {
+    user.Evidence = append(user.Evidence, evidence)
+  }
+}
+
+func excerpt(flag string) string {
+  if len(flag) > maxFlagExcerpt {
+    flag = flag[:maxFlagExcerpt] + "..."
+  }
+
+  return fmt.Sprintf("%q", flag)
+}
@@ -8,18 +8,35 @@ package submission
 
 import (
   "context"
+  "log"
+  "slices"
+  "time"
 
   "go.mongodb.org/mongo-driver/v2/bson"
   "go.mongodb.org/mongo-driver/v2/mongo"
+  "go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 type Repository struct {
   collection *mongo.Collection
+  attempts   *mongo.Collection
 }
 
 func NewRepository(db *mongo.Database) *Repository {
   repo := new(Repository)
   repo.collection = db.Collection("submissions")
+  repo.attempts = db.Collection("submission_attempts")
+
+  ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+  defer cancel()
+
+  _, err := repo.attempts.Indexes().CreateMany(ctx, []mongo.IndexModel{
+    {Keys: bson.D{{Key: "timestamp", Value: 1}}},
+    {Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "timestamp", Value: 1}}},
+  })
+  if err != nil {
+    log.Printf("submission: error: indexes(%v)\n", err)
+  }
 
   return repo
 }
@@ -30,6 +47,49 @@ func (r *Repository) Create(ctx context.Context, s *Submission) error {
   return err
 }
 
+func (r *Repository) CreateAttempt(ctx context.Context, a *Attempt) error {
+  _, err := r.attempts.InsertOne(ctx, a)
+
+  return err
+}
+
+// ListSolves returns the latest limit solves since the time, oldest first.
+func (r *Repository) ListSolves(ctx context.Context, since time.Time, limit int64) ([]Submission, error) {
+  opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(limit)
+
+  cursor, err := r.collection.Find(ctx, bson.M{"timestamp": bson.M{"$gte": since}}, opts)
+  if err != nil {
+    return nil, err
+  }
+
+  solves := []Submission{}
+  if err := cursor.All(ctx, &solves); err != nil {
+    return nil, err
+  }
+  slices.Reverse(solves)
+
+  return solves, nil
+}
+
+// ListAttempts returns the latest limit attempts since the time, oldest
+// first.
+func (r *Repository) ListAttempts(ctx context.Context, since time.Time, limit int64) ([]Attempt, error) {
+  opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(limit)
+
+  cursor, err := r.attempts.Find(ctx, bson.M{"timestamp": bson.M{"$gte": since}}, opts)
+  if err != nil {
+    return nil, err
+  }
+
+  attempts := []Attempt{}
+  if err := cursor.All(ctx, &attempts); err != nil {
+    return nil, err
+  }
+  slices.Reverse(attempts)
+
+  return attempts, nil
+}
+
 func (r *Repository) HasSolved(ctx context.Context, email string, challengeID string) (bool, error) {
   objId, err := bson.ObjectIDFromHex(challengeID)
   if err != nil {
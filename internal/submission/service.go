@@ -9,6 +9,8 @@ package submission
 import (
   "context"
   "errors"
+  "log"
+  "slices"
   "sync"
   "time"
 
@@ -16,6 +18,8 @@ import (
   "go.mongodb.org/mongo-driver/v2/bson"
 )
 
+const maxUserAgentLength = 512
+
 var (
   ErrAlreadySolved = errors.New("already solved")
   ErrIncorrectFlag = errors.New("incorrect flag")
@@ -40,13 +44,37 @@ func NewService(repo *Repository, challengeServ *challenge.Service) *Service {
   return serv
 }
 
-func (s *Service) Submit(ctx context.Context, userID, email, challengeID, submittedFlag string) error {
+// Submit checks the flag and records the solve. The other submissions are
+// kept as attempts, with the client IP and user agent, for the cheating
+// report.
+func (s *Service) Submit(ctx context.Context, userID, email, challengeID, submittedFlag, clientIP, userAgent string) error {
   challenge, err := s.challengeServ.GetChallenge(ctx, challengeID)
   if err != nil {
     return err
   }
 
+  userObjID, err := bson.ObjectIDFromHex(userID)
+  if err != nil {
+    return err
+  }
+
+  if len(userAgent) > maxUserAgentLength {
+    userAgent = userAgent[:maxUserAgentLength]
+  }
+
+  attempt := &Attempt{
+    UserID:      userObjID,
+    Email:       email,
+    ChallengeID: challenge.ID,
+    Flag:        submittedFlag,
+    Timestamp:   time.Now().UTC(),
+    ClientIP:    clientIP,
+    UserAgent:   userAgent,
+  }
+
   if challenge.Flag != submittedFlag {
+    attempt.Decoy = slices.Contains(challenge.DecoyFlags, submittedFlag)
+    s.recordAttempt(ctx, attempt)
     return ErrIncorrectFlag
   }
 
@@ -55,24 +83,20 @@ func (s *Service) Submit(ctx context.Context, userID, email, challengeID, submit
     return err
   }
   if solved {
+    attempt.Correct = true
+    // the flag itself is known, no need to keep it
+    attempt.Flag = ""
+    s.recordAttempt(ctx, attempt)
     return ErrAlreadySolved
   }
 
-  userObjID, err := bson.ObjectIDFromHex(userID)
-  if err != nil {
-    return err
-  }
-
-  chalObjID, err := bson.ObjectIDFromHex(challengeID)
-  if err != nil {
-    return err
-  }
-
   sub := &Submission{
     UserID:      userObjID,
     Email:       email,
-    ChallengeID: chalObjID,
-    Timestamp:   time.Now().UTC(),
+    ChallengeID: challenge.ID,
+    Timestamp:   attempt.Timestamp,
+    ClientIP:    clientIP,
+    UserAgent:   userAgent,
   }
 
   err = s.repo.Create(ctx, sub)
@@ -86,3 +110,10 @@ func (s *Service) Submit(ctx context.Context, userID, email, challengeID, submit
 
   return nil
 }
+
+// recordAttempt does not fail the submission, the answer is already known.
+func (s *Service) recordAttempt(ctx context.Context, attempt *Attempt) {
+  if err := s.repo.CreateAttempt(ctx, attempt); err != nil {
+    log.Printf("submission: error: attempt(%v)\n", err)
+  }
+}
@@ -0,0 +1,233 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package user
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "regexp"
+  "time"
+
+  "go.mongodb.org/mongo-driver/v2/bson"
+  "go.mongodb.org/mongo-driver/v2/mongo"
+
+  "github.com/CTFxd/ctfxd-server/internal/auth"
+)
+
+const maxPageSize = 100
+
+var (
+  ErrUserBanned      = errors.New("user is banned")
+  ErrSelfModeration  = errors.New("cannot moderate your own account")
+  ErrTargetPrivilege = errors.New("not allowed to manage privileged accounts")
+  ErrInvalidBanEnd   = errors.New("ban expiry must be in the future")
+)
+
+type UserFilter struct {
+  // substring of the email, case insensitive
+  Query  string
+  Role   string
+  Banned *bool
+  Hidden *bool
+}
+
+type UserPage struct {
+  Users   []UserSummary `json:"users"`
+  Total   int64         `json:"total"`
+  Page    int64         `json:"page"`
+  PerPage int64         `json:"per_page"`
+}
+
+// CheckAccount implements auth.AccountChecker, it rejects the banned and
+// deleted accounts.
+func (s *Service) CheckAccount(ctx context.Context, userID string) error {
+  user, err := s.repo.GetUserByID(ctx, userID)
+  if err != nil {
+    if errors.Is(err, mongo.ErrNoDocuments) {
+      return auth.ErrAccountDisabled
+    }
+
+    return err
+  }
+
+  if user.Ban.Active(time.Now()) {
+    return fmt.Errorf("%w: %w", auth.ErrAccountDisabled, ErrUserBanned)
+  }
+
+  return nil
+}
+
+func (s *Service) ListUsers(ctx context.Context, filter *UserFilter, page, perPage int64) (*UserPage, error) {
+  if page < 1 {
+    page = 1
+  }
+  if perPage < 1 || perPage > maxPageSize {
+    perPage = maxPageSize
+  }
+
+  query := bson.M{}
+  if filter.Query != "" {
+    query["email"] = bson.M{"$regex": regexp.QuoteMeta(filter.Query), "$options": "i"}
+  }
+  if filter.Role != "" {
+    query["role"] = filter.Role
+  }
+  if filter.Hidden != nil {
+    if *filter.Hidden {
+      query["hidden"] = true
+    } else {
+      query["hidden"] = bson.M{"$ne": true}
+    }
+  }
+  if filter.Banned != nil {
+    active := bson.M{
+      "ban": bson.M{"$exists": true},
+      "$or": bson.A{
+        bson.M{"ban.expires_at": bson.M{"$exists": false}},
+        bson.M{"ban.expires_at": bson.M{"$gt": time.Now().UTC()}},
+      },
+    }
+
+    if *filter.Banned {
+      query["$and"] = bson.A{active}
+    } else {
+      query["$nor"] = bson.A{active}
+    }
+  }
+
+  users, total, err := s.repo.ListUsers(ctx, query, (page-1)*perPage, perPage)
+  if err != nil {
+    return nil, err
+  }
+
+  summaries := make([]UserSummary, 0, len(users))
+  for i := range users {
+    summaries = append(summaries, users[i].Summary())
+  }
+
+  return &UserPage{
+    Users:   summaries,
+    Total:   total,
+    Page:    page,
+    PerPage: perPage,
+  }, nil
+}
+
+// SetRole changes the role of the user and revokes the sessions, so the new
+// role applies right away. It returns the previous role.
+func (s *Service) SetRole(ctx context.Context, actor *auth.Claims, userID, role string) (string, error) {
+  if !auth.IsValidRole(role) {
+    return "", ErrInvalidRole
+  }
+
+  user, err := s.moderationTarget(ctx, actor, userID)
+  if err != nil {
+    return "", err
+  }
+
+  if err := s.repo.Update(ctx, user.ID, bson.M{"$set": bson.M{"role": role}}); err != nil {
+    return "", err
+  }
+
+  return user.Role, s.tokens.RevokeUser(ctx, userID)
+}
+
+// Ban bans the user until expiresAt (forever if nil) and ends its sessions.
+func (s *Service) Ban(ctx context.Context, actor *auth.Claims, userID, reason string, expiresAt *time.Time) error {
+  if expiresAt != nil && !expiresAt.After(time.Now()) {
+    return ErrInvalidBanEnd
+  }
+
+  user, err := s.moderationTarget(ctx, actor, userID)
+  if err != nil {
+    return err
+  }
+
+  actorID, err := bson.ObjectIDFromHex(actor.UserID)
+  if err != nil {
+    return err
+  }
+
+  ban := &Ban{
+    Reason:    reason,
+    BannedBy:  actorID,
+    BannedAt:  time.Now().UTC(),
+    ExpiresAt: expiresAt,
+  }
+
+  if err := s.repo.Update(ctx, user.ID, bson.M{"$set": bson.M{"ban": ban}}); err != nil {
+    return err
+  }
+
+  return s.tokens.RevokeUser(ctx, userID)
+}
+
+func (s *Service) Unban(ctx context.Context, actor *auth.Claims, userID string) error {
+  user, err := s.moderationTarget(ctx, actor, userID)
+  if err != nil {
+    return err
+  }
+
+  return s.repo.Update(ctx, user.ID, bson.M{"$unset": bson.M{"ban": ""}})
+}
+
+// SetHidden hides the user from the scoreboard, or shows it again.
+func (s *Service) SetHidden(ctx context.Context, actor *auth.Claims, userID string, hidden bool) error {
+  user, err := s.moderationTarget(ctx, actor, userID)
+  if err != nil {
+    return err
+  }
+
+  update := bson.M{"$unset": bson.M{"hidden": ""}}
+  if hidden {
+    update = bson.M{"$set": bson.M{"hidden": true}}
+  }
+
+  return s.repo.Update(ctx, user.ID, update)
+}
+
+// DeleteUser deletes the account, ends its sessions and drops the data of the
+// registered cleaners. The submissions are kept but no longer appear on the
+// scoreboard.
+func (s *Service) DeleteUser(ctx context.Context, actor *auth.Claims, userID string) error {
+  user, err := s.moderationTarget(ctx, actor, userID)
+  if err != nil {
+    return err
+  }
+
+  if err := s.repo.DeleteUser(ctx, user.ID); err != nil {
+    return err
+  }
+
+  for _, cleaner := range s.cleaners {
+    if err := cleaner.DeleteUserData(ctx, userID); err != nil {
+      return err
+    }
+  }
+
+  return s.tokens.RevokeUser(ctx, userID)
+}
+
+// moderationTarget loads the user to moderate, making sure the actor may act
+// on it: never on itself, and only role managers act on privileged accounts.
+func (s *Service) moderationTarget(ctx context.Context, actor *auth.Claims, userID string) (*User, error) {
+  if actor.UserID == userID {
+    return nil, ErrSelfModeration
+  }
+
+  user, err := s.repo.GetUserByID(ctx, userID)
+  if err != nil {
+    return nil, err
+  }
+
+  if auth.IsPrivilegedRole(user.Role) && !auth.HasPermission(actor.Role, auth.PermManageRoles) {
+    return nil, ErrTargetPrivilege
+  }
+
+  return user, nil
+}
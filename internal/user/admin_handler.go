@@ -0,0 +1,681 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package user
+
+import (
+  "errors"
+  "log"
+  "net/http"
+  "strconv"
+  "time"
+
+  "github.com/gin-gonic/gin"
+  "go.mongodb.org/mongo-driver/v2/bson"
+  "go.mongodb.org/mongo-driver/v2/mongo"
+
+  "github.com/CTFxd/ctfxd-server/internal/audit"
+  "github.com/CTFxd/ctfxd-server/internal/auth"
+)
+
+// swagger:model SetRoleRequest
+type SetRoleRequest struct {
+  // New role of the user
+  // required: true
+  // enum: user,author,moderator,admin
+  // example: author
+  Role string `json:"role" binding:"required"`
+}
+
+// swagger:model BanRequest
+type BanRequest struct {
+  // Reason shown to the banned user
+  // example: flag sharing
+  Reason string `json:"reason" binding:"max=500"`
+
+  // End of the ban, the ban is permanent when omitted
+  // example: 2025-12-31T00:00:00Z
+  ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// swagger:model SetHiddenRequest
+type SetHiddenRequest struct {
+  // Hide the user from the scoreboard
+  // required: true
+  // example: true
+  Hidden *bool `json:"hidden" binding:"required"`
+}
+
+// swagger:model RegistrationSettingsRequest
+type RegistrationSettingsRequest struct {
+  // Registration mode
+  // required: true
+  // enum: open,invite,domain,closed
+  // example: domain
+  Mode string `json:"mode" binding:"required"`
+
+  // Allowed email domains (and their subdomains) in the domain mode
+  // example: ["university.edu"]
+  Domains []string `json:"domains"`
+}
+
+// swagger:model CreateInviteRequest
+type CreateInviteRequest struct {
+  // Custom code, a random one is generated when omitted
+  // example: FINALS2025
+  Code string `json:"code"`
+
+  // Note for the admins, e.g. who the code was given to
+  // example: team invites for the finals
+  Note string `json:"note" binding:"max=200"`
+
+  // Number of accounts that can be created with the code, defaults to 1
+  // example: 4
+  MaxUses int `json:"max_uses"`
+
+  // Expiry of the code, never expires when omitted
+  // example: 2025-12-31T00:00:00Z
+  ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// swagger:operation GET /admin/users admin listUsers
+// ---
+// tags: [admin]
+// description: Search the users (requires user management privileges)
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: q
+//     in: query
+//     type: string
+//     description: Part of the email address
+//   - name: role
+//     in: query
+//     type: string
+//   - name: banned
+//     in: query
+//     type: boolean
+//   - name: hidden
+//     in: query
+//     type: boolean
+//   - name: page
+//     in: query
+//     type: integer
+//     default: 1
+//   - name: per_page
+//     in: query
+//     type: integer
+//     default: 100
+//
+// responses:
+//
+//  200:
+//    description: A page of users
+//  400:
+//    description: Invalid query parameters
+//  500:
+//    description: Internal server error
+func (h *Handler) ListUsers(c *gin.Context) {
+  filter := &UserFilter{
+    Query: c.Query("q"),
+    Role:  c.Query("role"),
+  }
+
+  var err error
+  if filter.Banned, err = boolQuery(c, "banned"); err != nil {
+    c.JSON(http.StatusBadRequest, gin.H{"error": "invalid banned parameter"})
+    return
+  }
+  if filter.Hidden, err = boolQuery(c, "hidden"); err != nil {
+    c.JSON(http.StatusBadRequest, gin.H{"error": "invalid hidden parameter"})
+    return
+  }
+
+  page, err := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 64)
+  if err != nil {
+    c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page parameter"})
+    return
+  }
+
+  perPage, err := strconv.ParseInt(c.DefaultQuery("per_page", strconv.Itoa(maxPageSize)), 10, 64)
+  if err != nil {
+    c.JSON(http.StatusBadRequest, gin.H{"error": "invalid per_page parameter"})
+    return
+  }
+
+  users, err := h.service.ListUsers(c.Request.Context(), filter, page, perPage)
+  if err != nil {
+    log.Printf("list users: error(internal): %v\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list users"})
+    return
+  }
+
+  c.JSON(http.StatusOK, users)
+}
+
+// swagger:operation GET /admin/users/{id} admin getUser
+// ---
+// tags: [admin]
+// description: Get the details of a user (requires user management privileges)
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: id
+//     in: path
+//     required: true
+//     type: string
+//
+// responses:
+//
+//  200:
+//    description: The user
+//  404:
+//    description: User not found
+//  500:
+//    description: Internal server error
+func (h *Handler) GetUser(c *gin.Context) {
+  user, err := h.service.GetUser(c.Request.Context(), c.Param("id"))
+  if err != nil {
+    writeModerationError(c, err, "failed to get user")
+    return
+  }
+
+  c.JSON(http.StatusOK, user.Summary())
+}
+
+// swagger:operation PUT /admin/users/{id}/role admin setUserRole
+// ---
+// tags: [admin]
+// description: Change the role of a user and end its sessions (requires role management privileges)
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: id
+//     in: path
+//     required: true
+//     type: string
+//   - name: body
+//     in: body
+//     required: true
+//     schema:
+//       $ref: '#/definitions/SetRoleRequest'
+//
+// responses:
+//
+//  204:
+//    description: Role changed
+//  400:
+//    description: Invalid role
+//  403:
+//    description: Cannot change your own role
+//  404:
+//    description: User not found
+//  500:
+//    description: Internal server error
+func (h *Handler) SetUserRole(c *gin.Context) {
+  var req SetRoleRequest
+  if err := c.ShouldBindJSON(&req); err != nil {
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    return
+  }
+
+  previous, err := h.service.SetRole(c.Request.Context(), auth.GetClaims(c), c.Param("id"), req.Role)
+  if err != nil {
+    writeModerationError(c, err, "failed to change role")
+    return
+  }
+
+  h.audit.Record(c, audit.Entry{
+    Action:       audit.ActionUserRoleChange,
+    ResourceType: audit.ResourceUser,
+    ResourceID:   c.Param("id"),
+    Changes:      []audit.Change{{Field: "role", Before: previous, After: req.Role}},
+  })
+
+  c.Status(http.StatusNoContent)
+}
+
+// swagger:operation POST /admin/users/{id}/ban admin banUser
+// ---
+// tags: [admin]
+// description: Ban a user and end its sessions (requires user management privileges)
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: id
+//     in: path
+//     required: true
+//     type: string
+//   - name: body
+//     in: body
+//     required: true
+//     schema:
+//       $ref: '#/definitions/BanRequest'
+//
+// responses:
+//
+//  204:
+//    description: User banned
+//  400:
+//    description: Invalid request body or expiry in the past
+//  403:
+//    description: Not allowed to ban this user
+//  404:
+//    description: User not found
+//  500:
+//    description: Internal server error
+func (h *Handler) BanUser(c *gin.Context) {
+  var req BanRequest
+  if err := c.ShouldBindJSON(&req); err != nil {
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    return
+  }
+
+  err := h.service.Ban(c.Request.Context(), auth.GetClaims(c), c.Param("id"), req.Reason, req.ExpiresAt)
+  if err != nil {
+    writeModerationError(c, err, "failed to ban user")
+    return
+  }
+
+  h.audit.Record(c, audit.Entry{
+    Action:       audit.ActionUserBan,
+    ResourceType: audit.ResourceUser,
+    ResourceID:   c.Param("id"),
+    Metadata:     map[string]any{"reason": req.Reason, "expires_at": req.ExpiresAt},
+  })
+
+  c.Status(http.StatusNoContent)
+}
+
+// swagger:operation DELETE /admin/users/{id}/ban admin unbanUser
+// ---
+// tags: [admin]
+// description: Lift the ban of a user (requires user management privileges)
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: id
+//     in: path
+//     required: true
+//     type: string
+//
+// responses:
+//
+//  204:
+//    description: Ban lifted
+//  403:
+//    description: Not allowed to unban this user
+//  404:
+//    description: User not found
+//  500:
+//    description: Internal server error
+func (h *Handler) UnbanUser(c *gin.Context) {
+  err := h.service.Unban(c.Request.Context(), auth.GetClaims(c), c.Param("id"))
+  if err != nil {
+    writeModerationError(c, err, "failed to unban user")
+    return
+  }
+
+  h.audit.Record(c, audit.Entry{
+    Action:       audit.ActionUserUnban,
+    ResourceType: audit.ResourceUser,
+    ResourceID:   c.Param("id"),
+  })
+
+  c.Status(http.StatusNoContent)
+}
+
+// swagger:operation PUT /admin/users/{id}/hidden admin setUserHidden
+// ---
+// tags: [admin]
+// description: Hide a user from the scoreboard, or show it again (requires user management privileges)
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: id
+//     in: path
+//     required: true
+//     type: string
+//   - name: body
+//     in: body
+//     required: true
+//     schema:
+//       $ref: '#/definitions/SetHiddenRequest'
+//
+// responses:
+//
+//  204:
+//    description: Visibility changed
+//  400:
+//    description: Invalid request body
+//  403:
+//    description: Not allowed to hide this user
+//  404:
+//    description: User not found
+//  500:
+//    description: Internal server error
+func (h *Handler) SetUserHidden(c *gin.Context) {
+  var req SetHiddenRequest
+  if err := c.ShouldBindJSON(&req); err != nil {
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    return
+  }
+
+  err := h.service.SetHidden(c.Request.Context(), auth.GetClaims(c), c.Param("id"), *req.Hidden)
+  if err != nil {
+    writeModerationError(c, err, "failed to update user")
+    return
+  }
+
+  h.audit.Record(c, audit.Entry{
+    Action:       audit.ActionUserHide,
+    ResourceType: audit.ResourceUser,
+    ResourceID:   c.Param("id"),
+    Metadata:     map[string]any{"hidden": *req.Hidden},
+  })
+
+  c.Status(http.StatusNoContent)
+}
+
+// swagger:operation DELETE /admin/users/{id} admin deleteUser
+// ---
+// tags: [admin]
+// description: Delete a user and end its sessions (requires user management privileges)
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: id
+//     in: path
+//     required: true
+//     type: string
+//
+// responses:
+//
+//  204:
+//    description: User deleted
+//  403:
+//    description: Not allowed to delete this user
+//  404:
+//    description: User not found
+//  500:
+//    description: Internal server error
+func (h *Handler) DeleteUser(c *gin.Context) {
+  err := h.service.DeleteUser(c.Request.Context(), auth.GetClaims(c), c.Param("id"))
+  if err != nil {
+    writeModerationError(c, err, "failed to delete user")
+    return
+  }
+
+  h.audit.Record(c, audit.Entry{
+    Action:       audit.ActionUserDelete,
+    ResourceType: audit.ResourceUser,
+    ResourceID:   c.Param("id"),
+  })
+
+  c.Status(http.StatusNoContent)
+}
+
+// swagger:operation GET /admin/registration admin getRegistrationSettings
+// ---
+// tags: [admin]
+// description: Get the registration settings (requires settings privileges)
+// security:
+// - bearerAuth: []
+//
+// responses:
+//
+//  200:
+//    description: The registration settings
+//  500:
+//    description: Internal server error
+func (h *Handler) GetRegistrationSettings(c *gin.Context) {
+  settings, err := h.service.RegistrationSettings(c.Request.Context())
+  if err != nil {
+    log.Printf("registration: error(internal): %v\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get registration settings"})
+    return
+  }
+
+  c.JSON(http.StatusOK, settings)
+}
+
+// swagger:operation PUT /admin/registration admin updateRegistrationSettings
+// ---
+// tags: [admin]
+// description: Change the registration mode and allowed domains (requires settings privileges)
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: body
+//     in: body
+//     required: true
+//     schema: {$ref: "#/definitions/RegistrationSettingsRequest"}
+//
+// responses:
+//
+//  200:
+//    description: The new registration settings
+//  400:
+//    description: Invalid mode or domain
+//  500:
+//    description: Internal server error
+func (h *Handler) UpdateRegistrationSettings(c *gin.Context) {
+  var req RegistrationSettingsRequest
+  if err := c.ShouldBindJSON(&req); err != nil {
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    return
+  }
+
+  settings, err := h.service.UpdateRegistrationSettings(c.Request.Context(), req.Mode, req.Domains)
+  if err != nil {
+    if errors.Is(err, ErrInvalidRegistrationCfg) {
+      c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+      return
+    }
+
+    log.Printf("registration: error(internal): %v\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update registration settings"})
+    return
+  }
+
+  h.audit.Record(c, audit.Entry{
+    Action:       audit.ActionRegistrationUpdate,
+    ResourceType: audit.ResourceSettings,
+    ResourceID:   "registration",
+    Metadata:     map[string]any{"mode": settings.Mode, "domains": settings.Domains},
+  })
+
+  c.JSON(http.StatusOK, settings)
+}
+
+// swagger:operation GET /admin/invites admin listInvites
+// ---
+// tags: [admin]
+// description: List the invite codes with their usage (requires settings privileges)
+// security:
+// - bearerAuth: []
+//
+// responses:
+//
+//  200:
+//    description: The invite codes, newest first
+//  500:
+//    description: Internal server error
+func (h *Handler) ListInvites(c *gin.Context) {
+  invites, err := h.service.ListInvites(c.Request.Context())
+  if err != nil {
+    log.Printf("invites: error(internal): %v\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list invite codes"})
+    return
+  }
+
+  c.JSON(http.StatusOK, invites)
+}
+
+// swagger:operation POST /admin/invites admin createInvite
+// ---
+// tags: [admin]
+// description: Create an invite code (requires settings privileges)
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: body
+//     in: body
+//     required: true
+//     schema: {$ref: "#/definitions/CreateInviteRequest"}
+//
+// responses:
+//
+//  201:
+//    description: The new invite code
+//  400:
+//    description: Invalid uses, expiry or code
+//  409:
+//    description: Code already exists
+//  500:
+//    description: Internal server error
+func (h *Handler) CreateInvite(c *gin.Context) {
+  var req CreateInviteRequest
+  if err := c.ShouldBindJSON(&req); err != nil {
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    return
+  }
+
+  if req.MaxUses == 0 {
+    req.MaxUses = 1
+  }
+
+  invite, err := h.service.CreateInvite(c.Request.Context(), auth.GetClaims(c), &InviteRequest{
+    Code:      req.Code,
+    Note:      req.Note,
+    MaxUses:   req.MaxUses,
+    ExpiresAt: req.ExpiresAt,
+  })
+  if err != nil {
+    switch {
+    case errors.Is(err, ErrInvalidInviteCfg):
+      c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    case mongo.IsDuplicateKeyError(err):
+      c.JSON(http.StatusConflict, gin.H{"error": "invite code already exists"})
+    default:
+      log.Printf("invites: error(internal): %v\n", err)
+      c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create invite code"})
+    }
+    return
+  }
+
+  h.audit.Record(c, audit.Entry{
+    Action:       audit.ActionInviteCreate,
+    ResourceType: audit.ResourceInvite,
+    ResourceID:   invite.ID.Hex(),
+    Metadata:     map[string]any{"note": invite.Note, "max_uses": invite.MaxUses, "expires_at": invite.ExpiresAt},
+  })
+
+  c.JSON(http.StatusCreated, invite)
+}
+
+// swagger:operation GET /admin/invites/{id} admin getInvite
+// ---
+// tags: [admin]
+// description: Get an invite code with the accounts created with it (requires settings privileges)
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: id
+//     in: path
+//     required: true
+//     type: string
+//
+// responses:
+//
+//  200:
+//    description: The invite code
+//  404:
+//    description: Invite code not found
+//  500:
+//    description: Internal server error
+func (h *Handler) GetInvite(c *gin.Context) {
+  invite, err := h.service.GetInvite(c.Request.Context(), c.Param("id"))
+  if err != nil {
+    writeInviteError(c, err)
+    return
+  }
+
+  c.JSON(http.StatusOK, invite)
+}
+
+// swagger:operation DELETE /admin/invites/{id} admin revokeInvite
+// ---
+// tags: [admin]
+// description: Revoke an invite code (requires settings privileges)
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: id
+//     in: path
+//     required: true
+//     type: string
+//
+// responses:
+//
+//  204:
+//    description: Invite code revoked
+//  404:
+//    description: Invite code not found
+//  500:
+//    description: Internal server error
+func (h *Handler) RevokeInvite(c *gin.Context) {
+  if err := h.service.RevokeInvite(c.Request.Context(), c.Param("id")); err != nil {
+    writeInviteError(c, err)
+    return
+  }
+
+  h.audit.Record(c, audit.Entry{
+    Action:       audit.ActionInviteRevoke,
+    ResourceType: audit.ResourceInvite,
+    ResourceID:   c.Param("id"),
+  })
+
+  c.Status(http.StatusNoContent)
+}
+
+func writeInviteError(c *gin.Context, err error) {
+  if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, bson.ErrInvalidHex) {
+    c.JSON(http.StatusNotFound, gin.H{"error": "invite code not found"})
+    return
+  }
+
+  log.Printf("invites: error(internal): %v\n", err)
+  c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get invite code"})
+}
+
+func writeModerationError(c *gin.Context, err error, msg string) {
+  switch {
+  case errors.Is(err, mongo.ErrNoDocuments), errors.Is(err, bson.ErrInvalidHex):
+    c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+  case errors.Is(err, ErrSelfModeration), errors.Is(err, ErrTargetPrivilege):
+    c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+  case errors.Is(err, ErrInvalidRole), errors.Is(err, ErrInvalidBanEnd):
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+  default:
+    log.Printf("admin: error(internal): %v\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+  }
+}
+
+func boolQuery(c *gin.Context, key string) (*bool, error) {
+  raw, ok := c.GetQuery(key)
+  if !ok || raw == "" {
+    return nil, nil
+  }
+
+  value, err := strconv.ParseBool(raw)
+  if err != nil {
+    return nil, err
+  }
+
+  return &value, nil
+}
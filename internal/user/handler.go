@@ -9,16 +9,21 @@ package user
 import (
   "errors"
   "log"
+  "math"
   "net/http"
-  "time"
+  "strconv"
 
   "github.com/gin-gonic/gin"
+  "go.mongodb.org/mongo-driver/v2/bson"
+  "go.mongodb.org/mongo-driver/v2/mongo"
 
+  "github.com/CTFxd/ctfxd-server/internal/audit"
   "github.com/CTFxd/ctfxd-server/internal/auth"
 )
 
 type Handler struct {
   service *Service
+  audit   *audit.Service
 }
 
 // swagger:model RegisterRequest
@@ -32,6 +37,20 @@ type RegisterRequest struct {
   // required: true
   // example: password123
   Password string `json:"password" binding:"required,min=8"`
+
+  // Invite code, required while the registration is invite only
+  // example: K7QX2MPA9RTZ
+  InviteCode string `json:"invite_code"`
+}
+
+// swagger:model PrivilegedRegisterRequest
+type PrivilegedRegisterRequest struct {
+  RegisterRequest
+
+  // Role of the new account, defaults to admin
+  // enum: author,moderator,admin
+  // example: author
+  Role string `json:"role" binding:"omitempty,oneof=author moderator admin"`
 }
 
 // swagger:model LoginRequest
@@ -47,9 +66,132 @@ type LoginRequest struct {
   Password string `json:"password" binding:"required,min=8"`
 }
 
-func NewHandler(service *Service) *Handler {
+// swagger:model UpdateProfileRequest
+type UpdateProfileRequest struct {
+  // Unique display name shown on the scoreboard, 3 to 32 characters
+  // example: h4x0r
+  DisplayName *string `json:"display_name"`
+
+  // Team, school or company, empty to clear
+  // example: CTFxd University
+  Affiliation *string `json:"affiliation"`
+
+  // ISO 3166-1 alpha-2 country code, empty to clear
+  // example: IN
+  Country *string `json:"country"`
+
+  // http(s) URL, empty to clear
+  // example: https://example.com
+  Website *string `json:"website"`
+
+  // Short bio, empty to clear
+  Bio *string `json:"bio"`
+}
+
+// swagger:model RefreshRequest
+type RefreshRequest struct {
+  // Refresh token issued by login or a previous refresh, taken from the
+  // session cookie when omitted
+  RefreshToken string `json:"refresh_token"`
+}
+
+// swagger:model LogoutRequest
+type LogoutRequest struct {
+  // Refresh token of the session to end
+  RefreshToken string `json:"refresh_token"`
+
+  // End every session of the user
+  // example: false
+  All bool `json:"all"`
+}
+
+// swagger:model VerifyEmailRequest
+type VerifyEmailRequest struct {
+  // Token from the verification link
+  // required: true
+  Token string `json:"token" binding:"required"`
+}
+
+// swagger:model ResendVerificationRequest
+type ResendVerificationRequest struct {
+  // Email of the user
+  // required: true
+  // example: user@example.com
+  Email string `json:"email" binding:"required,email"`
+}
+
+// swagger:model ForgotPasswordRequest
+type ForgotPasswordRequest struct {
+  // Email of the user
+  // required: true
+  // example: user@example.com
+  Email string `json:"email" binding:"required,email"`
+}
+
+// swagger:model ResetPasswordRequest
+type ResetPasswordRequest struct {
+  // Token from the password reset link
+  // required: true
+  Token string `json:"token" binding:"required"`
+
+  // New password, at least 8 characters long
+  // required: true
+  // example: password123
+  Password string `json:"password" binding:"required,min=8"`
+}
+
+// swagger:model ChangePasswordRequest
+type ChangePasswordRequest struct {
+  // Current password of the user
+  // required: true
+  CurrentPassword string `json:"current_password" binding:"required"`
+
+  // New password, at least 8 characters long
+  // required: true
+  // example: password123
+  NewPassword string `json:"new_password" binding:"required,min=8"`
+}
+
+// swagger:model LoginMFARequest
+type LoginMFARequest struct {
+  // Token returned by the login
+  // required: true
+  MFAToken string `json:"mfa_token" binding:"required"`
+
+  // Code from the authenticator app
+  // example: 123456
+  Code string `json:"code"`
+
+  // One of the recovery codes, instead of the code
+  // example: abcde-fghij
+  RecoveryCode string `json:"recovery_code"`
+}
+
+// swagger:model TOTPCodeRequest
+type TOTPCodeRequest struct {
+  // Code from the authenticator app
+  // required: true
+  // example: 123456
+  Code string `json:"code" binding:"required"`
+}
+
+// swagger:model DisableTOTPRequest
+type DisableTOTPRequest struct {
+  // Password of the user
+  // required: true
+  Password string `json:"password" binding:"required"`
+
+  // Code from the authenticator app
+  Code string `json:"code"`
+
+  // One of the recovery codes, instead of the code
+  RecoveryCode string `json:"recovery_code"`
+}
+
+func NewHandler(service *Service, auditService *audit.Service) *Handler {
   handler := new(Handler)
   handler.service = service
+  handler.audit = auditService
   return handler
 }
 
@@ -77,6 +219,14 @@ func NewHandler(service *Service) *Handler {
 //        error:
 //          type: string
 //          example: "Invalid email format"
+//  403:
+//    description: Registration closed, email domain not allowed, or missing or invalid invite code
+//    schema:
+//      type: object
+//      properties:
+//        error:
+//          type: string
+//          example: "registration is closed"
 //  409:
 //    description: Conflict
 //    schema:
@@ -94,25 +244,34 @@ func NewHandler(service *Service) *Handler {
 //          type: string
 //          example: "failed to create user"
 func (h *Handler) RegisterUser(c *gin.Context) {
-  h.register(c, false)
+  var req RegisterRequest
+
+  if err := c.ShouldBindJSON(&req); err != nil {
+    log.Printf("register:(Invalid JSON Binding) error(%v)\n", err)
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    return
+  }
+
+  err := h.service.SignUp(c.Request.Context(), req.Email, req.Password, req.InviteCode)
+  writeRegisterResult(c, err)
 }
 
 // swagger:operation POST /admin/register admin registerAdmin
 // ---
 // tags: [admin]
-// description: Register a new admin account (requires admin privileges)
+// description: Register a new privileged (author, moderator or admin) account (requires admin privileges)
 // security:
 // - bearerAuth: []
 // parameters:
 //   - name: body
 //     in: body
 //     required: true
-//     schema: {$ref: "#/definitions/RegisterRequest"}
+//     schema: {$ref: "#/definitions/PrivilegedRegisterRequest"}
 //
 // responses:
 //
 //  201:
-//    description: Admin user created successfully
+//    description: Privileged user created successfully
 //  400:
 //    description: Bad request
 //    schema:
@@ -138,11 +297,7 @@ func (h *Handler) RegisterUser(c *gin.Context) {
 //          type: string
 //          example: "failed to create admin user"
 func (h *Handler) RegisterAdmin(c *gin.Context) {
-  h.register(c, true)
-}
-
-func (h *Handler) register(c *gin.Context, isAdmin bool) {
-  var req RegisterRequest
+  var req PrivilegedRegisterRequest
 
   if err := c.ShouldBindJSON(&req); err != nil {
     log.Printf("register:(Invalid JSON Binding) error(%v)\n", err)
@@ -150,7 +305,24 @@ func (h *Handler) register(c *gin.Context, isAdmin bool) {
     return
   }
 
-  err := h.service.Register(c.Request.Context(), req.Email, req.Password, isAdmin)
+  if req.Role == "" {
+    req.Role = auth.RoleAdmin
+  }
+
+  user, err := h.service.Register(c.Request.Context(), req.Email, req.Password, req.Role)
+  if err == nil {
+    h.audit.Record(c, audit.Entry{
+      Action:       audit.ActionUserRegisterPrivileged,
+      ResourceType: audit.ResourceUser,
+      ResourceID:   user.ID.Hex(),
+      Metadata:     map[string]any{"email": user.Email, "role": user.Role},
+    })
+  }
+
+  writeRegisterResult(c, err)
+}
+
+func writeRegisterResult(c *gin.Context, err error) {
   if err != nil {
     if errors.Is(err, ErrUserExists) {
       log.Printf("register: error: %v\n", err)
@@ -158,6 +330,13 @@ func (h *Handler) register(c *gin.Context, isAdmin bool) {
       return
     }
 
+    if errors.Is(err, ErrRegistrationClosed) || errors.Is(err, ErrEmailDomainNotAllowed) ||
+      errors.Is(err, ErrInviteRequired) || errors.Is(err, ErrInvalidInvite) {
+      log.Printf("register: error: %v\n", err)
+      c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+      return
+    }
+
     log.Printf("register: error(internal): %v\n", err)
     c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create user"})
     return
@@ -175,6 +354,13 @@ func (h *Handler) register(c *gin.Context, isAdmin bool) {
 //     in: body
 //     required: true
 //     schema: {$ref: "#/definitions/LoginRequest"}
+//   - name: X-Session-Mode
+//     in: header
+//     type: string
+//     enum: [cookie]
+//     description: Keep the tokens in HttpOnly cookies instead of the response body (browser clients,
+//       if cookie sessions are enabled). State-changing requests must then send the csrf_token
+//       of the response in the X-CSRF-Token header.
 //
 // responses:
 //
@@ -187,6 +373,25 @@ func (h *Handler) register(c *gin.Context, isAdmin bool) {
 //          type: string
 //          description: JWT access token
 //          example: eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9...
+//        refresh_token:
+//          type: string
+//          description: Opaque refresh token, single use
+//        expires_in:
+//          type: integer
+//          description: Lifetime of the access token in seconds
+//          example: 900
+//        csrf_token:
+//          type: string
+//          description: Set instead of the tokens for a cookie session, also in the ctfxd_csrf cookie
+//        mfa_required:
+//          type: boolean
+//          description: Set instead of the tokens when the account uses two-factor authentication
+//        mfa_token:
+//          type: string
+//          description: Token to pass to /login/2fa with the code
+//        mfa_enrollment_required:
+//          type: boolean
+//          description: Admin account without two-factor authentication while it is mandatory
 //        user:
 //          type: object
 //          description: Authenticated user details
@@ -201,7 +406,7 @@ func (h *Handler) register(c *gin.Context, isAdmin bool) {
 //              example: user@example.com
 //            role:
 //              type: string
-//              enum: [user, admin]
+//              enum: [user, author, moderator, admin]
 //              example: user
 //  400:
 //    description: Invalid request format
@@ -219,6 +424,25 @@ func (h *Handler) register(c *gin.Context, isAdmin bool) {
 //        error:
 //          type: string
 //          example: invalid credentials
+//  403:
+//    description: Email address not verified yet, or banned user
+//    schema:
+//      type: object
+//      properties:
+//        error:
+//          type: string
+//          example: email address not verified
+//  429:
+//    description: Too many failed attempts for the account or the client IP, see the Retry-After header
+//    schema:
+//      type: object
+//      properties:
+//        error:
+//          type: string
+//          example: too many failed login attempts
+//        retry_after:
+//          type: integer
+//          example: 30
 //  500:
 //    description: Internal server error
 //    schema:
@@ -235,10 +459,16 @@ func (h *Handler) Login(c *gin.Context) {
     return
   }
 
-  user, err := h.service.Login(c.Request.Context(), req.Email, req.Password)
+  user, err := h.service.Login(c.Request.Context(), req.Email, req.Password, c.ClientIP())
   if err != nil {
-    if errors.Is(err, ErrInvalidCredentials) {
-      time.Sleep(200 * time.Millisecond)
+    var throttleErr *ThrottleError
+    if errors.As(err, &throttleErr) {
+      log.Printf("login: error: %v\n", err)
+      writeThrottled(c, throttleErr)
+    } else if errors.Is(err, ErrEmailNotVerified) || errors.Is(err, ErrUserBanned) {
+      log.Printf("login: error: %v\n", err)
+      c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+    } else if errors.Is(err, ErrInvalidCredentials) {
       log.Printf("login: error: %v\n", err)
       c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
     } else {
@@ -248,60 +478,939 @@ func (h *Handler) Login(c *gin.Context) {
     return
   }
 
-  token, err := auth.GenerateJWT(user.ID.Hex(), user.Email, user.Role)
+  result, err := h.service.StartSession(c.Request.Context(), user)
   if err != nil {
     log.Printf("login: error JWT: %v\n", err)
     c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate auth token"})
     return
   }
 
-  c.JSON(http.StatusOK, gin.H{
-    "token": token,
-    "user": gin.H{
-      "id":    user.ID.Hex(),
-      "email": user.Email,
-      "role":  user.Role,
-    },
-  })
+  writeLoginResult(c, user, result)
 }
 
-// swagger:operation GET /me users getMe
+func writeLoginResult(c *gin.Context, user *User, result *LoginResult) {
+  if result.MFAToken != "" {
+    c.JSON(http.StatusOK, gin.H{
+      "mfa_required": true,
+      "mfa_token":    result.MFAToken,
+    })
+    return
+  }
+
+  writeSession(c, user, result.Tokens, result.MFAEnrollmentRequired)
+}
+
+// swagger:operation POST /login/2fa users loginMFA
 // ---
 // tags: [users]
-// description: Get current authenticated user details
-// security:
-// - bearerAuth: []
+// description: Second step of the login for accounts with two-factor authentication
+// parameters:
+//   - name: body
+//     in: body
+//     required: true
+//     schema: {$ref: "#/definitions/LoginMFARequest"}
+//
 // responses:
 //
 //  200:
-//    description: Successfully retrieved user details
+//    description: Successfully authenticated, same response as the login
+//  400:
+//    description: Invalid request format or missing code
+//  401:
+//    description: Invalid or expired two-factor login, or invalid code
 //    schema:
 //      type: object
 //      properties:
-//        id:
-//          type: string
-//          description: User ID in hex format
-//          example: 5f7d8f9e0c1d2e3f4a5b6c7d
-//        email:
-//          type: string
-//          format: email
-//          example: user@example.com
-//        role:
+//        error:
 //          type: string
-//          enum: [user, admin]
-//          example: user
-//  401:
-//    description: Unauthorized - missing or invalid token
+//          example: invalid two-factor code
+//  500:
+//    description: Internal server error
+func (h *Handler) LoginMFA(c *gin.Context) {
+  var req LoginMFARequest
+  if err := c.ShouldBindJSON(&req); err != nil {
+    log.Printf("login:(Invalid JSON Binding) error(%v)\n", err)
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    return
+  }
+
+  user, tokens, err := h.service.LoginMFA(c.Request.Context(), req.MFAToken, req.Code, req.RecoveryCode, c.ClientIP())
+  if err != nil {
+    var throttleErr *ThrottleError
+    switch {
+    case errors.As(err, &throttleErr):
+      log.Printf("login: error: %v\n", err)
+      writeThrottled(c, throttleErr)
+    case errors.Is(err, ErrMFACodeRequired):
+      c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    case errors.Is(err, ErrUserBanned):
+      c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+    case errors.Is(err, ErrInvalidMFAToken), errors.Is(err, ErrInvalidMFACode):
+      log.Printf("login: error: %v\n", err)
+      c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+    default:
+      log.Printf("login: error(internal): %v\n", err)
+      c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate auth token"})
+    }
+    return
+  }
+
+  writeSession(c, user, tokens, false)
+}
+
+func writeThrottled(c *gin.Context, err *ThrottleError) {
+  seconds := int(math.Ceil(err.RetryAfter.Seconds()))
+  c.Header("Retry-After", strconv.Itoa(seconds))
+  c.JSON(http.StatusTooManyRequests, gin.H{
+    "error":       ErrTooManyAttempts.Error(),
+    "retry_after": seconds,
+  })
+}
+
+func writeSession(c *gin.Context, user *User, tokens *auth.TokenPair, mfaEnrollmentRequired bool) {
+  response, err := sessionResponse(c, tokens, auth.WantsCookieSession(c))
+  if err != nil {
+    log.Printf("login: error(internal): %v\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate auth token"})
+    return
+  }
+
+  response["user"] = gin.H{
+    "id":           user.ID.Hex(),
+    "email":        user.Email,
+    "role":         user.Role,
+    "display_name": user.Name(),
+  }
+
+  if mfaEnrollmentRequired {
+    response["mfa_enrollment_required"] = true
+  }
+
+  c.JSON(http.StatusOK, response)
+}
+
+// sessionResponse puts the token pair in the response body, or in the
+// session cookies (with a new CSRF token in the body) for a cookie session.
+func sessionResponse(c *gin.Context, tokens *auth.TokenPair, cookie bool) (gin.H, error) {
+  if !cookie {
+    return gin.H{
+      "token":         tokens.AccessToken,
+      "refresh_token": tokens.RefreshToken,
+      "expires_in":    tokens.ExpiresIn,
+    }, nil
+  }
+
+  csrfToken, err := auth.SetSessionCookies(c, tokens)
+  if err != nil {
+    return nil, err
+  }
+
+  return gin.H{
+    "expires_in": tokens.ExpiresIn,
+    "csrf_token": csrfToken,
+  }, nil
+}
+
+// swagger:operation POST /verify-email users verifyEmail
+// ---
+// tags: [users]
+// description: Verify the email address with the token from the verification link
+// parameters:
+//   - name: body
+//     in: body
+//     required: true
+//     schema: {$ref: "#/definitions/VerifyEmailRequest"}
+//
+// responses:
+//
+//  204:
+//    description: Email address verified
+//  400:
+//    description: Invalid or expired verification link
 //    schema:
 //      type: object
 //      properties:
 //        error:
 //          type: string
-//          example: unauthorized
-func (h *Handler) GetMe(c *gin.Context) {
+//          example: invalid or expired verification link
+//  500:
+//    description: Internal server error
+func (h *Handler) VerifyEmail(c *gin.Context) {
+  var req VerifyEmailRequest
+  if err := c.ShouldBindJSON(&req); err != nil {
+    log.Printf("verify:(Invalid JSON Binding) error(%v)\n", err)
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    return
+  }
+
+  if err := h.service.VerifyEmail(c.Request.Context(), req.Token); err != nil {
+    if errors.Is(err, ErrInvalidVerification) {
+      c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+      return
+    }
+
+    log.Printf("verify: error(internal): %v\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify email address"})
+    return
+  }
+
+  c.Status(http.StatusNoContent)
+}
+
+// swagger:operation POST /verify-email/resend users resendVerification
+// ---
+// tags: [users]
+// description: Send a new verification link, if the address waits for verification
+// parameters:
+//   - name: body
+//     in: body
+//     required: true
+//     schema: {$ref: "#/definitions/ResendVerificationRequest"}
+//
+// responses:
+//
+//  202:
+//    description: Verification link sent, if the account exists and is not verified
+//  400:
+//    description: Invalid request format
+//  429:
+//    description: A link was sent to the address recently, see the Retry-After header
+//  500:
+//    description: Internal server error
+func (h *Handler) ResendVerification(c *gin.Context) {
+  var req ResendVerificationRequest
+  if err := c.ShouldBindJSON(&req); err != nil {
+    log.Printf("verify:(Invalid JSON Binding) error(%v)\n", err)
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    return
+  }
+
+  if err := h.service.ResendVerification(c.Request.Context(), req.Email); err != nil {
+    var throttleErr *ThrottleError
+    if errors.As(err, &throttleErr) {
+      seconds := int(math.Ceil(throttleErr.RetryAfter.Seconds()))
+      c.Header("Retry-After", strconv.Itoa(seconds))
+      c.JSON(http.StatusTooManyRequests, gin.H{
+        "error":       "verification email sent recently",
+        "retry_after": seconds,
+      })
+      return
+    }
+
+    log.Printf("verify: error(internal): %v\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send verification email"})
+    return
+  }
+
+  c.Status(http.StatusAccepted)
+}
+
+// swagger:operation POST /admin/users/{id}/unlock admin unlockUser
+// ---
+// tags: [admin]
+// description: Clear the failed login counter of an account (requires user management privileges)
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: id
+//     in: path
+//     required: true
+//     type: string
+//
+// responses:
+//
+//  204:
+//    description: Account unlocked
+//  404:
+//    description: User not found
+//  500:
+//    description: Internal server error
+func (h *Handler) UnlockUser(c *gin.Context) {
+  err := h.service.UnlockUser(c.Request.Context(), c.Param("id"))
+  if err != nil {
+    if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, bson.ErrInvalidHex) {
+      c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+      return
+    }
+
+    log.Printf("unlock: error(internal): %v\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unlock user"})
+    return
+  }
+
+  h.audit.Record(c, audit.Entry{
+    Action:       audit.ActionUserUnlock,
+    ResourceType: audit.ResourceUser,
+    ResourceID:   c.Param("id"),
+  })
+
+  c.Status(http.StatusNoContent)
+}
+
+// swagger:operation POST /admin/users/{id}/verify admin forceVerifyUser
+// ---
+// tags: [admin]
+// description: Mark the email address of a user as verified (requires user management privileges)
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: id
+//     in: path
+//     required: true
+//     type: string
+//
+// responses:
+//
+//  204:
+//    description: Email address marked as verified
+//  404:
+//    description: User not found
+//  409:
+//    description: Email address already verified
+//  500:
+//    description: Internal server error
+func (h *Handler) ForceVerifyUser(c *gin.Context) {
+  err := h.service.ForceVerify(c.Request.Context(), c.Param("id"))
+  if err != nil {
+    switch {
+    case errors.Is(err, mongo.ErrNoDocuments), errors.Is(err, bson.ErrInvalidHex):
+      c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+    case errors.Is(err, ErrAlreadyVerified):
+      c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+    default:
+      log.Printf("verify: error(internal): %v\n", err)
+      c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify user"})
+    }
+    return
+  }
+
+  h.audit.Record(c, audit.Entry{
+    Action:       audit.ActionUserVerify,
+    ResourceType: audit.ResourceUser,
+    ResourceID:   c.Param("id"),
+  })
+
+  c.Status(http.StatusNoContent)
+}
+
+// swagger:operation POST /password/forgot users forgotPassword
+// ---
+// tags: [users]
+// description: Send a password reset link to the email address
+// parameters:
+//   - name: body
+//     in: body
+//     required: true
+//     schema: {$ref: "#/definitions/ForgotPasswordRequest"}
+//
+// responses:
+//
+//  202:
+//    description: Reset link sent in the background, if the account exists
+//  400:
+//    description: Invalid request format
+func (h *Handler) ForgotPassword(c *gin.Context) {
+  var req ForgotPasswordRequest
+  if err := c.ShouldBindJSON(&req); err != nil {
+    log.Printf("password:(Invalid JSON Binding) error(%v)\n", err)
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    return
+  }
+
+  h.service.ForgotPassword(c.Request.Context(), req.Email, c.ClientIP())
+
+  c.Status(http.StatusAccepted)
+}
+
+// swagger:operation POST /password/reset users resetPassword
+// ---
+// tags: [users]
+// description: Set a new password with the token from the reset link, every session is signed out
+// parameters:
+//   - name: body
+//     in: body
+//     required: true
+//     schema: {$ref: "#/definitions/ResetPasswordRequest"}
+//
+// responses:
+//
+//  204:
+//    description: Password changed
+//  400:
+//    description: Invalid request format or invalid reset token
+//    schema:
+//      type: object
+//      properties:
+//        error:
+//          type: string
+//          example: invalid or expired reset token
+//  500:
+//    description: Internal server error
+func (h *Handler) ResetPassword(c *gin.Context) {
+  var req ResetPasswordRequest
+  if err := c.ShouldBindJSON(&req); err != nil {
+    log.Printf("password:(Invalid JSON Binding) error(%v)\n", err)
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    return
+  }
+
+  if err := h.service.ResetPassword(c.Request.Context(), req.Token, req.Password); err != nil {
+    if errors.Is(err, ErrInvalidResetToken) {
+      c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+      return
+    }
+
+    log.Printf("password: error(internal): %v\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset password"})
+    return
+  }
+
+  c.Status(http.StatusNoContent)
+}
+
+// swagger:operation POST /me/password users changePassword
+// ---
+// tags: [users]
+// description: Change the password, every other session is signed out and a new token pair is returned
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: body
+//     in: body
+//     required: true
+//     schema: {$ref: "#/definitions/ChangePasswordRequest"}
+//
+// responses:
+//
+//  200:
+//    description: Password changed, new token pair
+//    schema:
+//      type: object
+//      properties:
+//        token:
+//          type: string
+//        refresh_token:
+//          type: string
+//        expires_in:
+//          type: integer
+//  400:
+//    description: Invalid request format
+//  403:
+//    description: Current password is incorrect
+//  500:
+//    description: Internal server error
+func (h *Handler) ChangePassword(c *gin.Context) {
+  var req ChangePasswordRequest
+  if err := c.ShouldBindJSON(&req); err != nil {
+    log.Printf("password:(Invalid JSON Binding) error(%v)\n", err)
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    return
+  }
+
+  mfa := false
+  if claims := auth.GetClaims(c); claims != nil {
+    mfa = claims.MFA
+  }
+
+  tokens, err := h.service.ChangePassword(c.Request.Context(), auth.GetUserID(c), req.CurrentPassword, req.NewPassword, mfa)
+  if err != nil {
+    if errors.Is(err, ErrWrongPassword) {
+      c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+      return
+    }
+
+    log.Printf("password: error(internal): %v\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to change password"})
+    return
+  }
+
+  response, err := sessionResponse(c, tokens, auth.IsCookieSession(c))
+  if err != nil {
+    log.Printf("password: error(internal): %v\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to change password"})
+    return
+  }
+
+  c.JSON(http.StatusOK, response)
+}
+
+// swagger:operation POST /me/2fa/enroll users enrollTOTP
+// ---
+// tags: [users]
+// description: Start the two-factor (TOTP) enrollment, returns the secret and the provisioning URI for the QR code
+// security:
+// - bearerAuth: []
+// responses:
+//
+//  200:
+//    description: Enrollment started
+//    schema:
+//      type: object
+//      properties:
+//        secret:
+//          type: string
+//          example: JBSWY3DPEHPK3PXP
+//        uri:
+//          type: string
+//          example: otpauth://totp/CTFxd:user@example.com?secret=JBSWY3DPEHPK3PXP&issuer=CTFxd
+//  409:
+//    description: Two-factor authentication already enabled
+//  500:
+//    description: Internal server error
+func (h *Handler) EnrollTOTP(c *gin.Context) {
+  enrollment, err := h.service.EnrollTOTP(c.Request.Context(), auth.GetUserID(c))
+  if err != nil {
+    if errors.Is(err, ErrMFAAlreadyEnabled) {
+      c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+      return
+    }
+
+    log.Printf("2fa: error(internal): %v\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start enrollment"})
+    return
+  }
+
+  c.JSON(http.StatusOK, enrollment)
+}
+
+// swagger:operation POST /me/2fa/confirm users confirmTOTP
+// ---
+// tags: [users]
+// description: Enable two-factor authentication with a first code, returns the one-time recovery codes
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: body
+//     in: body
+//     required: true
+//     schema: {$ref: "#/definitions/TOTPCodeRequest"}
+//
+// responses:
+//
+//  200:
+//    description: Two-factor authentication enabled
+//    schema:
+//      type: object
+//      properties:
+//        recovery_codes:
+//          type: array
+//          items:
+//            type: string
+//            example: abcde-fghij
+//  400:
+//    description: Invalid code or enrollment not started
+//  409:
+//    description: Two-factor authentication already enabled
+//  500:
+//    description: Internal server error
+func (h *Handler) ConfirmTOTP(c *gin.Context) {
+  var req TOTPCodeRequest
+  if err := c.ShouldBindJSON(&req); err != nil {
+    log.Printf("2fa:(Invalid JSON Binding) error(%v)\n", err)
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    return
+  }
+
+  codes, err := h.service.ConfirmTOTP(c.Request.Context(), auth.GetUserID(c), req.Code)
+  if err != nil {
+    switch {
+    case errors.Is(err, ErrInvalidMFACode), errors.Is(err, ErrMFANotEnrolling):
+      c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    case errors.Is(err, ErrMFAAlreadyEnabled):
+      c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+    default:
+      log.Printf("2fa: error(internal): %v\n", err)
+      c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enable two-factor authentication"})
+    }
+    return
+  }
+
+  c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// swagger:operation POST /me/2fa/disable users disableTOTP
+// ---
+// tags: [users]
+// description: Disable two-factor authentication, needs the password and a code or recovery code
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: body
+//     in: body
+//     required: true
+//     schema: {$ref: "#/definitions/DisableTOTPRequest"}
+//
+// responses:
+//
+//  204:
+//    description: Two-factor authentication disabled
+//  400:
+//    description: Two-factor authentication not enabled
+//  403:
+//    description: Invalid password or two-factor code
+//  429:
+//    description: Too many failed attempts for the account or the client IP, see the Retry-After header
+//  500:
+//    description: Internal server error
+func (h *Handler) DisableTOTP(c *gin.Context) {
+  var req DisableTOTPRequest
+  if err := c.ShouldBindJSON(&req); err != nil {
+    log.Printf("2fa:(Invalid JSON Binding) error(%v)\n", err)
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    return
+  }
+
+  err := h.service.DisableTOTP(c.Request.Context(), auth.GetUserID(c), req.Password, req.Code, req.RecoveryCode, c.ClientIP())
+  if err != nil {
+    var throttleErr *ThrottleError
+    switch {
+    case errors.As(err, &throttleErr):
+      log.Printf("2fa: error: %v\n", err)
+      writeThrottled(c, throttleErr)
+    case errors.Is(err, ErrMFANotEnabled):
+      c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    case errors.Is(err, ErrWrongPasswordOrMFA):
+      c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+    default:
+      log.Printf("2fa: error(internal): %v\n", err)
+      c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disable two-factor authentication"})
+    }
+    return
+  }
+
+  c.Status(http.StatusNoContent)
+}
+
+// swagger:operation POST /me/2fa/recovery-codes users regenerateRecoveryCodes
+// ---
+// tags: [users]
+// description: Replace the recovery codes, the previous ones stop working
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: body
+//     in: body
+//     required: true
+//     schema: {$ref: "#/definitions/TOTPCodeRequest"}
+//
+// responses:
+//
+//  200:
+//    description: New recovery codes
+//    schema:
+//      type: object
+//      properties:
+//        recovery_codes:
+//          type: array
+//          items:
+//            type: string
+//  400:
+//    description: Invalid code or two-factor authentication not enabled
+//  429:
+//    description: Too many failed attempts for the account or the client IP, see the Retry-After header
+//  500:
+//    description: Internal server error
+func (h *Handler) RegenerateRecoveryCodes(c *gin.Context) {
+  var req TOTPCodeRequest
+  if err := c.ShouldBindJSON(&req); err != nil {
+    log.Printf("2fa:(Invalid JSON Binding) error(%v)\n", err)
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    return
+  }
+
+  codes, err := h.service.RegenerateRecoveryCodes(c.Request.Context(), auth.GetUserID(c), req.Code, c.ClientIP())
+  if err != nil {
+    var throttleErr *ThrottleError
+    if errors.As(err, &throttleErr) {
+      log.Printf("2fa: error: %v\n", err)
+      writeThrottled(c, throttleErr)
+      return
+    }
+
+    if errors.Is(err, ErrInvalidMFACode) || errors.Is(err, ErrMFANotEnabled) {
+      c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+      return
+    }
+
+    log.Printf("2fa: error(internal): %v\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to regenerate recovery codes"})
+    return
+  }
+
+  c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// swagger:operation POST /token/refresh users refreshToken
+// ---
+// tags: [users]
+// description: Exchange a refresh token for a new access token and refresh token. Without a
+//   refresh token in the body, the cookie session is refreshed (requires the X-CSRF-Token header).
+// parameters:
+//   - name: body
+//     in: body
+//     required: false
+//     schema: {$ref: "#/definitions/RefreshRequest"}
+//
+// responses:
+//
+//  200:
+//    description: New token pair, or new session cookies and CSRF token
+//    schema:
+//      type: object
+//      properties:
+//        token:
+//          type: string
+//          description: JWT access token
+//        refresh_token:
+//          type: string
+//          description: Opaque refresh token, single use
+//        expires_in:
+//          type: integer
+//          example: 900
+//        csrf_token:
+//          type: string
+//          description: Set instead of the tokens for a cookie session
+//  400:
+//    description: Invalid request format or missing refresh token
+//  403:
+//    description: Missing or invalid CSRF token
+//  401:
+//    description: Invalid, expired or reused refresh token
+//    schema:
+//      type: object
+//      properties:
+//        error:
+//          type: string
+//          example: invalid or expired refresh token
+//  500:
+//    description: Internal server error
+func (h *Handler) RefreshToken(c *gin.Context) {
+  var req RefreshRequest
+  if c.Request.ContentLength != 0 {
+    if err := c.ShouldBindJSON(&req); err != nil {
+      log.Printf("refresh:(Invalid JSON Binding) error(%v)\n", err)
+      c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+      return
+    }
+  }
+
+  cookie := false
+  if req.RefreshToken == "" {
+    req.RefreshToken = auth.RefreshTokenFromCookie(c)
+    if req.RefreshToken == "" {
+      c.JSON(http.StatusBadRequest, gin.H{"error": "refresh token required"})
+      return
+    }
+
+    if err := auth.VerifyCSRF(c); err != nil {
+      c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+      return
+    }
+    cookie = true
+  }
+
+  tokens, err := h.service.Refresh(c.Request.Context(), req.RefreshToken)
+  if err != nil {
+    if errors.Is(err, auth.ErrInvalidRefreshToken) || errors.Is(err, auth.ErrRefreshTokenReused) {
+      log.Printf("refresh: error: %v\n", err)
+      if cookie {
+        auth.ClearSessionCookies(c)
+      }
+      c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+      return
+    }
+
+    if errors.Is(err, ErrUserBanned) {
+      c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+      return
+    }
+
+    log.Printf("refresh: error(internal): %v\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh auth token"})
+    return
+  }
+
+  response, err := sessionResponse(c, tokens, cookie)
+  if err != nil {
+    log.Printf("refresh: error(internal): %v\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh auth token"})
+    return
+  }
+
+  c.JSON(http.StatusOK, response)
+}
+
+// swagger:operation POST /logout users logout
+// ---
+// tags: [users]
+// description: Revoke the current access token and the session's refresh token
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: body
+//     in: body
+//     required: false
+//     schema: {$ref: "#/definitions/LogoutRequest"}
+//
+// responses:
+//
+//  204:
+//    description: Logged out
+//  401:
+//    description: Unauthorized - missing or invalid token
+//  500:
+//    description: Internal server error
+func (h *Handler) Logout(c *gin.Context) {
+  var req LogoutRequest
+  if c.Request.ContentLength != 0 {
+    if err := c.ShouldBindJSON(&req); err != nil {
+      log.Printf("logout:(Invalid JSON Binding) error(%v)\n", err)
+      c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+      return
+    }
+  }
+
+  cookie := auth.IsCookieSession(c)
+  if cookie && req.RefreshToken == "" {
+    req.RefreshToken = auth.RefreshTokenFromCookie(c)
+  }
+
+  err := h.service.Logout(c.Request.Context(), auth.GetClaims(c), req.RefreshToken, req.All)
+  if cookie {
+    auth.ClearSessionCookies(c)
+  }
+  if err != nil {
+    if errors.Is(err, auth.ErrInvalidRefreshToken) {
+      c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+      return
+    }
+
+    log.Printf("logout: error(internal): %v\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to logout"})
+    return
+  }
+
+  c.Status(http.StatusNoContent)
+}
+
+// swagger:operation GET /me users getMe
+// ---
+// tags: [users]
+// description: Get current authenticated user details
+// security:
+// - bearerAuth: []
+// responses:
+//
+//  200:
+//    description: Successfully retrieved user details
+//    schema:
+//      type: object
+//      properties:
+//        id:
+//          type: string
+//          description: User ID in hex format
+//          example: 5f7d8f9e0c1d2e3f4a5b6c7d
+//        email:
+//          type: string
+//          format: email
+//          example: user@example.com
+//        role:
+//          type: string
+//          enum: [user, author, moderator, admin]
+//          example: user
+//        display_name:
+//          type: string
+//          example: h4x0r
+//        affiliation:
+//          type: string
+//        country:
+//          type: string
+//        website:
+//          type: string
+//        bio:
+//          type: string
+//  401:
+//    description: Unauthorized - missing or invalid token
+//    schema:
+//      type: object
+//      properties:
+//        error:
+//          type: string
+//          example: unauthorized
+func (h *Handler) GetMe(c *gin.Context) {
+  user, err := h.service.GetUser(c.Request.Context(), auth.GetUserID(c))
+  if err != nil {
+    log.Printf("me: error(internal): %v\n", err)
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user"})
+    return
+  }
+
+  writeMe(c, user)
+}
+
+// swagger:operation PATCH /me users updateProfile
+// ---
+// tags: [users]
+// description: Update the public profile of the current user
+// security:
+// - bearerAuth: []
+// parameters:
+//   - name: body
+//     in: body
+//     required: true
+//     schema: {$ref: "#/definitions/UpdateProfileRequest"}
+//
+// responses:
+//
+//  200:
+//    description: Profile updated, same body as GET /me
+//  400:
+//    description: Invalid profile field
+//  401:
+//    description: Unauthorized - missing or invalid token
+//  409:
+//    description: Display name already taken
+//  500:
+//    description: Internal server error
+func (h *Handler) UpdateProfile(c *gin.Context) {
+  var req UpdateProfileRequest
+  if err := c.ShouldBindJSON(&req); err != nil {
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    return
+  }
+
+  user, err := h.service.UpdateProfile(c.Request.Context(), auth.GetUserID(c), &ProfileUpdate{
+    DisplayName: req.DisplayName,
+    Affiliation: req.Affiliation,
+    Country:     req.Country,
+    Website:     req.Website,
+    Bio:         req.Bio,
+  })
+  if err != nil {
+    switch {
+    case errors.Is(err, ErrInvalidProfile):
+      c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    case errors.Is(err, ErrDisplayNameTaken):
+      c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+    default:
+      log.Printf("profile: error(internal): %v\n", err)
+      c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update profile"})
+    }
+    return
+  }
+
+  writeMe(c, user)
+}
+
+func writeMe(c *gin.Context, user *User) {
   c.JSON(http.StatusOK, gin.H{
-    "id":    auth.GetUserID(c),
-    "email": auth.GetUserEmail(c),
-    "role":  auth.GetUserRole(c),
+    "id":           user.ID.Hex(),
+    "email":        user.Email,
+    "role":         user.Role,
+    "display_name": user.Name(),
+    "affiliation":  user.Affiliation,
+    "country":      user.Country,
+    "website":      user.Website,
+    "bio":          user.Bio,
   })
 }
@@ -7,6 +7,8 @@
 package user
 
 import (
+  "time"
+
   "go.mongodb.org/mongo-driver/v2/bson"
 )
 
@@ -15,4 +17,181 @@ type User struct {
   Email    string        `bson:"email" json:"email"`
   Password string        `bson:"password,omitempty" json:"-"`
   Role     string        `bson:"role" json:"-"`
+
+  // public profile, see Profile
+  DisplayName string `bson:"display_name,omitempty" json:"display_name"`
+  // lowercase DisplayName, unique so names only differing by case clash
+  DisplayNameKey string `bson:"display_name_key,omitempty" json:"-"`
+  Affiliation    string `bson:"affiliation,omitempty" json:"affiliation,omitempty"`
+  Country        string `bson:"country,omitempty" json:"country,omitempty"`
+  Website        string `bson:"website,omitempty" json:"website,omitempty"`
+  Bio            string `bson:"bio,omitempty" json:"bio,omitempty"`
+
+  // accounts at the SSO providers that log into this user
+  Identities []Identity `bson:"identities,omitempty" json:"-"`
+
+  Ban    *Ban `bson:"ban,omitempty" json:"-"`
+  Hidden bool `bson:"hidden,omitempty" json:"-"`
+
+  // set while the email address of the account waits for verification
+  VerificationPending bool `bson:"verification_pending,omitempty" json:"-"`
+  // the address was proven: verification link, admin override or verified
+  // address of an SSO provider
+  EmailVerified bool `bson:"email_verified,omitempty" json:"-"`
+  // the address must be verified before the first login even without
+  // RequireEmailVerification, set when the domain proved the right to register
+  VerificationRequired bool `bson:"verification_required,omitempty" json:"-"`
+
+  // two-factor authentication (TOTP), enabled when TOTPSecret is set
+  TOTPSecret        string   `bson:"totp_secret,omitempty" json:"-"`
+  TOTPPendingSecret string   `bson:"totp_pending_secret,omitempty" json:"-"`
+  TOTPLastStep      int64    `bson:"totp_last_step,omitempty" json:"-"`
+  RecoveryCodes     []string `bson:"recovery_codes,omitempty" json:"-"`
+}
+
+type Ban struct {
+  Reason    string        `bson:"reason" json:"reason"`
+  BannedBy  bson.ObjectID `bson:"banned_by" json:"banned_by"`
+  BannedAt  time.Time     `bson:"banned_at" json:"banned_at"`
+  ExpiresAt *time.Time    `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+}
+
+// Active reports whether the ban applies at t.
+func (b *Ban) Active(t time.Time) bool {
+  return b != nil && (b.ExpiresAt == nil || t.Before(*b.ExpiresAt))
+}
+
+// Profile is the public view of an account.
+type Profile struct {
+  ID          string `json:"id"`
+  DisplayName string `json:"display_name"`
+  Affiliation string `json:"affiliation,omitempty"`
+  Country     string `json:"country,omitempty"`
+  Website     string `json:"website,omitempty"`
+  Bio         string `json:"bio,omitempty"`
+}
+
+// Name is the display name of the user, or a placeholder derived from the id
+// until the user picks one. The scoreboard computes the same placeholder.
+func (u *User) Name() string {
+  if u.DisplayName != "" {
+    return u.DisplayName
+  }
+
+  return DefaultNamePrefix + u.ID.Hex()[16:]
+}
+
+func (u *User) Profile() Profile {
+  return Profile{
+    ID:          u.ID.Hex(),
+    DisplayName: u.Name(),
+    Affiliation: u.Affiliation,
+    Country:     u.Country,
+    Website:     u.Website,
+    Bio:         u.Bio,
+  }
+}
+
+// UserSummary is the view of an account for the admins.
+type UserSummary struct {
+  ID          string `json:"id"`
+  Email       string `json:"email"`
+  DisplayName string `json:"display_name"`
+  Role        string `json:"role"`
+  Verified    bool   `json:"verified"`
+  MFAEnabled  bool   `json:"mfa_enabled"`
+  Hidden      bool   `json:"hidden"`
+  Ban         *Ban   `json:"ban,omitempty"`
+}
+
+func (u *User) Summary() UserSummary {
+  summary := UserSummary{
+    ID:          u.ID.Hex(),
+    Email:       u.Email,
+    DisplayName: u.Name(),
+    Role:        u.Role,
+    Verified:    !u.VerificationPending,
+    MFAEnabled:  u.TOTPSecret != "",
+    Hidden:      u.Hidden,
+  }
+
+  if u.Ban.Active(time.Now()) {
+    summary.Ban = u.Ban
+  }
+
+  return summary
+}
+
+// PasswordReset is a single-use password reset token, only its SHA-256 hash
+// is stored.
+type PasswordReset struct {
+  ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+  Hash      string        `bson:"hash" json:"-"`
+  UserID    bson.ObjectID `bson:"user_id" json:"user_id"`
+  CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+  ExpiresAt time.Time     `bson:"expires_at" json:"expires_at"`
+}
+
+const (
+  // anyone can register
+  RegistrationOpen = "open"
+  // a valid invite code is needed to register
+  RegistrationInvite = "invite"
+  // only the email addresses of the allowed domains can register
+  RegistrationDomain = "domain"
+  // nobody can register, admins can still create accounts
+  RegistrationClosed = "closed"
+)
+
+// RegistrationSettings controls the self-service registration, the admins
+// change them at runtime.
+type RegistrationSettings struct {
+  Mode string `bson:"mode" json:"mode"`
+  // allowed email domains in the domain mode, subdomains are allowed too
+  Domains   []string  `bson:"domains,omitempty" json:"domains"`
+  UpdatedAt time.Time `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// InviteCode lets MaxUses users register while the registration is in the
+// invite mode.
+type InviteCode struct {
+  ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+  Code      string        `bson:"code" json:"code"`
+  Note      string        `bson:"note,omitempty" json:"note,omitempty"`
+  MaxUses   int           `bson:"max_uses" json:"max_uses"`
+  Uses      int           `bson:"uses" json:"uses"`
+  UsedBy    []InviteUse   `bson:"used_by,omitempty" json:"used_by,omitempty"`
+  CreatedBy bson.ObjectID `bson:"created_by" json:"created_by"`
+  CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+  ExpiresAt *time.Time    `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+  Revoked   bool          `bson:"revoked" json:"revoked"`
+}
+
+type InviteUse struct {
+  UserID bson.ObjectID `bson:"user_id" json:"user_id"`
+  UsedAt time.Time     `bson:"used_at" json:"used_at"`
+}
+
+// Usable reports whether the code can still be redeemed at t.
+func (i *InviteCode) Usable(t time.Time) bool {
+  return !i.Revoked && i.Uses < i.MaxUses && (i.ExpiresAt == nil || t.Before(*i.ExpiresAt))
+}
+
+// Identity links an account at an SSO provider to the user.
+type Identity struct {
+  Provider string    `bson:"provider" json:"provider"`
+  Subject  string    `bson:"subject" json:"subject"`
+  Email    string    `bson:"email,omitempty" json:"email,omitempty"`
+  LinkedAt time.Time `bson:"linked_at" json:"linked_at"`
+}
+
+// SSOState is a pending SSO login, keyed by the hash of the state parameter.
+// The PKCE verifier never leaves the server.
+type SSOState struct {
+  Hash       string    `bson:"_id" json:"-"`
+  Provider   string    `bson:"provider" json:"provider"`
+  Verifier   string    `bson:"verifier" json:"-"`
+  Nonce      string    `bson:"nonce" json:"-"`
+  InviteCode string    `bson:"invite_code,omitempty" json:"-"`
+  ExpiresAt  time.Time `bson:"expires_at" json:"expires_at"`
 }
@@ -0,0 +1,173 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package user
+
+import (
+  "context"
+  "crypto/rand"
+  "encoding/base64"
+  "errors"
+  "fmt"
+  "log"
+  "net/url"
+  "strings"
+  "time"
+
+  "go.mongodb.org/mongo-driver/v2/bson"
+  "go.mongodb.org/mongo-driver/v2/mongo"
+  "golang.org/x/crypto/bcrypt"
+
+  "github.com/CTFxd/ctfxd-server/internal/auth"
+  "github.com/CTFxd/ctfxd-server/pkg/mail"
+)
+
+var (
+  ErrInvalidResetToken = errors.New("invalid or expired reset token")
+  ErrWrongPassword     = errors.New("current password is incorrect")
+)
+
+const (
+  // bounds the background work of ForgotPassword
+  passwordResetMailTimeout = 30 * time.Second
+  // minimum delay between two reset mails sent to an address
+  passwordResetCooldown = time.Minute
+  // reset requests of a client IP before it must stay quiet for the period
+  passwordResetIPLimit  = 10
+  passwordResetIPPeriod = time.Hour
+)
+
+// ForgotPassword mails a password reset link in the background and returns
+// right away, whether or not the address is registered, so neither the
+// answer nor its timing reveals which addresses are registered. The requests
+// are throttled per address and per client IP, the throttled ones are
+// dropped silently. The failures are only logged.
+func (s *Service) ForgotPassword(ctx context.Context, email, clientIP string) {
+  go func() {
+    ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), passwordResetMailTimeout)
+    defer cancel()
+
+    if err := s.throttlePasswordReset(ctx, email, clientIP); err != nil {
+      var throttleErr *ThrottleError
+      if !errors.As(err, &throttleErr) {
+        log.Printf("password: error: reset throttle(%v)\n", err)
+      }
+      return
+    }
+
+    if err := s.sendPasswordReset(ctx, email); err != nil {
+      log.Printf("password: error: reset mail(%v)\n", err)
+    }
+  }()
+}
+
+func (s *Service) throttlePasswordReset(ctx context.Context, email, clientIP string) error {
+  key := "reset:" + strings.ToLower(strings.TrimSpace(email))
+  if err := s.throttle.Cooldown(ctx, key, passwordResetCooldown); err != nil {
+    return err
+  }
+
+  return s.throttle.Limit(ctx, "reset-ip:"+clientIP, passwordResetIPLimit, passwordResetIPPeriod)
+}
+
+func (s *Service) sendPasswordReset(ctx context.Context, email string) error {
+  user, err := s.repo.GetUserByEmail(ctx, email)
+  if err != nil {
+    if errors.Is(err, mongo.ErrNoDocuments) {
+      return nil
+    }
+
+    return err
+  }
+
+  buf := make([]byte, 32)
+  if _, err := rand.Read(buf); err != nil {
+    return err
+  }
+  token := base64.RawURLEncoding.EncodeToString(buf)
+
+  now := time.Now().UTC()
+  reset := &PasswordReset{
+    Hash:      auth.HashToken(token),
+    UserID:    user.ID,
+    CreatedAt: now,
+    ExpiresAt: now.Add(s.config.PasswordResetTTL),
+  }
+
+  if err := s.repo.CreatePasswordReset(ctx, reset); err != nil {
+    return err
+  }
+
+  link := s.config.PasswordResetURL + "?token=" + url.QueryEscape(token)
+  body := fmt.Sprintf("A password reset was requested for your CTFxd account.\n\nOpen the link below to choose a new password:\n\n%s\n\nThe link expires at %s. If you did not request it, ignore this email.\n",
+    link, reset.ExpiresAt.Format(time.RFC1123))
+
+  return s.mailer.Send(ctx, &mail.Message{
+    To:      user.Email,
+    Subject: "Reset your password",
+    Body:    body,
+  })
+}
+
+// ResetPassword sets a new password with a reset token and signs the user out
+// of every session.
+func (s *Service) ResetPassword(ctx context.Context, token, password string) error {
+  reset, err := s.repo.ConsumePasswordReset(ctx, auth.HashToken(token))
+  if err != nil {
+    if errors.Is(err, mongo.ErrNoDocuments) {
+      return ErrInvalidResetToken
+    }
+
+    return err
+  }
+
+  if time.Now().After(reset.ExpiresAt) {
+    return ErrInvalidResetToken
+  }
+
+  if err := s.setPassword(ctx, reset.UserID, password); err != nil {
+    return err
+  }
+
+  if err := s.repo.DeletePasswordResets(ctx, reset.UserID); err != nil {
+    return err
+  }
+
+  return s.tokens.RevokeUser(ctx, reset.UserID.Hex())
+}
+
+// ChangePassword sets a new password after checking the current one. Every
+// session is revoked and a new token pair is issued for the caller.
+func (s *Service) ChangePassword(ctx context.Context, userID, currentPassword, newPassword string, mfa bool) (*auth.TokenPair, error) {
+  user, err := s.repo.GetUserByID(ctx, userID)
+  if err != nil {
+    return nil, err
+  }
+
+  err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(currentPassword))
+  if err != nil {
+    return nil, ErrWrongPassword
+  }
+
+  if err := s.setPassword(ctx, user.ID, newPassword); err != nil {
+    return nil, err
+  }
+
+  if err := s.tokens.RevokeUser(ctx, userID); err != nil {
+    return nil, err
+  }
+
+  return s.IssueTokens(ctx, user, mfa)
+}
+
+func (s *Service) setPassword(ctx context.Context, userID bson.ObjectID, password string) error {
+  hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+  if err != nil {
+    return err
+  }
+
+  return s.repo.Update(ctx, userID, bson.M{"$set": bson.M{"password": string(hashed)}})
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package user
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "net/url"
+  "regexp"
+  "strings"
+  "unicode/utf8"
+
+  "go.mongodb.org/mongo-driver/v2/bson"
+  "go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// DefaultNamePrefix starts the placeholder name of the users without a
+// display name, users cannot pick a name with this prefix.
+const DefaultNamePrefix = "player-"
+
+const (
+  maxAffiliationLen = 100
+  maxWebsiteLen     = 200
+  maxBioLen         = 1000
+)
+
+var (
+  ErrInvalidProfile   = errors.New("invalid profile")
+  ErrDisplayNameTaken = errors.New("display name already taken")
+)
+
+var (
+  displayNameRegex = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N} _.\-]{1,30}[\p{L}\p{N}]$`)
+  countryRegex     = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// ProfileUpdate holds the profile fields to change, nil fields are left as
+// they are and empty strings clear the field (except the display name).
+type ProfileUpdate struct {
+  DisplayName *string
+  Affiliation *string
+  Country     *string
+  Website     *string
+  Bio         *string
+}
+
+// UpdateProfile validates and applies the update, it returns the updated user.
+func (s *Service) UpdateProfile(ctx context.Context, userID string, update *ProfileUpdate) (*User, error) {
+  user, err := s.repo.GetUserByID(ctx, userID)
+  if err != nil {
+    return nil, err
+  }
+
+  set := bson.M{}
+  unset := bson.M{}
+  apply := func(key, value string) {
+    if value == "" {
+      unset[key] = ""
+    } else {
+      set[key] = value
+    }
+  }
+
+  if update.DisplayName != nil {
+    name := strings.TrimSpace(*update.DisplayName)
+    if !displayNameRegex.MatchString(name) {
+      return nil, fmt.Errorf("%w: display name must be 3 to 32 letters, digits, spaces, '_', '.' or '-'", ErrInvalidProfile)
+    }
+    if strings.HasPrefix(strings.ToLower(name), DefaultNamePrefix) {
+      return nil, fmt.Errorf("%w: display name cannot start with %q", ErrInvalidProfile, DefaultNamePrefix)
+    }
+
+    set["display_name"] = name
+    set["display_name_key"] = strings.ToLower(name)
+    user.DisplayName = name
+  }
+
+  if update.Affiliation != nil {
+    affiliation := strings.TrimSpace(*update.Affiliation)
+    if utf8.RuneCountInString(affiliation) > maxAffiliationLen {
+      return nil, fmt.Errorf("%w: affiliation is longer than %d characters", ErrInvalidProfile, maxAffiliationLen)
+    }
+
+    apply("affiliation", affiliation)
+    user.Affiliation = affiliation
+  }
+
+  if update.Country != nil {
+    country := strings.ToUpper(strings.TrimSpace(*update.Country))
+    if country != "" && !countryRegex.MatchString(country) {
+      return nil, fmt.Errorf("%w: country must be an ISO 3166-1 alpha-2 code", ErrInvalidProfile)
+    }
+
+    apply("country", country)
+    user.Country = country
+  }
+
+  if update.Website != nil {
+    website := strings.TrimSpace(*update.Website)
+    if website != "" && !validWebsite(website) {
+      return nil, fmt.Errorf("%w: website must be an http(s) URL of at most %d characters", ErrInvalidProfile, maxWebsiteLen)
+    }
+
+    apply("website", website)
+    user.Website = website
+  }
+
+  if update.Bio != nil {
+    bio := strings.TrimSpace(*update.Bio)
+    if utf8.RuneCountInString(bio) > maxBioLen {
+      return nil, fmt.Errorf("%w: bio is longer than %d characters", ErrInvalidProfile, maxBioLen)
+    }
+
+    apply("bio", bio)
+    user.Bio = bio
+  }
+
+  doc := bson.M{}
+  if len(set) > 0 {
+    doc["$set"] = set
+  }
+  if len(unset) > 0 {
+    doc["$unset"] = unset
+  }
+  if len(doc) == 0 {
+    return user, nil
+  }
+
+  if err := s.repo.Update(ctx, user.ID, doc); err != nil {
+    if mongo.IsDuplicateKeyError(err) {
+      return nil, ErrDisplayNameTaken
+    }
+
+    return nil, err
+  }
+
+  return user, nil
+}
+
+// GetProfile returns the public profile of the user, hidden users have none.
+func (s *Service) GetProfile(ctx context.Context, userID string) (*User, error) {
+  user, err := s.repo.GetUserByID(ctx, userID)
+  if err != nil {
+    return nil, err
+  }
+
+  if user.Hidden {
+    return nil, mongo.ErrNoDocuments
+  }
+
+  return user, nil
+}
+
+func validWebsite(website string) bool {
+  if len(website) > maxWebsiteLen {
+    return false
+  }
+
+  u, err := url.Parse(website)
+  if err != nil {
+    return false
+  }
+
+  return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,266 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package user
+
+import (
+  "context"
+  "crypto/rand"
+  "errors"
+  "fmt"
+  "regexp"
+  "strings"
+  "time"
+
+  "go.mongodb.org/mongo-driver/v2/bson"
+
+  "github.com/CTFxd/ctfxd-server/internal/auth"
+)
+
+const (
+  maxInviteUses    = 10000
+  inviteCodeLength = 12
+  // no 0/O or 1/I/L, the codes are often typed by hand
+  inviteAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+)
+
+var (
+  ErrRegistrationClosed     = errors.New("registration is closed")
+  ErrEmailDomainNotAllowed  = errors.New("email domain not allowed")
+  ErrInviteRequired         = errors.New("invite code required")
+  ErrInvalidInvite          = errors.New("invalid or expired invite code")
+  ErrInvalidRegistrationCfg = errors.New("invalid registration settings")
+  ErrInvalidInviteCfg       = errors.New("invalid invite code settings")
+)
+
+var domainRegex = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+type InviteRequest struct {
+  // custom code, generated when empty
+  Code      string
+  Note      string
+  MaxUses   int
+  ExpiresAt *time.Time
+}
+
+// SignUp is the self-service registration, it applies the registration
+// settings: the mode, the allowed domains and the invite codes. In the domain
+// mode the address is always verified, or anyone could register with an
+// address of the domain.
+func (s *Service) SignUp(ctx context.Context, email, password, inviteCode string) error {
+  inviteCode, domainRule, err := s.checkRegistration(ctx, email, inviteCode)
+  if err != nil {
+    return err
+  }
+
+  user := &User{
+    Email:                email,
+    Role:                 auth.RoleUser,
+    VerificationPending:  s.config.RequireEmailVerification || domainRule,
+    VerificationRequired: domainRule,
+  }
+
+  return s.createUser(ctx, user, password, inviteCode)
+}
+
+// checkRegistration tells whether email may register, it returns the invite
+// code to redeem (empty when the mode needs none) and whether the domain of
+// the address is what allows it, the address must then be verified.
+func (s *Service) checkRegistration(ctx context.Context, email, inviteCode string) (string, bool, error) {
+  settings, err := s.RegistrationSettings(ctx)
+  if err != nil {
+    return "", false, err
+  }
+
+  inviteCode = normalizeInviteCode(inviteCode)
+
+  switch settings.Mode {
+  case RegistrationClosed:
+    return "", false, ErrRegistrationClosed
+  case RegistrationDomain:
+    if !emailDomainAllowed(email, settings.Domains) {
+      return "", false, ErrEmailDomainNotAllowed
+    }
+    return "", true, nil
+  case RegistrationInvite:
+    if inviteCode == "" {
+      return "", false, ErrInviteRequired
+    }
+    return inviteCode, false, nil
+  }
+
+  return "", false, nil
+}
+
+// RegistrationSettings returns the settings saved by the admins, or the
+// configured defaults.
+func (s *Service) RegistrationSettings(ctx context.Context) (*RegistrationSettings, error) {
+  settings, err := s.repo.GetRegistrationSettings(ctx)
+  if err != nil {
+    return nil, err
+  }
+
+  if settings == nil {
+    defaults := s.config.Registration
+    if defaults.Mode == "" {
+      defaults.Mode = RegistrationOpen
+    }
+    if defaults.Domains == nil {
+      defaults.Domains = []string{}
+    }
+
+    return &defaults, nil
+  }
+
+  if settings.Domains == nil {
+    settings.Domains = []string{}
+  }
+
+  return settings, nil
+}
+
+func (s *Service) UpdateRegistrationSettings(ctx context.Context, mode string, domains []string) (*RegistrationSettings, error) {
+  settings, err := NewRegistrationSettings(mode, domains)
+  if err != nil {
+    return nil, err
+  }
+
+  settings.UpdatedAt = time.Now().UTC()
+  if err := s.repo.SaveRegistrationSettings(ctx, settings); err != nil {
+    return nil, err
+  }
+
+  return settings, nil
+}
+
+// NewRegistrationSettings validates and normalizes the settings.
+func NewRegistrationSettings(mode string, domains []string) (*RegistrationSettings, error) {
+  switch mode {
+  case RegistrationOpen, RegistrationInvite, RegistrationDomain, RegistrationClosed:
+  default:
+    return nil, fmt.Errorf("%w: unknown mode %q", ErrInvalidRegistrationCfg, mode)
+  }
+
+  normalized := []string{}
+  for _, domain := range domains {
+    domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+    if domain == "" {
+      continue
+    }
+
+    if !domainRegex.MatchString(domain) {
+      return nil, fmt.Errorf("%w: invalid domain %q", ErrInvalidRegistrationCfg, domain)
+    }
+    normalized = append(normalized, domain)
+  }
+
+  if mode == RegistrationDomain && len(normalized) == 0 {
+    return nil, fmt.Errorf("%w: the domain mode needs at least one domain", ErrInvalidRegistrationCfg)
+  }
+
+  return &RegistrationSettings{Mode: mode, Domains: normalized}, nil
+}
+
+func (s *Service) CreateInvite(ctx context.Context, actor *auth.Claims, req *InviteRequest) (*InviteCode, error) {
+  if req.MaxUses < 1 || req.MaxUses > maxInviteUses {
+    return nil, fmt.Errorf("%w: max uses must be between 1 and %d", ErrInvalidInviteCfg, maxInviteUses)
+  }
+
+  if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+    return nil, fmt.Errorf("%w: expiry must be in the future", ErrInvalidInviteCfg)
+  }
+
+  code := normalizeInviteCode(req.Code)
+  if code == "" {
+    var err error
+    if code, err = generateInviteCode(); err != nil {
+      return nil, err
+    }
+  } else if len(code) < 6 || len(code) > 64 {
+    return nil, fmt.Errorf("%w: code must be 6 to 64 characters", ErrInvalidInviteCfg)
+  }
+
+  createdBy, err := bson.ObjectIDFromHex(actor.UserID)
+  if err != nil {
+    return nil, err
+  }
+
+  invite := &InviteCode{
+    Code:      code,
+    Note:      req.Note,
+    MaxUses:   req.MaxUses,
+    CreatedBy: createdBy,
+    CreatedAt: time.Now().UTC(),
+    ExpiresAt: req.ExpiresAt,
+  }
+
+  if err := s.repo.CreateInvite(ctx, invite); err != nil {
+    return nil, err
+  }
+
+  return invite, nil
+}
+
+func (s *Service) ListInvites(ctx context.Context) ([]InviteCode, error) {
+  return s.repo.ListInvites(ctx)
+}
+
+func (s *Service) GetInvite(ctx context.Context, id string) (*InviteCode, error) {
+  objID, err := bson.ObjectIDFromHex(id)
+  if err != nil {
+    return nil, err
+  }
+
+  return s.repo.GetInvite(ctx, objID)
+}
+
+// RevokeInvite stops the code from being used, the accounts already created
+// with it are kept.
+func (s *Service) RevokeInvite(ctx context.Context, id string) error {
+  objID, err := bson.ObjectIDFromHex(id)
+  if err != nil {
+    return err
+  }
+
+  return s.repo.RevokeInvite(ctx, objID)
+}
+
+// emailDomainAllowed matches the domain of the email against the domains and
+// their subdomains.
+func emailDomainAllowed(email string, domains []string) bool {
+  at := strings.LastIndexByte(email, '@')
+  if at < 0 {
+    return false
+  }
+
+  domain := strings.ToLower(email[at+1:])
+  for _, allowed := range domains {
+    if domain == allowed || strings.HasSuffix(domain, "."+allowed) {
+      return true
+    }
+  }
+
+  return false
+}
+
+func normalizeInviteCode(code string) string {
+  return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func generateInviteCode() (string, error) {
+  buf := make([]byte, inviteCodeLength)
+  if _, err := rand.Read(buf); err != nil {
+    return "", err
+  }
+
+  // 256 is not a multiple of len(inviteAlphabet), the bias is negligible
+  // for codes that are single use and revocable
+  for i := range buf {
+    buf[i] = inviteAlphabet[int(buf[i])%len(inviteAlphabet)]
+  }
+
+  return string(buf), nil
+}
@@ -8,26 +8,97 @@ package user
 
 import (
   "context"
+  "errors"
+  "log"
+  "time"
 
   "go.mongodb.org/mongo-driver/v2/bson"
   "go.mongodb.org/mongo-driver/v2/mongo"
+  "go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 type Repository struct {
   collection *mongo.Collection
+  resets     *mongo.Collection
+  attempts   *mongo.Collection
+  invites    *mongo.Collection
+  settings   *mongo.Collection
+  ssoStates  *mongo.Collection
 }
 
+const registrationSettingsID = "registration"
+
 func NewRepository(db *mongo.Database) *Repository {
   repo := new(Repository)
   repo.collection = db.Collection("users")
+  repo.resets = db.Collection("password_resets")
+  repo.attempts = db.Collection("login_attempts")
+  repo.invites = db.Collection("invite_codes")
+  repo.settings = db.Collection("settings")
+  repo.ssoStates = db.Collection("sso_states")
+
+  ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+  defer cancel()
+
+  _, err := repo.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+    {
+      Keys: bson.D{{Key: "display_name_key", Value: 1}},
+      Options: options.Index().SetUnique(true).
+        SetPartialFilterExpression(bson.M{"display_name_key": bson.M{"$exists": true}}),
+    },
+    {
+      Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}},
+      Options: options.Index().SetUnique(true).
+        SetPartialFilterExpression(bson.M{"identities": bson.M{"$exists": true}}),
+    },
+  })
+  if err != nil {
+    log.Printf("user: error: user indexes(%v)\n", err)
+  }
+
+  _, err = repo.resets.Indexes().CreateMany(ctx, []mongo.IndexModel{
+    {Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+    {Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+  })
+  if err != nil {
+    log.Printf("user: error: password reset indexes(%v)\n", err)
+  }
+
+  _, err = repo.attempts.Indexes().CreateOne(ctx, mongo.IndexModel{
+    Keys:    bson.D{{Key: "last_failure", Value: 1}},
+    Options: options.Index().SetExpireAfterSeconds(int32((24 * time.Hour).Seconds())),
+  })
+  if err != nil {
+    log.Printf("user: error: login attempt indexes(%v)\n", err)
+  }
+
+  _, err = repo.ssoStates.Indexes().CreateOne(ctx, mongo.IndexModel{
+    Keys:    bson.D{{Key: "expires_at", Value: 1}},
+    Options: options.Index().SetExpireAfterSeconds(0),
+  })
+  if err != nil {
+    log.Printf("user: error: sso state indexes(%v)\n", err)
+  }
+
+  _, err = repo.invites.Indexes().CreateOne(ctx, mongo.IndexModel{
+    Keys:    bson.D{{Key: "code", Value: 1}},
+    Options: options.Index().SetUnique(true),
+  })
+  if err != nil {
+    log.Printf("user: error: invite code indexes(%v)\n", err)
+  }
 
   return repo
 }
 
 func (r *Repository) CreateUser(ctx context.Context, user *User) error {
-  _, err := r.collection.InsertOne(ctx, user)
+  result, err := r.collection.InsertOne(ctx, user)
+  if err != nil {
+    return err
+  }
 
-  return err
+  user.ID = result.InsertedID.(bson.ObjectID)
+  return nil
 }
 
 func (r *Repository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
@@ -40,3 +111,366 @@ func (r *Repository) GetUserByEmail(ctx context.Context, email string) (*User, e
 
   return user, nil
 }
+
+func (r *Repository) GetUserByID(ctx context.Context, id string) (*User, error) {
+  objId, err := bson.ObjectIDFromHex(id)
+  if err != nil {
+    return nil, err
+  }
+
+  user := new(User)
+  err = r.collection.FindOne(ctx, bson.M{"_id": objId}).Decode(user)
+  if err != nil {
+    return nil, err
+  }
+
+  return user, nil
+}
+
+// ListUsers returns a page of the users matching the filter, sorted by id
+// (creation time), and the total number of matches.
+func (r *Repository) ListUsers(ctx context.Context, filter bson.M, skip, limit int64) ([]User, int64, error) {
+  total, err := r.collection.CountDocuments(ctx, filter)
+  if err != nil {
+    return nil, 0, err
+  }
+
+  opts := options.Find().
+    SetSort(bson.D{{Key: "_id", Value: 1}}).
+    SetSkip(skip).
+    SetLimit(limit)
+
+  cursor, err := r.collection.Find(ctx, filter, opts)
+  if err != nil {
+    return nil, 0, err
+  }
+  defer cursor.Close(ctx)
+
+  users := []User{}
+  if err := cursor.All(ctx, &users); err != nil {
+    return nil, 0, err
+  }
+
+  return users, total, nil
+}
+
+func (r *Repository) DeleteUser(ctx context.Context, id bson.ObjectID) error {
+  result, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+  if err != nil {
+    return err
+  }
+
+  if result.DeletedCount == 0 {
+    return mongo.ErrNoDocuments
+  }
+
+  return nil
+}
+
+func (r *Repository) Update(ctx context.Context, id bson.ObjectID, update any) error {
+  result, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+  if err != nil {
+    return err
+  }
+
+  if result.MatchedCount == 0 {
+    return mongo.ErrNoDocuments
+  }
+
+  return nil
+}
+
+func (r *Repository) CreatePasswordReset(ctx context.Context, reset *PasswordReset) error {
+  _, err := r.resets.InsertOne(ctx, reset)
+
+  return err
+}
+
+// ConsumePasswordReset deletes the reset token and returns it, so a token can
+// be used only once even with concurrent requests.
+func (r *Repository) ConsumePasswordReset(ctx context.Context, hash string) (*PasswordReset, error) {
+  reset := new(PasswordReset)
+
+  err := r.resets.FindOneAndDelete(ctx, bson.M{"hash": hash}).Decode(reset)
+  if err != nil {
+    return nil, err
+  }
+
+  return reset, nil
+}
+
+func (r *Repository) DeletePasswordResets(ctx context.Context, userID bson.ObjectID) error {
+  _, err := r.resets.DeleteMany(ctx, bson.M{"user_id": userID})
+
+  return err
+}
+
+// SetTOTPLastStep records the last used TOTP step, it reports false if the
+// step (or a later one) was already used.
+func (r *Repository) SetTOTPLastStep(ctx context.Context, id bson.ObjectID, step int64) (bool, error) {
+  filter := bson.M{
+    "_id": id,
+    "$or": bson.A{
+      bson.M{"totp_last_step": bson.M{"$exists": false}},
+      bson.M{"totp_last_step": bson.M{"$lt": step}},
+    },
+  }
+
+  result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"totp_last_step": step}})
+  if err != nil {
+    return false, err
+  }
+
+  return result.ModifiedCount == 1, nil
+}
+
+// ConsumeRecoveryCode removes the (hashed) recovery code, it reports false if
+// the code does not exist or was already used.
+func (r *Repository) ConsumeRecoveryCode(ctx context.Context, id bson.ObjectID, hash string) (bool, error) {
+  filter := bson.M{"_id": id, "recovery_codes": hash}
+
+  result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"recovery_codes": hash}})
+  if err != nil {
+    return false, err
+  }
+
+  return result.ModifiedCount == 1, nil
+}
+
+func (r *Repository) GetLoginAttempts(ctx context.Context, key string) (*LoginAttempts, error) {
+  attempts := new(LoginAttempts)
+
+  err := r.attempts.FindOne(ctx, bson.M{"_id": key}).Decode(attempts)
+  if err != nil {
+    if errors.Is(err, mongo.ErrNoDocuments) {
+      return nil, nil
+    }
+
+    return nil, err
+  }
+
+  return attempts, nil
+}
+
+// RecordLoginFailure counts the failure with a single update, so concurrent
+// failures are all counted, and returns the updated counter.
+func (r *Repository) RecordLoginFailure(ctx context.Context, key string, failure LoginFailure) (*LoginAttempts, error) {
+  // the count restarts after a quiet period
+  fresh := bson.M{"$or": bson.A{
+    bson.M{"$eq": bson.A{bson.M{"$type": "$last_failure"}, "missing"}},
+    bson.M{"$lt": bson.A{"$last_failure", failure.ResetBefore}},
+  }}
+  locked := bson.M{"$and": bson.A{
+    bson.M{"$gt": bson.A{failure.LockAt, 0}},
+    bson.M{"$gte": bson.A{"$failures", failure.LockAt}},
+  }}
+
+  update := mongo.Pipeline{
+    {{Key: "$set", Value: bson.M{
+      "failures":     bson.M{"$cond": bson.A{fresh, 1, bson.M{"$add": bson.A{"$failures", 1}}}},
+      "last_failure": failure.Time,
+    }}},
+    {{Key: "$set", Value: bson.M{
+      "locked_until": bson.M{"$cond": bson.A{locked, failure.LockUntil, bson.M{"$ifNull": bson.A{"$locked_until", time.Time{}}}}},
+    }}},
+  }
+
+  attempts := new(LoginAttempts)
+  opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+  err := r.attempts.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(attempts)
+  if err != nil {
+    return nil, err
+  }
+
+  return attempts, nil
+}
+
+func (r *Repository) DeleteLoginAttempts(ctx context.Context, key string) error {
+  _, err := r.attempts.DeleteOne(ctx, bson.M{"_id": key})
+
+  return err
+}
+
+// GetRegistrationSettings returns nil, nil when the settings were never saved.
+func (r *Repository) GetRegistrationSettings(ctx context.Context) (*RegistrationSettings, error) {
+  settings := new(RegistrationSettings)
+
+  err := r.settings.FindOne(ctx, bson.M{"_id": registrationSettingsID}).Decode(settings)
+  if err != nil {
+    if errors.Is(err, mongo.ErrNoDocuments) {
+      return nil, nil
+    }
+
+    return nil, err
+  }
+
+  return settings, nil
+}
+
+func (r *Repository) SaveRegistrationSettings(ctx context.Context, settings *RegistrationSettings) error {
+  opts := options.Replace().SetUpsert(true)
+  _, err := r.settings.ReplaceOne(ctx, bson.M{"_id": registrationSettingsID}, settings, opts)
+
+  return err
+}
+
+func (r *Repository) CreateInvite(ctx context.Context, invite *InviteCode) error {
+  result, err := r.invites.InsertOne(ctx, invite)
+  if err != nil {
+    return err
+  }
+
+  invite.ID = result.InsertedID.(bson.ObjectID)
+  return nil
+}
+
+func (r *Repository) ListInvites(ctx context.Context) ([]InviteCode, error) {
+  opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+  cursor, err := r.invites.Find(ctx, bson.M{}, opts)
+  if err != nil {
+    return nil, err
+  }
+
+  invites := []InviteCode{}
+  if err := cursor.All(ctx, &invites); err != nil {
+    return nil, err
+  }
+
+  return invites, nil
+}
+
+func (r *Repository) GetInvite(ctx context.Context, id bson.ObjectID) (*InviteCode, error) {
+  invite := new(InviteCode)
+
+  err := r.invites.FindOne(ctx, bson.M{"_id": id}).Decode(invite)
+  if err != nil {
+    return nil, err
+  }
+
+  return invite, nil
+}
+
+func (r *Repository) RevokeInvite(ctx context.Context, id bson.ObjectID) error {
+  result, err := r.invites.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"revoked": true}})
+  if err != nil {
+    return err
+  }
+
+  if result.MatchedCount == 0 {
+    return mongo.ErrNoDocuments
+  }
+
+  return nil
+}
+
+// RedeemInvite atomically records a use of the code, it reports false when
+// the code does not exist or cannot be used anymore.
+func (r *Repository) RedeemInvite(ctx context.Context, code string, use *InviteUse) (bool, error) {
+  filter := bson.M{
+    "code":    code,
+    "revoked": false,
+    "$expr":   bson.M{"$lt": bson.A{"$uses", "$max_uses"}},
+    "$or": bson.A{
+      bson.M{"expires_at": bson.M{"$exists": false}},
+      bson.M{"expires_at": bson.M{"$gt": use.UsedAt}},
+    },
+  }
+  update := bson.M{
+    "$inc":  bson.M{"uses": 1},
+    "$push": bson.M{"used_by": use},
+  }
+
+  result, err := r.invites.UpdateOne(ctx, filter, update)
+  if err != nil {
+    return false, err
+  }
+
+  return result.ModifiedCount == 1, nil
+}
+
+// ReleaseInvite gives back a use taken by RedeemInvite, when the account
+// could not be created after all.
+func (r *Repository) ReleaseInvite(ctx context.Context, code string, userID bson.ObjectID) error {
+  update := bson.M{
+    "$inc":  bson.M{"uses": -1},
+    "$pull": bson.M{"used_by": bson.M{"user_id": userID}},
+  }
+  _, err := r.invites.UpdateOne(ctx, bson.M{"code": code, "used_by.user_id": userID}, update)
+
+  return err
+}
+
+func (r *Repository) GetUserByIdentity(ctx context.Context, provider, subject string) (*User, error) {
+  user := new(User)
+
+  filter := bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}}}
+  err := r.collection.FindOne(ctx, filter).Decode(user)
+  if err != nil {
+    return nil, err
+  }
+
+  return user, nil
+}
+
+// LinkIdentity adds the identity to the user, unless the user already has
+// one at the same provider.
+func (r *Repository) LinkIdentity(ctx context.Context, id bson.ObjectID, identity *Identity) (bool, error) {
+  filter := bson.M{"_id": id, "identities.provider": bson.M{"$ne": identity.Provider}}
+
+  result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$push": bson.M{"identities": identity}})
+  if err != nil {
+    return false, err
+  }
+
+  return result.ModifiedCount == 1, nil
+}
+
+// ClaimWithIdentity links the identity to a user whose address was never
+// verified, dropping the password and the second factor of the account: the
+// identity proves the address, whoever registered it may not own it. It
+// reports whether the user was claimed.
+func (r *Repository) ClaimWithIdentity(ctx context.Context, id bson.ObjectID, identity *Identity) (bool, error) {
+  filter := bson.M{
+    "_id":                 id,
+    "email_verified":      bson.M{"$ne": true},
+    "identities.provider": bson.M{"$ne": identity.Provider},
+  }
+  update := bson.M{
+    "$push": bson.M{"identities": identity},
+    "$set":  bson.M{"email_verified": true},
+    "$unset": bson.M{
+      "password":             "",
+      "verification_pending": "",
+      "totp_secret":          "",
+      "totp_pending_secret":  "",
+      "totp_last_step":       "",
+      "recovery_codes":       "",
+    },
+  }
+
+  result, err := r.collection.UpdateOne(ctx, filter, update)
+  if err != nil {
+    return false, err
+  }
+
+  return result.ModifiedCount == 1, nil
+}
+
+func (r *Repository) CreateSSOState(ctx context.Context, state *SSOState) error {
+  _, err := r.ssoStates.InsertOne(ctx, state)
+
+  return err
+}
+
+// ConsumeSSOState returns and deletes the state, so it can only be used once.
+func (r *Repository) ConsumeSSOState(ctx context.Context, hash string) (*SSOState, error) {
+  state := new(SSOState)
+
+  err := r.ssoStates.FindOneAndDelete(ctx, bson.M{"_id": hash}).Decode(state)
+  if err != nil {
+    return nil, err
+  }
+
+  return state, nil
+}
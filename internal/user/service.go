@@ -9,29 +9,123 @@ package user
 import (
   "context"
   "errors"
+  "log"
+  "time"
 
+  "go.mongodb.org/mongo-driver/v2/bson"
   "go.mongodb.org/mongo-driver/v2/mongo"
   "golang.org/x/crypto/bcrypt"
+
+  "github.com/CTFxd/ctfxd-server/internal/auth"
+  "github.com/CTFxd/ctfxd-server/pkg/mail"
 )
 
 var (
   ErrUserExists         = errors.New("user already exists")
+  ErrInvalidRole        = errors.New("invalid role")
   ErrInvalidCredentials = errors.New("invalid eamil or password")
+  ErrEmailNotVerified   = errors.New("email address not verified")
 )
 
+type Config struct {
+  // block the logins until the email address is verified
+  RequireEmailVerification bool
+  // page receiving the verification token, as "?token=..."
+  VerifyEmailURL  string
+  VerificationTTL time.Duration
+
+  // page receiving the password reset token, as "?token=..."
+  PasswordResetURL string
+  PasswordResetTTL time.Duration
+
+  // issuer shown by the authenticator apps
+  TOTPIssuer string
+  // two-factor authentication is mandatory for the admin role
+  RequireAdminMFA bool
+
+  // registration settings used until an admin changes them
+  Registration RegistrationSettings
+
+  // providers for the SSO logins
+  SSOProviders []*SSOProvider
+}
+
+// dummyHash is compared against when the email is unknown, so a login takes
+// the same time whether or not the account exists.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("ctfxd-dummy-password"), bcrypt.DefaultCost)
+
+// CredentialRevoker drops the credentials another package issued to an
+// account, e.g. when an SSO login claims the account.
+type CredentialRevoker interface {
+  RevokeUserCredentials(ctx context.Context, userID string) error
+}
+
+// AccountDataCleaner drops the data another package keeps for an account
+// when the account is deleted.
+type AccountDataCleaner interface {
+  DeleteUserData(ctx context.Context, userID string) error
+}
+
 type Service struct {
-  repo *Repository
+  repo      *Repository
+  ssoStates SSOStateStore
+  tokens    *auth.Service
+  mailer    mail.Mailer
+  throttle  *LoginThrottle
+  config    Config
+  cleaners  []AccountDataCleaner
+  revokers  []CredentialRevoker
 }
 
-func NewService(repo *Repository) *Service {
+func NewService(repo *Repository, tokens *auth.Service, mailer mail.Mailer, throttle *LoginThrottle, config Config) *Service {
   serv := new(Service)
   serv.repo = repo
+  serv.ssoStates = repo
+  serv.tokens = tokens
+  serv.mailer = mailer
+  serv.throttle = throttle
+  serv.config = config
 
   return serv
 }
 
-func (s *Service) Register(ctx context.Context, email, password string, isAdmin bool) error {
-  _, err := s.repo.GetUserByEmail(ctx, email)
+// AddAccountDataCleaner registers a cleaner run by DeleteUser.
+func (s *Service) AddAccountDataCleaner(cleaner AccountDataCleaner) {
+  s.cleaners = append(s.cleaners, cleaner)
+}
+
+// AddCredentialRevoker registers a revoker run when an account is claimed.
+func (s *Service) AddCredentialRevoker(revoker CredentialRevoker) {
+  s.revokers = append(s.revokers, revoker)
+}
+
+// Register creates an account with any role, regardless of the registration
+// settings. The self-service registration goes through SignUp.
+func (s *Service) Register(ctx context.Context, email, password, role string) (*User, error) {
+  if !auth.IsValidRole(role) {
+    return nil, ErrInvalidRole
+  }
+
+  // privileged accounts are created by admins, no need to verify them
+  user := &User{
+    Email:               email,
+    Role:                role,
+    VerificationPending: s.config.RequireEmailVerification && role == auth.RoleUser,
+  }
+
+  if err := s.createUser(ctx, user, password, ""); err != nil {
+    return nil, err
+  }
+
+  return user, nil
+}
+
+// createUser stores the new user, redeeming inviteCode (if not empty) on the
+// way. The invite use is given back when the account cannot be created. An
+// empty password creates an account that can only log in through SSO (or
+// after a password reset).
+func (s *Service) createUser(ctx context.Context, user *User, password, inviteCode string) error {
+  _, err := s.repo.GetUserByEmail(ctx, user.Email)
   if err == nil {
     return ErrUserExists
   }
@@ -40,38 +134,143 @@ func (s *Service) Register(ctx context.Context, email, password string, isAdmin
     return err
   }
 
-  hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-  if err != nil {
-    return err
+  if password != "" {
+    hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+    if err != nil {
+      return err
+    }
+    user.Password = string(hashed)
   }
 
-  user := &User{
-    Email:    email,
-    Password: string(hashed),
-    Role:     "user",
+  user.ID = bson.NewObjectID()
+
+  if inviteCode != "" {
+    ok, err := s.repo.RedeemInvite(ctx, inviteCode, &InviteUse{UserID: user.ID, UsedAt: time.Now().UTC()})
+    if err != nil {
+      return err
+    }
+
+    if !ok {
+      return ErrInvalidInvite
+    }
+  }
+
+  if err := s.repo.CreateUser(ctx, user); err != nil {
+    if inviteCode != "" {
+      if err := s.repo.ReleaseInvite(ctx, inviteCode, user.ID); err != nil {
+        log.Printf("user: error: release invite(%v)\n", err)
+      }
+    }
+
+    if mongo.IsDuplicateKeyError(err) {
+      return ErrUserExists
+    }
+
+    return err
   }
 
-  if isAdmin == true {
-    user.Role = "admin"
+  if user.VerificationPending {
+    if err := s.sendVerificationEmail(ctx, user); err != nil {
+      log.Printf("user: error: verification email(%v)\n", err)
+    }
   }
 
-  return s.repo.CreateUser(ctx, user)
+  return nil
 }
 
-func (s *Service) Login(ctx context.Context, email, password string) (*User, error) {
+// Login checks the credentials. Failed attempts are throttled per account and
+// per client IP, see LoginThrottle.
+func (s *Service) Login(ctx context.Context, email, password, clientIP string) (*User, error) {
+  if err := s.throttle.Check(ctx, email, clientIP); err != nil {
+    return nil, err
+  }
+
   user, err := s.repo.GetUserByEmail(ctx, email)
-  if err != nil {
-    if !errors.Is(err, mongo.ErrNoDocuments) {
-      return nil, err
+  if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+    return nil, err
+  }
+
+  hash := dummyHash
+  if user != nil {
+    hash = []byte(user.Password)
+  }
+
+  err = bcrypt.CompareHashAndPassword(hash, []byte(password))
+  if err != nil || user == nil {
+    if err := s.throttle.Fail(ctx, email, clientIP); err != nil {
+      log.Printf("user: error: login throttle(%v)\n", err)
     }
 
     return nil, ErrInvalidCredentials
   }
 
-  err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
-  if err != nil {
-    return nil, ErrInvalidCredentials
+  if err := s.throttle.Succeed(ctx, email); err != nil {
+    log.Printf("user: error: login throttle(%v)\n", err)
+  }
+
+  if user.Ban.Active(time.Now()) {
+    return nil, ErrUserBanned
+  }
+
+  if user.VerificationPending && s.mustVerify(user) {
+    return nil, ErrEmailNotVerified
   }
 
   return user, nil
 }
+
+// mustVerify tells whether a pending address blocks the logins of the user.
+func (s *Service) mustVerify(user *User) bool {
+  return s.config.RequireEmailVerification || user.VerificationRequired
+}
+
+// UnlockUser clears the failed login counter of the account.
+func (s *Service) UnlockUser(ctx context.Context, userID string) error {
+  user, err := s.repo.GetUserByID(ctx, userID)
+  if err != nil {
+    return err
+  }
+
+  return s.throttle.Unlock(ctx, user.Email)
+}
+
+func (s *Service) GetUser(ctx context.Context, id string) (*User, error) {
+  return s.repo.GetUserByID(ctx, id)
+}
+
+func (s *Service) IssueTokens(ctx context.Context, user *User, mfa bool) (*auth.TokenPair, error) {
+  return s.tokens.IssueTokens(ctx, user.ID.Hex(), user.Email, user.Role, "", mfa)
+}
+
+// Refresh rotates the refresh token and issues a new token pair with the
+// current email and role of the user.
+func (s *Service) Refresh(ctx context.Context, refreshToken string) (*auth.TokenPair, error) {
+  record, err := s.tokens.ConsumeRefreshToken(ctx, refreshToken)
+  if err != nil {
+    return nil, err
+  }
+
+  user, err := s.repo.GetUserByID(ctx, record.UserID)
+  if err != nil {
+    if errors.Is(err, mongo.ErrNoDocuments) {
+      return nil, auth.ErrInvalidRefreshToken
+    }
+
+    return nil, err
+  }
+
+  if user.Ban.Active(time.Now()) {
+    return nil, ErrUserBanned
+  }
+
+  return s.tokens.IssueTokens(ctx, record.UserID, user.Email, user.Role, record.FamilyID, record.MFA)
+}
+
+// Logout ends the current session, or every session of the user if all is set.
+func (s *Service) Logout(ctx context.Context, claims *auth.Claims, refreshToken string, all bool) error {
+  if all {
+    return s.tokens.RevokeUser(ctx, claims.UserID)
+  }
+
+  return s.tokens.Logout(ctx, claims, refreshToken)
+}
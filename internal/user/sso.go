@@ -0,0 +1,373 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package user
+
+import (
+  "context"
+  "crypto/subtle"
+  "errors"
+  "fmt"
+  "log"
+  "strings"
+  "time"
+
+  "go.mongodb.org/mongo-driver/v2/bson"
+  "go.mongodb.org/mongo-driver/v2/mongo"
+
+  "github.com/CTFxd/ctfxd-server/internal/auth"
+  "github.com/CTFxd/ctfxd-server/pkg/oidc"
+)
+
+const ssoStateTTL = 10 * time.Minute
+
+var (
+  ErrUnknownProvider  = errors.New("unknown SSO provider")
+  ErrInvalidSSOState  = errors.New("invalid or expired SSO login")
+  ErrSSOFailed        = errors.New("SSO login failed")
+  ErrSSOEmailRequired = errors.New("the SSO provider did not return an email address")
+  ErrIdentityConflict = errors.New("account already linked to another identity of this provider")
+)
+
+// SSOStateStore keeps the pending SSO logins. ConsumeSSOState returns
+// mongo.ErrNoDocuments for an unknown state.
+type SSOStateStore interface {
+  CreateSSOState(ctx context.Context, state *SSOState) error
+  ConsumeSSOState(ctx context.Context, hash string) (*SSOState, error)
+}
+
+// roles by increasing privilege, the most privileged mapped role wins
+var rolePrecedence = []string{auth.RoleUser, auth.RoleAuthor, auth.RoleModerator, auth.RoleAdmin}
+
+// SSOProvider is an OIDC (or OAuth2) provider users can log in with.
+type SSOProvider struct {
+  *oidc.Provider
+
+  // RoleClaim is the (dotted) path of the claim holding the groups or roles
+  // of the user, e.g. "groups" or "realm_access.roles". When set, the role
+  // of the user is synced from RoleMap on every login through the provider.
+  RoleClaim string
+  // claim value -> role
+  RoleMap map[string]string
+}
+
+type SSOProviderInfo struct {
+  Name        string `json:"name"`
+  DisplayName string `json:"display_name"`
+}
+
+func (s *Service) SSOProviders() []SSOProviderInfo {
+  providers := make([]SSOProviderInfo, 0, len(s.config.SSOProviders))
+  for _, p := range s.config.SSOProviders {
+    providers = append(providers, SSOProviderInfo{Name: p.Name(), DisplayName: p.DisplayName()})
+  }
+
+  return providers
+}
+
+// StartSSO returns the URL of the provider to send the user to, and the
+// binding the browser must present to FinishSSO (kept in a cookie), so a
+// flow started by someone else can't be completed in the browser. inviteCode
+// is kept for the registration of a new account at the end of the flow.
+func (s *Service) StartSSO(ctx context.Context, providerName, inviteCode string) (string, string, error) {
+  provider := s.ssoProvider(providerName)
+  if provider == nil {
+    return "", "", ErrUnknownProvider
+  }
+
+  state, err := oidc.RandomString(32)
+  if err != nil {
+    return "", "", err
+  }
+
+  nonce, err := oidc.RandomString(32)
+  if err != nil {
+    return "", "", err
+  }
+
+  verifier, challenge, err := oidc.NewVerifier()
+  if err != nil {
+    return "", "", err
+  }
+
+  authURL, err := provider.AuthCodeURL(ctx, state, nonce, challenge)
+  if err != nil {
+    return "", "", fmt.Errorf("%w: %w", ErrSSOFailed, err)
+  }
+
+  err = s.ssoStates.CreateSSOState(ctx, &SSOState{
+    Hash:       auth.HashToken(state),
+    Provider:   provider.Name(),
+    Verifier:   verifier,
+    Nonce:      nonce,
+    InviteCode: inviteCode,
+    ExpiresAt:  time.Now().UTC().Add(ssoStateTTL),
+  })
+  if err != nil {
+    return "", "", err
+  }
+
+  return authURL, ssoBinding(state), nil
+}
+
+// FinishSSO completes the login with the code and state the provider
+// redirected with. The identity logs into the user it is linked to, else it
+// is linked to the account with the same (verified) email, else a new
+// account is registered, following the registration settings. An account
+// whose own address was never verified is claimed, see claimUser. binding is
+// the one StartSSO returned to the browser.
+func (s *Service) FinishSSO(ctx context.Context, providerName, code, state, binding string) (*User, *LoginResult, error) {
+  provider := s.ssoProvider(providerName)
+  if provider == nil {
+    return nil, nil, ErrUnknownProvider
+  }
+
+  identity, pending, err := s.ssoIdentity(ctx, provider, code, state, binding)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  user, claimed, err := s.ssoUser(ctx, provider, identity, pending.InviteCode)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  if err := s.syncSSORole(ctx, provider, identity, user); err != nil {
+    return nil, nil, err
+  }
+
+  if user.Ban.Active(time.Now()) {
+    return nil, nil, ErrUserBanned
+  }
+
+  if user.VerificationPending {
+    // the provider vouches for the address
+    if identity.EmailVerified && strings.EqualFold(identity.Email, user.Email) {
+      if err := s.markVerified(ctx, user); err != nil {
+        return nil, nil, err
+      }
+    } else if s.mustVerify(user) {
+      return nil, nil, ErrEmailNotVerified
+    }
+  }
+
+  result, err := s.StartSession(ctx, user)
+  if err != nil {
+    return nil, nil, err
+  }
+  result.Claimed = claimed
+
+  return user, result, nil
+}
+
+// ssoIdentity checks the state of the flow and returns the identity at the
+// provider.
+func (s *Service) ssoIdentity(ctx context.Context, provider *SSOProvider, code, state, binding string) (*oidc.Identity, *SSOState, error) {
+  // checked before the state is consumed, a forged callback doesn't cancel
+  // the login of the user
+  if subtle.ConstantTimeCompare([]byte(binding), []byte(ssoBinding(state))) != 1 {
+    return nil, nil, ErrInvalidSSOState
+  }
+
+  pending, err := s.ssoStates.ConsumeSSOState(ctx, auth.HashToken(state))
+  if err != nil {
+    if errors.Is(err, mongo.ErrNoDocuments) {
+      return nil, nil, ErrInvalidSSOState
+    }
+
+    return nil, nil, err
+  }
+
+  if pending.Provider != provider.Name() || time.Now().After(pending.ExpiresAt) {
+    return nil, nil, ErrInvalidSSOState
+  }
+
+  token, err := provider.Exchange(ctx, code, pending.Verifier)
+  if err != nil {
+    return nil, nil, fmt.Errorf("%w: %w", ErrSSOFailed, err)
+  }
+
+  identity, err := provider.Identity(ctx, token, pending.Nonce)
+  if err != nil {
+    return nil, nil, fmt.Errorf("%w: %w", ErrSSOFailed, err)
+  }
+
+  return identity, pending, nil
+}
+
+// ssoBinding is the value the browser keeps while a flow is pending, the
+// hash of its state.
+func ssoBinding(state string) string {
+  return auth.HashToken(state)
+}
+
+// ssoUser returns the user of the identity, and whether it claimed an
+// existing account.
+func (s *Service) ssoUser(ctx context.Context, provider *SSOProvider, identity *oidc.Identity, inviteCode string) (*User, bool, error) {
+  user, err := s.repo.GetUserByIdentity(ctx, provider.Name(), identity.Subject)
+  if err == nil {
+    return user, false, nil
+  }
+
+  if !errors.Is(err, mongo.ErrNoDocuments) {
+    return nil, false, err
+  }
+
+  if identity.Email == "" {
+    return nil, false, ErrSSOEmailRequired
+  }
+
+  link := &Identity{
+    Provider: provider.Name(),
+    Subject:  identity.Subject,
+    Email:    identity.Email,
+    LinkedAt: time.Now().UTC(),
+  }
+
+  // only a verified address proves the identity owns the existing account
+  if identity.EmailVerified {
+    user, err := s.repo.GetUserByEmail(ctx, identity.Email)
+    if err == nil {
+      if !user.EmailVerified {
+        return user, true, s.claimUser(ctx, user, link)
+      }
+
+      linked, err := s.repo.LinkIdentity(ctx, user.ID, link)
+      if err != nil {
+        return nil, false, err
+      }
+
+      if !linked {
+        return nil, false, ErrIdentityConflict
+      }
+
+      return user, false, nil
+    }
+
+    if !errors.Is(err, mongo.ErrNoDocuments) {
+      return nil, false, err
+    }
+  }
+
+  inviteCode, domainRule, err := s.checkRegistration(ctx, identity.Email, inviteCode)
+  if err != nil {
+    return nil, false, err
+  }
+
+  // the domain only proves anything if the provider verified the address
+  if domainRule && !identity.EmailVerified {
+    return nil, false, ErrEmailNotVerified
+  }
+
+  user = &User{
+    Email:               identity.Email,
+    Role:                auth.RoleUser,
+    Identities:          []Identity{*link},
+    VerificationPending: s.config.RequireEmailVerification && !identity.EmailVerified,
+    EmailVerified:       identity.EmailVerified,
+  }
+
+  if err := s.createUser(ctx, user, "", inviteCode); err != nil {
+    return nil, false, err
+  }
+
+  return user, false, nil
+}
+
+// claimUser links the identity to an account whose address was never
+// verified. Anyone could have registered the address before its owner, the
+// password, the second factor, the sessions and the other credentials of the
+// account are dropped.
+func (s *Service) claimUser(ctx context.Context, user *User, link *Identity) error {
+  claimed, err := s.repo.ClaimWithIdentity(ctx, user.ID, link)
+  if err != nil {
+    return err
+  }
+
+  // verified or linked to the provider meanwhile
+  if !claimed {
+    return ErrIdentityConflict
+  }
+
+  log.Printf("sso: warning: %s claimed the unverified account %s\n", link.Provider, user.ID.Hex())
+
+  user.Identities = append(user.Identities, *link)
+  user.Password, user.EmailVerified, user.VerificationPending = "", true, false
+  user.TOTPSecret, user.TOTPPendingSecret, user.TOTPLastStep, user.RecoveryCodes = "", "", 0, nil
+
+  if err := s.tokens.RevokeUser(ctx, user.ID.Hex()); err != nil {
+    return err
+  }
+  for _, revoker := range s.revokers {
+    if err := revoker.RevokeUserCredentials(ctx, user.ID.Hex()); err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+// syncSSORole applies the role mapping of the provider, a changed role ends
+// the other sessions of the user.
+func (s *Service) syncSSORole(ctx context.Context, provider *SSOProvider, identity *oidc.Identity, user *User) error {
+  if provider.RoleClaim == "" {
+    return nil
+  }
+
+  role := provider.mapRole(identity)
+  if role == user.Role {
+    return nil
+  }
+
+  if err := s.repo.Update(ctx, user.ID, bson.M{"$set": bson.M{"role": role}}); err != nil {
+    return err
+  }
+
+  user.Role = role
+  return s.tokens.RevokeUser(ctx, user.ID.Hex())
+}
+
+// mapRole returns the most privileged role mapped from the values of the role
+// claim, which may be a string or a list of strings.
+func (p *SSOProvider) mapRole(identity *oidc.Identity) string {
+  var values []string
+  switch claim := identity.Claim(p.RoleClaim).(type) {
+  case string:
+    values = strings.Fields(claim)
+  case []any:
+    for _, v := range claim {
+      if str, ok := v.(string); ok {
+        values = append(values, str)
+      }
+    }
+  }
+
+  best := 0
+  for _, value := range values {
+    role, ok := p.RoleMap[value]
+    if !ok {
+      continue
+    }
+
+    for i, r := range rolePrecedence {
+      if r == role && i > best {
+        best = i
+      }
+    }
+  }
+
+  return rolePrecedence[best]
+}
+
+func (s *Service) ssoProvider(name string) *SSOProvider {
+  for _, p := range s.config.SSOProviders {
+    if p.Name() == name {
+      return p
+    }
+  }
+
+  return nil
+}
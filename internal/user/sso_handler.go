@@ -0,0 +1,183 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package user
+
+import (
+  "errors"
+  "log"
+  "net/http"
+
+  "github.com/gin-gonic/gin"
+
+  "github.com/CTFxd/ctfxd-server/internal/audit"
+  "github.com/CTFxd/ctfxd-server/internal/auth"
+)
+
+// ssoBindingCookie binds a pending SSO login to the browser that started it.
+const ssoBindingCookie = "ctfxd_sso"
+
+// swagger:model SSOCallbackRequest
+type SSOCallbackRequest struct {
+  // Authorization code the provider redirected with
+  // required: true
+  Code string `json:"code" binding:"required"`
+
+  // State the provider redirected with
+  // required: true
+  State string `json:"state" binding:"required"`
+}
+
+// swagger:operation GET /auth/providers users listSSOProviders
+// ---
+// tags: [users]
+// description: List the SSO providers users can log in with
+//
+// responses:
+//
+//  200:
+//    description: The SSO providers
+func (h *Handler) ListSSOProviders(c *gin.Context) {
+  c.JSON(http.StatusOK, h.service.SSOProviders())
+}
+
+// swagger:operation GET /auth/{provider}/login users startSSO
+// ---
+// tags: [users]
+// description: Start an SSO login, redirects to the provider. The provider redirects back to the
+//   frontend, which completes the login with POST /auth/{provider}/callback.
+// parameters:
+//   - name: provider
+//     in: path
+//     required: true
+//     type: string
+//   - name: invite_code
+//     in: query
+//     type: string
+//     description: Invite code, used if the login registers a new account
+//
+// responses:
+//
+//  302:
+//    description: Redirect to the provider, the login is bound to the browser by a cookie
+//  404:
+//    description: Unknown provider
+//  502:
+//    description: Provider unavailable
+func (h *Handler) StartSSO(c *gin.Context) {
+  authURL, binding, err := h.service.StartSSO(c.Request.Context(), c.Param("provider"), c.Query("invite_code"))
+  if err != nil {
+    switch {
+    case errors.Is(err, ErrUnknownProvider):
+      c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+    case errors.Is(err, ErrSSOFailed):
+      log.Printf("sso: error: %v\n", err)
+      c.JSON(http.StatusBadGateway, gin.H{"error": "SSO provider unavailable"})
+    default:
+      log.Printf("sso: error(internal): %v\n", err)
+      c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start SSO login"})
+    }
+    return
+  }
+
+  setSSOBindingCookie(c, binding, int(ssoStateTTL.Seconds()))
+  c.Header("Cache-Control", "no-store")
+  c.Redirect(http.StatusFound, authURL)
+}
+
+// swagger:operation POST /auth/{provider}/callback users finishSSO
+// ---
+// tags: [users]
+// description: Complete an SSO login, same response as POST /login
+// parameters:
+//   - name: provider
+//     in: path
+//     required: true
+//     type: string
+//   - name: body
+//     in: body
+//     required: true
+//     schema: {$ref: "#/definitions/SSOCallbackRequest"}
+//
+// responses:
+//
+//  200:
+//    description: Successfully authenticated, or a second factor is required
+//  400:
+//    description: Invalid request body
+//  401:
+//    description: Invalid or expired SSO login, or not started by this browser
+//  403:
+//    description: Registration not allowed, banned user or email address not verified
+//  404:
+//    description: Unknown provider
+//  409:
+//    description: Account already exists or is linked to another identity
+//  500:
+//    description: Internal server error
+func (h *Handler) FinishSSO(c *gin.Context) {
+  var req SSOCallbackRequest
+  if err := c.ShouldBindJSON(&req); err != nil {
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    return
+  }
+
+  // the cookie is single use like the state
+  binding, _ := c.Cookie(ssoBindingCookie)
+  setSSOBindingCookie(c, "", -1)
+
+  user, result, err := h.service.FinishSSO(c.Request.Context(), c.Param("provider"), req.Code, req.State, binding)
+  if err != nil {
+    switch {
+    case errors.Is(err, ErrUnknownProvider):
+      c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+    case errors.Is(err, ErrSSOFailed):
+      // the details of the provider error stay in the logs
+      log.Printf("sso: error: %v\n", err)
+      c.JSON(http.StatusUnauthorized, gin.H{"error": ErrSSOFailed.Error()})
+    case errors.Is(err, ErrInvalidSSOState), errors.Is(err, ErrSSOEmailRequired):
+      log.Printf("sso: error: %v\n", err)
+      c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+    case errors.Is(err, ErrRegistrationClosed), errors.Is(err, ErrEmailDomainNotAllowed),
+      errors.Is(err, ErrInviteRequired), errors.Is(err, ErrInvalidInvite),
+      errors.Is(err, ErrUserBanned), errors.Is(err, ErrEmailNotVerified):
+      c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+    case errors.Is(err, ErrUserExists), errors.Is(err, ErrIdentityConflict):
+      c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+    default:
+      log.Printf("sso: error(internal): %v\n", err)
+      c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to complete SSO login"})
+    }
+    return
+  }
+
+  if result.Claimed {
+    h.audit.Record(c, audit.Entry{
+      Action:       audit.ActionUserSSOClaim,
+      ResourceType: audit.ResourceUser,
+      ResourceID:   user.ID.Hex(),
+      Metadata:     map[string]any{"provider": c.Param("provider"), "email": user.Email},
+    })
+  }
+
+  writeLoginResult(c, user, result)
+}
+
+// setSSOBindingCookie sets the binding cookie. It is Lax whatever the session
+// cookies use: the provider redirects to the frontend with a top-level GET,
+// the callback comes from the frontend.
+func setSSOBindingCookie(c *gin.Context, binding string, maxAge int) {
+  http.SetCookie(c.Writer, &http.Cookie{
+    Name:     ssoBindingCookie,
+    Value:    binding,
+    Path:     auth.Cookies.Path,
+    Domain:   auth.Cookies.Domain,
+    MaxAge:   maxAge,
+    Secure:   auth.Cookies.Secure,
+    HttpOnly: true,
+    SameSite: http.SameSiteLaxMode,
+  })
+}
@@ -0,0 +1,256 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package user
+
+import (
+  "context"
+  "crypto/rand"
+  "crypto/rsa"
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/json"
+  "errors"
+  "math/big"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "sync"
+  "testing"
+  "time"
+
+  "github.com/gin-gonic/gin"
+  "github.com/golang-jwt/jwt/v5"
+  "go.mongodb.org/mongo-driver/v2/mongo"
+
+  "github.com/CTFxd/ctfxd-server/pkg/oidc"
+)
+
+const testClientID = "ctfxd-test"
+
+// mockIssuer is a minimal OIDC provider: discovery, JWKS and a token endpoint
+// trading the codes issued by authorize.
+type mockIssuer struct {
+  *httptest.Server
+  key *rsa.PrivateKey
+
+  mtx   sync.Mutex
+  codes map[string]url.Values
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+  key, err := rsa.GenerateKey(rand.Reader, 2048)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  issuer := &mockIssuer{key: key, codes: make(map[string]url.Values)}
+  mux := http.NewServeMux()
+  mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+    json.NewEncoder(w).Encode(map[string]string{
+      "issuer":                 issuer.URL,
+      "authorization_endpoint": issuer.URL + "/authorize",
+      "token_endpoint":         issuer.URL + "/token",
+      "jwks_uri":               issuer.URL + "/jwks",
+    })
+  })
+  mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+    json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+      "kty": "RSA",
+      "kid": "test",
+      "use": "sig",
+      "n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+      "e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+    }}})
+  })
+  mux.HandleFunc("/token", issuer.token)
+  issuer.Server = httptest.NewServer(mux)
+  t.Cleanup(issuer.Close)
+
+  return issuer
+}
+
+// authorize plays the user logging in at the provider, it returns the code
+// and state the provider redirects with.
+func (m *mockIssuer) authorize(t *testing.T, authURL string) (string, string) {
+  parsed, err := url.Parse(authURL)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  params := parsed.Query()
+  if params.Get("client_id") != testClientID {
+    t.Fatalf("authorize: client_id %q", params.Get("client_id"))
+  }
+
+  code, err := oidc.RandomString(16)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  m.mtx.Lock()
+  m.codes[code] = params
+  m.mtx.Unlock()
+
+  return code, params.Get("state")
+}
+
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+  m.mtx.Lock()
+  params, ok := m.codes[r.FormValue("code")]
+  delete(m.codes, r.FormValue("code"))
+  m.mtx.Unlock()
+
+  sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+  if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != params.Get("code_challenge") {
+    w.WriteHeader(http.StatusBadRequest)
+    json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+    return
+  }
+
+  now := time.Now()
+  idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+    "iss":            m.URL,
+    "aud":            testClientID,
+    "sub":            "user-1",
+    "email":          "user@example.com",
+    "email_verified": true,
+    "nonce":          params.Get("nonce"),
+    "iat":            now.Unix(),
+    "exp":            now.Add(time.Minute).Unix(),
+  })
+  idToken.Header["kid"] = "test"
+  signed, err := idToken.SignedString(m.key)
+  if err != nil {
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  json.NewEncoder(w).Encode(map[string]string{
+    "access_token": "access",
+    "token_type":   "Bearer",
+    "id_token":     signed,
+  })
+}
+
+type memSSOStateStore struct {
+  mtx    sync.Mutex
+  states map[string]SSOState
+}
+
+func (s *memSSOStateStore) CreateSSOState(ctx context.Context, state *SSOState) error {
+  s.mtx.Lock()
+  defer s.mtx.Unlock()
+
+  s.states[state.Hash] = *state
+  return nil
+}
+
+func (s *memSSOStateStore) ConsumeSSOState(ctx context.Context, hash string) (*SSOState, error) {
+  s.mtx.Lock()
+  defer s.mtx.Unlock()
+
+  state, ok := s.states[hash]
+  if !ok {
+    return nil, mongo.ErrNoDocuments
+  }
+  delete(s.states, hash)
+
+  return &state, nil
+}
+
+func newTestSSOService(t *testing.T, issuer *mockIssuer) (*Service, *SSOProvider) {
+  provider, err := oidc.NewProvider(oidc.Config{
+    Name:        "mock",
+    ClientID:    testClientID,
+    RedirectURL: "https://ctf.example.com/auth/mock/callback",
+    Issuer:      issuer.URL,
+  }, issuer.Client())
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  ssoProvider := &SSOProvider{Provider: provider}
+  service := &Service{
+    ssoStates: &memSSOStateStore{states: make(map[string]SSOState)},
+    config:    Config{SSOProviders: []*SSOProvider{ssoProvider}},
+  }
+
+  return service, ssoProvider
+}
+
+// startSSO goes through the handler and returns the redirect and the binding
+// cookie.
+func startSSO(t *testing.T, service *Service) (string, *http.Cookie) {
+  gin.SetMode(gin.TestMode)
+  w := httptest.NewRecorder()
+  c, _ := gin.CreateTestContext(w)
+  c.Request = httptest.NewRequest(http.MethodGet, "/auth/mock/login", nil)
+  c.Params = gin.Params{{Key: "provider", Value: "mock"}}
+
+  NewHandler(service, nil).StartSSO(c)
+  if w.Code != http.StatusFound {
+    t.Fatalf("StartSSO: status %d: %s", w.Code, w.Body)
+  }
+
+  for _, cookie := range w.Result().Cookies() {
+    if cookie.Name == ssoBindingCookie {
+      return w.Header().Get("Location"), cookie
+    }
+  }
+
+  t.Fatal("StartSSO: no binding cookie")
+  return "", nil
+}
+
+func TestSSOBindingCookie(t *testing.T) {
+  issuer := newMockIssuer(t)
+  service, _ := newTestSSOService(t, issuer)
+
+  authURL, cookie := startSSO(t, service)
+  _, state := issuer.authorize(t, authURL)
+
+  if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+    t.Errorf("cookie: HttpOnly %v, SameSite %v", cookie.HttpOnly, cookie.SameSite)
+  }
+  if cookie.Value == "" || cookie.Value == state || cookie.Value != ssoBinding(state) {
+    t.Errorf("cookie: value %q is not the hash of the state", cookie.Value)
+  }
+}
+
+func TestSSOFlowBoundToBrowser(t *testing.T) {
+  issuer := newMockIssuer(t)
+  service, provider := newTestSSOService(t, issuer)
+  ctx := context.Background()
+
+  authURL, cookie := startSSO(t, service)
+  code, state := issuer.authorize(t, authURL)
+
+  // the callback of another browser: no cookie, or the cookie of its own
+  // flow
+  _, otherCookie := startSSO(t, service)
+  for _, binding := range []string{"", otherCookie.Value} {
+    _, _, err := service.ssoIdentity(ctx, provider, code, state, binding)
+    if !errors.Is(err, ErrInvalidSSOState) {
+      t.Fatalf("binding %q: got %v, want %v", binding, err, ErrInvalidSSOState)
+    }
+  }
+
+  // the forged callbacks did not consume the state
+  identity, _, err := service.ssoIdentity(ctx, provider, code, state, cookie.Value)
+  if err != nil {
+    t.Fatalf("ssoIdentity: %v", err)
+  }
+  if identity.Subject != "user-1" || identity.Email != "user@example.com" || !identity.EmailVerified {
+    t.Errorf("identity: %+v", identity)
+  }
+
+  // the state is single use
+  _, _, err = service.ssoIdentity(ctx, provider, code, state, cookie.Value)
+  if !errors.Is(err, ErrInvalidSSOState) {
+    t.Fatalf("replay: got %v, want %v", err, ErrInvalidSSOState)
+  }
+}
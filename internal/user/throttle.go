@@ -0,0 +1,234 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package user
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "strings"
+  "time"
+)
+
+var ErrTooManyAttempts = errors.New("too many failed login attempts")
+
+// ThrottleError is returned while an account or an IP is backed off or locked.
+type ThrottleError struct {
+  RetryAfter time.Duration
+}
+
+func (e *ThrottleError) Error() string {
+  return fmt.Sprintf("%v, retry in %v", ErrTooManyAttempts, e.RetryAfter.Round(time.Second))
+}
+
+func (e *ThrottleError) Unwrap() error {
+  return ErrTooManyAttempts
+}
+
+type Clock interface {
+  Now() time.Time
+}
+
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+  return time.Now()
+}
+
+// LoginAttempts is the failure counter of one key (an account or an IP).
+type LoginAttempts struct {
+  Key         string    `bson:"_id" json:"key"`
+  Failures    int       `bson:"failures" json:"failures"`
+  LastFailure time.Time `bson:"last_failure" json:"last_failure"`
+  LockedUntil time.Time `bson:"locked_until" json:"locked_until"`
+}
+
+// LoginFailure describes a failure to count.
+type LoginFailure struct {
+  Time time.Time
+  // the count restarts if the last failure is older than this
+  ResetBefore time.Time
+  // the key is locked until LockUntil once the count reaches LockAt
+  LockAt    int
+  LockUntil time.Time
+}
+
+// AttemptStore persists the failure counters. Get returns nil, nil for an
+// unknown key. RecordLoginFailure must update the counter atomically and
+// return it as updated.
+type AttemptStore interface {
+  GetLoginAttempts(ctx context.Context, key string) (*LoginAttempts, error)
+  RecordLoginFailure(ctx context.Context, key string, failure LoginFailure) (*LoginAttempts, error)
+  DeleteLoginAttempts(ctx context.Context, key string) error
+}
+
+type ThrottlePolicy struct {
+  // failures allowed before any backoff
+  FreeAttempts int
+  // backoff after the first extra failure, doubled on every other one
+  BaseDelay time.Duration
+  MaxDelay  time.Duration
+  // failures after which the key is locked for LockDuration
+  LockThreshold int
+  LockDuration  time.Duration
+  // counters are forgotten after this long without a failure
+  ResetAfter time.Duration
+  // multiplier of FreeAttempts and LockThreshold for the IP keys, many
+  // users may share an IP
+  IPFactor int
+}
+
+var DefaultThrottlePolicy = ThrottlePolicy{
+  FreeAttempts:  5,
+  BaseDelay:     time.Second,
+  MaxDelay:      5 * time.Minute,
+  LockThreshold: 15,
+  LockDuration:  30 * time.Minute,
+  ResetAfter:    time.Hour,
+  IPFactor:      4,
+}
+
+// LoginThrottle tracks the failed logins per account and per IP with an
+// exponential backoff and a temporary lockout.
+type LoginThrottle struct {
+  store  AttemptStore
+  policy ThrottlePolicy
+  clock  Clock
+}
+
+func NewLoginThrottle(store AttemptStore, policy ThrottlePolicy, clock Clock) *LoginThrottle {
+  throttle := new(LoginThrottle)
+  throttle.store = store
+  throttle.policy = policy
+  throttle.clock = clock
+
+  return throttle
+}
+
+func accountKey(email string) string {
+  return "account:" + email
+}
+
+func ipKey(ip string) string {
+  return "ip:" + ip
+}
+
+// Check returns a *ThrottleError if the account or the IP may not attempt a
+// login yet.
+func (t *LoginThrottle) Check(ctx context.Context, email, ip string) error {
+  var wait time.Duration
+
+  for _, key := range []string{accountKey(email), ipKey(ip)} {
+    attempts, err := t.store.GetLoginAttempts(ctx, key)
+    if err != nil {
+      return err
+    }
+
+    if d := t.retryAfter(key, attempts); d > wait {
+      wait = d
+    }
+  }
+
+  if wait > 0 {
+    return &ThrottleError{RetryAfter: wait}
+  }
+
+  return nil
+}
+
+// Fail records a failed login for the account and the IP.
+func (t *LoginThrottle) Fail(ctx context.Context, email, ip string) error {
+  now := t.clock.Now()
+
+  for _, key := range []string{accountKey(email), ipKey(ip)} {
+    _, err := t.store.RecordLoginFailure(ctx, key, LoginFailure{
+      Time:        now,
+      ResetBefore: now.Add(-t.policy.ResetAfter),
+      LockAt:      t.scaled(key, t.policy.LockThreshold),
+      LockUntil:   now.Add(t.policy.LockDuration),
+    })
+    if err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+// Succeed clears the counter of the account. The IP counter is kept, or one
+// valid account would be enough to reset it.
+func (t *LoginThrottle) Succeed(ctx context.Context, email string) error {
+  return t.store.DeleteLoginAttempts(ctx, accountKey(email))
+}
+
+// Unlock clears the counter of the account (admin action).
+func (t *LoginThrottle) Unlock(ctx context.Context, email string) error {
+  return t.store.DeleteLoginAttempts(ctx, accountKey(email))
+}
+
+// Cooldown allows the action named by key once per period, it returns a
+// *ThrottleError until a whole period has passed without any attempt.
+func (t *LoginThrottle) Cooldown(ctx context.Context, key string, period time.Duration) error {
+  return t.Limit(ctx, key, 1, period)
+}
+
+// Limit allows the action named by key n times, then returns a
+// *ThrottleError until a whole period has passed without any attempt.
+func (t *LoginThrottle) Limit(ctx context.Context, key string, n int, period time.Duration) error {
+  now := t.clock.Now()
+
+  attempts, err := t.store.RecordLoginFailure(ctx, key, LoginFailure{Time: now, ResetBefore: now.Add(-period)})
+  if err != nil {
+    return err
+  }
+
+  if attempts.Failures > n {
+    return &ThrottleError{RetryAfter: period}
+  }
+
+  return nil
+}
+
+func (t *LoginThrottle) retryAfter(key string, attempts *LoginAttempts) time.Duration {
+  if attempts == nil {
+    return 0
+  }
+
+  now := t.clock.Now()
+  if now.Before(attempts.LockedUntil) {
+    return attempts.LockedUntil.Sub(now)
+  }
+
+  if now.Sub(attempts.LastFailure) > t.policy.ResetAfter {
+    return 0
+  }
+
+  extra := attempts.Failures - t.scaled(key, t.policy.FreeAttempts)
+  if extra <= 0 {
+    return 0
+  }
+
+  delay := t.policy.MaxDelay
+  if extra <= 30 {
+    delay = min(t.policy.BaseDelay<<(extra-1), t.policy.MaxDelay)
+  }
+
+  next := attempts.LastFailure.Add(delay)
+  if now.Before(next) {
+    return next.Sub(now)
+  }
+
+  return 0
+}
+
+func (t *LoginThrottle) scaled(key string, n int) int {
+  if strings.HasPrefix(key, "ip:") && t.policy.IPFactor > 1 {
+    return n * t.policy.IPFactor
+  }
+
+  return n
+}
@@ -0,0 +1,237 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package user
+
+import (
+  "context"
+  "errors"
+  "sync"
+  "testing"
+  "time"
+)
+
+type fakeClock struct {
+  now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+  return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+  c.now = c.now.Add(d)
+}
+
+// memAttemptStore applies the failures the way the MongoDB update does.
+type memAttemptStore struct {
+  mtx      sync.Mutex
+  attempts map[string]LoginAttempts
+}
+
+func newMemAttemptStore() *memAttemptStore {
+  return &memAttemptStore{attempts: make(map[string]LoginAttempts)}
+}
+
+func (s *memAttemptStore) GetLoginAttempts(ctx context.Context, key string) (*LoginAttempts, error) {
+  s.mtx.Lock()
+  defer s.mtx.Unlock()
+
+  attempts, ok := s.attempts[key]
+  if !ok {
+    return nil, nil
+  }
+
+  return &attempts, nil
+}
+
+func (s *memAttemptStore) RecordLoginFailure(ctx context.Context, key string, failure LoginFailure) (*LoginAttempts, error) {
+  s.mtx.Lock()
+  defer s.mtx.Unlock()
+
+  attempts, ok := s.attempts[key]
+  if !ok || attempts.LastFailure.Before(failure.ResetBefore) {
+    attempts = LoginAttempts{Key: key, LockedUntil: attempts.LockedUntil}
+  }
+
+  attempts.Failures++
+  attempts.LastFailure = failure.Time
+  if failure.LockAt > 0 && attempts.Failures >= failure.LockAt {
+    attempts.LockedUntil = failure.LockUntil
+  }
+  s.attempts[key] = attempts
+
+  return &attempts, nil
+}
+
+func (s *memAttemptStore) DeleteLoginAttempts(ctx context.Context, key string) error {
+  s.mtx.Lock()
+  defer s.mtx.Unlock()
+
+  delete(s.attempts, key)
+  return nil
+}
+
+var testThrottlePolicy = ThrottlePolicy{
+  FreeAttempts:  2,
+  BaseDelay:     time.Second,
+  MaxDelay:      3 * time.Second,
+  LockThreshold: 6,
+  LockDuration:  time.Minute,
+  ResetAfter:    time.Hour,
+  IPFactor:      4,
+}
+
+func newTestThrottle() (*LoginThrottle, *fakeClock) {
+  clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+  return NewLoginThrottle(newMemAttemptStore(), testThrottlePolicy, clock), clock
+}
+
+func retryAfter(t *testing.T, throttle *LoginThrottle) time.Duration {
+  t.Helper()
+
+  err := throttle.Check(context.Background(), "user@example.com", "192.0.2.1")
+  if err == nil {
+    return 0
+  }
+
+  var throttleErr *ThrottleError
+  if !errors.As(err, &throttleErr) {
+    t.Fatalf("Check: unexpected error %v", err)
+  }
+
+  return throttleErr.RetryAfter
+}
+
+func fail(t *testing.T, throttle *LoginThrottle, n int) {
+  t.Helper()
+
+  for i := 0; i < n; i++ {
+    if err := throttle.Fail(context.Background(), "user@example.com", "192.0.2.1"); err != nil {
+      t.Fatalf("Fail: %v", err)
+    }
+  }
+}
+
+func TestLoginThrottleBackoff(t *testing.T) {
+  throttle, clock := newTestThrottle()
+
+  fail(t, throttle, 2)
+  if d := retryAfter(t, throttle); d != 0 {
+    t.Fatalf("free attempts: retry after %v, want 0", d)
+  }
+
+  // the delay doubles with every extra failure, up to MaxDelay
+  for i, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+    fail(t, throttle, 1)
+    if d := retryAfter(t, throttle); d != want {
+      t.Fatalf("extra failure %d: retry after %v, want %v", i+1, d, want)
+    }
+  }
+
+  clock.Advance(3 * time.Second)
+  if d := retryAfter(t, throttle); d != 0 {
+    t.Fatalf("after the delay: retry after %v, want 0", d)
+  }
+}
+
+func TestLoginThrottleLockout(t *testing.T) {
+  throttle, clock := newTestThrottle()
+
+  fail(t, throttle, testThrottlePolicy.LockThreshold)
+  if d := retryAfter(t, throttle); d != time.Minute {
+    t.Fatalf("locked: retry after %v, want %v", d, time.Minute)
+  }
+
+  clock.Advance(30 * time.Second)
+  if d := retryAfter(t, throttle); d != 30*time.Second {
+    t.Fatalf("still locked: retry after %v, want 30s", d)
+  }
+
+  if err := throttle.Unlock(context.Background(), "user@example.com"); err != nil {
+    t.Fatalf("Unlock: %v", err)
+  }
+  // the IP counter is scaled by IPFactor, it is only backed off
+  if d := retryAfter(t, throttle); d != 0 {
+    t.Fatalf("unlocked: retry after %v, want 0", d)
+  }
+}
+
+func TestLoginThrottleReset(t *testing.T) {
+  throttle, clock := newTestThrottle()
+
+  fail(t, throttle, testThrottlePolicy.LockThreshold-1)
+  clock.Advance(testThrottlePolicy.ResetAfter + time.Second)
+  if d := retryAfter(t, throttle); d != 0 {
+    t.Fatalf("after ResetAfter: retry after %v, want 0", d)
+  }
+
+  // the count restarted, one more failure is within the free attempts
+  fail(t, throttle, 1)
+  if d := retryAfter(t, throttle); d != 0 {
+    t.Fatalf("after the reset: retry after %v, want 0", d)
+  }
+}
+
+func TestLoginThrottleConcurrentFailures(t *testing.T) {
+  throttle, _ := newTestThrottle()
+
+  var wg sync.WaitGroup
+  for i := 0; i < testThrottlePolicy.LockThreshold; i++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      throttle.Fail(context.Background(), "user@example.com", "192.0.2.1")
+    }()
+  }
+  wg.Wait()
+
+  if d := retryAfter(t, throttle); d != time.Minute {
+    t.Fatalf("locked: retry after %v, want %v", d, time.Minute)
+  }
+}
+
+func TestLoginThrottleCooldown(t *testing.T) {
+  throttle, clock := newTestThrottle()
+  ctx := context.Background()
+
+  if err := throttle.Cooldown(ctx, "resend:user@example.com", time.Minute); err != nil {
+    t.Fatalf("first: %v", err)
+  }
+
+  var throttleErr *ThrottleError
+  err := throttle.Cooldown(ctx, "resend:user@example.com", time.Minute)
+  if !errors.As(err, &throttleErr) {
+    t.Fatalf("second: got %v, want a *ThrottleError", err)
+  }
+
+  clock.Advance(time.Minute + time.Second)
+  if err := throttle.Cooldown(ctx, "resend:user@example.com", time.Minute); err != nil {
+    t.Fatalf("after the cooldown: %v", err)
+  }
+}
+
+func TestLoginThrottleLimit(t *testing.T) {
+  throttle, clock := newTestThrottle()
+  ctx := context.Background()
+
+  for i := 0; i < 3; i++ {
+    if err := throttle.Limit(ctx, "reset-ip:192.0.2.1", 3, time.Hour); err != nil {
+      t.Fatalf("attempt %d: %v", i+1, err)
+    }
+  }
+
+  var throttleErr *ThrottleError
+  err := throttle.Limit(ctx, "reset-ip:192.0.2.1", 3, time.Hour)
+  if !errors.As(err, &throttleErr) {
+    t.Fatalf("over the limit: got %v, want a *ThrottleError", err)
+  }
+
+  clock.Advance(time.Hour + time.Second)
+  if err := throttle.Limit(ctx, "reset-ip:192.0.2.1", 3, time.Hour); err != nil {
+    t.Fatalf("after the period: %v", err)
+  }
+}
@@ -0,0 +1,331 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package user
+
+import (
+  "context"
+  "crypto/rand"
+  "encoding/base32"
+  "errors"
+  "log"
+  "strings"
+  "time"
+
+  "go.mongodb.org/mongo-driver/v2/bson"
+  "golang.org/x/crypto/bcrypt"
+
+  "github.com/CTFxd/ctfxd-server/internal/auth"
+  "github.com/CTFxd/ctfxd-server/pkg/totp"
+)
+
+const (
+  mfaLoginPurpose    = "mfa-login"
+  mfaLoginTTL        = 5 * time.Minute
+  recoveryCodeCount  = 10
+  recoveryCodeLength = 10
+)
+
+var (
+  ErrInvalidMFAToken    = errors.New("invalid or expired two-factor login")
+  ErrInvalidMFACode     = errors.New("invalid two-factor code")
+  ErrMFAAlreadyEnabled  = errors.New("two-factor authentication already enabled")
+  ErrMFANotEnabled      = errors.New("two-factor authentication not enabled")
+  ErrMFANotEnrolling    = errors.New("two-factor enrollment not started")
+  ErrMFACodeRequired    = errors.New("two-factor code or recovery code required")
+  ErrWrongPasswordOrMFA = errors.New("invalid password or two-factor code")
+)
+
+type LoginResult struct {
+  Tokens *auth.TokenPair
+
+  // set instead of Tokens when the login needs a second factor
+  MFAToken string
+
+  // the account must enroll to two-factor authentication
+  MFAEnrollmentRequired bool
+
+  // the SSO login claimed an account whose address was never verified, its
+  // credentials were dropped
+  Claimed bool
+}
+
+type TOTPEnrollment struct {
+  Secret string `json:"secret"`
+  URI    string `json:"uri"`
+}
+
+// StartSession is the second half of the login, after the password check.
+// Users with two-factor authentication get a short-lived MFA token to pass
+// to LoginMFA instead of the session tokens.
+func (s *Service) StartSession(ctx context.Context, user *User) (*LoginResult, error) {
+  if user.TOTPSecret != "" {
+    token := auth.SignPayload(mfaLoginPurpose, user.ID.Hex(), time.Now().Add(mfaLoginTTL))
+    return &LoginResult{MFAToken: token}, nil
+  }
+
+  tokens, err := s.IssueTokens(ctx, user, false)
+  if err != nil {
+    return nil, err
+  }
+
+  return &LoginResult{
+    Tokens:                tokens,
+    MFAEnrollmentRequired: s.config.RequireAdminMFA && user.Role == auth.RoleAdmin,
+  }, nil
+}
+
+// LoginMFA completes a two-step login with a TOTP code or a recovery code.
+// Invalid codes count as failed logins.
+func (s *Service) LoginMFA(ctx context.Context, mfaToken, code, recoveryCode, clientIP string) (*User, *auth.TokenPair, error) {
+  userID, err := auth.VerifyPayload(mfaLoginPurpose, mfaToken)
+  if err != nil {
+    return nil, nil, ErrInvalidMFAToken
+  }
+
+  user, err := s.repo.GetUserByID(ctx, userID)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  if user.TOTPSecret == "" {
+    return nil, nil, ErrInvalidMFAToken
+  }
+
+  if err := s.throttle.Check(ctx, user.Email, clientIP); err != nil {
+    return nil, nil, err
+  }
+
+  if err := s.checkSecondFactor(ctx, user, code, recoveryCode); err != nil {
+    if errors.Is(err, ErrInvalidMFACode) {
+      if err := s.throttle.Fail(ctx, user.Email, clientIP); err != nil {
+        log.Printf("user: error: login throttle(%v)\n", err)
+      }
+    }
+
+    return nil, nil, err
+  }
+
+  if user.Ban.Active(time.Now()) {
+    return nil, nil, ErrUserBanned
+  }
+
+  tokens, err := s.IssueTokens(ctx, user, true)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  return user, tokens, nil
+}
+
+// EnrollTOTP starts the enrollment with a new secret. It only becomes active
+// once a code generated from it is confirmed with ConfirmTOTP.
+func (s *Service) EnrollTOTP(ctx context.Context, userID string) (*TOTPEnrollment, error) {
+  user, err := s.repo.GetUserByID(ctx, userID)
+  if err != nil {
+    return nil, err
+  }
+
+  if user.TOTPSecret != "" {
+    return nil, ErrMFAAlreadyEnabled
+  }
+
+  secret, err := totp.GenerateSecret()
+  if err != nil {
+    return nil, err
+  }
+
+  update := bson.M{"$set": bson.M{"totp_pending_secret": secret}}
+  if err := s.repo.Update(ctx, user.ID, update); err != nil {
+    return nil, err
+  }
+
+  return &TOTPEnrollment{
+    Secret: secret,
+    URI:    totp.ProvisioningURI(s.config.TOTPIssuer, user.Email, secret),
+  }, nil
+}
+
+// ConfirmTOTP enables two-factor authentication and returns the recovery
+// codes, they are only shown once.
+func (s *Service) ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error) {
+  user, err := s.repo.GetUserByID(ctx, userID)
+  if err != nil {
+    return nil, err
+  }
+
+  if user.TOTPSecret != "" {
+    return nil, ErrMFAAlreadyEnabled
+  }
+
+  if user.TOTPPendingSecret == "" {
+    return nil, ErrMFANotEnrolling
+  }
+
+  step, ok := totp.Validate(user.TOTPPendingSecret, code, time.Now())
+  if !ok {
+    return nil, ErrInvalidMFACode
+  }
+
+  codes, hashes, err := generateRecoveryCodes()
+  if err != nil {
+    return nil, err
+  }
+
+  update := bson.M{
+    "$set": bson.M{
+      "totp_secret":    user.TOTPPendingSecret,
+      "totp_last_step": step,
+      "recovery_codes": hashes,
+    },
+    "$unset": bson.M{"totp_pending_secret": ""},
+  }
+
+  if err := s.repo.Update(ctx, user.ID, update); err != nil {
+    return nil, err
+  }
+
+  return codes, nil
+}
+
+// DisableTOTP turns two-factor authentication off, it needs both the password
+// and a second factor. A wrong password or code counts as a failed login.
+func (s *Service) DisableTOTP(ctx context.Context, userID, password, code, recoveryCode, clientIP string) error {
+  user, err := s.repo.GetUserByID(ctx, userID)
+  if err != nil {
+    return err
+  }
+
+  if user.TOTPSecret == "" {
+    return ErrMFANotEnabled
+  }
+
+  if err := s.throttle.Check(ctx, user.Email, clientIP); err != nil {
+    return err
+  }
+
+  if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+    if err := s.throttle.Fail(ctx, user.Email, clientIP); err != nil {
+      log.Printf("user: error: login throttle(%v)\n", err)
+    }
+
+    return ErrWrongPasswordOrMFA
+  }
+
+  if err := s.checkSecondFactor(ctx, user, code, recoveryCode); err != nil {
+    if errors.Is(err, ErrInvalidMFACode) {
+      if err := s.throttle.Fail(ctx, user.Email, clientIP); err != nil {
+        log.Printf("user: error: login throttle(%v)\n", err)
+      }
+    }
+
+    return ErrWrongPasswordOrMFA
+  }
+
+  update := bson.M{"$unset": bson.M{
+    "totp_secret":         "",
+    "totp_pending_secret": "",
+    "totp_last_step":      "",
+    "recovery_codes":      "",
+  }}
+
+  return s.repo.Update(ctx, user.ID, update)
+}
+
+// RegenerateRecoveryCodes replaces every recovery code of the user. An
+// invalid code counts as a failed login.
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, userID, code, clientIP string) ([]string, error) {
+  user, err := s.repo.GetUserByID(ctx, userID)
+  if err != nil {
+    return nil, err
+  }
+
+  if user.TOTPSecret == "" {
+    return nil, ErrMFANotEnabled
+  }
+
+  if err := s.throttle.Check(ctx, user.Email, clientIP); err != nil {
+    return nil, err
+  }
+
+  if err := s.checkSecondFactor(ctx, user, code, ""); err != nil {
+    if errors.Is(err, ErrInvalidMFACode) {
+      if err := s.throttle.Fail(ctx, user.Email, clientIP); err != nil {
+        log.Printf("user: error: login throttle(%v)\n", err)
+      }
+    }
+
+    return nil, err
+  }
+
+  codes, hashes, err := generateRecoveryCodes()
+  if err != nil {
+    return nil, err
+  }
+
+  update := bson.M{"$set": bson.M{"recovery_codes": hashes}}
+  if err := s.repo.Update(ctx, user.ID, update); err != nil {
+    return nil, err
+  }
+
+  return codes, nil
+}
+
+func (s *Service) checkSecondFactor(ctx context.Context, user *User, code, recoveryCode string) error {
+  if code != "" {
+    step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+    if !ok {
+      return ErrInvalidMFACode
+    }
+
+    // every code is accepted only once
+    fresh, err := s.repo.SetTOTPLastStep(ctx, user.ID, step)
+    if err != nil {
+      return err
+    }
+    if !fresh {
+      return ErrInvalidMFACode
+    }
+
+    return nil
+  }
+
+  if recoveryCode != "" {
+    used, err := s.repo.ConsumeRecoveryCode(ctx, user.ID, auth.HashToken(normalizeRecoveryCode(recoveryCode)))
+    if err != nil {
+      return err
+    }
+    if !used {
+      return ErrInvalidMFACode
+    }
+
+    return nil
+  }
+
+  return ErrMFACodeRequired
+}
+
+func generateRecoveryCodes() ([]string, []string, error) {
+  encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+  var codes, hashes []string
+  for range recoveryCodeCount {
+    buf := make([]byte, 8)
+    if _, err := rand.Read(buf); err != nil {
+      return nil, nil, err
+    }
+
+    raw := strings.ToLower(encoding.EncodeToString(buf))[:recoveryCodeLength]
+    codes = append(codes, raw[:5]+"-"+raw[5:])
+    hashes = append(hashes, auth.HashToken(raw))
+  }
+
+  return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+  return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
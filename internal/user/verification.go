@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package user
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "net/url"
+  "strings"
+  "time"
+
+  "go.mongodb.org/mongo-driver/v2/bson"
+  "go.mongodb.org/mongo-driver/v2/mongo"
+
+  "github.com/CTFxd/ctfxd-server/internal/auth"
+  "github.com/CTFxd/ctfxd-server/pkg/mail"
+)
+
+const (
+  verifyEmailPurpose = "verify-email"
+  // minimum delay between two verification mails sent to an address
+  verificationResendCooldown = time.Minute
+)
+
+var (
+  ErrInvalidVerification = errors.New("invalid or expired verification link")
+  ErrAlreadyVerified     = errors.New("email address already verified")
+)
+
+// VerifyEmail validates the token of a verification link. The token is bound
+// to the email address, so it stops working if the address changes.
+func (s *Service) VerifyEmail(ctx context.Context, token string) error {
+  payload, err := auth.VerifyPayload(verifyEmailPurpose, token)
+  if err != nil {
+    return ErrInvalidVerification
+  }
+
+  userID, email, ok := strings.Cut(payload, ":")
+  if !ok {
+    return ErrInvalidVerification
+  }
+
+  user, err := s.repo.GetUserByID(ctx, userID)
+  if err != nil {
+    if errors.Is(err, mongo.ErrNoDocuments) || errors.Is(err, bson.ErrInvalidHex) {
+      return ErrInvalidVerification
+    }
+
+    return err
+  }
+
+  if user.Email != email {
+    return ErrInvalidVerification
+  }
+
+  return s.markVerified(ctx, user)
+}
+
+// ResendVerification sends a new verification link, at most once per
+// verificationResendCooldown for an address. Unknown and already verified
+// addresses are silently ignored, and throttled alike, so the endpoint does
+// not reveal which addresses are registered.
+func (s *Service) ResendVerification(ctx context.Context, email string) error {
+  key := "resend:" + strings.ToLower(strings.TrimSpace(email))
+  if err := s.throttle.Cooldown(ctx, key, verificationResendCooldown); err != nil {
+    return err
+  }
+
+  user, err := s.repo.GetUserByEmail(ctx, email)
+  if err != nil {
+    if errors.Is(err, mongo.ErrNoDocuments) {
+      return nil
+    }
+
+    return err
+  }
+
+  if user.EmailVerified {
+    return nil
+  }
+
+  return s.sendVerificationEmail(ctx, user)
+}
+
+// ForceVerify is the admin override, it marks the address as verified.
+func (s *Service) ForceVerify(ctx context.Context, userID string) error {
+  user, err := s.repo.GetUserByID(ctx, userID)
+  if err != nil {
+    return err
+  }
+
+  if user.EmailVerified {
+    return ErrAlreadyVerified
+  }
+
+  return s.markVerified(ctx, user)
+}
+
+func (s *Service) markVerified(ctx context.Context, user *User) error {
+  update := bson.M{
+    "$set":   bson.M{"email_verified": true},
+    "$unset": bson.M{"verification_pending": ""},
+  }
+  if err := s.repo.Update(ctx, user.ID, update); err != nil {
+    return err
+  }
+
+  user.VerificationPending, user.EmailVerified = false, true
+  return nil
+}
+
+func (s *Service) sendVerificationEmail(ctx context.Context, user *User) error {
+  exp := time.Now().Add(s.config.VerificationTTL)
+  token := auth.SignPayload(verifyEmailPurpose, user.ID.Hex()+":"+user.Email, exp)
+
+  link := s.config.VerifyEmailURL + "?token=" + url.QueryEscape(token)
+  body := fmt.Sprintf("Welcome to CTFxd!\n\nPlease verify your email address by opening the link below:\n\n%s\n\nThe link expires at %s.\n",
+    link, exp.UTC().Format(time.RFC1123))
+
+  return s.mailer.Send(ctx, &mail.Message{
+    To:      user.Email,
+    Subject: "Verify your email address",
+    Body:    body,
+  })
+}
@@ -8,32 +8,66 @@ package main
 
 import (
   "context"
+  "crypto/hmac"
+  "crypto/sha256"
   "errors"
   "fmt"
   "log"
+  "math"
   "net/http"
   "os"
   "os/signal"
   "regexp"
   "strconv"
+  "strings"
   "sync"
+  "syscall"
   "time"
 
   "github.com/CTFxd/ctfxd-server/api/handler"
+  "github.com/CTFxd/ctfxd-server/internal/apitoken"
+  "github.com/CTFxd/ctfxd-server/internal/audit"
   "github.com/CTFxd/ctfxd-server/internal/auth"
   "github.com/CTFxd/ctfxd-server/internal/challenge"
   "github.com/CTFxd/ctfxd-server/internal/scoreboard"
   "github.com/CTFxd/ctfxd-server/internal/submission"
   "github.com/CTFxd/ctfxd-server/internal/user"
   "github.com/CTFxd/ctfxd-server/pkg/db"
+  "github.com/CTFxd/ctfxd-server/pkg/mail"
+  "github.com/CTFxd/ctfxd-server/pkg/oidc"
+  "github.com/CTFxd/ctfxd-server/pkg/scanner"
+  "github.com/CTFxd/ctfxd-server/pkg/storage"
+  "github.com/CTFxd/ctfxd-server/pkg/urlsign"
   "github.com/gin-gonic/gin"
   "github.com/joho/godotenv"
+  "go.mongodb.org/mongo-driver/v2/mongo"
 )
 
 const (
   DEFAULT_DB_NAME        string = "ctdxd"
   DEFAULT_SERV_PORT             = "8080"
   DEFAULT_ROUTINE_PERIOD        = "30s"
+  DEFAULT_ACCESS_TOKEN_TTL      = "15m"
+  DEFAULT_REFRESH_TOKEN_TTL     = "168h"
+  DEFAULT_MAIL_BACKEND          = "log"
+  DEFAULT_MAIL_DIR              = "mails"
+  DEFAULT_SMTP_PORT             = "587"
+  DEFAULT_PUBLIC_URL            = "http://localhost:8080"
+  DEFAULT_VERIFICATION_TTL      = "48h"
+  DEFAULT_PASSWORD_RESET_TTL    = "1h"
+  DEFAULT_REGISTRATION_MODE     = "open"
+  DEFAULT_COOKIE_SAMESITE       = "lax"
+  DEFAULT_STORAGE_BACKEND       = "local"
+  DEFAULT_UPLOAD_DIR            = "uploads"
+  DEFAULT_MAX_FILE_SIZE         = "4GB"
+  DEFAULT_MAX_CHALLENGE_SIZE    = "16GB"
+  DEFAULT_FILE_URL_TTL          = "1h"
+  DEFAULT_ORPHAN_GRACE_PERIOD   = "1h"
+  DEFAULT_QUARANTINE_PERIOD     = "24h"
+  DEFAULT_SCANNER               = "none"
+  DEFAULT_CLAMD_ADDRESS         = "tcp://localhost:3310"
+  DEFAULT_SCAN_POLICY           = "block"
+  DEFAULT_SCAN_OVERSIZE_POLICY  = "flag"
 )
 
 type ServerConfig struct {
@@ -46,6 +80,38 @@ type ServerConfig struct {
   port           string
   routinePeriod  time.Duration
   trustedProxies []string
+  accessTTL      time.Duration
+  refreshTTL     time.Duration
+  jwtKeysDir     string
+  jwtActiveKID   string
+  mailBackend    string
+  mailFrom       string
+  mailDir        string
+  smtpHost       string
+  smtpPort       string
+  smtpUsername   string
+  smtpPassword   string
+  publicUrl      string
+  verifyEmail    bool
+  verifyEmailTTL time.Duration
+  resetTTL       time.Duration
+  adminMFA       bool
+  registration   *user.RegistrationSettings
+  ssoProviders   []*user.SSOProvider
+  cookies        auth.CookieConfig
+  storageBackend string
+  uploadDir      string
+  s3             storage.S3Config
+  maxFileSize    int64
+  maxChallSize   int64
+  fileURLKey     []byte
+  fileURLTTL     time.Duration
+  orphanGrace    time.Duration
+  quarantine     time.Duration
+  scanner        string
+  clamdAddress   string
+  scanPolicy     string
+  oversizePolicy string
 }
 
 func main() {
@@ -54,30 +120,82 @@ func main() {
     log.Fatalln(err)
   }
 
+  // e.g. "ctfxd-server import <bundle>", see runCommand
+  if len(os.Args) > 1 {
+    os.Exit(runCommand(serverConfigs, os.Args[1:]))
+  }
+
   auth.JwtKey = serverConfigs.secretPhrase
+  auth.AccessTokenTTL = serverConfigs.accessTTL
+  auth.RefreshTokenTTL = serverConfigs.refreshTTL
+  auth.RequireAdminMFA = serverConfigs.adminMFA
+  auth.Cookies = serverConfigs.cookies
+
+  if serverConfigs.jwtKeysDir != "" {
+    auth.Keys, err = auth.LoadKeySet(serverConfigs.jwtKeysDir, serverConfigs.jwtActiveKID)
+    if err != nil {
+      log.Fatalf("failed to load JWT signing keys: %v\n", err)
+    }
+  }
 
   mongoClient := db.NewMongodbInit(serverConfigs.mongodbUri, serverConfigs.dbName)
   defer mongoClient.Close()
 
+  authRepo := auth.NewRepository(mongoClient.Database)
+  authService := auth.NewService(authRepo)
+  auth.Revocations = authService
+  authHandler := auth.NewHandler(authService)
+
+  auditRepo := audit.NewRepository(mongoClient.Database)
+  auditService := audit.NewService(auditRepo)
+  auditHandler := audit.NewHandler(auditService)
+
   userRepo := user.NewRepository(mongoClient.Database)
-  userService := user.NewService(userRepo)
-  userHandler := user.NewHandler(userService)
+  mailer, err := newMailer(serverConfigs)
+  if err != nil {
+    log.Fatalln(err)
+  }
+
+  loginThrottle := user.NewLoginThrottle(userRepo, user.DefaultThrottlePolicy, user.SystemClock{})
+  userService := user.NewService(userRepo, authService, mailer, loginThrottle, user.Config{
+    RequireEmailVerification: serverConfigs.verifyEmail,
+    VerifyEmailURL:           serverConfigs.publicUrl + "/verify-email",
+    VerificationTTL:          serverConfigs.verifyEmailTTL,
+    PasswordResetURL:         serverConfigs.publicUrl + "/reset-password",
+    PasswordResetTTL:         serverConfigs.resetTTL,
+    TOTPIssuer:               "CTFxd",
+    RequireAdminMFA:          serverConfigs.adminMFA,
+    Registration:             *serverConfigs.registration,
+    SSOProviders:             serverConfigs.ssoProviders,
+  })
+  userHandler := user.NewHandler(userService, auditService)
+  auth.Accounts = userService
+
+  tokenRepo := apitoken.NewRepository(mongoClient.Database)
+  tokenService := apitoken.NewService(tokenRepo, userService)
+  userService.AddAccountDataCleaner(tokenService)
+  userService.AddCredentialRevoker(tokenService)
+  tokenHandler := apitoken.NewHandler(tokenService)
+  auth.PersonalTokens = tokenService
 
   status := createSuperUser(userService, serverConfigs.superuserEmail, serverConfigs.superuserPass)
   if status == false {
     log.Fatalf("failed to create superuser(id:%s password: %s)\n", serverConfigs.superuserEmail, serverConfigs.superuserPass)
   }
 
-  challengeRepo := challenge.NewRepository(mongoClient.Database)
-  challengeService := challenge.NewService(challengeRepo)
-  challengeHandler := challenge.NewHandler(challengeService)
+  challengeService, err := newChallengeService(serverConfigs, mongoClient.Database)
+  if err != nil {
+    log.Fatalln(err)
+  }
+  fileURLs := urlsign.New(serverConfigs.fileURLKey, serverConfigs.fileURLTTL)
+  challengeHandler := challenge.NewHandler(challengeService, userService, auditService, fileURLs)
 
   submissionRepo := submission.NewRepository(mongoClient.Database)
   submissionService := submission.NewService(submissionRepo, challengeService)
   submissionHandler := submission.NewHandler(submissionService)
 
   scoreboardRepo := scoreboard.NewRepository(submissionRepo)
-  scoreboardService := scoreboard.NewService(scoreboardRepo)
+  scoreboardService := scoreboard.NewService(scoreboardRepo, userService)
   scoreboardHandler := scoreboard.NewHandler(scoreboardService)
 
   router := gin.Default()
@@ -94,10 +212,14 @@ func main() {
 
   apiV1.GET("/reference", apiReferenceGen())
 
+  handler.SetupWellKnownRoutes(&router.RouterGroup, authHandler)
+
   handler.SetupUserRoutes(apiV1, userHandler)
+  handler.SetupTokenRoutes(apiV1, tokenHandler)
   handler.SetupChallengeRoutes(apiV1, challengeHandler)
   handler.SetupSubmissionRoutes(apiV1, submissionHandler)
   handler.SetupScoreboardRoutes(apiV1, scoreboardHandler)
+  handler.SetupAuditRoutes(apiV1, auditHandler)
 
   srv := &http.Server{
     Addr:    fmt.Sprintf("%s:%s", serverConfigs.host, serverConfigs.port),
@@ -109,8 +231,13 @@ func main() {
 
   signal.Notify(quit, os.Interrupt)
 
+  // SIGHUP reloads the JWT signing keys (key rotation)
+  reload := make(chan os.Signal, 1)
+  signal.Notify(reload, syscall.SIGHUP)
+  go reloadSigningKeysRoutine(reload, serverConfigs.jwtActiveKID)
+
   var wg sync.WaitGroup
-  wg.Add(2)
+  wg.Add(3)
 
   go func() {
     defer wg.Done()
@@ -129,10 +256,20 @@ func main() {
     cleanOrphanFileUploadsRoutine(challengeService, cleanerCtx, serverConfigs.routinePeriod)
   }()
 
+  // stopped once the server is down, the last downloads are recorded
+  recorderCtx, recorderCancel := context.WithCancel(context.Background())
+  defer recorderCancel()
+
+  go func() {
+    defer wg.Done()
+    challengeService.RunDownloadRecorder(recorderCtx)
+  }()
+
   select {
   case err := <-errChan:
     log.Printf("Server failed to start: %v", err)
     cleanerCancel()
+    recorderCancel()
     wg.Wait()
     os.Exit(1)
   case sig := <-quit:
@@ -147,13 +284,16 @@ func main() {
   if err := srv.Shutdown(serverClosingCtx); err != nil {
     log.Fatal("Server forced to shutdown:", err)
   }
+  recorderCancel()
 
   wg.Wait()
 }
 
-func loadServerConfigs() (*ServerConfig, error) {
-  re := regexp.MustCompile(`^(\d+)([hms]{1})$`)
+var timePeriodRe = regexp.MustCompile(`^(\d+)([hms]{1})$`)
+var sizeRe = regexp.MustCompile(`^(\d+)(B|KB|MB|GB|TB)?$`)
+var providerNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
 
+func loadServerConfigs() (*ServerConfig, error) {
   err := godotenv.Load()
   if err != nil {
     return nil, errors.New("error loading .env file")
@@ -219,35 +359,423 @@ func loadServerConfigs() (*ServerConfig, error) {
     log.Printf("warning: ROUTINE_PERIOD not found! using default(%s)\n", timePeriod)
   }
 
-  timePeriodMatch := re.FindStringSubmatch(timePeriod)
-  if timePeriodMatch == nil {
+  serverConfig.routinePeriod, err = parseTimePeriod(timePeriod)
+  if err != nil {
     return nil, errors.New("error: invalid ROUTINE_PERIOD format!")
   }
 
+  // check for ACCESS_TOKEN_TTL (the lifetime of the access tokens)
+  accessTTL, ok := os.LookupEnv("ACCESS_TOKEN_TTL")
+  if !ok || accessTTL == "" {
+    accessTTL = DEFAULT_ACCESS_TOKEN_TTL
+  }
+
+  serverConfig.accessTTL, err = parseTimePeriod(accessTTL)
+  if err != nil {
+    return nil, errors.New("error: invalid ACCESS_TOKEN_TTL format!")
+  }
+
+  // check for REFRESH_TOKEN_TTL (the lifetime of the refresh tokens)
+  refreshTTL, ok := os.LookupEnv("REFRESH_TOKEN_TTL")
+  if !ok || refreshTTL == "" {
+    refreshTTL = DEFAULT_REFRESH_TOKEN_TTL
+  }
+
+  serverConfig.refreshTTL, err = parseTimePeriod(refreshTTL)
+  if err != nil {
+    return nil, errors.New("error: invalid REFRESH_TOKEN_TTL format!")
+  }
+
+  // check for JWT_KEYS_DIR (asymmetric signing keys, HS256 is used if unset)
+  serverConfig.jwtKeysDir = os.Getenv("JWT_KEYS_DIR")
+  serverConfig.jwtActiveKID = os.Getenv("JWT_ACTIVE_KID")
+  if serverConfig.jwtKeysDir == "" {
+    log.Println("warning: JWT_KEYS_DIR not found! signing tokens with SECRET_PHRASE(HS256)")
+  }
+
+  // check for MAIL_BACKEND (smtp, file or log)
+  serverConfig.mailBackend = lookupEnvDefault("MAIL_BACKEND", DEFAULT_MAIL_BACKEND)
+  serverConfig.mailFrom = lookupEnvDefault("MAIL_FROM", "noreply@localhost")
+  serverConfig.mailDir = lookupEnvDefault("MAIL_DIR", DEFAULT_MAIL_DIR)
+  serverConfig.smtpHost = os.Getenv("SMTP_HOST")
+  serverConfig.smtpPort = lookupEnvDefault("SMTP_PORT", DEFAULT_SMTP_PORT)
+  serverConfig.smtpUsername = os.Getenv("SMTP_USERNAME")
+  serverConfig.smtpPassword = os.Getenv("SMTP_PASSWORD")
+
+  // check for PUBLIC_URL (base url of the links sent by mail)
+  serverConfig.publicUrl = strings.TrimSuffix(lookupEnvDefault("PUBLIC_URL", DEFAULT_PUBLIC_URL), "/")
+
+  // check for EMAIL_VERIFICATION (block logins until the address is verified)
+  serverConfig.verifyEmail, err = lookupEnvBool("EMAIL_VERIFICATION", false)
+  if err != nil {
+    return nil, errors.New("error: invalid EMAIL_VERIFICATION value!")
+  }
+
+  serverConfig.verifyEmailTTL, err = parseTimePeriod(lookupEnvDefault("EMAIL_VERIFICATION_TTL", DEFAULT_VERIFICATION_TTL))
+  if err != nil {
+    return nil, errors.New("error: invalid EMAIL_VERIFICATION_TTL format!")
+  }
+
+  serverConfig.resetTTL, err = parseTimePeriod(lookupEnvDefault("PASSWORD_RESET_TTL", DEFAULT_PASSWORD_RESET_TTL))
+  if err != nil {
+    return nil, errors.New("error: invalid PASSWORD_RESET_TTL format!")
+  }
+
+  // check for REQUIRE_ADMIN_2FA (admin permissions need a 2FA session)
+  serverConfig.adminMFA, err = lookupEnvBool("REQUIRE_ADMIN_2FA", false)
+  if err != nil {
+    return nil, errors.New("error: invalid REQUIRE_ADMIN_2FA value!")
+  }
+
+  // check for REGISTRATION_MODE and REGISTRATION_DOMAINS (comma separated),
+  // only used until an admin changes the registration settings
+  serverConfig.registration, err = user.NewRegistrationSettings(
+    lookupEnvDefault("REGISTRATION_MODE", DEFAULT_REGISTRATION_MODE),
+    strings.Split(lookupEnvDefault("REGISTRATION_DOMAINS", ""), ","),
+  )
+  if err != nil {
+    return nil, fmt.Errorf("error: %v", err)
+  }
+
+  // check for OIDC_PROVIDERS (comma separated names of the SSO providers)
+  serverConfig.ssoProviders, err = loadSSOProviders(serverConfig.publicUrl)
+  if err != nil {
+    return nil, fmt.Errorf("error: %v", err)
+  }
+
+  // check for SESSION_COOKIES (let browser clients keep the tokens in cookies)
+  serverConfig.cookies, err = loadCookieConfig()
+  if err != nil {
+    return nil, fmt.Errorf("error: %v", err)
+  }
+
+  // check for STORAGE_BACKEND (local or s3) of the uploaded files
+  serverConfig.storageBackend = lookupEnvDefault("STORAGE_BACKEND", DEFAULT_STORAGE_BACKEND)
+  serverConfig.uploadDir = lookupEnvDefault("UPLOAD_DIR", DEFAULT_UPLOAD_DIR)
+  serverConfig.s3 = storage.S3Config{
+    Endpoint:  os.Getenv("S3_ENDPOINT"),
+    Region:    os.Getenv("S3_REGION"),
+    Bucket:    os.Getenv("S3_BUCKET"),
+    AccessKey: os.Getenv("S3_ACCESS_KEY"),
+    SecretKey: os.Getenv("S3_SECRET_KEY"),
+    Prefix:    os.Getenv("S3_PREFIX"),
+  }
+  serverConfig.s3.PathStyle, err = lookupEnvBool("S3_PATH_STYLE", false)
+  if err != nil {
+    return nil, errors.New("error: invalid S3_PATH_STYLE value!")
+  }
+
+  // check for MAX_FILE_SIZE and MAX_CHALLENGE_SIZE (all the files of a
+  // challenge) of the uploads, 0 for no limit
+  serverConfig.maxFileSize, err = parseSize(lookupEnvDefault("MAX_FILE_SIZE", DEFAULT_MAX_FILE_SIZE))
+  if err != nil {
+    return nil, errors.New("error: invalid MAX_FILE_SIZE format!")
+  }
+
+  serverConfig.maxChallSize, err = parseSize(lookupEnvDefault("MAX_CHALLENGE_SIZE", DEFAULT_MAX_CHALLENGE_SIZE))
+  if err != nil {
+    return nil, errors.New("error: invalid MAX_CHALLENGE_SIZE format!")
+  }
+
+  // check for FILE_URL_SECRET (key of the signed download URLs, shared with a
+  // CDN checking them) and FILE_URL_TTL
+  if fileURLSecret := os.Getenv("FILE_URL_SECRET"); fileURLSecret != "" {
+    serverConfig.fileURLKey = []byte(fileURLSecret)
+  } else {
+    mac := hmac.New(sha256.New, serverConfig.secretPhrase)
+    mac.Write([]byte("ctfxd file urls"))
+    serverConfig.fileURLKey = mac.Sum(nil)
+  }
+
+  serverConfig.fileURLTTL, err = parseTimePeriod(lookupEnvDefault("FILE_URL_TTL", DEFAULT_FILE_URL_TTL))
+  if err != nil {
+    return nil, errors.New("error: invalid FILE_URL_TTL format!")
+  }
+
+  // check for ORPHAN_GRACE_PERIOD (age of the unreferenced files the cleaner
+  // may reclaim) and QUARANTINE_PERIOD ("0s" to delete them right away)
+  serverConfig.orphanGrace, err = parseTimePeriod(lookupEnvDefault("ORPHAN_GRACE_PERIOD", DEFAULT_ORPHAN_GRACE_PERIOD))
+  if err != nil {
+    return nil, errors.New("error: invalid ORPHAN_GRACE_PERIOD format!")
+  }
+
+  serverConfig.quarantine, err = parseTimePeriod(lookupEnvDefault("QUARANTINE_PERIOD", DEFAULT_QUARANTINE_PERIOD))
+  if err != nil {
+    return nil, errors.New("error: invalid QUARANTINE_PERIOD format!")
+  }
+
+  // check for SCANNER (none or clamd), CLAMD_ADDRESS, SCAN_POLICY (block or
+  // flag the infected uploads) and SCAN_OVERSIZE_POLICY (block or flag the
+  // uploads too large to be scanned, e.g. over the StreamMaxLength of clamd)
+  serverConfig.scanner = lookupEnvDefault("SCANNER", DEFAULT_SCANNER)
+  serverConfig.clamdAddress = lookupEnvDefault("CLAMD_ADDRESS", DEFAULT_CLAMD_ADDRESS)
+  serverConfig.scanPolicy = lookupEnvDefault("SCAN_POLICY", DEFAULT_SCAN_POLICY)
+  if serverConfig.scanPolicy != challenge.ScanPolicyBlock && serverConfig.scanPolicy != challenge.ScanPolicyFlag {
+    return nil, errors.New("error: invalid SCAN_POLICY, expected block or flag!")
+  }
+  serverConfig.oversizePolicy = lookupEnvDefault("SCAN_OVERSIZE_POLICY", DEFAULT_SCAN_OVERSIZE_POLICY)
+  if serverConfig.oversizePolicy != challenge.ScanPolicyBlock && serverConfig.oversizePolicy != challenge.ScanPolicyFlag {
+    return nil, errors.New("error: invalid SCAN_OVERSIZE_POLICY, expected block or flag!")
+  }
+
+  return serverConfig, nil
+}
+
+// loadCookieConfig reads the cookie session settings: SESSION_COOKIES,
+// COOKIE_DOMAIN, COOKIE_SECURE (default true) and COOKIE_SAMESITE (lax,
+// strict or none).
+func loadCookieConfig() (auth.CookieConfig, error) {
+  config := auth.CookieConfig{
+    Domain: os.Getenv("COOKIE_DOMAIN"),
+    Path:   "/",
+  }
+
+  var err error
+  if config.Enabled, err = lookupEnvBool("SESSION_COOKIES", false); err != nil {
+    return config, errors.New("invalid SESSION_COOKIES value")
+  }
+
+  if config.Secure, err = lookupEnvBool("COOKIE_SECURE", true); err != nil {
+    return config, errors.New("invalid COOKIE_SECURE value")
+  }
+
+  switch sameSite := strings.ToLower(lookupEnvDefault("COOKIE_SAMESITE", DEFAULT_COOKIE_SAMESITE)); sameSite {
+  case "lax":
+    config.SameSite = http.SameSiteLaxMode
+  case "strict":
+    config.SameSite = http.SameSiteStrictMode
+  case "none":
+    if !config.Secure {
+      return config, errors.New("COOKIE_SAMESITE=none requires COOKIE_SECURE")
+    }
+    config.SameSite = http.SameSiteNoneMode
+  default:
+    return config, fmt.Errorf("invalid COOKIE_SAMESITE value(%s)", sameSite)
+  }
+
+  if config.Enabled && !config.Secure {
+    log.Println("warning: COOKIE_SECURE disabled! session cookies are sent over plain HTTP")
+  }
+
+  return config, nil
+}
+
+// loadSSOProviders reads the settings of every provider listed in
+// OIDC_PROVIDERS from the OIDC_<NAME>_* variables:
+//
+//  TYPE           oidc (default), github or oauth2
+//  ISSUER         issuer URL, required for oidc
+//  CLIENT_ID      required
+//  CLIENT_SECRET
+//  DISPLAY_NAME
+//  SCOPES         space separated, defaults depend on the type
+//  REDIRECT_URL   defaults to PUBLIC_URL/auth/<name>/callback
+//  AUTH_URL, TOKEN_URL, USERINFO_URL  endpoints, required for oauth2
+//  ROLE_CLAIM     claim holding the groups/roles, e.g. "groups"
+//  ROLE_MAP       claim value to role, e.g. "ctf-admins=admin,ctf-authors=author"
+func loadSSOProviders(publicUrl string) ([]*user.SSOProvider, error) {
+  var providers []*user.SSOProvider
+
+  for _, name := range strings.Split(lookupEnvDefault("OIDC_PROVIDERS", ""), ",") {
+    name = strings.TrimSpace(name)
+    if name == "" {
+      continue
+    }
+
+    if !providerNameRe.MatchString(name) {
+      return nil, fmt.Errorf("invalid OIDC provider name(%s)", name)
+    }
+
+    prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+    env := func(key string) string {
+      return lookupEnvDefault(prefix+key, "")
+    }
+
+    provider, err := oidc.NewProvider(oidc.Config{
+      Name:         name,
+      DisplayName:  env("DISPLAY_NAME"),
+      Type:         env("TYPE"),
+      Issuer:       env("ISSUER"),
+      ClientID:     env("CLIENT_ID"),
+      ClientSecret: env("CLIENT_SECRET"),
+      RedirectURL:  lookupEnvDefault(prefix+"REDIRECT_URL", publicUrl+"/auth/"+name+"/callback"),
+      Scopes:       strings.Fields(env("SCOPES")),
+      AuthURL:      env("AUTH_URL"),
+      TokenURL:     env("TOKEN_URL"),
+      UserInfoURL:  env("USERINFO_URL"),
+    }, nil)
+    if err != nil {
+      return nil, err
+    }
+
+    roleMap := make(map[string]string)
+    for _, pair := range strings.Split(env("ROLE_MAP"), ",") {
+      if strings.TrimSpace(pair) == "" {
+        continue
+      }
+
+      value, role, ok := strings.Cut(pair, "=")
+      role = strings.TrimSpace(role)
+      if !ok || !auth.IsValidRole(role) {
+        return nil, fmt.Errorf("invalid %sROLE_MAP entry(%s)", prefix, pair)
+      }
+      roleMap[strings.TrimSpace(value)] = role
+    }
+
+    providers = append(providers, &user.SSOProvider{
+      Provider:  provider,
+      RoleClaim: env("ROLE_CLAIM"),
+      RoleMap:   roleMap,
+    })
+  }
+
+  return providers, nil
+}
+
+func lookupEnvDefault(key, defaultValue string) string {
+  value, ok := os.LookupEnv(key)
+  if !ok || value == "" {
+    return defaultValue
+  }
+
+  return value
+}
+
+func lookupEnvBool(key string, defaultValue bool) (bool, error) {
+  value, ok := os.LookupEnv(key)
+  if !ok || value == "" {
+    return defaultValue, nil
+  }
+
+  return strconv.ParseBool(value)
+}
+
+func newMailer(config *ServerConfig) (mail.Mailer, error) {
+  switch config.mailBackend {
+  case "smtp":
+    if config.smtpHost == "" {
+      return nil, errors.New("error: SMTP_HOST not found!")
+    }
+    return mail.NewSMTPMailer(config.smtpHost, config.smtpPort, config.smtpUsername, config.smtpPassword, config.mailFrom), nil
+  case "file":
+    return mail.NewFileMailer(config.mailDir, config.mailFrom), nil
+  case "log":
+    return mail.LogMailer{}, nil
+  }
+
+  return nil, fmt.Errorf("error: unknown MAIL_BACKEND(%s)", config.mailBackend)
+}
+
+func newStorage(config *ServerConfig) (storage.Storage, error) {
+  switch config.storageBackend {
+  case "local":
+    return storage.NewLocal(config.uploadDir)
+  case "s3":
+    return storage.NewS3(config.s3, nil)
+  }
+
+  return nil, fmt.Errorf("error: unknown STORAGE_BACKEND(%s)", config.storageBackend)
+}
+
+func newChallengeService(config *ServerConfig, database *mongo.Database) (*challenge.Service, error) {
+  store, err := newStorage(config)
+  if err != nil {
+    return nil, err
+  }
+
+  fileScanner, err := newScanner(config)
+  if err != nil {
+    return nil, err
+  }
+
+  challengeRepo := challenge.NewRepository(database)
+  return challenge.NewService(challengeRepo, store, challenge.Config{
+    MaxFileSize:       config.maxFileSize,
+    MaxChallengeSize:  config.maxChallSize,
+    OrphanGracePeriod: config.orphanGrace,
+    QuarantinePeriod:  config.quarantine,
+    Scanner:           fileScanner,
+    ScanPolicy:        config.scanPolicy,
+    OversizePolicy:    config.oversizePolicy,
+  }), nil
+}
+
+func newScanner(config *ServerConfig) (scanner.Scanner, error) {
+  switch config.scanner {
+  case "none":
+    return nil, nil
+  case "clamd":
+    clamd, err := scanner.NewClamd(config.clamdAddress)
+    if err != nil {
+      return nil, err
+    }
+
+    // the uploads fail or are flagged until clamd is up, the server starts
+    // anyway
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    if err := clamd.Ping(ctx); err != nil {
+      log.Printf("Warning: clamd unreachable(%v)\n", err)
+    }
+
+    return clamd, nil
+  }
+
+  return nil, fmt.Errorf("error: unknown SCANNER(%s)", config.scanner)
+}
+
+// parseTimePeriod parses periods of the form <number><h|m|s>, e.g. "30s"
+func parseTimePeriod(timePeriod string) (time.Duration, error) {
+  timePeriodMatch := timePeriodRe.FindStringSubmatch(timePeriod)
+  if timePeriodMatch == nil {
+    return 0, errors.New("error: invalid time period format")
+  }
+
   tick, err := strconv.ParseUint(timePeriodMatch[1], 10, 64)
   if err != nil {
-    return nil, errors.New("error: invalid time period format")
+    return 0, errors.New("error: invalid time period format")
   }
 
-  serverConfig.routinePeriod = time.Duration(tick)
+  period := time.Duration(tick)
 
   switch timePeriodMatch[2] {
   case "h":
-    serverConfig.routinePeriod *= time.Hour
+    period *= time.Hour
   case "m":
-    serverConfig.routinePeriod *= time.Minute
+    period *= time.Minute
   case "s":
-    serverConfig.routinePeriod *= time.Second
+    period *= time.Second
   }
 
-  return serverConfig, nil
+  return period, nil
+}
+
+// parseSize parses sizes of the form <number>[B|KB|MB|GB|TB], in powers of
+// 1024, e.g. "512MB"
+func parseSize(size string) (int64, error) {
+  sizeMatch := sizeRe.FindStringSubmatch(strings.ToUpper(size))
+  if sizeMatch == nil {
+    return 0, errors.New("error: invalid size format")
+  }
+
+  value, err := strconv.ParseInt(sizeMatch[1], 10, 64)
+  if err != nil {
+    return 0, errors.New("error: invalid size format")
+  }
+
+  shift := map[string]uint{"": 0, "B": 0, "KB": 10, "MB": 20, "GB": 30, "TB": 40}[sizeMatch[2]]
+  if value > math.MaxInt64>>shift {
+    return 0, errors.New("error: invalid size format")
+  }
+
+  return value << shift, nil
 }
 
 func createSuperUser(userService *user.Service, email, password string) bool {
   ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
   defer cancel()
 
-  err := userService.Register(ctx, email, password, true)
+  _, err := userService.Register(ctx, email, password, auth.RoleAdmin)
   if err == user.ErrUserExists {
     return true
   }
@@ -269,18 +797,51 @@ func cleanOrphanFileUploadsRoutine(service *challenge.Service, ctx context.Conte
       log.Println("Stopping file cleaner...")
       return
     case <-ticker.C:
-      serv_ctx, cancel := context.WithTimeout(context.Background(), period)
-      defer cancel()
-
-      log.Println("Running file cleaner...")
-      fileNames, err := service.CleanOrphanFileUploads(serv_ctx)
-      if err != nil {
-        log.Printf("Error: file clearner: %v\n", err)
-      } else if len(fileNames) > 0 {
-        log.Printf("Cleaned files successfully: %v\n", fileNames)
-      } else {
-        log.Println("No files to be cleaned")
-      }
+      cleanOrphanFileUploads(service, period)
+    }
+  }
+}
+
+// cleanOrphanFileUploads runs the cleaner once, it may take up to a period.
+func cleanOrphanFileUploads(service *challenge.Service, period time.Duration) {
+  serv_ctx, cancel := context.WithTimeout(context.Background(), period)
+  defer cancel()
+
+  log.Println("Running file cleaner...")
+  report, err := service.CleanOrphanFileUploads(serv_ctx, false)
+  if err != nil {
+    log.Printf("Error: file cleaner: %v\n", err)
+  }
+
+  if report.QuarantinedFiles > 0 || report.ReclaimedFiles > 0 {
+    log.Printf("File cleaner: quarantined %d files (%d bytes), reclaimed %d files (%d bytes), kept %d recent files\n",
+      report.QuarantinedFiles, report.QuarantinedBytes, report.ReclaimedFiles, report.ReclaimedBytes, report.Kept)
+  } else if err == nil {
+    log.Println("No files to be cleaned")
+  }
+}
+
+// reloadSigningKeysRoutine reloads the signing keys on SIGHUP. The process
+// environment can't change, so JWT_ACTIVE_KID is re-read from the .env file
+// and the startup value is kept if the file doesn't set it.
+func reloadSigningKeysRoutine(reload <-chan os.Signal, activeKID string) {
+  for range reload {
+    if auth.Keys == nil {
+      continue
+    }
+
+    env, err := godotenv.Read()
+    if err != nil {
+      log.Printf("Error: signing keys reload: reading .env: %v\n", err)
+      continue
+    }
+    if kid, ok := env["JWT_ACTIVE_KID"]; ok {
+      activeKID = kid
+    }
+
+    log.Println("Reloading JWT signing keys...")
+    if err := auth.Keys.Reload(activeKID); err != nil {
+      log.Printf("Error: signing keys reload: %v\n", err)
     }
   }
 }
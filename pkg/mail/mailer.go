@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package mail
+
+import (
+  "context"
+  "fmt"
+  "log"
+  "net"
+  "net/smtp"
+  "os"
+  "path/filepath"
+  "strings"
+  "time"
+
+  "github.com/google/uuid"
+)
+
+type Message struct {
+  To      string
+  Subject string
+  Body    string
+}
+
+// Mailer delivers the emails sent by the server (verification, password
+// reset...).
+type Mailer interface {
+  Send(ctx context.Context, msg *Message) error
+}
+
+// SMTPMailer sends the messages through an SMTP server, with PLAIN auth if
+// a username is set.
+type SMTPMailer struct {
+  host     string
+  port     string
+  username string
+  password string
+  from     string
+}
+
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+  mailer := new(SMTPMailer)
+  mailer.host = host
+  mailer.port = port
+  mailer.username = username
+  mailer.password = password
+  mailer.from = from
+
+  return mailer
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg *Message) error {
+  var smtpAuth smtp.Auth
+  if m.username != "" {
+    smtpAuth = smtp.PlainAuth("", m.username, m.password, m.host)
+  }
+
+  errChan := make(chan error, 1)
+  go func() {
+    errChan <- smtp.SendMail(net.JoinHostPort(m.host, m.port), smtpAuth, m.from, []string{msg.To}, format(m.from, msg))
+  }()
+
+  select {
+  case err := <-errChan:
+    return err
+  case <-ctx.Done():
+    return ctx.Err()
+  }
+}
+
+// FileMailer writes every message as an .eml file into a directory, so the
+// mail flows can be used locally without an SMTP server.
+type FileMailer struct {
+  dir  string
+  from string
+}
+
+func NewFileMailer(dir, from string) *FileMailer {
+  mailer := new(FileMailer)
+  mailer.dir = dir
+  mailer.from = from
+  os.MkdirAll(dir, 0700)
+
+  return mailer
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg *Message) error {
+  name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405"), uuid.NewString())
+  path := filepath.Join(m.dir, name)
+
+  if err := os.WriteFile(path, format(m.from, msg), 0600); err != nil {
+    return err
+  }
+
+  log.Printf("mail: message to %s written to %s\n", msg.To, path)
+  return nil
+}
+
+// LogMailer only logs the messages.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, msg *Message) error {
+  log.Printf("mail: to(%s) subject(%s)\n%s\n", msg.To, msg.Subject, msg.Body)
+  return nil
+}
+
+func format(from string, msg *Message) []byte {
+  var b strings.Builder
+
+  fmt.Fprintf(&b, "From: %s\r\n", from)
+  fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+  fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+  fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+  b.WriteString("MIME-Version: 1.0\r\n")
+  b.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+  b.WriteString("\r\n")
+  b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+  return []byte(b.String())
+}
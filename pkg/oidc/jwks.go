@@ -0,0 +1,229 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package oidc
+
+import (
+  "context"
+  "crypto/ecdsa"
+  "crypto/ed25519"
+  "crypto/elliptic"
+  "crypto/rsa"
+  "encoding/base64"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "math/big"
+  "net/http"
+  "sync"
+  "time"
+
+  "github.com/golang-jwt/jwt/v5"
+)
+
+// the keys are fetched again on an unknown kid, at most this often
+const jwksMinRefresh = time.Minute
+
+var errUnknownKey = errors.New("unknown signing key")
+
+// keySet caches the signing keys of the provider (jwks_uri).
+type keySet struct {
+  uri    string
+  client *http.Client
+
+  mtx       sync.Mutex
+  keys      map[string]any
+  fetchedAt time.Time
+}
+
+type jsonWebKey struct {
+  Kty string `json:"kty"`
+  Kid string `json:"kid"`
+  Use string `json:"use"`
+  N   string `json:"n"`
+  E   string `json:"e"`
+  Crv string `json:"crv"`
+  X   string `json:"x"`
+  Y   string `json:"y"`
+}
+
+func newKeySet(uri string, client *http.Client) *keySet {
+  return &keySet{uri: uri, client: client}
+}
+
+func (ks *keySet) lookup(ctx context.Context, kid string) (any, error) {
+  ks.mtx.Lock()
+  defer ks.mtx.Unlock()
+
+  if key, ok := ks.find(kid); ok {
+    return key, nil
+  }
+
+  // the provider may have rotated its keys
+  if time.Since(ks.fetchedAt) < jwksMinRefresh {
+    return nil, errUnknownKey
+  }
+
+  if err := ks.fetch(ctx); err != nil {
+    return nil, err
+  }
+
+  if key, ok := ks.find(kid); ok {
+    return key, nil
+  }
+
+  return nil, errUnknownKey
+}
+
+// find also accepts a token without kid when the provider has a single key.
+func (ks *keySet) find(kid string) (any, bool) {
+  if kid == "" && len(ks.keys) == 1 {
+    for _, key := range ks.keys {
+      return key, true
+    }
+  }
+
+  key, ok := ks.keys[kid]
+  return key, ok
+}
+
+func (ks *keySet) fetch(ctx context.Context) error {
+  ks.fetchedAt = time.Now()
+
+  req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.uri, nil)
+  if err != nil {
+    return err
+  }
+
+  resp, err := ks.client.Do(req)
+  if err != nil {
+    return err
+  }
+  defer resp.Body.Close()
+
+  if resp.StatusCode != http.StatusOK {
+    return fmt.Errorf("jwks: status %d", resp.StatusCode)
+  }
+
+  var set struct {
+    Keys []jsonWebKey `json:"keys"`
+  }
+  if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&set); err != nil {
+    return fmt.Errorf("jwks: %w", err)
+  }
+
+  keys := make(map[string]any)
+  for _, jwk := range set.Keys {
+    if jwk.Use != "" && jwk.Use != "sig" {
+      continue
+    }
+
+    // keys of unsupported types are skipped, not fatal
+    if key, err := jwk.publicKey(); err == nil {
+      keys[jwk.Kid] = key
+    }
+  }
+
+  ks.keys = keys
+  return nil
+}
+
+func (k *jsonWebKey) publicKey() (any, error) {
+  switch k.Kty {
+  case "RSA":
+    n, err := decodeBigInt(k.N)
+    if err != nil {
+      return nil, err
+    }
+    e, err := decodeBigInt(k.E)
+    if err != nil {
+      return nil, err
+    }
+
+    return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+  case "EC":
+    var curve elliptic.Curve
+    switch k.Crv {
+    case "P-256":
+      curve = elliptic.P256()
+    case "P-384":
+      curve = elliptic.P384()
+    case "P-521":
+      curve = elliptic.P521()
+    default:
+      return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+    }
+
+    x, err := decodeBigInt(k.X)
+    if err != nil {
+      return nil, err
+    }
+    y, err := decodeBigInt(k.Y)
+    if err != nil {
+      return nil, err
+    }
+
+    return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+  case "OKP":
+    if k.Crv != "Ed25519" {
+      return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+    }
+
+    x, err := base64.RawURLEncoding.DecodeString(k.X)
+    if err != nil || len(x) != ed25519.PublicKeySize {
+      return nil, errors.New("invalid Ed25519 key")
+    }
+
+    return ed25519.PublicKey(x), nil
+  }
+
+  return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+  buf, err := base64.RawURLEncoding.DecodeString(s)
+  if err != nil {
+    return nil, err
+  }
+
+  return new(big.Int).SetBytes(buf), nil
+}
+
+// verifyIDToken checks the ID token as required by OIDC core 3.1.3.7 and
+// returns its claims.
+func (p *Provider) verifyIDToken(ctx context.Context, doc *discoveryDocument, idToken, nonce string) (map[string]any, error) {
+  parser := jwt.NewParser(
+    jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+    jwt.WithIssuer(doc.Issuer),
+    jwt.WithAudience(p.config.ClientID),
+    jwt.WithExpirationRequired(),
+    jwt.WithIssuedAt(),
+    jwt.WithLeeway(time.Minute),
+  )
+
+  claims := jwt.MapClaims{}
+  _, err := parser.ParseWithClaims(idToken, claims, func(token *jwt.Token) (any, error) {
+    kid, _ := token.Header["kid"].(string)
+    return p.keys.lookup(ctx, kid)
+  })
+  if err != nil {
+    return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+  }
+
+  if got, _ := claims["nonce"].(string); got != nonce {
+    return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+  }
+
+  // with several audiences, the token must have been issued to us
+  if aud, err := claims.GetAudience(); err == nil && len(aud) > 1 {
+    if azp, _ := claims["azp"].(string); azp != p.config.ClientID {
+      return nil, fmt.Errorf("%w: azp mismatch", ErrInvalidIDToken)
+    }
+  }
+
+  return claims, nil
+}
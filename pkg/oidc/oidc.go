@@ -0,0 +1,487 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+// Package oidc is a small OpenID Connect / OAuth2 relying party: discovery,
+// the authorization code flow with PKCE (RFC 7636) and ID token verification.
+// Plain OAuth2 providers (GitHub) are supported through their user endpoint.
+package oidc
+
+import (
+  "context"
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "net/http"
+  "net/url"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+const (
+  TypeOIDC   = "oidc"
+  TypeOAuth2 = "oauth2"
+  TypeGitHub = "github"
+
+  maxResponseSize = 1 << 20
+)
+
+var (
+  ErrUnknownType     = errors.New("unknown provider type")
+  ErrMissingEndpoint = errors.New("missing provider endpoint")
+  ErrExchange        = errors.New("authorization code exchange failed")
+  ErrInvalidIDToken  = errors.New("invalid ID token")
+  ErrNoSubject       = errors.New("provider returned no subject")
+)
+
+type Config struct {
+  // Name identifies the provider in the URLs, e.g. "campus"
+  Name        string
+  DisplayName string
+  // TypeOIDC (default), TypeOAuth2 or TypeGitHub
+  Type         string
+  ClientID     string
+  ClientSecret string
+  RedirectURL  string
+  Scopes       []string
+
+  // Issuer is required for TypeOIDC, the endpoints are discovered from it
+  Issuer string
+
+  // endpoints of the TypeOAuth2 providers, they override the discovered
+  // ones (and the GitHub defaults)
+  AuthURL     string
+  TokenURL    string
+  UserInfoURL string
+}
+
+// Identity is the account of the user at the provider.
+type Identity struct {
+  Subject       string
+  Email         string
+  EmailVerified bool
+  Name          string
+  // every claim of the ID token (merged with the userinfo response)
+  Claims map[string]any
+}
+
+type Token struct {
+  AccessToken string `json:"access_token"`
+  TokenType   string `json:"token_type"`
+  IDToken     string `json:"id_token"`
+}
+
+type Provider struct {
+  config Config
+  client *http.Client
+
+  mtx       sync.Mutex
+  discovery *discoveryDocument
+  keys      *keySet
+}
+
+type discoveryDocument struct {
+  Issuer           string `json:"issuer"`
+  AuthEndpoint     string `json:"authorization_endpoint"`
+  TokenEndpoint    string `json:"token_endpoint"`
+  UserInfoEndpoint string `json:"userinfo_endpoint"`
+  JWKSURI          string `json:"jwks_uri"`
+}
+
+// NewProvider checks the configuration, the OIDC discovery happens on first
+// use so the server starts even while the provider is down. client defaults
+// to a client with a 10 seconds timeout.
+func NewProvider(config Config, client *http.Client) (*Provider, error) {
+  if config.Name == "" || config.ClientID == "" || config.RedirectURL == "" {
+    return nil, fmt.Errorf("provider %q: name, client id and redirect url are required", config.Name)
+  }
+
+  if config.DisplayName == "" {
+    config.DisplayName = config.Name
+  }
+
+  switch config.Type {
+  case "", TypeOIDC:
+    config.Type = TypeOIDC
+    if config.Issuer == "" {
+      return nil, fmt.Errorf("provider %q: %w: issuer", config.Name, ErrMissingEndpoint)
+    }
+    if len(config.Scopes) == 0 {
+      config.Scopes = []string{"openid", "email", "profile"}
+    }
+  case TypeGitHub:
+    config.AuthURL = valueOr(config.AuthURL, "https://github.com/login/oauth/authorize")
+    config.TokenURL = valueOr(config.TokenURL, "https://github.com/login/oauth/access_token")
+    config.UserInfoURL = valueOr(config.UserInfoURL, "https://api.github.com/user")
+    if len(config.Scopes) == 0 {
+      config.Scopes = []string{"read:user", "user:email"}
+    }
+  case TypeOAuth2:
+    if config.AuthURL == "" || config.TokenURL == "" || config.UserInfoURL == "" {
+      return nil, fmt.Errorf("provider %q: %w: auth, token and userinfo urls are required", config.Name, ErrMissingEndpoint)
+    }
+  default:
+    return nil, fmt.Errorf("provider %q: %w: %s", config.Name, ErrUnknownType, config.Type)
+  }
+
+  if client == nil {
+    client = &http.Client{Timeout: 10 * time.Second}
+  }
+
+  provider := new(Provider)
+  provider.config = config
+  provider.client = client
+
+  return provider, nil
+}
+
+func (p *Provider) Name() string {
+  return p.config.Name
+}
+
+func (p *Provider) DisplayName() string {
+  return p.config.DisplayName
+}
+
+// AuthCodeURL returns the URL to send the user to. codeChallenge is the S256
+// challenge of the PKCE verifier, see NewVerifier.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+  authURL := p.config.AuthURL
+  if p.config.Type == TypeOIDC {
+    doc, err := p.discover(ctx)
+    if err != nil {
+      return "", err
+    }
+    authURL = valueOr(authURL, doc.AuthEndpoint)
+  }
+
+  params := url.Values{}
+  params.Set("response_type", "code")
+  params.Set("client_id", p.config.ClientID)
+  params.Set("redirect_uri", p.config.RedirectURL)
+  params.Set("scope", strings.Join(p.config.Scopes, " "))
+  params.Set("state", state)
+  params.Set("code_challenge", codeChallenge)
+  params.Set("code_challenge_method", "S256")
+  if p.config.Type == TypeOIDC {
+    params.Set("nonce", nonce)
+  }
+
+  sep := "?"
+  if strings.Contains(authURL, "?") {
+    sep = "&"
+  }
+
+  return authURL + sep + params.Encode(), nil
+}
+
+// Exchange trades the authorization code (and the PKCE verifier) for tokens.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (*Token, error) {
+  tokenURL := p.config.TokenURL
+  if p.config.Type == TypeOIDC {
+    doc, err := p.discover(ctx)
+    if err != nil {
+      return nil, err
+    }
+    tokenURL = valueOr(tokenURL, doc.TokenEndpoint)
+  }
+
+  form := url.Values{}
+  form.Set("grant_type", "authorization_code")
+  form.Set("code", code)
+  form.Set("redirect_uri", p.config.RedirectURL)
+  form.Set("code_verifier", verifier)
+
+  req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+  if err != nil {
+    return nil, err
+  }
+  req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+  req.Header.Set("Accept", "application/json")
+  req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+  resp, err := p.client.Do(req)
+  if err != nil {
+    return nil, err
+  }
+  defer resp.Body.Close()
+
+  body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+  if err != nil {
+    return nil, err
+  }
+
+  // GitHub answers errors with a 200
+  var result struct {
+    Token
+    Error       string `json:"error"`
+    Description string `json:"error_description"`
+  }
+  if err := json.Unmarshal(body, &result); err != nil {
+    return nil, fmt.Errorf("%w: status %d", ErrExchange, resp.StatusCode)
+  }
+
+  if resp.StatusCode != http.StatusOK || result.Error != "" || result.AccessToken == "" {
+    return nil, fmt.Errorf("%w: status %d: %s %s", ErrExchange, resp.StatusCode, result.Error, result.Description)
+  }
+
+  if p.config.Type == TypeOIDC && result.IDToken == "" {
+    return nil, fmt.Errorf("%w: no id_token in response", ErrExchange)
+  }
+
+  return &result.Token, nil
+}
+
+// Identity returns the user behind the token. For OIDC providers the ID token
+// is verified (signature, issuer, audience, expiry and nonce) and completed
+// with the userinfo endpoint when it carries no email.
+func (p *Provider) Identity(ctx context.Context, token *Token, nonce string) (*Identity, error) {
+  if p.config.Type != TypeOIDC {
+    return p.oauth2Identity(ctx, token)
+  }
+
+  doc, err := p.discover(ctx)
+  if err != nil {
+    return nil, err
+  }
+
+  claims, err := p.verifyIDToken(ctx, doc, token.IDToken, nonce)
+  if err != nil {
+    return nil, err
+  }
+
+  userInfoURL := valueOr(p.config.UserInfoURL, doc.UserInfoEndpoint)
+  if _, ok := claims["email"]; !ok && userInfoURL != "" {
+    info, err := p.fetchJSON(ctx, userInfoURL, token.AccessToken)
+    if err != nil {
+      return nil, err
+    }
+
+    // the userinfo response must be about the same user (OIDC core 5.3.2)
+    if sub, _ := info["sub"].(string); sub == claims["sub"] {
+      for k, v := range info {
+        if _, ok := claims[k]; !ok {
+          claims[k] = v
+        }
+      }
+    }
+  }
+
+  return identityFromClaims(claims)
+}
+
+func (p *Provider) oauth2Identity(ctx context.Context, token *Token) (*Identity, error) {
+  claims, err := p.fetchJSON(ctx, p.config.UserInfoURL, token.AccessToken)
+  if err != nil {
+    return nil, err
+  }
+
+  // GitHub has numeric ids and a "login" instead of OIDC claims
+  if _, ok := claims["sub"]; !ok {
+    switch id := claims["id"].(type) {
+    case float64:
+      claims["sub"] = strconv.FormatInt(int64(id), 10)
+    case string:
+      claims["sub"] = id
+    }
+  }
+  if _, ok := claims["name"]; !ok {
+    claims["name"] = claims["login"]
+  }
+
+  if p.config.Type == TypeGitHub {
+    // the public email of the profile may be missing or unverified, use the
+    // primary verified one instead
+    delete(claims, "email")
+    if email, ok := p.githubPrimaryEmail(ctx, token.AccessToken); ok {
+      claims["email"] = email
+      claims["email_verified"] = true
+    }
+  }
+
+  return identityFromClaims(claims)
+}
+
+func (p *Provider) githubPrimaryEmail(ctx context.Context, accessToken string) (string, bool) {
+  emailsURL := strings.TrimSuffix(p.config.UserInfoURL, "/") + "/emails"
+
+  req, err := http.NewRequestWithContext(ctx, http.MethodGet, emailsURL, nil)
+  if err != nil {
+    return "", false
+  }
+  req.Header.Set("Authorization", "Bearer "+accessToken)
+  req.Header.Set("Accept", "application/json")
+
+  resp, err := p.client.Do(req)
+  if err != nil {
+    return "", false
+  }
+  defer resp.Body.Close()
+
+  if resp.StatusCode != http.StatusOK {
+    return "", false
+  }
+
+  var emails []struct {
+    Email    string `json:"email"`
+    Primary  bool   `json:"primary"`
+    Verified bool   `json:"verified"`
+  }
+  if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&emails); err != nil {
+    return "", false
+  }
+
+  for _, e := range emails {
+    if e.Primary && e.Verified {
+      return e.Email, true
+    }
+  }
+
+  return "", false
+}
+
+func (p *Provider) discover(ctx context.Context) (*discoveryDocument, error) {
+  p.mtx.Lock()
+  defer p.mtx.Unlock()
+
+  if p.discovery != nil {
+    return p.discovery, nil
+  }
+
+  issuer := strings.TrimSuffix(p.config.Issuer, "/")
+  req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+  if err != nil {
+    return nil, err
+  }
+
+  resp, err := p.client.Do(req)
+  if err != nil {
+    return nil, err
+  }
+  defer resp.Body.Close()
+
+  if resp.StatusCode != http.StatusOK {
+    return nil, fmt.Errorf("oidc discovery: status %d", resp.StatusCode)
+  }
+
+  doc := new(discoveryDocument)
+  if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(doc); err != nil {
+    return nil, fmt.Errorf("oidc discovery: %w", err)
+  }
+
+  if strings.TrimSuffix(doc.Issuer, "/") != issuer {
+    return nil, fmt.Errorf("oidc discovery: issuer mismatch(%s)", doc.Issuer)
+  }
+
+  if doc.AuthEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+    return nil, fmt.Errorf("oidc discovery: %w", ErrMissingEndpoint)
+  }
+
+  p.discovery = doc
+  p.keys = newKeySet(doc.JWKSURI, p.client)
+
+  return doc, nil
+}
+
+func (p *Provider) fetchJSON(ctx context.Context, endpoint, accessToken string) (map[string]any, error) {
+  req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+  if err != nil {
+    return nil, err
+  }
+  req.Header.Set("Authorization", "Bearer "+accessToken)
+  req.Header.Set("Accept", "application/json")
+
+  resp, err := p.client.Do(req)
+  if err != nil {
+    return nil, err
+  }
+  defer resp.Body.Close()
+
+  if resp.StatusCode != http.StatusOK {
+    return nil, fmt.Errorf("userinfo: status %d", resp.StatusCode)
+  }
+
+  claims := make(map[string]any)
+  if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&claims); err != nil {
+    return nil, fmt.Errorf("userinfo: %w", err)
+  }
+
+  return claims, nil
+}
+
+func identityFromClaims(claims map[string]any) (*Identity, error) {
+  identity := &Identity{Claims: claims}
+
+  identity.Subject, _ = claims["sub"].(string)
+  if identity.Subject == "" {
+    return nil, ErrNoSubject
+  }
+
+  identity.Email, _ = claims["email"].(string)
+  identity.Name, _ = claims["name"].(string)
+  if identity.Name == "" {
+    identity.Name, _ = claims["preferred_username"].(string)
+  }
+
+  // some providers send "true" as a string
+  switch verified := claims["email_verified"].(type) {
+  case bool:
+    identity.EmailVerified = verified
+  case string:
+    identity.EmailVerified = verified == "true"
+  }
+
+  return identity, nil
+}
+
+// NewVerifier returns a random PKCE code verifier and its S256 challenge.
+func NewVerifier() (verifier, challenge string, err error) {
+  verifier, err = RandomString(32)
+  if err != nil {
+    return "", "", err
+  }
+
+  sum := sha256.Sum256([]byte(verifier))
+  return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// RandomString returns n random bytes, base64url encoded (for states and
+// nonces).
+func RandomString(n int) (string, error) {
+  buf := make([]byte, n)
+  if _, err := rand.Read(buf); err != nil {
+    return "", err
+  }
+
+  return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Claim looks up a claim by its dotted path, e.g. "realm_access.roles".
+func (i *Identity) Claim(path string) any {
+  var value any = i.Claims
+  for _, key := range strings.Split(path, ".") {
+    m, ok := value.(map[string]any)
+    if !ok {
+      return nil
+    }
+    value = m[key]
+  }
+
+  return value
+}
+
+func valueOr(value, fallback string) string {
+  if value != "" {
+    return value
+  }
+
+  return fallback
+}
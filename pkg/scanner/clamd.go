@@ -0,0 +1,171 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package scanner
+
+import (
+  "bufio"
+  "context"
+  "encoding/binary"
+  "fmt"
+  "io"
+  "net"
+  "strings"
+  "time"
+)
+
+const (
+  // clamd reads the stream in chunks of at most StreamMaxLength
+  clamdChunkSize   = 64 << 10
+  clamdDialTimeout = 10 * time.Second
+)
+
+// Clamd scans with a ClamAV daemon, streaming the content with the INSTREAM
+// command. A content over its StreamMaxLength (25 MB by default) fails with
+// ErrTooLarge.
+type Clamd struct {
+  network string
+  address string
+}
+
+// NewClamd connects to clamd at address: "unix:/path/to/clamd.sock",
+// "tcp://host:port" or "host:port".
+func NewClamd(address string) (*Clamd, error) {
+  network := "tcp"
+  switch {
+  case strings.HasPrefix(address, "unix:"):
+    network, address = "unix", strings.TrimPrefix(strings.TrimPrefix(address, "unix:"), "//")
+  case strings.HasPrefix(address, "tcp://"):
+    address = strings.TrimPrefix(address, "tcp://")
+  }
+
+  if address == "" {
+    return nil, fmt.Errorf("clamd: invalid address")
+  }
+
+  return &Clamd{network: network, address: address}, nil
+}
+
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (*Result, error) {
+  reply, err := c.command(ctx, "INSTREAM", func(conn net.Conn) error {
+    chunk := make([]byte, 4+clamdChunkSize)
+    for {
+      n, err := io.ReadFull(r, chunk[4:])
+      if n > 0 {
+        binary.BigEndian.PutUint32(chunk, uint32(n))
+        if _, err := conn.Write(chunk[:4+n]); err != nil {
+          return err
+        }
+      }
+
+      if err == io.EOF || err == io.ErrUnexpectedEOF {
+        break
+      } else if err != nil {
+        return err
+      }
+    }
+
+    // a zero length chunk ends the stream
+    _, err := conn.Write([]byte{0, 0, 0, 0})
+    return err
+  })
+  if err != nil {
+    return nil, err
+  }
+
+  // e.g. "stream: OK" or "stream: Eicar-Signature FOUND"
+  result := strings.TrimPrefix(reply, "stream: ")
+  switch {
+  case result == "OK":
+    return &Result{}, nil
+  case strings.HasSuffix(result, " FOUND"):
+    return &Result{Infected: true, Threat: strings.TrimSuffix(result, " FOUND")}, nil
+  }
+
+  return nil, replyError(reply)
+}
+
+// Ping checks clamd is reachable.
+func (c *Clamd) Ping(ctx context.Context) error {
+  reply, err := c.command(ctx, "PING", nil)
+  if err != nil {
+    return err
+  }
+
+  if reply != "PONG" {
+    return fmt.Errorf("%w: clamd: %s", ErrScan, reply)
+  }
+
+  return nil
+}
+
+// command sends a null-terminated command, its payload, and reads the reply.
+func (c *Clamd) command(ctx context.Context, name string, payload func(net.Conn) error) (string, error) {
+  dialer := net.Dialer{Timeout: clamdDialTimeout}
+  conn, err := dialer.DialContext(ctx, c.network, c.address)
+  if err != nil {
+    return "", fmt.Errorf("%w: clamd: %v", ErrScan, err)
+  }
+  defer conn.Close()
+
+  // unblocks the reads and writes once the context is done
+  stop := context.AfterFunc(ctx, func() {
+    conn.SetDeadline(time.Now())
+  })
+  defer stop()
+
+  _, err = conn.Write([]byte("z" + name + "\x00"))
+  if err == nil && payload != nil {
+    err = payload(conn)
+  }
+  if err != nil {
+    if ctx.Err() != nil {
+      return "", ctx.Err()
+    }
+
+    // clamd replies and closes the connection as soon as it rejects the
+    // stream (e.g. size limit exceeded), the reply explains the failed
+    // write. A partial stream must not pass for a scanned one.
+    if closer, ok := conn.(interface{ CloseWrite() error }); ok {
+      closer.CloseWrite()
+    }
+    conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+    if reply, _ := readReply(conn); strings.HasSuffix(reply, "ERROR") {
+      return "", replyError(reply)
+    }
+    return "", fmt.Errorf("%w: clamd: %v", ErrScan, err)
+  }
+
+  reply, err := readReply(conn)
+  if err != nil {
+    if ctx.Err() != nil {
+      return "", ctx.Err()
+    }
+    return "", fmt.Errorf("%w: clamd: %v", ErrScan, err)
+  }
+
+  return reply, nil
+}
+
+// replyError is the error of an unexpected reply, e.g. "INSTREAM size limit
+// exceeded. ERROR".
+func replyError(reply string) error {
+  if strings.Contains(reply, "size limit exceeded") {
+    return fmt.Errorf("%w: clamd: %s", ErrTooLarge, reply)
+  }
+
+  return fmt.Errorf("%w: clamd: %s", ErrScan, reply)
+}
+
+// readReply reads a null-terminated reply.
+func readReply(conn net.Conn) (string, error) {
+  reply, err := bufio.NewReader(conn).ReadString(0)
+  if err != nil && reply == "" {
+    return "", err
+  }
+
+  return strings.TrimSpace(strings.TrimSuffix(reply, "\x00")), nil
+}
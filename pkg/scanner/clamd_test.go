@@ -0,0 +1,142 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package scanner
+
+import (
+  "bufio"
+  "bytes"
+  "context"
+  "encoding/binary"
+  "errors"
+  "io"
+  "net"
+  "strings"
+  "testing"
+)
+
+// fakeClamd answers PING and INSTREAM the way clamd does, rejecting the
+// streams over maxLength.
+func fakeClamd(t *testing.T, maxLength int) string {
+  listener, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  t.Cleanup(func() { listener.Close() })
+
+  go func() {
+    for {
+      conn, err := listener.Accept()
+      if err != nil {
+        return
+      }
+      go serveClamd(conn, maxLength)
+    }
+  }()
+
+  return listener.Addr().String()
+}
+
+func serveClamd(conn net.Conn, maxLength int) {
+  defer conn.Close()
+
+  r := bufio.NewReader(conn)
+  command, err := r.ReadString(0)
+  if err != nil {
+    return
+  }
+
+  switch command {
+  case "zPING\x00":
+    conn.Write([]byte("PONG\x00"))
+  case "zINSTREAM\x00":
+    var content bytes.Buffer
+    for {
+      var size uint32
+      if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+        return
+      }
+      if size == 0 {
+        break
+      }
+
+      if content.Len()+int(size) > maxLength {
+        conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+        // the client sees the reply rather than a reset connection
+        io.Copy(io.Discard, r)
+        return
+      }
+      if _, err := io.CopyN(&content, r, int64(size)); err != nil {
+        return
+      }
+    }
+
+    if strings.Contains(content.String(), "EICAR") {
+      conn.Write([]byte("stream: Eicar-Signature FOUND\x00"))
+    } else {
+      conn.Write([]byte("stream: OK\x00"))
+    }
+  default:
+    conn.Write([]byte("UNKNOWN COMMAND\x00"))
+  }
+}
+
+func TestClamdScan(t *testing.T) {
+  clamd, err := NewClamd("tcp://" + fakeClamd(t, 1<<20))
+  if err != nil {
+    t.Fatal(err)
+  }
+  ctx := context.Background()
+
+  if err := clamd.Ping(ctx); err != nil {
+    t.Fatalf("Ping: %v", err)
+  }
+
+  result, err := clamd.Scan(ctx, strings.NewReader("hello"))
+  if err != nil || result.Infected {
+    t.Fatalf("clean: got %+v, %v", result, err)
+  }
+
+  // spans several chunks
+  infected := strings.Repeat("a", 3*clamdChunkSize) + "EICAR"
+  result, err = clamd.Scan(ctx, strings.NewReader(infected))
+  if err != nil || !result.Infected || result.Threat != "Eicar-Signature" {
+    t.Fatalf("infected: got %+v, %v", result, err)
+  }
+}
+
+func TestClamdTooLarge(t *testing.T) {
+  clamd, err := NewClamd(fakeClamd(t, 1<<20))
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  for _, size := range []int{1<<20 + 1, 8 << 20} {
+    _, err := clamd.Scan(context.Background(), bytes.NewReader(make([]byte, size)))
+    if !errors.Is(err, ErrTooLarge) {
+      t.Fatalf("size %d: got %v, want %v", size, err, ErrTooLarge)
+    }
+  }
+}
+
+func TestClamdUnreachable(t *testing.T) {
+  listener, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  address := listener.Addr().String()
+  listener.Close()
+
+  clamd, err := NewClamd(address)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  _, err = clamd.Scan(context.Background(), strings.NewReader("hello"))
+  if !errors.Is(err, ErrScan) || errors.Is(err, ErrTooLarge) {
+    t.Fatalf("got %v, want %v", err, ErrScan)
+  }
+}
@@ -0,0 +1,33 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+// Package scanner scans the uploaded files for malware, e.g. with ClamAV.
+package scanner
+
+import (
+  "context"
+  "errors"
+  "io"
+)
+
+var (
+  ErrScan = errors.New("scan failed")
+  // the content exceeds the size the scanner accepts, it is not scanned
+  ErrTooLarge = errors.New("content too large to scan")
+)
+
+type Result struct {
+  Infected bool
+  // name of the detected threat, e.g. "Eicar-Signature"
+  Threat string
+}
+
+type Scanner interface {
+  // Scan reads the whole content of r. A content which could not be scanned
+  // fails with ErrTooLarge if it is over the size limit of the scanner, with
+  // ErrScan otherwise (unreachable scanner...).
+  Scan(ctx context.Context, r io.Reader) (*Result, error)
+}
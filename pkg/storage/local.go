@@ -0,0 +1,229 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package storage
+
+import (
+  "context"
+  "errors"
+  "io"
+  "io/fs"
+  "os"
+  "path/filepath"
+  "strings"
+)
+
+// partial uploads, skipped by List
+const tempPrefix = ".tmp-"
+
+// Local stores the objects as files under a directory, the key is the path
+// relative to it.
+type Local struct {
+  root string
+}
+
+func NewLocal(root string) (*Local, error) {
+  if err := os.MkdirAll(root, 0755); err != nil {
+    return nil, err
+  }
+
+  return &Local{root: root}, nil
+}
+
+// Put writes to a temporary file renamed over the key, readers never see a
+// partial object.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+  path, err := l.path(key)
+  if err != nil {
+    return err
+  }
+
+  if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+    return err
+  }
+
+  tmp, err := os.CreateTemp(filepath.Dir(path), tempPrefix+"*")
+  if err != nil {
+    return err
+  }
+  defer os.Remove(tmp.Name())
+
+  if _, err := io.Copy(tmp, contextReader{ctx, r}); err != nil {
+    tmp.Close()
+    return err
+  }
+
+  if err := tmp.Close(); err != nil {
+    return err
+  }
+
+  return os.Rename(tmp.Name(), path)
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+  path, err := l.path(key)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  file, err := os.Open(path)
+  if err != nil {
+    return nil, nil, notFound(err)
+  }
+
+  stat, err := file.Stat()
+  if err != nil {
+    file.Close()
+    return nil, nil, err
+  }
+
+  if stat.IsDir() {
+    file.Close()
+    return nil, nil, ErrNotFound
+  }
+
+  return file, &ObjectInfo{Key: key, Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+func (l *Local) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+  r, _, err := l.Get(ctx, key)
+  if err != nil {
+    return nil, err
+  }
+
+  file := r.(*os.File)
+  if _, err := file.Seek(offset, io.SeekStart); err != nil {
+    file.Close()
+    return nil, err
+  }
+
+  if length < 0 {
+    return file, nil
+  }
+
+  return limitedReadCloser{io.LimitReader(file, length), file}, nil
+}
+
+func (l *Local) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+  path, err := l.path(key)
+  if err != nil {
+    return nil, err
+  }
+
+  stat, err := os.Stat(path)
+  if err != nil {
+    return nil, notFound(err)
+  }
+
+  if stat.IsDir() {
+    return nil, ErrNotFound
+  }
+
+  return &ObjectInfo{Key: key, Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+func (l *Local) Rename(ctx context.Context, src, dst string) error {
+  srcPath, err := l.path(src)
+  if err != nil {
+    return err
+  }
+
+  dstPath, err := l.path(dst)
+  if err != nil {
+    return err
+  }
+
+  if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+    return err
+  }
+
+  return notFound(os.Rename(srcPath, dstPath))
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+  path, err := l.path(key)
+  if err != nil {
+    return err
+  }
+
+  if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+    return err
+  }
+
+  return nil
+}
+
+func (l *Local) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+  return filepath.WalkDir(l.root, func(path string, entry fs.DirEntry, err error) error {
+    if err != nil {
+      return err
+    }
+
+    if err := ctx.Err(); err != nil {
+      return err
+    }
+
+    if entry.IsDir() || strings.HasPrefix(entry.Name(), tempPrefix) {
+      return nil
+    }
+
+    rel, err := filepath.Rel(l.root, path)
+    if err != nil {
+      return err
+    }
+
+    key := filepath.ToSlash(rel)
+    if !strings.HasPrefix(key, prefix) {
+      return nil
+    }
+
+    info, err := entry.Info()
+    if err != nil {
+      // removed while walking
+      if errors.Is(err, fs.ErrNotExist) {
+        return nil
+      }
+      return err
+    }
+
+    return fn(ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+  })
+}
+
+func (l *Local) path(key string) (string, error) {
+  if !ValidKey(key) {
+    return "", ErrInvalidKey
+  }
+
+  return filepath.Join(l.root, filepath.FromSlash(key)), nil
+}
+
+func notFound(err error) error {
+  if errors.Is(err, fs.ErrNotExist) {
+    return ErrNotFound
+  }
+
+  return err
+}
+
+// contextReader stops a long copy when the context is done.
+type contextReader struct {
+  ctx context.Context
+  r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+  if err := cr.ctx.Err(); err != nil {
+    return 0, err
+  }
+
+  return cr.r.Read(p)
+}
+
+type limitedReadCloser struct {
+  io.Reader
+  io.Closer
+}
@@ -0,0 +1,599 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package storage
+
+import (
+  "bytes"
+  "context"
+  "crypto/hmac"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/xml"
+  "errors"
+  "fmt"
+  "io"
+  "net/http"
+  "net/url"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+)
+
+const (
+  unsignedPayload = "UNSIGNED-PAYLOAD"
+  // sha256 of an empty body
+  emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+  maxErrorBody     = 4 << 10
+  // S3 wants parts of at least 5 MiB, and at most 10000 parts (about 160 GiB)
+  multipartPartSize = 16 << 20
+  // larger objects cannot be copied in one request
+  maxCopySize = 5 << 30
+)
+
+type S3Config struct {
+  // e.g. https://s3.eu-west-1.amazonaws.com or http://localhost:9000 (MinIO)
+  Endpoint  string
+  Region    string
+  Bucket    string
+  AccessKey string
+  SecretKey string
+  // bucket in the path (MinIO, most S3 compatible stores) instead of the
+  // host name
+  PathStyle bool
+  // prepended to every key, e.g. "ctfxd/"
+  Prefix string
+}
+
+// S3 stores the objects in an S3 compatible bucket, the requests are signed
+// with AWS signature version 4.
+type S3 struct {
+  config   S3Config
+  endpoint *url.URL
+  client   *http.Client
+}
+
+func NewS3(config S3Config, client *http.Client) (*S3, error) {
+  if config.Bucket == "" || config.AccessKey == "" || config.SecretKey == "" {
+    return nil, errors.New("s3: bucket, access key and secret key are required")
+  }
+
+  if config.Region == "" {
+    config.Region = "us-east-1"
+  }
+
+  endpoint, err := url.Parse(strings.TrimSuffix(config.Endpoint, "/"))
+  if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+    return nil, fmt.Errorf("s3: invalid endpoint %q", config.Endpoint)
+  }
+
+  if client == nil {
+    client = &http.Client{Timeout: 10 * time.Minute}
+  }
+
+  return &S3{config: config, endpoint: endpoint, client: client}, nil
+}
+
+// Put streams the content of an unknown size with a multipart upload.
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+  if !ValidKey(key) {
+    return ErrInvalidKey
+  }
+
+  if size < 0 {
+    return s.putMultipart(ctx, key, r)
+  }
+
+  return s.putObject(ctx, key, r, size)
+}
+
+func (s *S3) putObject(ctx context.Context, key string, r io.Reader, size int64) error {
+  req, err := s.newRequest(ctx, http.MethodPut, key, nil, r)
+  if err != nil {
+    return err
+  }
+  req.ContentLength = size
+  req.Header.Set("Content-Type", "application/octet-stream")
+  if size == 0 {
+    req.Body = http.NoBody
+  }
+
+  resp, err := s.do(req, unsignedPayload)
+  if err != nil {
+    return err
+  }
+  resp.Body.Close()
+
+  return nil
+}
+
+type completedPart struct {
+  PartNumber int    `xml:"PartNumber"`
+  ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+  XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+  Parts   []completedPart `xml:"Part"`
+}
+
+// putMultipart uploads the content in parts of multipartPartSize, buffered in
+// memory one at a time. A content fitting in one part is sent as is.
+func (s *S3) putMultipart(ctx context.Context, key string, r io.Reader) error {
+  buf := make([]byte, multipartPartSize)
+  n, err := io.ReadFull(r, buf)
+  if err == io.EOF || err == io.ErrUnexpectedEOF {
+    return s.putObject(ctx, key, bytes.NewReader(buf[:n]), int64(n))
+  } else if err != nil {
+    return err
+  }
+
+  uploadID, err := s.createMultipartUpload(ctx, key)
+  if err != nil {
+    return err
+  }
+
+  var complete completeMultipartUpload
+  for part := 1; n > 0; part++ {
+    etag, err := s.uploadPart(ctx, key, uploadID, part, buf[:n])
+    if err != nil {
+      s.abortMultipartUpload(key, uploadID)
+      return err
+    }
+    complete.Parts = append(complete.Parts, completedPart{PartNumber: part, ETag: etag})
+
+    n, err = io.ReadFull(r, buf)
+    if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+      s.abortMultipartUpload(key, uploadID)
+      return err
+    }
+  }
+
+  body, err := xml.Marshal(complete)
+  if err != nil {
+    s.abortMultipartUpload(key, uploadID)
+    return err
+  }
+
+  query := url.Values{"uploadId": {uploadID}}
+  req, err := s.newRequest(ctx, http.MethodPost, key, query, bytes.NewReader(body))
+  if err != nil {
+    s.abortMultipartUpload(key, uploadID)
+    return err
+  }
+
+  resp, err := s.do(req, payloadHash(body))
+  if err != nil {
+    s.abortMultipartUpload(key, uploadID)
+    return err
+  }
+  defer resp.Body.Close()
+
+  // the completion can fail after a 200 OK, the error is in the body
+  var result struct {
+    XMLName xml.Name
+    Code    string `xml:"Code"`
+    Message string `xml:"Message"`
+  }
+  if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+    return fmt.Errorf("s3: complete multipart upload: %w", err)
+  }
+  if result.XMLName.Local == "Error" {
+    return fmt.Errorf("s3: complete multipart upload: %s: %s", result.Code, result.Message)
+  }
+
+  return nil
+}
+
+func (s *S3) createMultipartUpload(ctx context.Context, key string) (string, error) {
+  req, err := s.newRequest(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+  if err != nil {
+    return "", err
+  }
+  req.Header.Set("Content-Type", "application/octet-stream")
+
+  resp, err := s.do(req, emptyPayloadHash)
+  if err != nil {
+    return "", err
+  }
+  defer resp.Body.Close()
+
+  var result struct {
+    UploadID string `xml:"UploadId"`
+  }
+  if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil || result.UploadID == "" {
+    return "", fmt.Errorf("s3: create multipart upload: invalid response(%v)", err)
+  }
+
+  return result.UploadID, nil
+}
+
+func (s *S3) uploadPart(ctx context.Context, key, uploadID string, part int, data []byte) (string, error) {
+  query := url.Values{"partNumber": {strconv.Itoa(part)}, "uploadId": {uploadID}}
+  req, err := s.newRequest(ctx, http.MethodPut, key, query, bytes.NewReader(data))
+  if err != nil {
+    return "", err
+  }
+
+  resp, err := s.do(req, unsignedPayload)
+  if err != nil {
+    return "", err
+  }
+  resp.Body.Close()
+
+  return resp.Header.Get("ETag"), nil
+}
+
+// abortMultipartUpload frees the uploaded parts, even if the upload was
+// cancelled.
+func (s *S3) abortMultipartUpload(key, uploadID string) {
+  ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+  defer cancel()
+
+  req, err := s.newRequest(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil)
+  if err != nil {
+    return
+  }
+
+  if resp, err := s.do(req, emptyPayloadHash); err == nil {
+    resp.Body.Close()
+  }
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+  if !ValidKey(key) {
+    return nil, nil, ErrInvalidKey
+  }
+
+  req, err := s.newRequest(ctx, http.MethodGet, key, nil, nil)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  resp, err := s.do(req, emptyPayloadHash)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  return resp.Body, objectInfo(key, resp), nil
+}
+
+func (s *S3) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+  if !ValidKey(key) {
+    return nil, ErrInvalidKey
+  }
+
+  req, err := s.newRequest(ctx, http.MethodGet, key, nil, nil)
+  if err != nil {
+    return nil, err
+  }
+
+  if length < 0 {
+    req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+  } else if length == 0 {
+    return io.NopCloser(strings.NewReader("")), nil
+  } else {
+    req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+  }
+
+  resp, err := s.do(req, emptyPayloadHash)
+  if err != nil {
+    return nil, err
+  }
+
+  // a store ignoring the range sends the whole object
+  if resp.StatusCode != http.StatusPartialContent {
+    resp.Body.Close()
+    return nil, fmt.Errorf("s3: GET %s: range not supported (status %d)", req.URL.Path, resp.StatusCode)
+  }
+
+  return resp.Body, nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+  if !ValidKey(key) {
+    return nil, ErrInvalidKey
+  }
+
+  req, err := s.newRequest(ctx, http.MethodHead, key, nil, nil)
+  if err != nil {
+    return nil, err
+  }
+
+  resp, err := s.do(req, emptyPayloadHash)
+  if err != nil {
+    return nil, err
+  }
+  resp.Body.Close()
+
+  return objectInfo(key, resp), nil
+}
+
+// Rename copies the object on the server side, then deletes it.
+func (s *S3) Rename(ctx context.Context, src, dst string) error {
+  if !ValidKey(src) || !ValidKey(dst) {
+    return ErrInvalidKey
+  }
+
+  info, err := s.Stat(ctx, src)
+  if err != nil {
+    return err
+  }
+  if info.Size > maxCopySize {
+    return errors.ErrUnsupported
+  }
+
+  req, err := s.newRequest(ctx, http.MethodPut, dst, nil, nil)
+  if err != nil {
+    return err
+  }
+  req.Header.Set("X-Amz-Copy-Source", escapePath("/"+s.config.Bucket+"/"+s.config.Prefix+src))
+
+  resp, err := s.do(req, emptyPayloadHash)
+  if err != nil {
+    return err
+  }
+  defer resp.Body.Close()
+
+  // the copy can fail after a 200 OK, the error is in the body
+  var result struct {
+    XMLName xml.Name
+    Code    string `xml:"Code"`
+    Message string `xml:"Message"`
+  }
+  if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+    return fmt.Errorf("s3: copy object: %w", err)
+  }
+  if result.XMLName.Local == "Error" {
+    return fmt.Errorf("s3: copy object: %s: %s", result.Code, result.Message)
+  }
+
+  return s.Delete(ctx, src)
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+  if !ValidKey(key) {
+    return ErrInvalidKey
+  }
+
+  req, err := s.newRequest(ctx, http.MethodDelete, key, nil, nil)
+  if err != nil {
+    return err
+  }
+
+  resp, err := s.do(req, emptyPayloadHash)
+  if err != nil {
+    if errors.Is(err, ErrNotFound) {
+      return nil
+    }
+    return err
+  }
+  resp.Body.Close()
+
+  return nil
+}
+
+type listBucketResult struct {
+  Contents []struct {
+    Key          string    `xml:"Key"`
+    Size         int64     `xml:"Size"`
+    LastModified time.Time `xml:"LastModified"`
+  } `xml:"Contents"`
+  IsTruncated           bool   `xml:"IsTruncated"`
+  NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List pages through ListObjectsV2.
+func (s *S3) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+  token := ""
+  for {
+    query := url.Values{}
+    query.Set("list-type", "2")
+    query.Set("prefix", s.config.Prefix+prefix)
+    if token != "" {
+      query.Set("continuation-token", token)
+    }
+
+    req, err := s.newRequest(ctx, http.MethodGet, "", query, nil)
+    if err != nil {
+      return err
+    }
+
+    resp, err := s.do(req, emptyPayloadHash)
+    if err != nil {
+      return err
+    }
+
+    var result listBucketResult
+    err = xml.NewDecoder(resp.Body).Decode(&result)
+    resp.Body.Close()
+    if err != nil {
+      return fmt.Errorf("s3: list: %w", err)
+    }
+
+    for _, object := range result.Contents {
+      err := fn(ObjectInfo{
+        Key:     strings.TrimPrefix(object.Key, s.config.Prefix),
+        Size:    object.Size,
+        ModTime: object.LastModified,
+      })
+      if err != nil {
+        return err
+      }
+    }
+
+    if !result.IsTruncated || result.NextContinuationToken == "" {
+      return nil
+    }
+    token = result.NextContinuationToken
+  }
+}
+
+// newRequest builds the request for the object key (the bucket if empty).
+func (s *S3) newRequest(ctx context.Context, method, key string, query url.Values, body io.Reader) (*http.Request, error) {
+  u := *s.endpoint
+
+  path := ""
+  if key != "" {
+    path = "/" + s.config.Prefix + key
+  }
+
+  if s.config.PathStyle {
+    u.Path += "/" + s.config.Bucket + path
+  } else {
+    u.Host = s.config.Bucket + "." + u.Host
+    u.Path += path
+  }
+  if u.Path == "" {
+    u.Path = "/"
+  }
+  // sent as signed
+  u.RawPath = escapePath(u.Path)
+  if query != nil {
+    u.RawQuery = canonicalQuery(query)
+  }
+
+  return http.NewRequestWithContext(ctx, method, u.String(), body)
+}
+
+// do signs and sends the request, an error status is turned into an error.
+func (s *S3) do(req *http.Request, payloadHash string) (*http.Response, error) {
+  s.sign(req, payloadHash, time.Now().UTC())
+
+  resp, err := s.client.Do(req)
+  if err != nil {
+    return nil, err
+  }
+
+  if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+    return resp, nil
+  }
+
+  defer resp.Body.Close()
+  if resp.StatusCode == http.StatusNotFound {
+    return nil, ErrNotFound
+  }
+
+  msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+  return nil, fmt.Errorf("s3: %s %s: status %d: %s", req.Method, req.URL.Path, resp.StatusCode, bytes.TrimSpace(msg))
+}
+
+// sign adds the AWS signature version 4 Authorization header.
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+  amzDate := now.Format("20060102T150405Z")
+  date := amzDate[:8]
+
+  req.Header.Set("X-Amz-Date", amzDate)
+  req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+  // host, x-amz-* and the content headers are signed
+  headers := map[string]string{"host": req.URL.Host}
+  for name, values := range req.Header {
+    name = strings.ToLower(name)
+    if strings.HasPrefix(name, "x-amz-") || name == "content-type" || name == "range" {
+      headers[name] = strings.TrimSpace(strings.Join(values, ","))
+    }
+  }
+
+  names := make([]string, 0, len(headers))
+  for name := range headers {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+
+  var canonicalHeaders strings.Builder
+  for _, name := range names {
+    canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+  }
+  signedHeaders := strings.Join(names, ";")
+
+  canonicalRequest := strings.Join([]string{
+    req.Method,
+    escapePath(req.URL.Path),
+    canonicalQuery(req.URL.Query()),
+    canonicalHeaders.String(),
+    signedHeaders,
+    payloadHash,
+  }, "\n")
+
+  scope := date + "/" + s.config.Region + "/s3/aws4_request"
+  hash := sha256.Sum256([]byte(canonicalRequest))
+  stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+  key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), date)
+  key = hmacSHA256(key, s.config.Region)
+  key = hmacSHA256(key, "s3")
+  key = hmacSHA256(key, "aws4_request")
+  signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+  req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+    s.config.AccessKey, scope, signedHeaders, signature))
+}
+
+func objectInfo(key string, resp *http.Response) *ObjectInfo {
+  info := &ObjectInfo{Key: key, Size: resp.ContentLength}
+  if size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); err == nil {
+    info.Size = size
+  }
+  if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+    info.ModTime = modTime
+  }
+
+  return info
+}
+
+func payloadHash(body []byte) string {
+  hash := sha256.Sum256(body)
+  return hex.EncodeToString(hash[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+  mac := hmac.New(sha256.New, key)
+  mac.Write([]byte(data))
+  return mac.Sum(nil)
+}
+
+// canonicalQuery sorts and encodes the query as required by the signature.
+func canonicalQuery(query url.Values) string {
+  keys := make([]string, 0, len(query))
+  for k := range query {
+    keys = append(keys, k)
+  }
+  sort.Strings(keys)
+
+  var parts []string
+  for _, k := range keys {
+    values := append([]string(nil), query[k]...)
+    sort.Strings(values)
+    for _, v := range values {
+      parts = append(parts, escape(k, true)+"="+escape(v, true))
+    }
+  }
+
+  return strings.Join(parts, "&")
+}
+
+func escapePath(path string) string {
+  return escape(path, false)
+}
+
+// escape percent-encodes everything but the unreserved characters (and the
+// slashes of a path).
+func escape(s string, encodeSlash bool) string {
+  var b strings.Builder
+  for i := 0; i < len(s); i++ {
+    c := s[i]
+    if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+      c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+      b.WriteByte(c)
+    } else {
+      fmt.Fprintf(&b, "%%%02X", c)
+    }
+  }
+
+  return b.String()
+}
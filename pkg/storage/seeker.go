@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+package storage
+
+import (
+  "context"
+  "errors"
+  "io"
+)
+
+// ReadSeeker reads an object of a known size, every seek followed by a read
+// opens a range of the object. It lets http.ServeContent answer the range
+// requests from any storage.
+type ReadSeeker struct {
+  ctx    context.Context
+  store  Storage
+  key    string
+  size   int64
+  offset int64
+  r      io.ReadCloser
+}
+
+func NewReadSeeker(ctx context.Context, store Storage, key string, size int64) *ReadSeeker {
+  return &ReadSeeker{ctx: ctx, store: store, key: key, size: size}
+}
+
+func (rs *ReadSeeker) Size() int64 {
+  return rs.size
+}
+
+func (rs *ReadSeeker) Read(p []byte) (int, error) {
+  if rs.offset >= rs.size {
+    return 0, io.EOF
+  }
+
+  if rs.r == nil {
+    r, err := rs.store.GetRange(rs.ctx, rs.key, rs.offset, -1)
+    if err != nil {
+      return 0, err
+    }
+    rs.r = r
+  }
+
+  n, err := rs.r.Read(p)
+  rs.offset += int64(n)
+  if err == io.EOF && rs.offset < rs.size {
+    err = io.ErrUnexpectedEOF
+  }
+
+  return n, err
+}
+
+func (rs *ReadSeeker) Seek(offset int64, whence int) (int64, error) {
+  switch whence {
+  case io.SeekCurrent:
+    offset += rs.offset
+  case io.SeekEnd:
+    offset += rs.size
+  }
+
+  if offset < 0 {
+    return 0, errors.New("storage: negative seek offset")
+  }
+
+  if offset != rs.offset {
+    rs.Close()
+    rs.offset = offset
+  }
+
+  return offset, nil
+}
+
+func (rs *ReadSeeker) Close() error {
+  if rs.r == nil {
+    return nil
+  }
+
+  err := rs.r.Close()
+  rs.r = nil
+  return err
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2025, Arka Mondal. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that
+ * can be found in the LICENSE file.
+ */
+
+// Package storage stores the uploaded files (objects) by key, on the local
+// disk or in an S3 compatible bucket.
+package storage
+
+import (
+  "context"
+  "errors"
+  "io"
+  "strings"
+  "time"
+)
+
+var (
+  ErrNotFound   = errors.New("object not found")
+  ErrInvalidKey = errors.New("invalid object key")
+)
+
+type ObjectInfo struct {
+  Key     string
+  Size    int64
+  ModTime time.Time
+}
+
+// Storage is a flat object store. Keys are slash separated paths, e.g.
+// "challenge/<uuid>".
+type Storage interface {
+  // Put stores the content of r under the key, replacing any previous
+  // object. size is the length of the content, or -1 if unknown.
+  Put(ctx context.Context, key string, r io.Reader, size int64) error
+  // Get streams the object, the caller closes the reader.
+  Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+  // GetRange streams length bytes of the object from offset, up to its end
+  // if length is negative.
+  GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+  Stat(ctx context.Context, key string) (*ObjectInfo, error)
+  // Delete removes the object, deleting a missing object is not an error.
+  Delete(ctx context.Context, key string) error
+  // List calls fn for every object whose key starts with prefix.
+  List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error
+}
+
+// Renamer is implemented by the stores moving an object without copying its
+// content through the server.
+type Renamer interface {
+  // Rename fails with errors.ErrUnsupported if the object cannot be renamed,
+  // e.g. too large.
+  Rename(ctx context.Context, src, dst string) error
+}
+
+// Move moves the object to dst, renaming it if the store can, else copying
+// it.
+func Move(ctx context.Context, store Storage, src, dst string) error {
+  if renamer, ok := store.(Renamer); ok {
+    err := renamer.Rename(ctx, src, dst)
+    if !errors.Is(err, errors.ErrUnsupported) {
+      return err
+    }
+  }
+
+  r, info, err := store.Get(ctx, src)
+  if err != nil {
+    return err
+  }
+  defer r.Close()
+
+  if err := store.Put(ctx, dst, r, info.Size); err != nil {
+    return err
+  }
+
+  return store.Delete(ctx, src)
+}
+
+// ValidKey rejects empty keys, absolute paths and keys climbing out of the
+// store with "..".
+func ValidKey(key string) bool {
+  if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+    return false
+  }
+
+  for _, part := range strings.Split(key, "/") {
+    if part == "" || part == "." || part == ".." {
+      return false
+    }
+  }
+
+  return true
+}